@@ -0,0 +1,155 @@
+// Package archive converts workspace archives to and from the flat CSV
+// layout, with one todo per row. CSV only carries todos and the names of
+// their lists; comments and history need the JSON archive
+package archive
+
+import (
+	"encoding/csv"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/greeneg/todoer/model"
+)
+
+// Columns are the CSV columns, in the order they are written
+var Columns = []string{
+	"id",
+	"description",
+	"status",
+	"list",
+	"creator",
+	"assignee",
+	"priority",
+	"dueDate",
+	"remindDate",
+	"completionDate",
+	"tags",
+	"creationDate",
+	"lastChangedDate",
+}
+
+// EncodeCSV writes the todos of a workspace as CSV, with a header row
+func EncodeCSV(w io.Writer, ws model.Workspace) error {
+	listNames := make(map[int]string)
+	for _, l := range ws.Lists {
+		listNames[l.Id] = l.Name
+	}
+
+	out := csv.NewWriter(w)
+	if err := out.Write(Columns); err != nil {
+		return err
+	}
+	for _, todo := range ws.Todos {
+		err := out.Write([]string{
+			strconv.Itoa(todo.Id),
+			todo.Description,
+			todo.Status,
+			listNames[todo.ListId],
+			todo.Creator,
+			todo.Assignee,
+			strconv.Itoa(todo.Priority),
+			todo.DueDate,
+			todo.RemindDate,
+			todo.CompletionDate,
+			strings.Join(todo.Tags, ","),
+			todo.CreationDate,
+			todo.LastChangedDate,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	out.Flush()
+
+	return out.Error()
+}
+
+// DecodeCSV reads todos written by EncodeCSV into a workspace. Columns are
+// matched by the names in the header row and only description is required.
+// Lists are made up from the distinct list names
+func DecodeCSV(r io.Reader) (model.Workspace, error) {
+	in := csv.NewReader(r)
+	in.FieldsPerRecord = -1
+	header, err := in.Read()
+	if err != nil {
+		return model.Workspace{}, errors.New("missing header row: " + err.Error())
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.TrimSpace(name)] = i
+	}
+	if _, found := columns["description"]; !found {
+		return model.Workspace{}, errors.New("missing description column")
+	}
+
+	ws := model.Workspace{
+		Version:  model.WorkspaceVersion,
+		Lists:    make([]model.WorkspaceList, 0),
+		Tags:     make([]string, 0),
+		Todos:    make([]model.WorkspaceTodo, 0),
+		Comments: make([]model.Comment, 0),
+		History:  make([]model.HistoryEntry, 0),
+	}
+	listIds := make(map[string]int)
+	for {
+		record, err := in.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return model.Workspace{}, err
+		}
+		line, _ := in.FieldPos(0)
+		field := func(name string) string {
+			if i, found := columns[name]; found && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+		fail := func(msg string) (model.Workspace, error) {
+			return model.Workspace{}, errors.New("line " + strconv.Itoa(line) + ": " + msg)
+		}
+
+		todo := model.WorkspaceTodo{
+			Id:              len(ws.Todos) + 1,
+			Description:     field("description"),
+			Status:          field("status"),
+			Creator:         field("creator"),
+			Assignee:        field("assignee"),
+			DueDate:         field("dueDate"),
+			RemindDate:      field("remindDate"),
+			CompletionDate:  field("completionDate"),
+			Tags:            make([]string, 0),
+			CreationDate:    field("creationDate"),
+			LastChangedDate: field("lastChangedDate"),
+		}
+		if id := field("id"); id != "" {
+			if todo.Id, err = strconv.Atoi(id); err != nil {
+				return fail("invalid id '" + id + "'")
+			}
+		}
+		if todo.Status == "" {
+			todo.Status = "new"
+		}
+		if priority := field("priority"); priority != "" {
+			if todo.Priority, err = strconv.Atoi(priority); err != nil {
+				return fail("invalid priority '" + priority + "'")
+			}
+		}
+		if tags := field("tags"); tags != "" {
+			todo.Tags = strings.Split(tags, ",")
+		}
+		if name := field("list"); name != "" {
+			if _, found := listIds[name]; !found {
+				listIds[name] = len(ws.Lists) + 1
+				ws.Lists = append(ws.Lists, model.WorkspaceList{Id: listIds[name], Name: name, Members: make([]string, 0)})
+			}
+			todo.ListId = listIds[name]
+		}
+		ws.Todos = append(ws.Todos, todo)
+	}
+
+	return ws, nil
+}
@@ -0,0 +1,204 @@
+// Package caldav serves todos over CalDAV (RFC 4791) so that calendar and
+// reminder applications can sync them both ways. Each list the user can see
+// is a calendar collection holding one VTODO resource per todo on it
+package caldav
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/greeneg/todoer/ical"
+	"github.com/greeneg/todoer/model"
+)
+
+// Prefix is where the CalDAV tree is mounted
+const Prefix = "/dav"
+
+// Methods are the HTTP methods the CalDAV tree answers to
+var Methods = []string{"OPTIONS", "PROPFIND", "PROPPATCH", "REPORT", "GET", "HEAD", "PUT", "DELETE"}
+
+// kinds of resource in the tree
+const (
+	kindRoot = iota
+	kindPrincipal
+	kindHome
+	kindCalendar
+	kindObject
+)
+
+// target is the resource a request path refers to
+type target struct {
+	kind int
+	list model.List
+	name string
+}
+
+// href returns the path of a resource, with a trailing slash for collections
+func href(segments ...string) string {
+	escaped := make([]string, 0, len(segments))
+	for _, s := range segments {
+		escaped = append(escaped, url.PathEscape(s))
+	}
+
+	path := Prefix + "/" + strings.Join(escaped, "/")
+	if len(segments) != 0 {
+		path += "/"
+	}
+
+	return path
+}
+
+func principalHref(user model.User) string {
+	return href("principals", user.UserName)
+}
+
+func homeHref(user model.User) string {
+	return href("calendars", user.UserName)
+}
+
+func calendarHref(user model.User, list model.List) string {
+	return href("calendars", user.UserName, strconv.Itoa(list.Id))
+}
+
+// resourceName returns the name of the resource a todo is stored under
+func resourceName(todo model.Todo) string {
+	if todo.ResourceName != "" {
+		return todo.ResourceName
+	}
+
+	return "todo-" + strconv.Itoa(todo.Id) + ".ics"
+}
+
+func objectHref(user model.User, todo model.Todo) string {
+	return calendarHref(user, model.List{Id: todo.ListId}) + url.PathEscape(resourceName(todo))
+}
+
+// resolve finds what a path below Prefix refers to. Users only see their own
+// principal and calendar home, and the lists they can see
+func resolve(user model.User, path string) (target, bool) {
+	segments := make([]string, 0)
+	for _, s := range strings.Split(path, "/") {
+		if s != "" {
+			segments = append(segments, s)
+		}
+	}
+
+	switch {
+	case len(segments) == 0:
+		return target{kind: kindRoot}, true
+	case segments[0] == "principals" && len(segments) == 2 && segments[1] == user.UserName:
+		return target{kind: kindPrincipal}, true
+	case segments[0] != "calendars" || len(segments) < 2 || segments[1] != user.UserName:
+		return target{}, false
+	case len(segments) == 2:
+		return target{kind: kindHome}, true
+	}
+
+	listId, err := strconv.Atoi(segments[2])
+	if err != nil || !model.CanViewList(user.Id, listId) {
+		return target{}, false
+	}
+	list, err := model.GetListById(listId)
+	if err != nil || list.Id == 0 {
+		return target{}, false
+	}
+
+	switch len(segments) {
+	case 3:
+		return target{kind: kindCalendar, list: list}, true
+	case 4:
+		return target{kind: kindObject, list: list, name: segments[3]}, true
+	}
+
+	return target{}, false
+}
+
+// findTodo returns the todo stored under a resource name on a list, or an
+// empty todo if there is none
+func findTodo(list model.List, name string) (model.Todo, error) {
+	todo, err := model.GetTodoByResourceName(list.Id, name)
+	if err != nil || todo.Id != 0 {
+		return todo, err
+	}
+
+	// todos created through the API are named after their Id
+	idString, ok := strings.CutPrefix(strings.TrimSuffix(name, ".ics"), "todo-")
+	id, err := strconv.Atoi(idString)
+	if !ok || err != nil {
+		return model.Todo{}, nil
+	}
+	todo, err = model.GetTodoById(id)
+	if err != nil || todo.ListId != list.Id || todo.ResourceName != "" {
+		return model.Todo{}, err
+	}
+
+	return todo, nil
+}
+
+// render returns a todo as a calendar object resource, and its entity tag
+func render(todo model.Todo) ([]byte, string) {
+	cal := ical.NewCalendar("")
+	cal.Components = append(cal.Components, ical.FromTodo(todo))
+
+	var b strings.Builder
+	cal.Encode(&b)
+	sum := sha256.Sum256([]byte(b.String()))
+
+	return []byte(b.String()), `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// ctag returns a tag that changes whenever anything on a list changes
+func ctag(list model.List, todos []model.Todo) string {
+	h := sha256.New()
+	h.Write([]byte(list.Name + "\n"))
+	for _, todo := range todos {
+		_, etag := render(todo)
+		h.Write([]byte(resourceName(todo) + " " + etag + "\n"))
+	}
+
+	return hex.EncodeToString(h.Sum(nil)[:16])
+}
+
+// Serve answers a CalDAV request for the user
+func Serve(c *gin.Context, user model.User) {
+	c.Header("DAV", "1, 3, calendar-access")
+	path := c.Param("path")
+	t, ok := resolve(user, path)
+	if !ok {
+		c.Status(http.StatusNotFound)
+		return
+	}
+
+	switch c.Request.Method {
+	case "OPTIONS":
+		c.Header("Allow", strings.Join(Methods, ", "))
+		c.Status(http.StatusOK)
+	case "PROPFIND":
+		propfind(c, user, t)
+	case "PROPPATCH":
+		proppatch(c, user, t)
+	case "REPORT":
+		report(c, user, t)
+	case "GET", "HEAD":
+		get(c, user, t)
+	case "PUT":
+		put(c, user, t)
+	case "DELETE":
+		del(c, user, t)
+	default:
+		c.Status(http.StatusMethodNotAllowed)
+	}
+}
+
+// WellKnown sends clients looking for the CalDAV service to its root
+func WellKnown(c *gin.Context) {
+	log.Println("INFO: Redirecting CalDAV service discovery to " + Prefix + "/")
+	c.Redirect(http.StatusMovedPermanently, Prefix+"/")
+}
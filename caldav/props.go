@@ -0,0 +1,521 @@
+package caldav
+
+import (
+	"encoding/xml"
+	"html"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/greeneg/todoer/events"
+	"github.com/greeneg/todoer/model"
+)
+
+const (
+	nsDAV    = "DAV:"
+	nsCalDAV = "urn:ietf:params:xml:ns:caldav"
+	nsCS     = "http://calendarserver.org/ns/"
+)
+
+// prefixes used for the namespaces of the properties we know
+var prefixes = map[string]string{nsDAV: "d", nsCalDAV: "c", nsCS: "cs"}
+
+// the largest request body accepted
+const maxBodySize = 10 << 20
+
+// anyElement matches any XML element, remembering its name
+type anyElement struct {
+	XMLName xml.Name
+}
+
+type propList struct {
+	Names []anyElement `xml:",any"`
+}
+
+func (p *propList) names() []xml.Name {
+	names := make([]xml.Name, 0)
+	if p == nil {
+		return names
+	}
+	for _, e := range p.Names {
+		names = append(names, e.XMLName)
+	}
+
+	return names
+}
+
+type propfindRequest struct {
+	XMLName  xml.Name  `xml:"DAV: propfind"`
+	Prop     *propList `xml:"DAV: prop"`
+	AllProp  *struct{} `xml:"DAV: allprop"`
+	PropName *struct{} `xml:"DAV: propname"`
+}
+
+type propertyUpdate struct {
+	XMLName xml.Name `xml:"DAV: propertyupdate"`
+	Set     []struct {
+		Prop struct {
+			Any []struct {
+				XMLName xml.Name
+				Value   string `xml:",chardata"`
+			} `xml:",any"`
+		} `xml:"DAV: prop"`
+	} `xml:"DAV: set"`
+	Remove []struct {
+		Prop propList `xml:"DAV: prop"`
+	} `xml:"DAV: remove"`
+}
+
+// compFilter is a comp-filter of a calendar-query, possibly nested
+type compFilter struct {
+	Name    string       `xml:"name,attr"`
+	Filters []compFilter `xml:"urn:ietf:params:xml:ns:caldav comp-filter"`
+}
+
+type reportRequest struct {
+	XMLName xml.Name
+	Prop    *propList `xml:"DAV: prop"`
+	Filter  struct {
+		Filters []compFilter `xml:"urn:ietf:params:xml:ns:caldav comp-filter"`
+	} `xml:"urn:ietf:params:xml:ns:caldav filter"`
+	Hrefs []string `xml:"DAV: href"`
+}
+
+// readBody decodes an XML request body into v. An empty body leaves v alone
+func readBody(c *gin.Context, v any) bool {
+	body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxBodySize))
+	if err != nil {
+		c.Status(http.StatusBadRequest)
+		return false
+	}
+	if strings.TrimSpace(string(body)) == "" {
+		return true
+	}
+	if err := xml.Unmarshal(body, v); err != nil {
+		c.String(http.StatusBadRequest, "invalid XML: "+err.Error())
+		return false
+	}
+
+	return true
+}
+
+// element renders an empty or filled property element
+func element(name xml.Name, inner string) string {
+	prefix, ok := prefixes[name.Space]
+	if !ok {
+		open := "x:" + name.Local + ` xmlns:x="` + html.EscapeString(name.Space) + `"`
+		if inner == "" {
+			return "<" + open + "/>"
+		}
+		return "<" + open + ">" + inner + "</x:" + name.Local + ">"
+	}
+
+	tag := prefix + ":" + name.Local
+	if inner == "" {
+		return "<" + tag + "/>"
+	}
+
+	return "<" + tag + ">" + inner + "</" + tag + ">"
+}
+
+func hrefElement(h string) string {
+	return "<d:href>" + html.EscapeString(h) + "</d:href>"
+}
+
+// response is one response of a multistatus body: either a status for the
+// whole resource, or its properties grouped by outcome
+type response struct {
+	href    string
+	status  int
+	found   []string
+	denied  []string
+	failed  []string
+	missing []string
+}
+
+func statusText(code int) string {
+	return strconv.Itoa(code) + " " + http.StatusText(code)
+}
+
+// writeMultistatus sends a 207 Multi-Status response
+func writeMultistatus(c *gin.Context, responses []response) {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="utf-8"?>` + "\n")
+	b.WriteString(`<d:multistatus xmlns:d="DAV:" xmlns:c="` + nsCalDAV + `" xmlns:cs="` + nsCS + `">`)
+	for _, r := range responses {
+		b.WriteString("<d:response>" + hrefElement(r.href))
+		if r.status != 0 {
+			b.WriteString("<d:status>HTTP/1.1 " + statusText(r.status) + "</d:status>")
+		}
+		for _, group := range []struct {
+			props []string
+			code  int
+		}{{r.found, http.StatusOK}, {r.denied, http.StatusForbidden}, {r.failed, http.StatusFailedDependency},
+			{r.missing, http.StatusNotFound}} {
+			if len(group.props) == 0 {
+				continue
+			}
+			b.WriteString("<d:propstat><d:prop>" + strings.Join(group.props, "") + "</d:prop>")
+			b.WriteString("<d:status>HTTP/1.1 " + statusText(group.code) + "</d:status></d:propstat>")
+		}
+		b.WriteString("</d:response>")
+	}
+	b.WriteString("</d:multistatus>")
+
+	c.Data(http.StatusMultiStatus, "application/xml; charset=utf-8", []byte(b.String()))
+}
+
+// defaultProps are returned for allprop requests
+var defaultProps = map[int][]xml.Name{
+	kindRoot:      {{Space: nsDAV, Local: "resourcetype"}, {Space: nsDAV, Local: "current-user-principal"}},
+	kindPrincipal: {{Space: nsDAV, Local: "resourcetype"}, {Space: nsDAV, Local: "displayname"}, {Space: nsCalDAV, Local: "calendar-home-set"}},
+	kindHome:      {{Space: nsDAV, Local: "resourcetype"}, {Space: nsDAV, Local: "displayname"}},
+	kindCalendar: {{Space: nsDAV, Local: "resourcetype"}, {Space: nsDAV, Local: "displayname"}, {Space: nsCS, Local: "getctag"},
+		{Space: nsCalDAV, Local: "supported-calendar-component-set"}},
+	kindObject: {{Space: nsDAV, Local: "resourcetype"}, {Space: nsDAV, Local: "getetag"}, {Space: nsDAV, Local: "getcontenttype"},
+		{Space: nsDAV, Local: "getcontentlength"}, {Space: nsDAV, Local: "getlastmodified"}},
+}
+
+// node is a resource whose properties are being reported
+type node struct {
+	kind  int
+	href  string
+	list  model.List
+	todos []model.Todo
+	todo  model.Todo
+}
+
+// privileges reported for calendars and their objects. Anyone who can see a
+// list can change the todos on it
+const privileges = "<d:privilege><d:read/></d:privilege><d:privilege><d:write/></d:privilege>" +
+	"<d:privilege><d:write-content/></d:privilege><d:privilege><d:bind/></d:privilege>" +
+	"<d:privilege><d:unbind/></d:privilege><d:privilege><d:read-current-user-privilege-set/></d:privilege>"
+
+// property returns the rendered value of a property of a node
+func property(user model.User, n node, name xml.Name) (string, bool) {
+	switch name {
+	case xml.Name{Space: nsDAV, Local: "resourcetype"}:
+		switch n.kind {
+		case kindPrincipal:
+			return element(name, "<d:collection/><d:principal/>"), true
+		case kindCalendar:
+			return element(name, "<d:collection/><c:calendar/>"), true
+		case kindObject:
+			return element(name, ""), true
+		}
+		return element(name, "<d:collection/>"), true
+	case xml.Name{Space: nsDAV, Local: "current-user-principal"}, xml.Name{Space: nsDAV, Local: "principal-URL"}:
+		return element(name, hrefElement(principalHref(user))), true
+	case xml.Name{Space: nsCalDAV, Local: "calendar-home-set"}:
+		return element(name, hrefElement(homeHref(user))), true
+	case xml.Name{Space: nsCalDAV, Local: "calendar-user-address-set"}:
+		if user.Email == "" {
+			return "", false
+		}
+		return element(name, hrefElement("mailto:"+user.Email)), true
+	case xml.Name{Space: nsDAV, Local: "owner"}:
+		if n.kind != kindCalendar {
+			return "", false
+		}
+		owner, err := model.GetUserById(n.list.OwnerId)
+		if err != nil || owner.UserName == "" {
+			return "", false
+		}
+		return element(name, hrefElement(principalHref(owner))), true
+	case xml.Name{Space: nsDAV, Local: "displayname"}:
+		switch n.kind {
+		case kindPrincipal:
+			if user.FullName != "" {
+				return element(name, html.EscapeString(user.FullName)), true
+			}
+			return element(name, html.EscapeString(user.UserName)), true
+		case kindHome:
+			return element(name, html.EscapeString(user.UserName)), true
+		case kindCalendar:
+			return element(name, html.EscapeString(n.list.Name)), true
+		}
+	case xml.Name{Space: nsDAV, Local: "current-user-privilege-set"}:
+		if n.kind == kindCalendar || n.kind == kindObject {
+			return element(name, privileges), true
+		}
+	case xml.Name{Space: nsCalDAV, Local: "supported-calendar-component-set"}:
+		if n.kind == kindCalendar {
+			return element(name, `<c:comp name="VTODO"/>`), true
+		}
+	case xml.Name{Space: nsDAV, Local: "supported-report-set"}:
+		if n.kind == kindCalendar {
+			return element(name, "<d:supported-report><d:report><c:calendar-query/></d:report></d:supported-report>"+
+				"<d:supported-report><d:report><c:calendar-multiget/></d:report></d:supported-report>"), true
+		}
+	case xml.Name{Space: nsCS, Local: "getctag"}:
+		if n.kind == kindCalendar {
+			return element(name, ctag(n.list, n.todos)), true
+		}
+	case xml.Name{Space: nsDAV, Local: "getetag"}:
+		if n.kind == kindObject {
+			_, etag := render(n.todo)
+			return element(name, html.EscapeString(etag)), true
+		}
+	case xml.Name{Space: nsDAV, Local: "getcontenttype"}:
+		if n.kind == kindObject {
+			return element(name, "text/calendar; charset=utf-8; component=VTODO"), true
+		}
+	case xml.Name{Space: nsDAV, Local: "getcontentlength"}:
+		if n.kind == kindObject {
+			body, _ := render(n.todo)
+			return element(name, strconv.Itoa(len(body))), true
+		}
+	case xml.Name{Space: nsDAV, Local: "getlastmodified"}:
+		if n.kind == kindObject {
+			if d, err := time.Parse(time.RFC3339, n.todo.LastChangedDate); err == nil {
+				return element(name, d.UTC().Format(http.TimeFormat)), true
+			}
+		}
+	case xml.Name{Space: nsCalDAV, Local: "calendar-data"}:
+		if n.kind == kindObject {
+			body, _ := render(n.todo)
+			return element(name, html.EscapeString(string(body))), true
+		}
+	}
+
+	return "", false
+}
+
+// describe reports the requested properties of a node
+func describe(user model.User, n node, names []xml.Name, namesOnly bool) response {
+	r := response{href: n.href}
+	for _, name := range names {
+		value, ok := property(user, n, name)
+		switch {
+		case !ok:
+			r.missing = append(r.missing, element(name, ""))
+		case namesOnly:
+			r.found = append(r.found, element(name, ""))
+		default:
+			r.found = append(r.found, value)
+		}
+	}
+
+	return r
+}
+
+// calendarNode returns the node of a calendar with the todos on it
+func calendarNode(user model.User, list model.List) (node, error) {
+	todos, err := model.GetTodosForList(list.Id)
+	if err != nil {
+		return node{}, err
+	}
+
+	return node{kind: kindCalendar, href: calendarHref(user, list), list: list, todos: todos}, nil
+}
+
+func objectNode(user model.User, todo model.Todo) node {
+	return node{kind: kindObject, href: objectHref(user, todo), todo: todo}
+}
+
+// nodes returns the node a target refers to and, unless depth is 0, its
+// children
+func nodes(user model.User, t target, depth string) ([]node, bool, error) {
+	switch t.kind {
+	case kindRoot:
+		return []node{{kind: kindRoot, href: href()}}, true, nil
+	case kindPrincipal:
+		return []node{{kind: kindPrincipal, href: principalHref(user)}}, true, nil
+	case kindHome:
+		found := []node{{kind: kindHome, href: homeHref(user)}}
+		if depth == "0" {
+			return found, true, nil
+		}
+		lists, err := model.GetListsForUser(user.Id)
+		if err != nil {
+			return nil, false, err
+		}
+		for _, list := range lists {
+			n, err := calendarNode(user, list)
+			if err != nil {
+				return nil, false, err
+			}
+			found = append(found, n)
+		}
+		return found, true, nil
+	case kindCalendar:
+		n, err := calendarNode(user, t.list)
+		if err != nil {
+			return nil, false, err
+		}
+		found := []node{n}
+		if depth != "0" {
+			for _, todo := range n.todos {
+				found = append(found, objectNode(user, todo))
+			}
+		}
+		return found, true, nil
+	}
+
+	todo, err := findTodo(t.list, t.name)
+	if err != nil || todo.Id == 0 {
+		return nil, false, err
+	}
+
+	return []node{objectNode(user, todo)}, true, nil
+}
+
+func propfind(c *gin.Context, user model.User, t target) {
+	var req propfindRequest
+	if !readBody(c, &req) {
+		return
+	}
+
+	names := req.Prop.names()
+	if req.Prop == nil {
+		names = defaultProps[t.kind]
+	}
+	found, ok, err := nodes(user, t, c.GetHeader("Depth"))
+	if err != nil {
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		c.Status(http.StatusNotFound)
+		return
+	}
+
+	responses := make([]response, 0, len(found))
+	for _, n := range found {
+		nodeNames := names
+		if req.Prop == nil {
+			nodeNames = defaultProps[n.kind]
+		}
+		responses = append(responses, describe(user, n, nodeNames, req.PropName != nil))
+	}
+	writeMultistatus(c, responses)
+}
+
+// proppatch only allows the owner of a list to rename its calendar. As
+// property updates are all or nothing, nothing is changed if anything else
+// is asked for
+func proppatch(c *gin.Context, user model.User, t target) {
+	var req propertyUpdate
+	if !readBody(c, &req) {
+		return
+	}
+
+	displayName := xml.Name{Space: nsDAV, Local: "displayname"}
+	r := response{href: c.Request.URL.Path}
+	newName := ""
+	for _, set := range req.Set {
+		for _, prop := range set.Prop.Any {
+			if prop.XMLName == displayName && t.kind == kindCalendar && t.list.OwnerId == user.Id &&
+				strings.TrimSpace(prop.Value) != "" {
+				newName = strings.TrimSpace(prop.Value)
+				r.found = append(r.found, element(prop.XMLName, ""))
+			} else {
+				r.denied = append(r.denied, element(prop.XMLName, ""))
+			}
+		}
+	}
+	for _, remove := range req.Remove {
+		for _, name := range remove.Prop.names() {
+			r.denied = append(r.denied, element(name, ""))
+		}
+	}
+
+	if len(r.denied) != 0 {
+		r.failed, r.found = r.found, nil
+	} else if newName != "" && newName != t.list.Name {
+		list, err := model.RenameList(t.list.Id, newName)
+		if err != nil {
+			c.Status(http.StatusInternalServerError)
+			return
+		}
+		events.Publish(events.ListUpdated, user.UserName, list, t.list)
+	}
+	writeMultistatus(c, []response{r})
+}
+
+// wantsTodos reports whether a calendar-query filter can match VTODO
+// components. Other filters, such as time ranges, are not applied
+func wantsTodos(filters []compFilter) bool {
+	if len(filters) == 0 {
+		return true
+	}
+	for _, f := range filters {
+		name := strings.ToUpper(f.Name)
+		if name == "VTODO" || (name == "VCALENDAR" && wantsTodos(f.Filters)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// hrefPath returns the path below Prefix of an href, which may be a full URL
+func hrefPath(h string) (string, bool) {
+	u, err := url.Parse(strings.TrimSpace(h))
+	if err != nil {
+		return "", false
+	}
+
+	return strings.CutPrefix(u.Path, Prefix)
+}
+
+func report(c *gin.Context, user model.User, t target) {
+	var req reportRequest
+	if !readBody(c, &req) {
+		return
+	}
+
+	names := req.Prop.names()
+	if len(names) == 0 {
+		names = []xml.Name{{Space: nsDAV, Local: "getetag"}}
+	}
+
+	responses := make([]response, 0)
+	switch req.XMLName {
+	case xml.Name{Space: nsCalDAV, Local: "calendar-query"}:
+		if t.kind != kindCalendar {
+			c.Status(http.StatusForbidden)
+			return
+		}
+		if wantsTodos(req.Filter.Filters) {
+			found, _, err := nodes(user, t, "1")
+			if err != nil {
+				c.Status(http.StatusInternalServerError)
+				return
+			}
+			for _, n := range found[1:] {
+				responses = append(responses, describe(user, n, names, false))
+			}
+		}
+	case xml.Name{Space: nsCalDAV, Local: "calendar-multiget"}:
+		for _, h := range req.Hrefs {
+			path, ok := hrefPath(h)
+			ht, found := resolve(user, path)
+			if !ok || !found || ht.kind != kindObject {
+				responses = append(responses, response{href: h, status: http.StatusNotFound})
+				continue
+			}
+			todo, err := findTodo(ht.list, ht.name)
+			if err != nil {
+				c.Status(http.StatusInternalServerError)
+				return
+			}
+			if todo.Id == 0 {
+				responses = append(responses, response{href: h, status: http.StatusNotFound})
+				continue
+			}
+			responses = append(responses, describe(user, objectNode(user, todo), names, false))
+		}
+	default:
+		c.Data(http.StatusForbidden, "application/xml; charset=utf-8", []byte(`<?xml version="1.0" encoding="utf-8"?>`+
+			`<d:error xmlns:d="DAV:"><d:supported-report/></d:error>`))
+		return
+	}
+
+	writeMultistatus(c, responses)
+}
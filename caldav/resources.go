@@ -0,0 +1,238 @@
+package caldav
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/greeneg/todoer/events"
+	"github.com/greeneg/todoer/ical"
+	"github.com/greeneg/todoer/model"
+	"github.com/greeneg/todoer/notify"
+)
+
+// precondition sends a CalDAV precondition failure
+func precondition(c *gin.Context, code int, name string) {
+	c.Data(code, "application/xml; charset=utf-8", []byte(`<?xml version="1.0" encoding="utf-8"?>`+
+		`<d:error xmlns:d="DAV:" xmlns:c="`+nsCalDAV+`"><c:`+name+`/></d:error>`))
+}
+
+// matches reports whether the If-Match and If-None-Match headers of a
+// request allow it to go ahead given the current entity tag, "" meaning the
+// resource does not exist
+func matches(c *gin.Context, etag string) bool {
+	if ifMatch := c.GetHeader("If-Match"); ifMatch != "" {
+		if etag == "" || (strings.TrimSpace(ifMatch) != "*" && !strings.Contains(ifMatch, etag)) {
+			return false
+		}
+	}
+	if ifNoneMatch := c.GetHeader("If-None-Match"); ifNoneMatch != "" {
+		if etag != "" && (strings.TrimSpace(ifNoneMatch) == "*" || strings.Contains(ifNoneMatch, etag)) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func get(c *gin.Context, user model.User, t target) {
+	switch t.kind {
+	case kindCalendar:
+		todos, err := model.GetTodosForList(t.list.Id)
+		if err != nil {
+			c.Status(http.StatusInternalServerError)
+			return
+		}
+		cal := ical.NewCalendar(t.list.Name)
+		for _, todo := range todos {
+			cal.Components = append(cal.Components, ical.FromTodo(todo))
+		}
+		var b bytes.Buffer
+		cal.Encode(&b)
+		c.Data(http.StatusOK, "text/calendar; charset=utf-8", b.Bytes())
+	case kindObject:
+		todo, err := findTodo(t.list, t.name)
+		if err != nil {
+			c.Status(http.StatusInternalServerError)
+			return
+		}
+		if todo.Id == 0 {
+			c.Status(http.StatusNotFound)
+			return
+		}
+		body, etag := render(todo)
+		c.Header("ETag", etag)
+		c.Data(http.StatusOK, "text/calendar; charset=utf-8", body)
+	default:
+		c.Status(http.StatusMethodNotAllowed)
+	}
+}
+
+// sameDate reports whether two dates, as read from the DB or a calendar,
+// are the same
+func sameDate(a string, b string) bool {
+	a, _ = model.NormalizeDate(a)
+	b, _ = model.NormalizeDate(b)
+	return a == b
+}
+
+// put stores a calendar object resource, creating or updating a todo. Only
+// the properties todos have are kept, so no entity tag is returned and
+// clients fetch the stored version
+func put(c *gin.Context, user model.User, t target) {
+	if t.kind != kindObject {
+		c.Status(http.StatusMethodNotAllowed)
+		return
+	}
+
+	existing, err := findTodo(t.list, t.name)
+	if err != nil {
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	etag := ""
+	if existing.Id != 0 {
+		_, etag = render(existing)
+	}
+	if !matches(c, etag) {
+		c.Status(http.StatusPreconditionFailed)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxBodySize))
+	if err != nil {
+		c.Status(http.StatusBadRequest)
+		return
+	}
+	cal, err := ical.Parse(bytes.NewReader(body))
+	if err != nil || cal.Name != "VCALENDAR" {
+		precondition(c, http.StatusForbidden, "valid-calendar-data")
+		return
+	}
+	vtodos := cal.Children("VTODO")
+	if len(vtodos) != 1 || len(cal.Children("VEVENT")) != 0 || len(cal.Children("VJOURNAL")) != 0 {
+		precondition(c, http.StatusForbidden, "supported-calendar-component")
+		return
+	}
+	item, err := ical.ToItem(vtodos[0])
+	if err != nil || item.Uid == "" {
+		precondition(c, http.StatusForbidden, "valid-calendar-object-resource")
+		return
+	}
+
+	other, err := model.GetTodoByUid(t.list.Id, item.Uid)
+	if err != nil {
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	if other.Id != 0 && other.Id != existing.Id {
+		precondition(c, http.StatusForbidden, "no-uid-conflict")
+		return
+	}
+
+	if existing.Id == 0 {
+		item.Proposed.ListId = t.list.Id
+		item.Proposed.Uid = item.Uid
+		item.Proposed.ResourceName = t.name
+		todo, err := model.CreateTodo(item.Proposed, user.Id)
+		if err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		notify.TodoMentions(todo, user)
+		events.Publish(events.TodoCreated, user.UserName, todo, nil)
+		if err := setStatus(user, todo, item.Status); err != nil {
+			c.Status(http.StatusInternalServerError)
+			return
+		}
+		c.Status(http.StatusCreated)
+		return
+	}
+
+	current := existing
+	p := item.Proposed
+	if p.Description != existing.Description || !sameDate(p.DueDate, existing.DueDate) ||
+		p.Priority != existing.Priority || !slices.Equal(normalizedTags(p.Tags), existing.Tags) {
+		todo, err := model.ModifyTodo(existing.Id, model.TodoUpdate{
+			Description: &p.Description,
+			DueDate:     &p.DueDate,
+			Priority:    &p.Priority,
+			Tags:        &p.Tags,
+		})
+		if err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		if todo.Description != existing.Description {
+			notify.TodoMentions(todo, user)
+		}
+		events.Publish(events.TodoUpdated, user.UserName, todo, existing)
+		current = todo
+	}
+	if err := setStatus(user, current, item.Status); err != nil {
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func normalizedTags(tags []string) []string {
+	normalized, err := model.NormalizeTags(tags)
+	if err != nil {
+		return tags
+	}
+
+	return normalized
+}
+
+// setStatus changes the status of a todo if it differs, publishing the change
+func setStatus(user model.User, before model.Todo, status string) error {
+	if before.Status == status {
+		return nil
+	}
+
+	statusId, err := model.GetStatusByName(status)
+	if err != nil {
+		return err
+	}
+	todo, err := model.UpdateTodo(before.Id, statusId)
+	if err != nil {
+		return err
+	}
+
+	events.Publish(events.TodoStatusChanged, user.UserName, todo, before)
+	return nil
+}
+
+func del(c *gin.Context, user model.User, t target) {
+	if t.kind != kindObject {
+		c.Status(http.StatusForbidden)
+		return
+	}
+
+	todo, err := findTodo(t.list, t.name)
+	if err != nil {
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	if todo.Id == 0 {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	_, etag := render(todo)
+	if !matches(c, etag) {
+		c.Status(http.StatusPreconditionFailed)
+		return
+	}
+
+	if _, err := model.DeleteTodo(todo.Id); err != nil {
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	events.Publish(events.TodoDeleted, user.UserName, todo, nil)
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,114 @@
+// Package clientcert lets clients such as service accounts authenticate with
+// TLS client certificates. Certificates are checked against a CA bundle
+// during the handshake, and a field of a verified certificate names the user
+package clientcert
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/greeneg/todoer/globals"
+	"github.com/greeneg/todoer/model"
+)
+
+// where the user name is taken from
+const (
+	FromCommonName = "cn"
+	FromEmail      = "email"
+	FromDns        = "dns"
+)
+
+var (
+	userFrom = FromCommonName
+	scopes   = []string{model.ScopeTodoWrite}
+)
+
+// Init checks the client certificate settings and returns the TLS settings
+// that ask clients for certificates, or nil when they are not asked for. A
+// relative TLSClientCaFile is taken to be in configDir
+func Init(c globals.Config, configDir string) (*tls.Config, error) {
+	var clientAuth tls.ClientAuthType
+	switch strings.ToLower(c.TLSClientAuth) {
+	case "", "none":
+		return nil, nil
+	case "optional":
+		clientAuth = tls.VerifyClientCertIfGiven
+	case "require":
+		clientAuth = tls.RequireAndVerifyClientCert
+	default:
+		return nil, errors.New("invalid tlsClientAuth value: " + c.TLSClientAuth)
+	}
+	if !c.UseTLS {
+		return nil, errors.New("tlsClientAuth requires useTls")
+	}
+
+	switch strings.ToLower(c.TLSClientUserFrom) {
+	case "", FromCommonName:
+		userFrom = FromCommonName
+	case FromEmail, FromDns:
+		userFrom = strings.ToLower(c.TLSClientUserFrom)
+	default:
+		return nil, errors.New("invalid tlsClientUserFrom value: " + c.TLSClientUserFrom)
+	}
+
+	if len(c.TLSClientScopes) > 0 {
+		for _, scope := range c.TLSClientScopes {
+			if !model.IsValidScope(scope) || scope == model.ScopeAdmin {
+				return nil, errors.New("invalid tlsClientScopes value: " + scope)
+			}
+		}
+		scopes = c.TLSClientScopes
+	}
+
+	if c.TLSClientCaFile == "" {
+		return nil, errors.New("tlsClientAuth requires tlsClientCaFile")
+	}
+	caFile := c.TLSClientCaFile
+	if !filepath.IsAbs(caFile) {
+		caFile = filepath.Join(configDir, caFile)
+	}
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, errors.New("no certificates found in " + caFile)
+	}
+
+	return &tls.Config{ClientCAs: pool, ClientAuth: clientAuth}, nil
+}
+
+// Scopes Returns what certificate logins may do
+func Scopes() []string {
+	return scopes
+}
+
+// Username Returns the user name a verified client certificate of the
+// request names, or an empty string when there is none
+func Username(r *http.Request) string {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return ""
+	}
+	cert := r.TLS.VerifiedChains[0][0]
+
+	switch userFrom {
+	case FromEmail:
+		if len(cert.EmailAddresses) > 0 {
+			return cert.EmailAddresses[0]
+		}
+	case FromDns:
+		if len(cert.DNSNames) > 0 {
+			return cert.DNSNames[0]
+		}
+	default:
+		return cert.Subject.CommonName
+	}
+
+	return ""
+}
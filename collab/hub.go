@@ -0,0 +1,325 @@
+// Package collab runs the WebSocket collaboration channel: clients subscribe
+// to lists, receive changes to them and share what they are looking at
+package collab
+
+import (
+	"encoding/json"
+	"log"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/greeneg/todoer/events"
+	"github.com/greeneg/todoer/model"
+)
+
+const (
+	// how many messages a client may fall behind before it is dropped
+	sendBuffer = 64
+
+	writeWait      = 10 * time.Second
+	pongWait       = 60 * time.Second
+	pingPeriod     = (pongWait * 9) / 10
+	maxMessageSize = 4096
+
+	// how often the user is checked to still be allowed to stay connected
+	recheckPeriod = 30 * time.Second
+)
+
+// Presence describes what a user is doing on a list
+type Presence struct {
+	UserName string `json:"userName"`
+	State    string `json:"state" enum:"viewing,editing"`
+	TodoId   int    `json:"todoId,omitempty"`
+}
+
+// clientMessage is sent by clients to subscribe to a list, leave it, or
+// update their presence on it
+type clientMessage struct {
+	Type   string `json:"type" enum:"subscribe,unsubscribe,presence"`
+	ListId int    `json:"listId"`
+	State  string `json:"state"`
+	TodoId int    `json:"todoId"`
+}
+
+// serverMessage is sent to clients
+type serverMessage struct {
+	Type     string        `json:"type" enum:"subscribed,unsubscribed,event,presence,error"`
+	ListId   int           `json:"listId,omitempty"`
+	Event    *events.Event `json:"event,omitempty"`
+	Presence []Presence    `json:"presence,omitempty"`
+	Message  string        `json:"message,omitempty"`
+}
+
+type client struct {
+	user       model.User
+	conn       *websocket.Conn
+	send       chan serverMessage
+	lists      map[int]Presence
+	closed     bool
+	authorized func() bool
+}
+
+var (
+	mtx     sync.Mutex
+	clients = make(map[*client]struct{})
+)
+
+// Init subscribes the hub to published events
+func Init() {
+	events.Subscribe(dispatch)
+}
+
+// trySend queues a message for a client without blocking. A client whose
+// buffer is full is disconnected so it cannot hold up anyone else. Must be
+// called with mtx held
+func trySend(c *client, msg serverMessage) {
+	if c.closed {
+		return
+	}
+
+	select {
+	case c.send <- msg:
+	default:
+		log.Println("WARN: Dropping slow WebSocket client for user '" + c.user.UserName + "'")
+		drop(c)
+	}
+}
+
+// drop disconnects a client. Must be called with mtx held
+func drop(c *client) {
+	if c.closed {
+		return
+	}
+	c.closed = true
+	close(c.send)
+	delete(clients, c)
+	for listId := range c.lists {
+		delete(c.lists, listId)
+		broadcastPresence(listId)
+	}
+}
+
+// presenceFor Returns everyone on a list. Must be called with mtx held
+func presenceFor(listId int) []Presence {
+	presence := make([]Presence, 0)
+	for c := range clients {
+		if p, ok := c.lists[listId]; ok {
+			presence = append(presence, p)
+		}
+	}
+	sort.Slice(presence, func(i, j int) bool {
+		return presence[i].UserName < presence[j].UserName
+	})
+
+	return presence
+}
+
+// broadcastPresence sends the presence on a list to all its subscribers.
+// Must be called with mtx held
+func broadcastPresence(listId int) {
+	msg := serverMessage{Type: "presence", ListId: listId, Presence: presenceFor(listId)}
+	for c := range clients {
+		if _, ok := c.lists[listId]; ok {
+			trySend(c, msg)
+		}
+	}
+}
+
+// affectedLists Returns the lists an event concerns
+func affectedLists(e events.Event) []int {
+	ids := make([]int, 0, 2)
+	for _, resource := range []any{e.Data, e.Previous} {
+		switch r := resource.(type) {
+		case model.Todo:
+			if r.ListId != 0 {
+				ids = append(ids, r.ListId)
+			}
+		case model.List:
+			ids = append(ids, r.Id)
+		}
+	}
+
+	return ids
+}
+
+func dispatch(e events.Event) {
+	listIds := affectedLists(e)
+	if len(listIds) == 0 {
+		return
+	}
+
+	// membership may have changed, so check who can still see the list
+	// before taking the lock
+	revoked := make(map[*client][]int)
+	if e.Type == events.ListUpdated || e.Type == events.ListDeleted {
+		mtx.Lock()
+		subscribed := make(map[*client]bool)
+		for c := range clients {
+			if _, ok := c.lists[listIds[0]]; ok {
+				subscribed[c] = true
+			}
+		}
+		mtx.Unlock()
+		for c := range subscribed {
+			if e.Type == events.ListDeleted || !model.CanViewList(c.user.Id, listIds[0]) {
+				revoked[c] = append(revoked[c], listIds[0])
+			}
+		}
+	}
+
+	mtx.Lock()
+	defer mtx.Unlock()
+	for c := range clients {
+		for _, listId := range listIds {
+			if _, ok := c.lists[listId]; ok {
+				trySend(c, serverMessage{Type: "event", ListId: listId, Event: &e})
+				break
+			}
+		}
+	}
+	for c, ids := range revoked {
+		for _, listId := range ids {
+			if _, ok := c.lists[listId]; ok {
+				delete(c.lists, listId)
+				trySend(c, serverMessage{Type: "unsubscribed", ListId: listId})
+				broadcastPresence(listId)
+			}
+		}
+	}
+}
+
+func (c *client) handle(msg clientMessage) {
+	switch msg.Type {
+	case "subscribe":
+		if !model.CanViewList(c.user.Id, msg.ListId) {
+			mtx.Lock()
+			trySend(c, serverMessage{Type: "error", ListId: msg.ListId,
+				Message: "no records found with list id " + strconv.Itoa(msg.ListId)})
+			mtx.Unlock()
+			return
+		}
+
+		mtx.Lock()
+		defer mtx.Unlock()
+		c.lists[msg.ListId] = Presence{UserName: c.user.UserName, State: "viewing"}
+		trySend(c, serverMessage{Type: "subscribed", ListId: msg.ListId})
+		broadcastPresence(msg.ListId)
+	case "unsubscribe":
+		mtx.Lock()
+		defer mtx.Unlock()
+		if _, ok := c.lists[msg.ListId]; ok {
+			delete(c.lists, msg.ListId)
+			trySend(c, serverMessage{Type: "unsubscribed", ListId: msg.ListId})
+			broadcastPresence(msg.ListId)
+		}
+	case "presence":
+		mtx.Lock()
+		defer mtx.Unlock()
+		if _, ok := c.lists[msg.ListId]; !ok {
+			trySend(c, serverMessage{Type: "error", ListId: msg.ListId, Message: "not subscribed to this list"})
+			return
+		}
+		if msg.State != "viewing" && msg.State != "editing" {
+			trySend(c, serverMessage{Type: "error", ListId: msg.ListId,
+				Message: "presence state must be either 'viewing' or 'editing'"})
+			return
+		}
+		c.lists[msg.ListId] = Presence{UserName: c.user.UserName, State: msg.State, TodoId: msg.TodoId}
+		broadcastPresence(msg.ListId)
+	default:
+		mtx.Lock()
+		defer mtx.Unlock()
+		trySend(c, serverMessage{Type: "error", Message: "unknown message type '" + msg.Type + "'"})
+	}
+}
+
+// writePump sends queued messages and keep-alive pings until the client is
+// dropped, or closes the connection once the user may no longer keep it
+func (c *client) writePump() {
+	ticker := time.NewTicker(pingPeriod)
+	recheck := time.NewTicker(recheckPeriod)
+	defer func() {
+		ticker.Stop()
+		recheck.Stop()
+		c.conn.Close()
+	}()
+
+	for {
+		select {
+		case msg, open := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if !open {
+				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+				return
+			}
+			if err := c.conn.WriteJSON(msg); err != nil {
+				return
+			}
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		case <-recheck.C:
+			if !c.authorized() {
+				// closing the connection ends the read loop of Serve, which
+				// drops the client
+				log.Println("INFO: Closing WebSocket client for user '" + c.user.UserName + "': no longer authorized")
+				c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+				c.conn.WriteMessage(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "not authorized"))
+				return
+			}
+		}
+	}
+}
+
+// Serve runs a connected client until it disconnects, or until authorized
+// reports that the user may no longer stay connected
+func Serve(conn *websocket.Conn, user model.User, authorized func() bool) {
+	c := &client{
+		user:       user,
+		conn:       conn,
+		send:       make(chan serverMessage, sendBuffer),
+		lists:      make(map[int]Presence),
+		authorized: authorized,
+	}
+	mtx.Lock()
+	clients[c] = struct{}{}
+	mtx.Unlock()
+
+	go c.writePump()
+	defer func() {
+		mtx.Lock()
+		drop(c)
+		mtx.Unlock()
+	}()
+
+	conn.SetReadLimit(maxMessageSize)
+	conn.SetReadDeadline(time.Now().Add(pongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			if _, ok := err.(*websocket.CloseError); !ok {
+				log.Println("INFO: WebSocket client for user '" + user.UserName + "' disconnected: " + err.Error())
+			}
+			return
+		}
+
+		var msg clientMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			mtx.Lock()
+			trySend(c, serverMessage{Type: "error", Message: "invalid message: " + err.Error()})
+			mtx.Unlock()
+			continue
+		}
+		c.handle(msg)
+	}
+}
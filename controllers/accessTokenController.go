@@ -0,0 +1,181 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/greeneg/todoer/model"
+	"github.com/gin-gonic/gin"
+)
+
+// AccessTokenPrefix starts every personal access token, so that leaked
+// tokens are easy to spot
+const AccessTokenPrefix = "todoer_"
+
+// validateAccessToken Returns an error message if the proposed token is
+// unusable, and normalizes its expiry date
+func validateAccessToken(p *model.ProposedAccessToken) string {
+	p.Name = strings.TrimSpace(p.Name)
+	if p.Name == "" {
+		return "access token must have a name"
+	}
+	if len(p.Scopes) == 0 {
+		return "access token must have at least one scope"
+	}
+	for _, scope := range p.Scopes {
+		if !model.IsValidScope(scope) {
+			return "unknown scope '" + scope + "'"
+		}
+	}
+
+	expiryDate, err := model.NormalizeDate(p.ExpiryDate)
+	if err != nil {
+		return "invalid expiry date: " + p.ExpiryDate
+	}
+	if expiryDate != "" && expiryDate <= model.Now() {
+		return "expiry date must be in the future"
+	}
+	p.ExpiryDate = expiryDate
+
+	return ""
+}
+
+// tokenOwner Returns the user named in the request path if it is the
+// logged in user. Tokens are personal, so while admins may list and revoke
+// the tokens of others, only the user creates them
+func (g *TodoerService) tokenOwner(c *gin.Context) (model.User, bool) {
+	user, authed := g.GetUserId(c)
+	if !authed || user.UserName != c.Param("name") {
+		c.IndentedJSON(http.StatusForbidden, gin.H{"error": "Insufficient access. Access denied!"})
+		return model.User{}, false
+	}
+
+	return user, true
+}
+
+// tokenUser Returns the user named in the request path, whose tokens an
+// admin or the user themself may see and revoke
+func (g *TodoerService) tokenUser(c *gin.Context) (model.User, bool) {
+	if _, authed := g.GetUserId(c); !authed {
+		c.IndentedJSON(http.StatusForbidden, gin.H{"error": "Insufficient access. Access denied!"})
+		return model.User{}, false
+	}
+
+	return pathUser(c)
+}
+
+// CreateAccessToken Create a personal access token
+//
+//	@Summary		Create a personal access token
+//	@Description	Create a token for use as "Authorization: Bearer <token>". The token is returned once and only its hash is kept
+//	@Tags			user
+//	@Accept			json
+//	@Produce		json
+//	@Param			name	path	string						true	"User name"
+//	@Param			token	body	model.ProposedAccessToken	true	"Token Data"
+//	@Security		BasicAuth
+//	@Success		200	{object}	model.AccessTokenCreatedMsg
+//	@Failure		400	{object}	model.FailureMsg
+//	@Failure		403	{object}	model.FailureMsg
+//	@Router			/user/{name}/tokens [post]
+func (g *TodoerService) CreateAccessToken(c *gin.Context) {
+	user, ok := g.tokenOwner(c)
+	if !ok {
+		return
+	}
+
+	var json model.ProposedAccessToken
+	if err := c.ShouldBindJSON(&json); err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if msg := validateAccessToken(&json); msg != "" {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+
+	secret, err := randomToken()
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+		return
+	}
+	token := AccessTokenPrefix + secret
+
+	accessToken, err := model.CreateAccessToken(user.Id, json, model.HashToken(token))
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, model.AccessTokenCreatedMsg{
+		Message:     "Access token '" + accessToken.Name + "' has been created",
+		Token:       token,
+		AccessToken: accessToken,
+	})
+}
+
+// GetAccessTokens Retrieve the personal access tokens of a user
+//
+//	@Summary		Retrieve the personal access tokens of a user
+//	@Description	Retrieve the personal access tokens of a user, expired ones included
+//	@Tags			user
+//	@Produce		json
+//	@Param			name	path	string	true	"User name"
+//	@Security		BasicAuth
+//	@Success		200	{object}	model.AccessTokenList
+//	@Failure		403	{object}	model.FailureMsg
+//	@Router			/user/{name}/tokens [get]
+func (g *TodoerService) GetAccessTokens(c *gin.Context) {
+	user, ok := g.tokenUser(c)
+	if !ok {
+		return
+	}
+
+	tokens, err := model.GetAccessTokensForUser(user.Id)
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, gin.H{"data": tokens})
+}
+
+// DeleteAccessToken Revoke a personal access token
+//
+//	@Summary		Revoke a personal access token
+//	@Description	Revoke a personal access token
+//	@Tags			user
+//	@Produce		json
+//	@Param			name	path	string	true	"User name"
+//	@Param			id		path	int		true	"Token Id"
+//	@Security		BasicAuth
+//	@Success		200	{object}	model.SuccessMsg
+//	@Failure		400	{object}	model.FailureMsg
+//	@Failure		403	{object}	model.FailureMsg
+//	@Router			/user/{name}/tokens/{id} [delete]
+func (g *TodoerService) DeleteAccessToken(c *gin.Context) {
+	user, ok := g.tokenUser(c)
+	if !ok {
+		return
+	}
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "invalid token id"})
+		return
+	}
+
+	status, err := model.DeleteAccessToken(user.Id, id)
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+		return
+	}
+
+	idString := strconv.Itoa(id)
+	if status {
+		c.IndentedJSON(http.StatusOK, gin.H{"message": "Access token " + idString + " has been revoked"})
+	} else {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "no records found with token id " + idString})
+	}
+}
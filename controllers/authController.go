@@ -0,0 +1,143 @@
+package controllers
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/greeneg/todoer/globals"
+	"github.com/greeneg/todoer/helpers"
+	"github.com/greeneg/todoer/lockout"
+	"github.com/greeneg/todoer/model"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+)
+
+// currentUser Returns the user as shown to themselves
+func currentUser(u model.User) CurrentUser {
+	return CurrentUser{
+		Id:              u.Id,
+		UserName:        u.UserName,
+		FullName:        u.FullName,
+		Email:           u.Email,
+		Status:          u.Status,
+		OrgUnitId:       u.OrgUnitId,
+		CreationDate:    u.CreationDate,
+		LastChangedDate: u.LastChangedDate,
+	}
+}
+
+// Login Start a session
+//
+//	@Summary		Start a session
+//	@Description	Check a user name and password and start a session, returned as the todoer-session cookie. Users with two-factor authentication also send a one-time password or recovery code as code. Users whose password is older than the maximum password age must change it first
+//	@Tags			auth
+//	@Accept			json
+//	@Produce		json
+//	@Param			credentials	body	model.Credentials	true	"User name and password"
+//	@Success		200	{object}	LoginMsg
+//	@Failure		400	{object}	model.FailureMsg
+//	@Failure		401	{object}	model.FailureMsg
+//	@Failure		403	{object}	model.FailureMsg
+//	@Failure		429	{object}	model.FailureMsg
+//	@Router			/login [post]
+func (g *TodoerService) Login(c *gin.Context) {
+	var json model.Credentials
+	if err := c.ShouldBindJSON(&json); err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := lockout.Wait(json.UserName, c.ClientIP()); err != nil {
+		c.IndentedJSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+		return
+	}
+	if helpers.EmptyUserPass(json.UserName, json.Password) || !helpers.CheckUserPass(json.UserName, json.Password) {
+		log.Println("ERROR: Login failed for user '" + json.UserName + "'")
+		lockout.Failed(json.UserName, c.ClientIP())
+		c.IndentedJSON(http.StatusUnauthorized, gin.H{"error": "invalid user name or password"})
+		return
+	}
+
+	user, err := model.GetUserByUserName(json.UserName)
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+		return
+	}
+
+	twoFactor, err := model.TwoFactorEnabled(user.Id)
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+		return
+	}
+	if twoFactor && json.Code == "" {
+		c.IndentedJSON(http.StatusUnauthorized, gin.H{"error": "two-factor code required", "twoFactorRequired": true})
+		return
+	}
+	if twoFactor && !helpers.CheckSecondFactor(user, json.Code) {
+		log.Println("ERROR: Login failed for user '" + json.UserName + "': invalid two-factor code")
+		lockout.Failed(json.UserName, c.ClientIP())
+		c.IndentedJSON(http.StatusUnauthorized, gin.H{"error": "invalid two-factor code"})
+		return
+	}
+
+	lockout.Succeeded(user.UserName)
+	if helpers.PasswordExpired(user, json.Password) {
+		log.Println("WARN: Password of user '" + user.UserName + "' has expired")
+		c.IndentedJSON(http.StatusForbidden, gin.H{"error": "password has expired; change it with PATCH /api/v1/user/" +
+			user.UserName, "passwordExpired": true})
+		return
+	}
+
+	// the store issues a new session Id when the user changes
+	session := sessions.Default(c)
+	session.Clear()
+	session.Set(globals.UserKey, user.UserName)
+	if err := session.Save(); err != nil {
+		log.Println("ERROR: Cannot save session: " + string(err.Error()))
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "failed to save user session"})
+		return
+	}
+
+	log.Println("INFO: User '" + user.UserName + "' logged in")
+	c.IndentedJSON(http.StatusOK, LoginMsg{Message: "Logged in as '" + user.UserName + "'", User: currentUser(user)})
+}
+
+// Logout End the current session
+//
+//	@Summary		End the current session
+//	@Description	End the current session and clear its cookie
+//	@Tags			auth
+//	@Produce		json
+//	@Success		200	{object}	model.SuccessMsg
+//	@Router			/logout [post]
+func (g *TodoerService) Logout(c *gin.Context) {
+	session := sessions.Default(c)
+	session.Clear()
+	session.Options(sessions.Options{Path: "/", MaxAge: -1})
+	if err := session.Save(); err != nil {
+		log.Println("ERROR: Cannot delete session: " + string(err.Error()))
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "failed to end user session"})
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, gin.H{"message": "Logged out"})
+}
+
+// GetMe Retrieve the logged in user
+//
+//	@Summary		Retrieve the logged in user
+//	@Description	Retrieve the logged in user
+//	@Tags			auth
+//	@Produce		json
+//	@Security		BasicAuth
+//	@Success		200	{object}	CurrentUser
+//	@Failure		403	{object}	model.FailureMsg
+//	@Router			/me [get]
+func (g *TodoerService) GetMe(c *gin.Context) {
+	user, authed := g.GetUserId(c)
+	if authed {
+		c.IndentedJSON(http.StatusOK, currentUser(user))
+	} else {
+		c.IndentedJSON(http.StatusForbidden, gin.H{"error": "Insufficient access. Access denied!"})
+	}
+}
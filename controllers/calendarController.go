@@ -0,0 +1,218 @@
+package controllers
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/greeneg/todoer/events"
+	"github.com/greeneg/todoer/helpers"
+	"github.com/greeneg/todoer/ical"
+	"github.com/greeneg/todoer/model"
+	"github.com/gin-gonic/gin"
+)
+
+// the largest calendar file accepted for import
+const maxCalendarSize = 10 << 20
+
+// GetCalendarFeed Retrieve the todos of a user as an iCalendar feed
+//
+//	@Summary		Retrieve calendar feed
+//	@Description	Renders the todos with due dates the owner of the feed token can see as RFC 5545 VTODO components, for subscribing to from calendar applications. Authenticated by the feed token rather than a login
+//	@Tags			calendar
+//	@Produce		text/calendar
+//	@Param			token	query	string	true	"Feed token"
+//	@Success		200	{string}	string
+//	@Failure		403	{object}	model.FailureMsg
+//	@Router			/calendar.ics [get]
+func (g *TodoerService) GetCalendarFeed(c *gin.Context) {
+	token := c.Query("token")
+	if token == "" {
+		c.IndentedJSON(http.StatusForbidden, gin.H{"error": "Insufficient access. Access denied!"})
+		return
+	}
+
+	user, err := model.GetUserByFeedToken(model.HashToken(token))
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+		return
+	}
+	if user.UserName == "" || !helpers.CheckIsNotLocked(user) {
+		c.IndentedJSON(http.StatusForbidden, gin.H{"error": "Insufficient access. Access denied!"})
+		return
+	}
+
+	todos, err := model.GetTodosForUser(user.Id)
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+		return
+	}
+
+	cal := ical.NewCalendar("todoer: " + user.UserName)
+	for _, todo := range todos {
+		if todo.DueDate != "" {
+			cal.Components = append(cal.Components, ical.FromTodo(todo))
+		}
+	}
+
+	c.Header("Content-Type", "text/calendar; charset=utf-8")
+	c.Header("Content-Disposition", "inline; filename=\"todoer.ics\"")
+	c.Status(http.StatusOK)
+	cal.Encode(c.Writer)
+}
+
+// CreateFeedToken Create or replace the calendar feed token of the current user
+//
+//	@Summary		Create calendar feed token
+//	@Description	Creates a secret token for the calendar feed of the current user, replacing any previous one. The token is only shown once
+//	@Tags			calendar
+//	@Produce		json
+//	@Security		BasicAuth
+//	@Success		200	{object}	model.FeedTokenMsg
+//	@Failure		400	{object}	model.FailureMsg
+//	@Router			/calendar/token [post]
+func (g *TodoerService) CreateFeedToken(c *gin.Context) {
+	user, authed := g.GetUserId(c)
+	if authed {
+		token, err := randomToken()
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+			return
+		}
+
+		if err := model.SetFeedToken(user.Id, model.HashToken(token)); err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, model.FeedTokenMsg{
+			Message: "Calendar feed token has been created",
+			Token:   token,
+			Path:    "/api/v1/calendar.ics?token=" + token,
+		})
+	} else {
+		c.IndentedJSON(http.StatusForbidden, gin.H{"error": "Insufficient access. Access denied!"})
+	}
+}
+
+// DeleteFeedToken Revoke the calendar feed token of the current user
+//
+//	@Summary		Revoke calendar feed token
+//	@Description	Revokes the calendar feed token of the current user
+//	@Tags			calendar
+//	@Produce		json
+//	@Security		BasicAuth
+//	@Success		200	{object}	model.SuccessMsg
+//	@Failure		400	{object}	model.FailureMsg
+//	@Router			/calendar/token [delete]
+func (g *TodoerService) DeleteFeedToken(c *gin.Context) {
+	user, authed := g.GetUserId(c)
+	if authed {
+		status, err := model.DeleteFeedToken(user.Id)
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+			return
+		}
+
+		if status {
+			c.IndentedJSON(http.StatusOK, gin.H{"message": "Calendar feed token has been revoked"})
+		} else {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "no calendar feed token to revoke"})
+		}
+	} else {
+		c.IndentedJSON(http.StatusForbidden, gin.H{"error": "Insufficient access. Access denied!"})
+	}
+}
+
+// uploadedFile Returns an uploaded file, sent either as the "file" field of a
+// multipart form or as the request body
+func uploadedFile(c *gin.Context, maxSize int64) ([]byte, error) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxSize)
+	if strings.HasPrefix(c.ContentType(), "multipart/") {
+		header, err := c.FormFile("file")
+		if err != nil {
+			return nil, err
+		}
+		f, err := header.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return io.ReadAll(f)
+	}
+
+	return io.ReadAll(c.Request.Body)
+}
+
+// ImportCalendar Import the VTODO items of an iCalendar file into a list
+//
+//	@Summary		Import calendar
+//	@Description	Creates a todo on the given list for every VTODO in an uploaded .ics file, sent as the "file" field of a form or as the request body. Items that cannot be read are reported and skipped
+//	@Tags			calendar
+//	@Accept			text/calendar
+//	@Accept			multipart/form-data
+//	@Produce		json
+//	@Param			listId	query	int		true	"List Id"
+//	@Param			file	formData	file	false	"iCalendar file"
+//	@Security		BasicAuth
+//	@Success		200	{object}	model.ImportResult
+//	@Failure		400	{object}	model.FailureMsg
+//	@Router			/calendar/import [post]
+func (g *TodoerService) ImportCalendar(c *gin.Context) {
+	user, authed := g.GetUserId(c)
+	if !authed {
+		c.IndentedJSON(http.StatusForbidden, gin.H{"error": "Insufficient access. Access denied!"})
+		return
+	}
+
+	listId, _ := strconv.Atoi(c.Query("listId"))
+	if listId == 0 || !model.CanViewList(user.Id, listId) {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "no records found with list id " + c.Query("listId")})
+		return
+	}
+
+	data, err := uploadedFile(c, maxCalendarSize)
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "Unable to read calendar file: " + err.Error()})
+		return
+	}
+	cal, err := ical.Parse(bytes.NewReader(data))
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "Invalid calendar file: " + err.Error()})
+		return
+	}
+
+	items, itemErrors := ical.Items(cal)
+	result := model.ImportResult{Errors: make([]model.ImportError, 0)}
+	for _, e := range itemErrors {
+		result.Errors = append(result.Errors, model.ImportError{Item: e.Uid, Error: e.Error()})
+	}
+	for _, item := range items {
+		item.Proposed.ListId = listId
+		todo, err := model.CreateTodo(item.Proposed, user.Id)
+		if err != nil {
+			result.Errors = append(result.Errors, model.ImportError{Item: item.Uid, Error: err.Error()})
+			continue
+		}
+		events.Publish(events.TodoCreated, user.UserName, todo, nil)
+
+		if item.Status != "new" {
+			statusId, err := model.GetStatusByName(item.Status)
+			if err == nil {
+				var updated model.Todo
+				if updated, err = model.UpdateTodo(todo.Id, statusId); err == nil {
+					events.Publish(events.TodoStatusChanged, user.UserName, updated, todo)
+				}
+			}
+			if err != nil {
+				result.Errors = append(result.Errors, model.ImportError{Item: item.Uid, Error: err.Error()})
+			}
+		}
+		result.Imported++
+	}
+
+	result.Message = strconv.Itoa(result.Imported) + " todos have been imported"
+	c.IndentedJSON(http.StatusOK, result)
+}
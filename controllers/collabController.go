@@ -0,0 +1,50 @@
+package controllers
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+
+	"github.com/greeneg/todoer/collab"
+)
+
+// Collaborate Open the WebSocket collaboration channel
+//
+//	@Summary		Open the collaboration channel
+//	@Description	Upgrades to a WebSocket. Clients send {"type":"subscribe","listId":N} to receive changes to a list and the presence of other users on it, {"type":"presence","listId":N,"state":"editing","todoId":M} to share what they are doing, and {"type":"unsubscribe","listId":N} to leave. The connection is closed once the user is locked or the session it was opened with is revoked
+//	@Tags			event
+//	@Security		BasicAuth
+//	@Success		101
+//	@Failure		403	{object}	model.FailureMsg
+//	@Router			/ws [get]
+func (g *TodoerService) Collaborate(c *gin.Context) {
+	user, authed := g.GetUserId(c)
+	if !authed {
+		c.IndentedJSON(http.StatusForbidden, gin.H{"error": "Insufficient access. Access denied!"})
+		return
+	}
+
+	upgrader := websocket.Upgrader{}
+	if len(g.ConfStruct.WebSocketOrigins) > 0 {
+		upgrader.CheckOrigin = func(r *http.Request) bool {
+			origin := r.Header.Get("Origin")
+			for _, allowed := range g.ConfStruct.WebSocketOrigins {
+				if origin == allowed {
+					return true
+				}
+			}
+			return false
+		}
+	}
+
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		// the upgrader has already written an error response
+		log.Println("ERROR: WebSocket upgrade failed: " + string(err.Error()))
+		return
+	}
+
+	collab.Serve(conn, user, stillAuthorized(c, user))
+}
@@ -0,0 +1,184 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/greeneg/todoer/model"
+	"github.com/gin-gonic/gin"
+)
+
+// visibleTodo Returns the todo named by the id path parameter, answering the
+// request itself and returning false if the id is malformed or the user
+// cannot see the todo. Todos of other users and org units are answered as
+// not found, so that their existence is not revealed
+func visibleTodo(c *gin.Context, user model.User) (model.Todo, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "invalid todo id '" + c.Param("id") + "'"})
+		return model.Todo{}, false
+	}
+	todo, err := model.GetTodoById(id)
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+		return model.Todo{}, false
+	}
+	if todo.Description == "" || !model.CanViewTodo(user.Id, todo) {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"error": "no records found with todo id " + strconv.Itoa(id)})
+		return model.Todo{}, false
+	}
+
+	return todo, true
+}
+
+// GetComments Retrieve the comments on a todo
+//
+//	@Summary		Retrieve comments
+//	@Description	Retrieve the comments on a todo, oldest first
+//	@Tags			todo
+//	@Produce		json
+//	@Param			id	path int true "Todo ID"
+//	@Security		BasicAuth
+//	@Success		200	{object}	model.CommentList
+//	@Failure		400	{object}	model.FailureMsg
+//	@Failure		404	{object}	model.FailureMsg
+//	@Router			/todo/{id}/comments [get]
+func (g *TodoerService) GetComments(c *gin.Context) {
+	user, authed := g.GetUserId(c)
+	if authed {
+		todo, found := visibleTodo(c, user)
+		if !found {
+			return
+		}
+
+		comments, err := model.GetCommentsForTodo(todo.Id)
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+			return
+		}
+		c.IndentedJSON(http.StatusOK, gin.H{"data": comments})
+	} else {
+		c.IndentedJSON(http.StatusForbidden, gin.H{"error": "Insufficient access. Access denied!"})
+	}
+}
+
+// CreateComment Add a comment to a todo
+//
+//	@Summary		Add comment
+//	@Description	Add a comment by the current user to a todo
+//	@Tags			todo
+//	@Accept			json
+//	@Produce		json
+//	@Param			id	path int true "Todo ID"
+//	@Param			comment	body	model.ProposedComment	true	"Comment Data"
+//	@Security		BasicAuth
+//	@Success		200	{object}	model.Comment
+//	@Failure		400	{object}	model.FailureMsg
+//	@Failure		404	{object}	model.FailureMsg
+//	@Router			/todo/{id}/comments [post]
+func (g *TodoerService) CreateComment(c *gin.Context) {
+	user, authed := g.GetUserId(c)
+	if authed {
+		todo, found := visibleTodo(c, user)
+		if !found {
+			return
+		}
+
+		var json model.ProposedComment
+		if err := c.ShouldBindJSON(&json); err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		comment, err := model.CreateComment(todo.Id, json, user.Id)
+		if err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": string(err.Error())})
+			return
+		}
+		c.IndentedJSON(http.StatusOK, comment)
+	} else {
+		c.IndentedJSON(http.StatusForbidden, gin.H{"error": "Insufficient access. Access denied!"})
+	}
+}
+
+// DeleteComment Remove a comment from a todo
+//
+//	@Summary		Delete comment
+//	@Description	Delete a comment. Only its author may delete it
+//	@Tags			todo
+//	@Produce		json
+//	@Param			id	path int true "Todo ID"
+//	@Param			commentId	path int true "Comment ID"
+//	@Security		BasicAuth
+//	@Success		200	{object}	model.SuccessMsg
+//	@Failure		400	{object}	model.FailureMsg
+//	@Failure		403	{object}	model.FailureMsg
+//	@Failure		404	{object}	model.FailureMsg
+//	@Router			/todo/{id}/comments/{commentId} [delete]
+func (g *TodoerService) DeleteComment(c *gin.Context) {
+	user, authed := g.GetUserId(c)
+	if authed {
+		todo, found := visibleTodo(c, user)
+		if !found {
+			return
+		}
+
+		id, _ := strconv.Atoi(c.Param("commentId"))
+		comment, err := model.GetCommentById(id)
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+			return
+		}
+		if comment.Id == 0 || comment.TodoId != todo.Id {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "no records found with comment id " + strconv.Itoa(id)})
+			return
+		}
+		if comment.AuthorId != user.Id {
+			c.IndentedJSON(http.StatusForbidden, gin.H{"error": "Insufficient access. Access denied!"})
+			return
+		}
+
+		status, err := model.DeleteComment(id)
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+			return
+		}
+		if status {
+			c.IndentedJSON(http.StatusOK, gin.H{"message": "Comment " + strconv.Itoa(id) + " has been deleted"})
+		} else {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "no records found with comment id " + strconv.Itoa(id)})
+		}
+	} else {
+		c.IndentedJSON(http.StatusForbidden, gin.H{"error": "Insufficient access. Access denied!"})
+	}
+}
+
+// GetTodoHistory Retrieve the change history of a todo
+//
+//	@Summary		Retrieve todo history
+//	@Description	Retrieve the recorded changes to the fields of a todo, oldest first
+//	@Tags			todo
+//	@Produce		json
+//	@Param			id	path int true "Todo ID"
+//	@Security		BasicAuth
+//	@Success		200	{object}	model.HistoryList
+//	@Failure		400	{object}	model.FailureMsg
+//	@Failure		404	{object}	model.FailureMsg
+//	@Router			/todo/{id}/history [get]
+func (g *TodoerService) GetTodoHistory(c *gin.Context) {
+	user, authed := g.GetUserId(c)
+	if authed {
+		todo, found := visibleTodo(c, user)
+		if !found {
+			return
+		}
+
+		history, err := model.GetTodoHistory(todo.Id)
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+			return
+		}
+		c.IndentedJSON(http.StatusOK, gin.H{"data": history})
+	} else {
+		c.IndentedJSON(http.StatusForbidden, gin.H{"error": "Insufficient access. Access denied!"})
+	}
+}
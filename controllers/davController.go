@@ -0,0 +1,19 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/greeneg/todoer/caldav"
+	"github.com/gin-gonic/gin"
+)
+
+// Dav Answer a CalDAV request. CalDAV methods are not part of the REST API,
+// so this handler is not documented in swagger
+func (g *TodoerService) Dav(c *gin.Context) {
+	user, authed := g.GetUserId(c)
+	if authed {
+		caldav.Serve(c, user)
+	} else {
+		c.IndentedJSON(http.StatusForbidden, gin.H{"error": "Insufficient access. Access denied!"})
+	}
+}
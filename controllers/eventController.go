@@ -0,0 +1,101 @@
+package controllers
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-contrib/sse"
+	"github.com/gin-gonic/gin"
+
+	"github.com/greeneg/todoer/eventlog"
+	"github.com/greeneg/todoer/events"
+	"github.com/greeneg/todoer/model"
+)
+
+// how often a comment is sent to keep idle connections open through proxies,
+// and the user checked to still be allowed to receive events
+const keepAliveInterval = 30 * time.Second
+
+// todoEventVisible reports whether the event concerns a todo the user can
+// see, either before or after the change
+func todoEventVisible(userId int, e events.Event) bool {
+	if !strings.HasPrefix(e.Type, "todo.") {
+		return false
+	}
+	if todo, ok := e.Data.(model.Todo); ok && model.CanViewTodo(userId, todo) {
+		return true
+	}
+	if todo, ok := e.Previous.(model.Todo); ok && model.CanViewTodo(userId, todo) {
+		return true
+	}
+
+	return false
+}
+
+// StreamEvents Stream live todo changes as Server-Sent Events
+//
+//	@Summary		Stream live todo changes
+//	@Description	Streams todo.created, todo.updated, todo.status_changed and todo.deleted events for todos the user can see as Server-Sent Events. Reconnecting clients send Last-Event-ID to resume; if the events after it are no longer retained a "reset" event is sent and the client should refetch its todos. The stream is closed once the user is locked or the session it was opened with is revoked
+//	@Tags			event
+//	@Produce		text/event-stream
+//	@Param			Last-Event-ID	header	string	false	"Id of the last event received"
+//	@Security		BasicAuth
+//	@Success		200	{object}	events.Event
+//	@Failure		403	{object}	model.FailureMsg
+//	@Router			/events [get]
+func (g *TodoerService) StreamEvents(c *gin.Context) {
+	user, authed := g.GetUserId(c)
+	if !authed {
+		c.IndentedJSON(http.StatusForbidden, gin.H{"error": "Insufficient access. Access denied!"})
+		return
+	}
+
+	authorized := stillAuthorized(c, user)
+	backlog, ch, complete := eventlog.Subscribe(c.GetHeader("Last-Event-ID"))
+	defer eventlog.Unsubscribe(ch)
+
+	c.Header("Content-Type", sse.ContentType)
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	if !complete {
+		c.Render(-1, sse.Event{Event: "reset", Data: gin.H{"message": "events were missed, refetch todos"}})
+	}
+	for _, entry := range backlog {
+		if todoEventVisible(user.Id, entry.Event) {
+			c.Render(-1, sse.Event{Id: entry.Id(), Event: entry.Event.Type, Data: entry.Event})
+		}
+	}
+	c.Writer.Flush()
+
+	ticker := time.NewTicker(keepAliveInterval)
+	defer ticker.Stop()
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case entry, open := <-ch:
+			if !open {
+				// we fell too far behind; the client will reconnect and resume
+				return false
+			}
+			if todoEventVisible(user.Id, entry.Event) {
+				c.Render(-1, sse.Event{Id: entry.Id(), Event: entry.Event.Type, Data: entry.Event})
+			}
+			return true
+		case <-ticker.C:
+			// the user may have been locked or signed out since connecting
+			if !authorized() {
+				log.Println("INFO: Closing event stream of user '" + user.UserName + "': no longer authorized")
+				return false
+			}
+			io.WriteString(w, ": keep-alive\n\n")
+			return true
+		case <-c.Request.Context().Done():
+			return false
+		}
+	})
+}
@@ -1,12 +1,17 @@
 package controllers
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
 	"log"
 	"net/http"
 	"strconv"
 
+	"github.com/greeneg/todoer/helpers"
 	"github.com/greeneg/todoer/model"
+	"github.com/greeneg/todoer/passpolicy"
+	"github.com/greeneg/todoer/sessionstore"
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
 )
@@ -35,3 +40,102 @@ func (g *TodoerService) GetUserId(c *gin.Context) (model.User, bool) {
 	log.Println("INFO: Session user's ID: " + strconv.Itoa(userObject.Id))
 	return userObject, true
 }
+
+// pathUser Returns the user named by the :name path parameter, answering
+// the request itself when there is no such user
+func pathUser(c *gin.Context) (model.User, bool) {
+	username := c.Param("name")
+	user, err := model.GetUserByUserName(username)
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+		return model.User{}, false
+	}
+	if user.UserName == "" {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"error": "no user found with name " + username})
+		return model.User{}, false
+	}
+
+	return user, true
+}
+
+// safeUser Returns the user without the password hash
+func safeUser(u model.User) SafeUser {
+	return SafeUser{
+		Id:           u.Id,
+		UserName:     u.UserName,
+		Status:       u.Status,
+		OrgUnitId:    u.OrgUnitId,
+		CreationDate: u.CreationDate,
+	}
+}
+
+// isAdmin reports whether the user has the admin role
+func isAdmin(u model.User) (bool, error) {
+	return model.UserHasRole(u.Id, model.RoleAdmin)
+}
+
+// canSeeUser reports whether the actor may see the user. Users of other org
+// units are hidden from everyone but admins
+func canSeeUser(actor model.User, u model.User) (bool, error) {
+	if actor.OrgUnitId == u.OrgUnitId {
+		return true, nil
+	}
+
+	return isAdmin(actor)
+}
+
+// randomToken Returns 32 random bytes, hex encoded, for use as a secret
+func randomToken() (string, error) {
+	token := make([]byte, 32)
+	if _, err := rand.Read(token); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(token), nil
+}
+
+// passwordAccepted Reports whether a new password meets the password policy,
+// answering the request with every rule it breaks when not. The history rule
+// is only checked for existing users, with a non-zero userId
+func passwordAccepted(c *gin.Context, userId int, password string) bool {
+	violations := passpolicy.Check(password)
+	if userId != 0 {
+		history, err := passpolicy.CheckHistory(userId, password)
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+			return false
+		}
+		violations = append(violations, history...)
+	}
+	if len(violations) > 0 {
+		c.IndentedJSON(http.StatusBadRequest, model.PasswordPolicyFailureMsg{
+			Error:      "password does not meet the password policy",
+			Violations: violations,
+		})
+		return false
+	}
+
+	return true
+}
+
+// stillAuthorized Returns a check for long-lived connections, such as event
+// streams and WebSockets, that reports whether the user who opened one may
+// keep it open: they must still exist and not be locked, and the login
+// session the connection was opened with, if any, must not have been revoked
+// or have expired
+func stillAuthorized(c *gin.Context, user model.User) func() bool {
+	sessionId := sessions.Default(c).ID()
+
+	return func() bool {
+		current, err := model.GetUserById(user.Id)
+		if err != nil || current.Id == 0 || !helpers.CheckIsNotLocked(current) {
+			return false
+		}
+		if sessionId == "" {
+			return true
+		}
+		_, found, err := model.GetSessionData(model.HashToken(sessionId), sessionstore.IdleCutoff())
+
+		return err == nil && found
+	}
+}
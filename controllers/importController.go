@@ -0,0 +1,132 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/greeneg/todoer/events"
+	"github.com/greeneg/todoer/importers"
+	"github.com/greeneg/todoer/model"
+	"github.com/gin-gonic/gin"
+)
+
+// ImportFrom Import the export file of another task manager
+//
+//	@Summary		Import from another task manager
+//	@Description	Imports a file exported from Taskwarrior (`task export`), a Trello board or GitHub issues (REST API or `gh issue list --json`), sent as the "file" field of a form or as the request body. Projects, board lists and milestones become lists, labels and tags become tags, annotations, descriptions and comments become comments and checklists become subtasks. Todos the source does not put on a list go on the list given by the list parameter, if any. With dryRun nothing is stored and the report says what would be created. The import is all or nothing
+//	@Tags			workspace
+//	@Accept			json
+//	@Accept			multipart/form-data
+//	@Produce		json
+//	@Param			source	path	string	true	"Source"	Enums(taskwarrior, trello, github)
+//	@Param			list	query	string	false	"List name for todos without one"
+//	@Param			dryRun	query	bool	false	"Only report what would be created"
+//	@Param			file	formData	file	false	"Export file"
+//	@Security		BasicAuth
+//	@Success		200	{object}	model.ImportReport
+//	@Failure		400	{object}	model.ImportReport
+//	@Router			/import/{source} [post]
+func (g *TodoerService) ImportFrom(c *gin.Context) {
+	user, authed := g.GetUserId(c)
+	if !authed {
+		c.IndentedJSON(http.StatusForbidden, gin.H{"error": "Insufficient access. Access denied!"})
+		return
+	}
+
+	importer, found := importers.Find(c.Param("source"))
+	if !found {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "unsupported import source '" + c.Param("source") +
+			"', must be one of " + strings.Join(importers.Names(), ", ")})
+		return
+	}
+	dryRun, _ := strconv.ParseBool(c.DefaultQuery("dryRun", "false"))
+
+	data, err := uploadedFile(c, maxArchiveSize)
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "Unable to read export file: " + err.Error()})
+		return
+	}
+	ws, err := importer.Read(data)
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "Invalid " + importer.Name() + " export: " + err.Error()})
+		return
+	}
+
+	ws.UserName = user.UserName
+	if name := strings.TrimSpace(c.Query("list")); name != "" {
+		listId := 0
+		for _, l := range ws.Lists {
+			if l.Name == name {
+				listId = l.Id
+			}
+		}
+		if listId == 0 {
+			listId = len(ws.Lists) + 1
+			ws.Lists = append(ws.Lists, model.WorkspaceList{Id: listId, Name: name, Members: make([]string, 0)})
+		}
+		for i := range ws.Todos {
+			if ws.Todos[i].ListId == 0 {
+				ws.Todos[i].ListId = listId
+			}
+		}
+	}
+
+	// tags that exist already in the org unit are not created
+	existing, err := model.GetTagsInOrgUnit(user.OrgUnitId)
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+		return
+	}
+	known := make(map[string]bool)
+	for _, tag := range existing {
+		known[tag.Name] = true
+	}
+
+	report := model.ImportReport{
+		DryRun: dryRun,
+		Lists:  make([]string, 0),
+		Tags:   make([]string, 0),
+		Errors: make([]model.ImportError, 0),
+	}
+	imported, err := model.ImportWorkspace(user.Id, ws, model.ImportOptions{DryRun: dryRun})
+	if err != nil {
+		var failed *model.ImportItemFailed
+		if errors.As(err, &failed) {
+			report.Message = "Nothing has been imported"
+			report.Errors = append(report.Errors, model.ImportError{Item: failed.Item, Error: failed.Error()})
+			c.IndentedJSON(http.StatusBadRequest, report)
+			return
+		}
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+		return
+	}
+
+	for _, l := range imported.Lists {
+		report.Lists = append(report.Lists, l.Name)
+	}
+	for _, tag := range ws.Tags {
+		if !known[tag] {
+			report.Tags = append(report.Tags, tag)
+		}
+	}
+	report.Todos = len(imported.Todos)
+	report.Subtasks = imported.Subtasks
+	report.Comments = imported.Comments
+
+	counts := strconv.Itoa(len(report.Lists)) + " lists, " + strconv.Itoa(report.Todos) + " todos (" +
+		strconv.Itoa(report.Subtasks) + " of them subtasks) and " + strconv.Itoa(report.Comments) + " comments"
+	if dryRun {
+		report.Message = counts + " would be imported"
+	} else {
+		report.Message = counts + " have been imported"
+		for _, l := range imported.Lists {
+			events.Publish(events.ListCreated, user.UserName, l, nil)
+		}
+		for _, todo := range imported.Todos {
+			events.Publish(events.TodoCreated, user.UserName, todo, nil)
+		}
+	}
+	c.IndentedJSON(http.StatusOK, report)
+}
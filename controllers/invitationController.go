@@ -0,0 +1,366 @@
+package controllers
+
+import (
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/greeneg/todoer/events"
+	"github.com/greeneg/todoer/model"
+	"github.com/greeneg/todoer/notify"
+	"github.com/greeneg/todoer/passhash"
+	"github.com/gin-gonic/gin"
+)
+
+// defaultInvitationDays is how long an invitation lasts when neither the
+// invitation nor the config say
+const defaultInvitationDays = 7
+
+// validateInvitation Returns an error message and status if the proposed
+// invitation is unusable, and normalizes its roles, org unit and expiry date.
+// Admins may invite into any org unit and as any role, and org admins only
+// into their own org unit and not as admins
+func (g *TodoerService) validateInvitation(actor model.User, p *model.ProposedInvitation) (int, string) {
+	admin, err := isAdmin(actor)
+	if err != nil {
+		return http.StatusInternalServerError, string(err.Error())
+	}
+
+	if p.OrgUnitId == 0 {
+		p.OrgUnitId = actor.OrgUnitId
+	}
+	if p.OrgUnitId != actor.OrgUnitId && !admin {
+		return http.StatusForbidden, "Insufficient access. Access denied!"
+	}
+	orgUnit, err := model.GetOrgUnitById(p.OrgUnitId)
+	if err != nil {
+		return http.StatusInternalServerError, string(err.Error())
+	}
+	if orgUnit.Id == 0 {
+		return http.StatusBadRequest, "no records found with org unit id " + strconv.Itoa(p.OrgUnitId)
+	}
+
+	roles := make([]string, 0)
+	for _, roleName := range p.Roles {
+		roleName = strings.TrimSpace(roleName)
+		if roleName == "" || roleName == model.RoleMember || slices.Contains(roles, roleName) {
+			continue
+		}
+		role, err := model.GetRoleByName(roleName)
+		if err != nil {
+			return http.StatusInternalServerError, string(err.Error())
+		}
+		if role.Id == 0 {
+			return http.StatusBadRequest, "unknown role '" + roleName + "'"
+		}
+		if role.RoleName == model.RoleAdmin && !admin {
+			return http.StatusForbidden, "Insufficient access. Access denied!"
+		}
+		roles = append(roles, role.RoleName)
+	}
+	p.Roles = roles
+
+	p.Email = strings.TrimSpace(p.Email)
+	if err := model.ValidateEmail(p.Email); err != nil {
+		return http.StatusBadRequest, err.Error()
+	}
+	p.FullName = strings.TrimSpace(p.FullName)
+	expiryDate, err := model.NormalizeDate(p.ExpiryDate)
+	if err != nil {
+		return http.StatusBadRequest, "invalid expiry date: " + p.ExpiryDate
+	}
+	if expiryDate == "" {
+		days := g.ConfStruct.Invitations.ExpiryDays
+		if days <= 0 {
+			days = defaultInvitationDays
+		}
+		expiryDate = time.Now().UTC().AddDate(0, 0, days).Format(model.SqlDateTimeFormat)
+	}
+	if expiryDate <= model.Now() {
+		return http.StatusBadRequest, "expiry date must be in the future"
+	}
+	p.ExpiryDate = expiryDate
+
+	return http.StatusOK, ""
+}
+
+// invitationScope Returns the org unit whose invitations the actor manages,
+// or 0 for admins, who manage those of every org unit
+func invitationScope(actor model.User) (int, error) {
+	admin, err := isAdmin(actor)
+	if err != nil || admin {
+		return 0, err
+	}
+
+	return actor.OrgUnitId, nil
+}
+
+// pathInvitation Returns the invitation named by the :id path parameter,
+// answering the request itself when it is not one the actor manages.
+// Invitations into other org units look the same as missing ones
+func pathInvitation(c *gin.Context, actor model.User) (model.Invitation, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "invalid invitation id"})
+		return model.Invitation{}, false
+	}
+
+	scope, err := invitationScope(actor)
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+		return model.Invitation{}, false
+	}
+	invitation, err := model.GetInvitationById(id)
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+		return model.Invitation{}, false
+	}
+	if invitation.Id == 0 || (scope != 0 && invitation.OrgUnitId != scope) {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"error": "no records found with invitation id " + c.Param("id")})
+		return model.Invitation{}, false
+	}
+
+	return invitation, true
+}
+
+// CreateInvitation Invite someone to set up an account
+//
+//	@Summary		Invite someone to set up an account
+//	@Description	Create an invitation into an org unit with preassigned roles. The token is returned once, and mailed to email when given; the invitee accepts it with POST /invitations/accept. Admins may invite into any org unit, by default their own, and org admins only into their own and not as admins
+//	@Tags			user
+//	@Accept			json
+//	@Produce		json
+//	@Param			invitation	body	model.ProposedInvitation	true	"Invitation Data"
+//	@Security		BasicAuth
+//	@Success		200	{object}	model.InvitationCreatedMsg
+//	@Failure		400	{object}	model.FailureMsg
+//	@Failure		403	{object}	model.FailureMsg
+//	@Router			/invitations [post]
+func (g *TodoerService) CreateInvitation(c *gin.Context) {
+	actor, authed := g.GetUserId(c)
+	if authed {
+		var json model.ProposedInvitation
+		if err := c.ShouldBindJSON(&json); err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if status, msg := g.validateInvitation(actor, &json); msg != "" {
+			c.IndentedJSON(status, gin.H{"error": msg})
+			return
+		}
+
+		token, err := randomToken()
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+			return
+		}
+		invitation, err := model.CreateInvitation(json, actor.Id, model.HashToken(token))
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+			return
+		}
+		if invitation.Email != "" {
+			notify.Invitation(invitation, actor, token, g.ConfStruct.Invitations.Url)
+		}
+
+		c.IndentedJSON(http.StatusOK, model.InvitationCreatedMsg{
+			Message:    "Invitation " + strconv.Itoa(invitation.Id) + " has been created",
+			Token:      token,
+			Invitation: invitation,
+		})
+	} else {
+		c.IndentedJSON(http.StatusForbidden, gin.H{"error": "Insufficient access. Access denied!"})
+	}
+}
+
+// GetInvitations Retrieve invitations
+//
+//	@Summary		Retrieve invitations
+//	@Description	Retrieve invitations, newest first. Admins see those of every org unit and org admins those of their own
+//	@Tags			user
+//	@Produce		json
+//	@Param			status	query	string	false	"Only invitations with this status"	Enums(pending, accepted, revoked, expired)
+//	@Security		BasicAuth
+//	@Success		200	{object}	model.InvitationList
+//	@Failure		400	{object}	model.FailureMsg
+//	@Failure		403	{object}	model.FailureMsg
+//	@Router			/invitations [get]
+func (g *TodoerService) GetInvitations(c *gin.Context) {
+	actor, authed := g.GetUserId(c)
+	if authed {
+		status := c.Query("status")
+		if status != "" && !model.IsValidInvitationStatus(status) {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "unknown invitation status '" + status + "'"})
+			return
+		}
+
+		scope, err := invitationScope(actor)
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+			return
+		}
+		invitations, err := model.GetInvitations(scope, status)
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, gin.H{"data": invitations})
+	} else {
+		c.IndentedJSON(http.StatusForbidden, gin.H{"error": "Insufficient access. Access denied!"})
+	}
+}
+
+// GetInvitationById Retrieve an invitation
+//
+//	@Summary		Retrieve an invitation
+//	@Description	Retrieve an invitation by its Id
+//	@Tags			user
+//	@Produce		json
+//	@Param			id	path	int	true	"Invitation Id"
+//	@Security		BasicAuth
+//	@Success		200	{object}	model.Invitation
+//	@Failure		403	{object}	model.FailureMsg
+//	@Failure		404	{object}	model.FailureMsg
+//	@Router			/invitations/{id} [get]
+func (g *TodoerService) GetInvitationById(c *gin.Context) {
+	actor, authed := g.GetUserId(c)
+	if authed {
+		invitation, ok := pathInvitation(c, actor)
+		if !ok {
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, invitation)
+	} else {
+		c.IndentedJSON(http.StatusForbidden, gin.H{"error": "Insufficient access. Access denied!"})
+	}
+}
+
+// RevokeInvitation Revoke an invitation
+//
+//	@Summary		Revoke an invitation
+//	@Description	Withdraw a pending invitation, so that its token no longer works
+//	@Tags			user
+//	@Produce		json
+//	@Param			id	path	int	true	"Invitation Id"
+//	@Security		BasicAuth
+//	@Success		200	{object}	model.SuccessMsg
+//	@Failure		400	{object}	model.FailureMsg
+//	@Failure		403	{object}	model.FailureMsg
+//	@Failure		404	{object}	model.FailureMsg
+//	@Router			/invitations/{id} [delete]
+func (g *TodoerService) RevokeInvitation(c *gin.Context) {
+	actor, authed := g.GetUserId(c)
+	if authed {
+		invitation, ok := pathInvitation(c, actor)
+		if !ok {
+			return
+		}
+
+		status, err := model.RevokeInvitation(invitation.Id)
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+			return
+		}
+
+		idString := strconv.Itoa(invitation.Id)
+		if status {
+			log.Println("INFO: Invitation " + idString + " revoked by '" + actor.UserName + "'")
+			c.IndentedJSON(http.StatusOK, gin.H{"message": "Invitation " + idString + " has been revoked"})
+		} else {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "invitation " + idString + " is " + invitation.Status +
+				"; only pending invitations can be revoked"})
+		}
+	} else {
+		c.IndentedJSON(http.StatusForbidden, gin.H{"error": "Insufficient access. Access denied!"})
+	}
+}
+
+// AcceptInvitation Set up an account with an invitation
+//
+//	@Summary		Set up an account with an invitation
+//	@Description	Create the account of an invitee, with the roles and org unit of their invitation and the user name and password they choose. The full name and email default to those of the invitation. A password breaking the password policy is refused with the rules it breaks
+//	@Tags			auth
+//	@Accept			json
+//	@Produce		json
+//	@Param			acceptance	body	model.InvitationAcceptance	true	"Invitation token and account details"
+//	@Success		200	{object}	model.SuccessMsg
+//	@Failure		400	{object}	model.FailureMsg
+//	@Router			/invitations/accept [post]
+func (g *TodoerService) AcceptInvitation(c *gin.Context) {
+	var json model.InvitationAcceptance
+	if err := c.ShouldBindJSON(&json); err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	json.UserName = strings.TrimSpace(json.UserName)
+	if json.Token == "" || json.UserName == "" || json.Password == "" {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "token, userName and password are required"})
+		return
+	}
+	json.Email = strings.TrimSpace(json.Email)
+	if err := model.ValidateEmail(json.Email); err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tokenHash := model.HashToken(json.Token)
+	invitation, err := model.GetPendingInvitation(tokenHash)
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+		return
+	}
+	if invitation.Id == 0 {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "invalid or expired invitation"})
+		return
+	}
+	existing, err := model.GetUserByUserName(json.UserName)
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+		return
+	}
+	if existing.Id != 0 {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "user name '" + json.UserName + "' is taken"})
+		return
+	}
+
+	if !passwordAccepted(c, 0, json.Password) {
+		return
+	}
+
+	fullName := strings.TrimSpace(json.FullName)
+	if fullName == "" {
+		fullName = invitation.FullName
+	}
+	if fullName == "" {
+		fullName = json.UserName
+	}
+	email := json.Email
+	if email == "" {
+		email = invitation.Email
+	}
+
+	hash, err := passhash.Hash(json.Password)
+	if err != nil {
+		log.Println("ERROR: Cannot hash password: " + string(err.Error()))
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "User could not be created!"})
+		return
+	}
+	user, err := model.AcceptInvitation(tokenHash, model.ProposedUser{UserName: json.UserName, Email: email},
+		fullName, hash)
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if user.Id == 0 {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "invalid or expired invitation"})
+		return
+	}
+	events.Publish(events.UserCreated, user.UserName, safeUser(user), nil)
+
+	c.IndentedJSON(http.StatusOK, gin.H{"message": "User has been added to system"})
+}
@@ -0,0 +1,70 @@
+package controllers
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/greeneg/todoer/model"
+	"github.com/gin-gonic/gin"
+)
+
+// SetUserLdapDn Link a user to a directory entry
+//
+//	@Summary		Link a user to a directory entry
+//	@Description	Let an existing user log in with the password of an LDAP directory entry, whose groups then decide their mapped roles. Admins only. An empty dn unlinks the user, who then only logs in with their todoer password
+//	@Tags			user
+//	@Accept			json
+//	@Produce		json
+//	@Param			name	path	string				true	"User name"
+//	@Param			link	body	model.UserLdapLink	true	"Directory entry"
+//	@Security		BasicAuth
+//	@Success		200	{object}	model.SuccessMsg
+//	@Failure		400	{object}	model.FailureMsg
+//	@Failure		403	{object}	model.FailureMsg
+//	@Failure		404	{object}	model.FailureMsg
+//	@Router			/user/{name}/ldap [patch]
+func (g *TodoerService) SetUserLdapDn(c *gin.Context) {
+	_, authed := g.GetUserId(c)
+	if authed {
+		user, ok := pathUser(c)
+		if !ok {
+			return
+		}
+		var json model.UserLdapLink
+		if err := c.ShouldBindJSON(&json); err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		json.Dn = strings.TrimSpace(json.Dn)
+		if json.Dn != "" {
+			if _, err := ldap.ParseDN(json.Dn); err != nil {
+				c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "invalid DN '" + json.Dn + "'"})
+				return
+			}
+			linked, err := model.GetUserByLdapDn(json.Dn)
+			if err != nil {
+				c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+				return
+			}
+			if linked.Id != 0 && linked.Id != user.Id {
+				c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "LDAP entry '" + json.Dn + "' is linked to another user"})
+				return
+			}
+		}
+
+		if err := model.SetUserLdapDn(user.Id, json.Dn); err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+			return
+		}
+
+		if json.Dn == "" {
+			c.IndentedJSON(http.StatusOK, gin.H{"message": "User '" + user.UserName + "' is not linked to the directory"})
+		} else {
+			c.IndentedJSON(http.StatusOK, gin.H{"message": "User '" + user.UserName + "' is linked to '" + json.Dn + "'"})
+		}
+	} else {
+		c.IndentedJSON(http.StatusForbidden, gin.H{"error": "Insufficient access. Access denied!"})
+	}
+}
@@ -0,0 +1,352 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/greeneg/todoer/events"
+	"github.com/greeneg/todoer/model"
+	"github.com/gin-gonic/gin"
+)
+
+// getVisibleList Returns the list named in the request path if the user can
+// see it, writing an error response otherwise
+func getVisibleList(c *gin.Context, user model.User) (model.List, bool) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	list, err := model.GetListById(id)
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+		return model.List{}, false
+	}
+
+	if list.Name == "" || !model.CanViewList(user.Id, list.Id) {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "no records found with list id " + strconv.Itoa(id)})
+		return model.List{}, false
+	}
+
+	return list, true
+}
+
+// getOwnedList Returns the list named in the request path if the user owns
+// it, writing an error response otherwise
+func getOwnedList(c *gin.Context, user model.User) (model.List, bool) {
+	list, ok := getVisibleList(c, user)
+	if !ok {
+		return model.List{}, false
+	}
+
+	if list.OwnerId != user.Id {
+		c.IndentedJSON(http.StatusForbidden, gin.H{"error": "Only the owner of a list can change it"})
+		return model.List{}, false
+	}
+
+	return list, true
+}
+
+// CreateList Create a new list
+//
+//	@Summary		Create list
+//	@Description	Create a new list owned by the current user
+//	@Tags			list
+//	@Accept			json
+//	@Produce		json
+//	@Param			list	body	model.ProposedList	true	"List Data"
+//	@Security		BasicAuth
+//	@Success		200	{object}	model.List
+//	@Failure		400	{object}	model.FailureMsg
+//	@Router			/list [post]
+func (g *TodoerService) CreateList(c *gin.Context) {
+	user, authed := g.GetUserId(c)
+	if authed {
+		var json model.ProposedList
+		if err := c.ShouldBindJSON(&json); err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if strings.TrimSpace(json.Name) == "" {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "list name must not be empty"})
+			return
+		}
+
+		list, err := model.CreateList(json, user.Id)
+		if err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		events.Publish(events.ListCreated, user.UserName, list, nil)
+		c.IndentedJSON(http.StatusOK, list)
+	} else {
+		c.IndentedJSON(http.StatusForbidden, gin.H{"error": "Insufficient access. Access denied!"})
+	}
+}
+
+// RenameList Rename a list
+//
+//	@Summary		Rename list
+//	@Description	Rename a list. Only the owner of a list may rename it
+//	@Tags			list
+//	@Accept			json
+//	@Produce		json
+//	@Param			id		path	int					true	"List Id"
+//	@Param			list	body	model.ProposedList	true	"List Data"
+//	@Security		BasicAuth
+//	@Success		200	{object}	model.List
+//	@Failure		400	{object}	model.FailureMsg
+//	@Router			/list/{id} [patch]
+func (g *TodoerService) RenameList(c *gin.Context) {
+	user, authed := g.GetUserId(c)
+	if authed {
+		previous, ok := getOwnedList(c, user)
+		if !ok {
+			return
+		}
+
+		var json model.ProposedList
+		if err := c.ShouldBindJSON(&json); err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if strings.TrimSpace(json.Name) == "" {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "list name must not be empty"})
+			return
+		}
+
+		list, err := model.RenameList(previous.Id, json.Name)
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+			return
+		}
+
+		events.Publish(events.ListUpdated, user.UserName, list, previous)
+		c.IndentedJSON(http.StatusOK, list)
+	} else {
+		c.IndentedJSON(http.StatusForbidden, gin.H{"error": "Insufficient access. Access denied!"})
+	}
+}
+
+// DeleteList Remove a list
+//
+//	@Summary		Delete list
+//	@Description	Delete a list. Its todos are kept but no longer belong to a list. Only the owner of a list may delete it
+//	@Tags			list
+//	@Produce		json
+//	@Param			id	path	int	true	"List Id"
+//	@Security		BasicAuth
+//	@Success		200	{object}	model.SuccessMsg
+//	@Failure		400	{object}	model.FailureMsg
+//	@Router			/list/{id} [delete]
+func (g *TodoerService) DeleteList(c *gin.Context) {
+	user, authed := g.GetUserId(c)
+	if authed {
+		list, ok := getOwnedList(c, user)
+		if !ok {
+			return
+		}
+
+		status, err := model.DeleteList(list.Id)
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "Unable to remove list! " + string(err.Error())})
+			return
+		}
+
+		if status {
+			events.Publish(events.ListDeleted, user.UserName, list, nil)
+			c.IndentedJSON(http.StatusOK, gin.H{"message": "List " + strconv.Itoa(list.Id) + " has been removed"})
+		} else {
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "Unable to remove list!"})
+		}
+	} else {
+		c.IndentedJSON(http.StatusForbidden, gin.H{"error": "Insufficient access. Access denied!"})
+	}
+}
+
+// GetLists Retrieve the lists of the current user
+//
+//	@Summary		Retrieve lists
+//	@Description	Retrieve the lists the current user owns or is a member of
+//	@Tags			list
+//	@Produce		json
+//	@Security		BasicAuth
+//	@Success		200	{object}	model.ListsList
+//	@Failure		400	{object}	model.FailureMsg
+//	@Router			/lists [get]
+func (g *TodoerService) GetLists(c *gin.Context) {
+	user, authed := g.GetUserId(c)
+	if authed {
+		lists, err := model.GetListsForUser(user.Id)
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, gin.H{"data": lists})
+	} else {
+		c.IndentedJSON(http.StatusForbidden, gin.H{"error": "Insufficient access. Access denied!"})
+	}
+}
+
+// GetListById Retrieve a list by its Id
+//
+//	@Summary		Retrieve a list by its Id
+//	@Description	Retrieve a list by its Id
+//	@Tags			list
+//	@Produce		json
+//	@Param			id	path	int	true	"List Id"
+//	@Security		BasicAuth
+//	@Success		200	{object}	model.List
+//	@Failure		400	{object}	model.FailureMsg
+//	@Router			/list/{id} [get]
+func (g *TodoerService) GetListById(c *gin.Context) {
+	user, authed := g.GetUserId(c)
+	if authed {
+		list, ok := getVisibleList(c, user)
+		if ok {
+			c.IndentedJSON(http.StatusOK, list)
+		}
+	} else {
+		c.IndentedJSON(http.StatusForbidden, gin.H{"error": "Insufficient access. Access denied!"})
+	}
+}
+
+// GetListTodos Retrieve the todos on a list
+//
+//	@Summary		Retrieve the todos on a list
+//	@Description	Retrieve the todos on a list
+//	@Tags			list
+//	@Produce		json
+//	@Param			id	path	int	true	"List Id"
+//	@Security		BasicAuth
+//	@Success		200	{object}	model.TodoList
+//	@Failure		400	{object}	model.FailureMsg
+//	@Router			/list/{id}/todos [get]
+func (g *TodoerService) GetListTodos(c *gin.Context) {
+	user, authed := g.GetUserId(c)
+	if authed {
+		list, ok := getVisibleList(c, user)
+		if !ok {
+			return
+		}
+
+		todos, err := model.GetTodosForList(list.Id)
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, gin.H{"data": todos})
+	} else {
+		c.IndentedJSON(http.StatusForbidden, gin.H{"error": "Insufficient access. Access denied!"})
+	}
+}
+
+// GetListMembers Retrieve the users a list is shared with
+//
+//	@Summary		Retrieve list members
+//	@Description	Retrieve the users a list is shared with, not including its owner
+//	@Tags			list
+//	@Produce		json
+//	@Param			id	path	int	true	"List Id"
+//	@Security		BasicAuth
+//	@Success		200	{array}		SafeUser
+//	@Failure		400	{object}	model.FailureMsg
+//	@Router			/list/{id}/members [get]
+func (g *TodoerService) GetListMembers(c *gin.Context) {
+	user, authed := g.GetUserId(c)
+	if authed {
+		list, ok := getVisibleList(c, user)
+		if !ok {
+			return
+		}
+
+		members, err := model.GetListMembers(list.Id)
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+			return
+		}
+
+		safeUsers := make([]SafeUser, 0)
+		for _, member := range members {
+			safeUsers = append(safeUsers, safeUser(member))
+		}
+		c.IndentedJSON(http.StatusOK, gin.H{"data": safeUsers})
+	} else {
+		c.IndentedJSON(http.StatusForbidden, gin.H{"error": "Insufficient access. Access denied!"})
+	}
+}
+
+// AddListMember Share a list with a user
+//
+//	@Summary		Add list member
+//	@Description	Share a list with a user. Only the owner of a list may share it
+//	@Tags			list
+//	@Produce		json
+//	@Param			id		path	int		true	"List Id"
+//	@Param			name	path	string	true	"User name"
+//	@Security		BasicAuth
+//	@Success		200	{object}	model.SuccessMsg
+//	@Failure		400	{object}	model.FailureMsg
+//	@Router			/list/{id}/member/{name} [post]
+func (g *TodoerService) AddListMember(c *gin.Context) {
+	g.changeListMember(c, true)
+}
+
+// RemoveListMember Stop sharing a list with a user
+//
+//	@Summary		Remove list member
+//	@Description	Stop sharing a list with a user. Only the owner of a list may change its members
+//	@Tags			list
+//	@Produce		json
+//	@Param			id		path	int		true	"List Id"
+//	@Param			name	path	string	true	"User name"
+//	@Security		BasicAuth
+//	@Success		200	{object}	model.SuccessMsg
+//	@Failure		400	{object}	model.FailureMsg
+//	@Router			/list/{id}/member/{name} [delete]
+func (g *TodoerService) RemoveListMember(c *gin.Context) {
+	g.changeListMember(c, false)
+}
+
+func (g *TodoerService) changeListMember(c *gin.Context, add bool) {
+	user, authed := g.GetUserId(c)
+	if !authed {
+		c.IndentedJSON(http.StatusForbidden, gin.H{"error": "Insufficient access. Access denied!"})
+		return
+	}
+
+	list, ok := getOwnedList(c, user)
+	if !ok {
+		return
+	}
+
+	username := c.Param("name")
+	member, err := model.GetUserByUserName(username)
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+		return
+	}
+	// users of other org units may not know of each other
+	if member.UserName == "" || !model.SameOrgUnit(user.Id, member.Id) {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "no records found with user name " + username})
+		return
+	}
+
+	listId := strconv.Itoa(list.Id)
+	if add {
+		err = model.AddListMember(list.Id, member.Id)
+	} else {
+		err = model.RemoveListMember(list.Id, member.Id)
+	}
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+		return
+	}
+
+	events.Publish(events.ListUpdated, user.UserName, list, nil)
+	if add {
+		c.IndentedJSON(http.StatusOK, gin.H{"message": "List " + listId + " has been shared with " + username})
+	} else {
+		c.IndentedJSON(http.StatusOK, gin.H{"message": "List " + listId + " is no longer shared with " + username})
+	}
+}
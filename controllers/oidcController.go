@@ -0,0 +1,418 @@
+package controllers
+
+import (
+	"crypto/subtle"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/greeneg/todoer/events"
+	"github.com/greeneg/todoer/globals"
+	"github.com/greeneg/todoer/helpers"
+	"github.com/greeneg/todoer/lockout"
+	"github.com/greeneg/todoer/model"
+	"github.com/greeneg/todoer/oidc"
+	"github.com/greeneg/todoer/passpolicy"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+)
+
+// session keys of a login waiting for the browser to come back from the
+// identity provider
+const (
+	oidcStateKey    = "oidcState"
+	oidcNonceKey    = "oidcNonce"
+	oidcVerifierKey = "oidcVerifier"
+	oidcLinkKey     = "oidcLink"
+)
+
+// session keys of a login through the provider waiting for the two-factor
+// code of the user, and how long it waits
+const (
+	oidcPendingUserKey   = "oidcPendingUser"
+	oidcPendingExpiryKey = "oidcPendingExpiry"
+	oidcPendingTimeout   = 5 * time.Minute
+)
+
+// errOidcNotLinked is returned for provider accounts named like a todoer user
+// they are not linked to
+var errOidcNotLinked = errors.New("user is not linked to the identity provider")
+
+// oidcUser Returns the user an identity provider account logs in as. Accounts
+// are found by their subject once linked, which happens when the user is
+// created by their first login, or when a logged in user links their account
+// through GET /me/oidc/link. The user name claim may be changed by whoever
+// controls the provider account, so it never links an account by itself, and
+// errOidcNotLinked is returned for a todoer user of that name. Unknown users
+// are created when the config allows it, and an empty user is returned
+// otherwise
+func oidcUser(identity oidc.Identity) (model.User, error) {
+	user, err := model.GetUserByOidcSubject(identity.Issuer, identity.Subject)
+	if err != nil || user.Id != 0 {
+		if err == nil {
+			err = model.LinkOidcIdentity(user.Id, identity.Issuer, identity.Subject)
+		}
+		return user, err
+	}
+
+	user, err = model.GetUserByUserName(identity.UserName)
+	if err != nil {
+		return model.User{}, err
+	}
+	if user.Id != 0 {
+		log.Println("ERROR: User '" + user.UserName + "' is not linked to OpenID Connect subject '" +
+			identity.Subject + "'")
+		return model.User{}, errOidcNotLinked
+	}
+
+	config := oidc.Config()
+	if !config.AutoCreateUsers {
+		return model.User{}, nil
+	}
+	fullName := identity.FullName
+	if fullName == "" {
+		fullName = identity.UserName
+	}
+	user, err = model.CreateOidcUser(model.ProposedUser{
+		UserName:  identity.UserName,
+		Email:     identity.Email,
+		OrgUnitId: config.OrgUnitId,
+	}, fullName, identity.Issuer, identity.Subject)
+	if err != nil {
+		return model.User{}, err
+	}
+	events.Publish(events.UserCreated, user.UserName, safeUser(user), nil)
+
+	return user, nil
+}
+
+// OidcLogin Log in through the identity provider
+//
+//	@Summary		Log in through the identity provider
+//	@Description	Send the browser to the OpenID Connect identity provider to log in. It comes back to /oidc/callback
+//	@Tags			auth
+//	@Produce		json
+//	@Success		302
+//	@Failure		404	{object}	model.FailureMsg
+//	@Failure		502	{object}	model.FailureMsg
+//	@Router			/oidc/login [get]
+func (g *TodoerService) OidcLogin(c *gin.Context) {
+	startOidcLogin(c, "")
+}
+
+// startOidcLogin Sends the browser to the identity provider, remembering the
+// login in the session. linkUser names the logged in user whose account is
+// to be linked, if that is what the login is for
+func startOidcLogin(c *gin.Context, linkUser string) {
+	if !oidc.Enabled() {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"error": string(oidc.ErrDisabled.Error())})
+		return
+	}
+
+	request, authUrl, err := oidc.NewRequest()
+	if err != nil {
+		log.Println("ERROR: Cannot start OpenID Connect login: " + string(err.Error()))
+		c.IndentedJSON(http.StatusBadGateway, gin.H{"error": "identity provider is not available"})
+		return
+	}
+
+	session := sessions.Default(c)
+	session.Set(oidcStateKey, request.State)
+	session.Set(oidcNonceKey, request.Nonce)
+	session.Set(oidcVerifierKey, request.Verifier)
+	if linkUser != "" {
+		session.Set(oidcLinkKey, linkUser)
+	} else {
+		session.Delete(oidcLinkKey)
+	}
+	if err := session.Save(); err != nil {
+		log.Println("ERROR: Cannot save session: " + string(err.Error()))
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "failed to save user session"})
+		return
+	}
+
+	c.Redirect(http.StatusFound, authUrl)
+}
+
+// OidcLink Link the logged in user to an identity provider account
+//
+//	@Summary		Link the logged in user to an identity provider account
+//	@Description	Send the browser to the OpenID Connect identity provider, and link the account logged in there to the logged in user when it comes back to /oidc/callback. The user may then log in through the provider. Only browser sessions may link accounts, not requests sending credentials or client certificates
+//	@Tags			auth
+//	@Produce		json
+//	@Security		BasicAuth
+//	@Success		302
+//	@Failure		400	{object}	model.FailureMsg
+//	@Failure		403	{object}	model.FailureMsg
+//	@Failure		404	{object}	model.FailureMsg
+//	@Failure		502	{object}	model.FailureMsg
+//	@Router			/me/oidc/link [get]
+func (g *TodoerService) OidcLink(c *gin.Context) {
+	user, authed := g.GetUserId(c)
+	if authed {
+		// only a session stored at login may link. Credentials sent with the
+		// request, or a client certificate, which carries scopes, would
+		// otherwise be turned into a stored session when the login is
+		// remembered
+		_, scoped := c.Get(globals.ScopesKey)
+		if sessions.Default(c).ID() == "" || scoped || c.GetHeader("Authorization") != "" {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "accounts can only be linked from a browser session"})
+			return
+		}
+		startOidcLogin(c, user.UserName)
+	} else {
+		c.IndentedJSON(http.StatusForbidden, gin.H{"error": "Insufficient access. Access denied!"})
+	}
+}
+
+// finishOidcLink Links the provider account that logged in to the user who
+// started linking, if they are still logged in, and answers the request
+func finishOidcLink(c *gin.Context, session sessions.Session, linkUser string, identity oidc.Identity) {
+	if current, _ := session.Get(globals.UserKey).(string); current != linkUser {
+		log.Println("ERROR: User '" + linkUser + "' is no longer logged in to link OpenID Connect subject")
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "the session that started linking has ended; log in again"})
+		return
+	}
+	user, err := model.GetUserByUserName(linkUser)
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+		return
+	}
+
+	linked, err := model.GetUserByOidcSubject(identity.Issuer, identity.Subject)
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+		return
+	}
+	if linked.Id != 0 && linked.Id != user.Id {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "the identity provider account is linked to another user"})
+		return
+	}
+	// a user may only be linked to one account of the provider
+	subject, err := model.GetOidcSubject(user.Id, identity.Issuer)
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+		return
+	}
+	if subject != "" && subject != identity.Subject {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "user '" + user.UserName +
+			"' is linked to another identity provider account"})
+		return
+	}
+
+	log.Println("INFO: Linking user '" + user.UserName + "' to OpenID Connect subject '" + identity.Subject + "'")
+	if err := model.LinkOidcIdentity(user.Id, identity.Issuer, identity.Subject); err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+		return
+	}
+
+	if postLoginUrl := oidc.Config().PostLoginUrl; postLoginUrl != "" {
+		c.Redirect(http.StatusFound, postLoginUrl)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, gin.H{"message": "User '" + user.UserName + "' is linked to the identity provider"})
+}
+
+// OidcCallback Finish logging in through the identity provider
+//
+//	@Summary		Finish logging in through the identity provider
+//	@Description	Check the authorization code the identity provider sent the browser back with and start a session, returned as the todoer-session cookie, or link the account when the login was started by /me/oidc/link. The browser is sent on to the configured postLoginUrl, if any
+//	@Tags			auth
+//	@Produce		json
+//	@Param			code	query	string	false	"Authorization code"
+//	@Param			state	query	string	true	"State of the login"
+//	@Param			error	query	string	false	"Error from the identity provider"
+//	@Success		200	{object}	LoginMsg
+//	@Success		302
+//	@Failure		400	{object}	model.FailureMsg
+//	@Failure		401	{object}	model.FailureMsg
+//	@Failure		403	{object}	model.FailureMsg
+//	@Failure		404	{object}	model.FailureMsg
+//	@Router			/oidc/callback [get]
+func (g *TodoerService) OidcCallback(c *gin.Context) {
+	if !oidc.Enabled() {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"error": string(oidc.ErrDisabled.Error())})
+		return
+	}
+
+	// the waiting login is used up, whatever the outcome
+	session := sessions.Default(c)
+	state, _ := session.Get(oidcStateKey).(string)
+	nonce, _ := session.Get(oidcNonceKey).(string)
+	verifier, _ := session.Get(oidcVerifierKey).(string)
+	linkUser, _ := session.Get(oidcLinkKey).(string)
+	session.Delete(oidcStateKey)
+	session.Delete(oidcNonceKey)
+	session.Delete(oidcVerifierKey)
+	session.Delete(oidcLinkKey)
+	if err := session.Save(); err != nil {
+		log.Println("ERROR: Cannot save session: " + string(err.Error()))
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "failed to save user session"})
+		return
+	}
+
+	if state == "" || subtle.ConstantTimeCompare([]byte(c.Query("state")), []byte(state)) != 1 {
+		log.Println("ERROR: OpenID Connect callback does not match a login of this browser")
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "unknown or expired login; start again"})
+		return
+	}
+	if providerError := c.Query("error"); providerError != "" {
+		log.Println("ERROR: Identity provider refused the login: " + providerError + " " + c.Query("error_description"))
+		c.IndentedJSON(http.StatusUnauthorized, gin.H{"error": "identity provider refused the login: " + providerError})
+		return
+	}
+
+	identity, err := oidc.Exchange(oidc.Request{State: state, Nonce: nonce, Verifier: verifier}, c.Query("code"))
+	if err != nil {
+		log.Println("ERROR: OpenID Connect login failed: " + string(err.Error()))
+		c.IndentedJSON(http.StatusUnauthorized, gin.H{"error": "login through the identity provider failed"})
+		return
+	}
+
+	if linkUser != "" {
+		finishOidcLink(c, session, linkUser, identity)
+		return
+	}
+
+	user, err := oidcUser(identity)
+	if errors.Is(err, errOidcNotLinked) {
+		c.IndentedJSON(http.StatusForbidden, gin.H{"error": "user '" + identity.UserName + "' is not linked to the " +
+			"identity provider; log in with the password and link it with GET /api/v1/me/oidc/link"})
+		return
+	}
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+		return
+	}
+	if user.Id == 0 {
+		log.Println("ERROR: No user for OpenID Connect subject '" + identity.Subject + "' named '" + identity.UserName + "'")
+		c.IndentedJSON(http.StatusForbidden, gin.H{"error": "no account for user '" + identity.UserName + "'"})
+		return
+	}
+	if !helpers.CheckIsNotLocked(user) {
+		log.Println("WARN: User '" + user.UserName + "' is locked!")
+		c.IndentedJSON(http.StatusForbidden, gin.H{"error": "user '" + user.UserName + "' is locked"})
+		return
+	}
+
+	twoFactor, err := model.TwoFactorEnabled(user.Id)
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+		return
+	}
+	if twoFactor {
+		// the provider stands in for the password only, so the session is
+		// not logged in until the code has been sent to /oidc/2fa
+		session.Clear()
+		session.Set(oidcPendingUserKey, user.UserName)
+		session.Set(oidcPendingExpiryKey, time.Now().Add(oidcPendingTimeout).Unix())
+		if err := session.Save(); err != nil {
+			log.Println("ERROR: Cannot save session: " + string(err.Error()))
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "failed to save user session"})
+			return
+		}
+		log.Println("INFO: User '" + user.UserName + "' logged in through the identity provider needs a two-factor code")
+		if postLoginUrl := oidc.Config().PostLoginUrl; postLoginUrl != "" {
+			if u, err := url.Parse(postLoginUrl); err == nil {
+				q := u.Query()
+				q.Set("twoFactorRequired", "true")
+				u.RawQuery = q.Encode()
+				c.Redirect(http.StatusFound, u.String())
+				return
+			}
+		}
+		c.IndentedJSON(http.StatusUnauthorized, gin.H{"error": "two-factor code required; send it to /api/v1/oidc/2fa",
+			"twoFactorRequired": true})
+		return
+	}
+
+	startOidcSession(c, session, user)
+}
+
+// startOidcSession Logs the session in as a user who came through the
+// identity provider, unless their password has expired, and answers the
+// request
+func startOidcSession(c *gin.Context, session sessions.Session, user model.User) {
+	// accounts with a todoer password have to change it once it is too old,
+	// however they log in; those created by the provider have none of their own
+	if user.Source == model.SourceLocal && passpolicy.Expired(user) {
+		log.Println("WARN: Password of user '" + user.UserName + "' has expired")
+		c.IndentedJSON(http.StatusForbidden, gin.H{"error": "password has expired; change it with PATCH /api/v1/user/" +
+			user.UserName, "passwordExpired": true})
+		return
+	}
+
+	// the store issues a new session Id when the user changes
+	session.Clear()
+	session.Set(globals.UserKey, user.UserName)
+	if err := session.Save(); err != nil {
+		log.Println("ERROR: Cannot save session: " + string(err.Error()))
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "failed to save user session"})
+		return
+	}
+
+	log.Println("INFO: User '" + user.UserName + "' logged in through the identity provider")
+	if postLoginUrl := oidc.Config().PostLoginUrl; postLoginUrl != "" {
+		c.Redirect(http.StatusFound, postLoginUrl)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, LoginMsg{Message: "Logged in as '" + user.UserName + "'", User: currentUser(user)})
+}
+
+// OidcTwoFactor Finish logging in through the identity provider with a two-factor code
+//
+//	@Summary		Finish logging in through the identity provider with a two-factor code
+//	@Description	Check the one-time password or recovery code of a user with two-factor authentication who logged in through the identity provider, and start their session. The login waits five minutes for the code, and wrong codes count as failed logins
+//	@Tags			auth
+//	@Accept			json
+//	@Produce		json
+//	@Param			code	body	model.TwoFactorCode	true	"One-time password or recovery code"
+//	@Success		200	{object}	LoginMsg
+//	@Failure		400	{object}	model.FailureMsg
+//	@Failure		401	{object}	model.FailureMsg
+//	@Failure		403	{object}	model.FailureMsg
+//	@Failure		429	{object}	model.FailureMsg
+//	@Router			/oidc/2fa [post]
+func (g *TodoerService) OidcTwoFactor(c *gin.Context) {
+	var json model.TwoFactorCode
+	if err := c.ShouldBindJSON(&json); err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	session := sessions.Default(c)
+	username, _ := session.Get(oidcPendingUserKey).(string)
+	expiry, _ := session.Get(oidcPendingExpiryKey).(int64)
+	if username == "" || time.Now().Unix() > expiry {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "no login through the identity provider is waiting for a " +
+			"two-factor code; start again"})
+		return
+	}
+
+	if err := lockout.Wait(username, c.ClientIP()); err != nil {
+		c.IndentedJSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+		return
+	}
+	user, err := model.GetUserByUserName(username)
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+		return
+	}
+	if user.Id == 0 || !helpers.CheckIsNotLocked(user) {
+		log.Println("WARN: User '" + username + "' is locked or gone!")
+		c.IndentedJSON(http.StatusForbidden, gin.H{"error": "user '" + username + "' is locked"})
+		return
+	}
+	if !helpers.CheckSecondFactor(user, json.Code) {
+		log.Println("ERROR: Login through the identity provider failed for user '" + username +
+			"': invalid two-factor code")
+		lockout.Failed(username, c.ClientIP())
+		c.IndentedJSON(http.StatusUnauthorized, gin.H{"error": "invalid two-factor code"})
+		return
+	}
+
+	lockout.Succeeded(username)
+	startOidcSession(c, session, user)
+}
@@ -0,0 +1,249 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/greeneg/todoer/model"
+	"github.com/gin-gonic/gin"
+)
+
+// pathOrgUnit Returns the org unit with the Id of the :id path parameter,
+// answering the request itself when there is no such org unit
+func pathOrgUnit(c *gin.Context) (model.OrgUnit, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "invalid org unit id"})
+		return model.OrgUnit{}, false
+	}
+	orgUnit, err := model.GetOrgUnitById(id)
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+		return model.OrgUnit{}, false
+	}
+	if orgUnit.Id == 0 {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"error": "no org unit found with id " + strconv.Itoa(id)})
+		return model.OrgUnit{}, false
+	}
+
+	return orgUnit, true
+}
+
+// GetOrgUnits Retrieve the org units
+//
+//	@Summary		Retrieve the org units
+//	@Description	Retrieve the org units. Admins only
+//	@Tags			orgunit
+//	@Produce		json
+//	@Security		BasicAuth
+//	@Success		200	{object}	model.OrgUnitList
+//	@Failure		403	{object}	model.FailureMsg
+//	@Router			/orgunits [get]
+func (g *TodoerService) GetOrgUnits(c *gin.Context) {
+	_, authed := g.GetUserId(c)
+	if authed {
+		orgUnits, err := model.GetOrgUnits()
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, gin.H{"data": orgUnits})
+	} else {
+		c.IndentedJSON(http.StatusForbidden, gin.H{"error": "Insufficient access. Access denied!"})
+	}
+}
+
+// GetOrgUnitUsers Retrieve the users of an org unit
+//
+//	@Summary		Retrieve the users of an org unit
+//	@Description	Retrieve the users of an org unit. Admins may see any org unit and org admins their own
+//	@Tags			orgunit
+//	@Produce		json
+//	@Param			id	path	int	true	"Org unit Id"
+//	@Security		BasicAuth
+//	@Success		200	{object}	model.UsersList
+//	@Failure		403	{object}	model.FailureMsg
+//	@Failure		404	{object}	model.FailureMsg
+//	@Router			/orgunits/{id}/users [get]
+func (g *TodoerService) GetOrgUnitUsers(c *gin.Context) {
+	user, authed := g.GetUserId(c)
+	if authed {
+		orgUnit, ok := pathOrgUnit(c)
+		if !ok {
+			return
+		}
+
+		may, err := isAdmin(user)
+		if err == nil && !may && orgUnit.Id == user.OrgUnitId {
+			may, err = model.UserHasRole(user.Id, model.RoleOrgAdmin)
+		}
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+			return
+		}
+		if !may {
+			c.IndentedJSON(http.StatusForbidden, gin.H{"error": "Insufficient access. Access denied!"})
+			return
+		}
+
+		users, err := model.GetUsersInOrgUnit(orgUnit.Id)
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+			return
+		}
+
+		safeUsers := make([]SafeUser, 0)
+		for _, u := range users {
+			safeUsers = append(safeUsers, safeUser(u))
+		}
+		c.IndentedJSON(http.StatusOK, gin.H{"data": safeUsers})
+	} else {
+		c.IndentedJSON(http.StatusForbidden, gin.H{"error": "Insufficient access. Access denied!"})
+	}
+}
+
+// CreateOrgUnit Create an org unit
+//
+//	@Summary		Create an org unit
+//	@Description	Create an org unit. Admins only
+//	@Tags			orgunit
+//	@Accept			json
+//	@Produce		json
+//	@Param			orgUnit	body	model.ProposedOrgUnit	true	"Org unit Data"
+//	@Security		BasicAuth
+//	@Success		200	{object}	model.OrgUnit
+//	@Failure		400	{object}	model.FailureMsg
+//	@Failure		403	{object}	model.FailureMsg
+//	@Router			/orgunits [post]
+func (g *TodoerService) CreateOrgUnit(c *gin.Context) {
+	_, authed := g.GetUserId(c)
+	if authed {
+		var json model.ProposedOrgUnit
+		if err := c.ShouldBindJSON(&json); err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		json.OrgUnitName = strings.TrimSpace(json.OrgUnitName)
+		if json.OrgUnitName == "" {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "org unit must have a name"})
+			return
+		}
+
+		orgUnit, err := model.CreateOrgUnit(json)
+		if err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, orgUnit)
+	} else {
+		c.IndentedJSON(http.StatusForbidden, gin.H{"error": "Insufficient access. Access denied!"})
+	}
+}
+
+// DeleteOrgUnit Remove an org unit
+//
+//	@Summary		Remove an org unit
+//	@Description	Remove an org unit. Admins only. Its users must be moved elsewhere first, and the default org unit stays
+//	@Tags			orgunit
+//	@Produce		json
+//	@Param			id	path	int	true	"Org unit Id"
+//	@Security		BasicAuth
+//	@Success		200	{object}	model.SuccessMsg
+//	@Failure		400	{object}	model.FailureMsg
+//	@Failure		403	{object}	model.FailureMsg
+//	@Failure		404	{object}	model.FailureMsg
+//	@Router			/orgunits/{id} [delete]
+func (g *TodoerService) DeleteOrgUnit(c *gin.Context) {
+	_, authed := g.GetUserId(c)
+	if authed {
+		orgUnit, ok := pathOrgUnit(c)
+		if !ok {
+			return
+		}
+		if orgUnit.Id == model.DefaultOrgUnitId {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "cannot remove the default org unit"})
+			return
+		}
+
+		count, err := model.CountUsersInOrgUnit(orgUnit.Id)
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+			return
+		}
+		if count > 0 {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "org unit '" + orgUnit.OrgUnitName + "' still has " +
+				strconv.Itoa(count) + " users"})
+			return
+		}
+
+		status, err := model.DeleteOrgUnit(orgUnit.Id)
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+			return
+		}
+
+		if status {
+			c.IndentedJSON(http.StatusOK, gin.H{"message": "Org unit '" + orgUnit.OrgUnitName + "' has been removed"})
+		} else {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "no records found with org unit id " + strconv.Itoa(orgUnit.Id)})
+		}
+	} else {
+		c.IndentedJSON(http.StatusForbidden, gin.H{"error": "Insufficient access. Access denied!"})
+	}
+}
+
+// SetUserOrgUnit Move a user to another org unit
+//
+//	@Summary		Move a user to another org unit
+//	@Description	Move a user to another org unit. Admins only. The user leaves the lists of their old org unit, their own lists are no longer shared there, and todos lose assignees and lists from the other org unit
+//	@Tags			orgunit
+//	@Accept			json
+//	@Produce		json
+//	@Param			name	path	string				true	"User name"
+//	@Param			orgUnit	body	model.UserOrgUnit	true	"Org unit"
+//	@Security		BasicAuth
+//	@Success		200	{object}	model.SuccessMsg
+//	@Failure		400	{object}	model.FailureMsg
+//	@Failure		403	{object}	model.FailureMsg
+//	@Failure		404	{object}	model.FailureMsg
+//	@Router			/user/{name}/orgunit [patch]
+func (g *TodoerService) SetUserOrgUnit(c *gin.Context) {
+	_, authed := g.GetUserId(c)
+	if authed {
+		user, ok := pathUser(c)
+		if !ok {
+			return
+		}
+		var json model.UserOrgUnit
+		if err := c.ShouldBindJSON(&json); err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		orgUnit, err := model.GetOrgUnitById(json.OrgUnitId)
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+			return
+		}
+		if orgUnit.Id == 0 {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "no records found with org unit id " +
+				strconv.Itoa(json.OrgUnitId)})
+			return
+		}
+
+		if user.OrgUnitId != orgUnit.Id {
+			if err := model.SetUserOrgUnit(user.Id, orgUnit.Id); err != nil {
+				c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+				return
+			}
+		}
+
+		c.IndentedJSON(http.StatusOK, gin.H{"message": "User '" + user.UserName + "' is in org unit '" +
+			orgUnit.OrgUnitName + "'"})
+	} else {
+		c.IndentedJSON(http.StatusForbidden, gin.H{"error": "Insufficient access. Access denied!"})
+	}
+}
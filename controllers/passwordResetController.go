@@ -0,0 +1,168 @@
+package controllers
+
+import (
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/greeneg/todoer/model"
+	"github.com/greeneg/todoer/notify"
+	"github.com/greeneg/todoer/passhash"
+	"github.com/gin-gonic/gin"
+)
+
+// defaultResetTokenMinutes is how long a reset token works when the config
+// does not say
+const defaultResetTokenMinutes = 60
+
+// resetMailInterval limits how often reset mails are sent to one address
+const resetMailInterval = 5 * time.Minute
+
+// resetRequestedMsg is the answer to every reset request, so that it does not
+// tell whether an account exists
+const resetRequestedMsg = "If the account exists and has an email address, a password reset token has been sent to it"
+
+// resetUsers Returns the users a reset request names, by user name or else
+// by email address
+func resetUsers(r model.PasswordResetRequest) ([]model.User, error) {
+	if r.UserName != "" {
+		user, err := model.GetUserByUserName(r.UserName)
+		if err != nil || user.Id == 0 {
+			return nil, err
+		}
+		if r.Email != "" && !strings.EqualFold(user.Email, r.Email) {
+			return nil, nil
+		}
+		return []model.User{user}, nil
+	}
+
+	return model.GetUsersByEmail(r.Email)
+}
+
+// sendPasswordReset Mails a new reset token to each user a reset request
+// names, unless they are locked, have no email address, have their password
+// kept by a directory or identity provider, or were sent one only a moment ago
+func (g *TodoerService) sendPasswordReset(r model.PasswordResetRequest) {
+	users, err := resetUsers(r)
+	if err != nil {
+		return
+	}
+
+	minutes := g.ConfStruct.PasswordReset.TokenMinutes
+	if minutes <= 0 {
+		minutes = defaultResetTokenMinutes
+	}
+	for _, user := range users {
+		if user.Email == "" || user.Status == "locked" || user.Source != model.SourceLocal {
+			continue
+		}
+		since := time.Now().UTC().Add(-resetMailInterval).Format(model.SqlDateTimeFormat)
+		if sent, err := model.HasMailSince("passwordreset", user.Email, since); err != nil || sent {
+			continue
+		}
+
+		token, err := randomToken()
+		if err != nil {
+			log.Println("ERROR: Cannot create password reset token: " + string(err.Error()))
+			return
+		}
+		expiryDate := time.Now().UTC().Add(time.Duration(minutes) * time.Minute).Format(model.SqlDateTimeFormat)
+		if err := model.CreatePasswordReset(user.Id, model.HashToken(token), expiryDate); err != nil {
+			continue
+		}
+		if !g.ConfStruct.Smtp.Enabled {
+			log.Println("WARN: Cannot mail password reset token to '" + user.UserName + "', as SMTP is disabled")
+			continue
+		}
+		notify.PasswordReset(user, token, g.ConfStruct.PasswordReset.Url, minutes)
+	}
+}
+
+// RequestPasswordReset Ask for a password reset token
+//
+//	@Summary		Ask for a password reset token
+//	@Description	Mail a single-use, time-limited password reset token to the account named by userName or email. Accounts from a directory or identity provider are not sent one. The answer is the same whether or not the account exists
+//	@Tags			auth
+//	@Accept			json
+//	@Produce		json
+//	@Param			request	body	model.PasswordResetRequest	true	"User name or email address"
+//	@Success		202	{object}	model.SuccessMsg
+//	@Failure		400	{object}	model.FailureMsg
+//	@Router			/password-reset [post]
+func (g *TodoerService) RequestPasswordReset(c *gin.Context) {
+	var json model.PasswordResetRequest
+	if err := c.ShouldBindJSON(&json); err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	json.UserName = strings.TrimSpace(json.UserName)
+	json.Email = strings.TrimSpace(json.Email)
+	if json.UserName == "" && json.Email == "" {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "userName or email is required"})
+		return
+	}
+
+	// the lookup and mail happen after answering, so that the time taken
+	// does not tell either
+	go g.sendPasswordReset(json)
+
+	c.IndentedJSON(http.StatusAccepted, gin.H{"message": resetRequestedMsg})
+}
+
+// ConfirmPasswordReset Set a new password with a reset token
+//
+//	@Summary		Set a new password with a reset token
+//	@Description	Set a new password with a token from a password reset mail. The token works once, and all sessions of the user are ended. A password breaking the password policy is refused with the rules it breaks
+//	@Tags			auth
+//	@Accept			json
+//	@Produce		json
+//	@Param			reset	body	model.PasswordResetConfirm	true	"Reset token and new password"
+//	@Success		200	{object}	model.SuccessMsg
+//	@Failure		400	{object}	model.FailureMsg
+//	@Router			/password-reset/confirm [post]
+func (g *TodoerService) ConfirmPasswordReset(c *gin.Context) {
+	var json model.PasswordResetConfirm
+	if err := c.ShouldBindJSON(&json); err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if json.Token == "" || json.NewPassword == "" {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "token and newPassword are required"})
+		return
+	}
+
+	tokenHash := model.HashToken(json.Token)
+	user, err := model.GetPasswordResetUser(tokenHash)
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+		return
+	}
+	// the password of an account since taken over by a directory or identity
+	// provider is not ours to reset
+	if user.Id == 0 || user.Source != model.SourceLocal {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "invalid or expired reset token"})
+		return
+	}
+	if !passwordAccepted(c, user.Id, json.NewPassword) {
+		return
+	}
+
+	hash, err := passhash.Hash(json.NewPassword)
+	if err != nil {
+		log.Println("ERROR: Cannot hash password: " + string(err.Error()))
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "User password could not be updated!"})
+		return
+	}
+	user, err = model.ResetPassword(tokenHash, hash)
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+		return
+	}
+	if user.Id == 0 {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "invalid or expired reset token"})
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, gin.H{"message": "User '" + user.UserName + "' has changed their password"})
+}
@@ -0,0 +1,114 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/greeneg/todoer/model"
+	"github.com/gin-gonic/gin"
+)
+
+// validateProfile Returns an error message if the profile is unusable, and
+// normalizes its fields
+func validateProfile(userId int, p *model.UserProfile) string {
+	p.FullName = strings.TrimSpace(p.FullName)
+	if p.FullName == "" {
+		return "full name must not be empty"
+	}
+	p.Email = strings.TrimSpace(p.Email)
+	if err := model.ValidateEmail(p.Email); err != nil {
+		return string(err.Error())
+	}
+	p.Timezone = strings.TrimSpace(p.Timezone)
+	if err := model.ValidateTimezone(p.Timezone); err != nil {
+		return string(err.Error())
+	}
+	locale, err := model.NormalizeLocale(strings.TrimSpace(p.Locale))
+	if err != nil {
+		return string(err.Error())
+	}
+	p.Locale = locale
+	if err := model.ValidatePreferences(userId, p.Preferences); err != nil {
+		return string(err.Error())
+	}
+
+	return ""
+}
+
+// GetProfile Retrieve the profile of the logged in user
+//
+//	@Summary		Retrieve the profile of the logged in user
+//	@Description	Retrieve the full name, email address, time zone, locale and preferences of the logged in user
+//	@Tags			user
+//	@Produce		json
+//	@Security		BasicAuth
+//	@Success		200	{object}	model.UserProfile
+//	@Failure		403	{object}	model.FailureMsg
+//	@Router			/me/profile [get]
+func (g *TodoerService) GetProfile(c *gin.Context) {
+	user, authed := g.GetUserId(c)
+	if authed {
+		c.IndentedJSON(http.StatusOK, model.GetProfile(user))
+	} else {
+		c.IndentedJSON(http.StatusForbidden, gin.H{"error": "Insufficient access. Access denied!"})
+	}
+}
+
+// UpdateProfile Change the profile of the logged in user
+//
+//	@Summary		Change the profile of the logged in user
+//	@Description	Change the full name, email address, time zone, locale or preferences of the logged in user. Omitted fields are left unchanged, and preferences are merged into the stored ones. The time zone is an IANA name such as Europe/Berlin and the locale a BCP 47 tag such as de-DE; empty strings unset them
+//	@Tags			user
+//	@Accept			json
+//	@Produce		json
+//	@Param			profile	body	model.ProfileUpdate	true	"Profile Data"
+//	@Security		BasicAuth
+//	@Success		200	{object}	model.UserProfile
+//	@Failure		400	{object}	model.FailureMsg
+//	@Failure		403	{object}	model.FailureMsg
+//	@Router			/me/profile [patch]
+func (g *TodoerService) UpdateProfile(c *gin.Context) {
+	user, authed := g.GetUserId(c)
+	if authed {
+		var update model.ProfileUpdate
+		if err := c.ShouldBindJSON(&update); err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		profile := model.GetProfile(user)
+		if update.FullName != nil {
+			profile.FullName = *update.FullName
+		}
+		if update.Email != nil {
+			profile.Email = *update.Email
+		}
+		if update.Timezone != nil {
+			profile.Timezone = *update.Timezone
+		}
+		if update.Locale != nil {
+			profile.Locale = *update.Locale
+		}
+		if len(update.Preferences) > 0 && string(update.Preferences) != "null" {
+			if err := json.Unmarshal(update.Preferences, &profile.Preferences); err != nil {
+				c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "invalid preferences: " + err.Error()})
+				return
+			}
+		}
+		if msg := validateProfile(user.Id, &profile); msg != "" {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+
+		user, err := model.UpdateProfile(user.Id, profile)
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, model.GetProfile(user))
+	} else {
+		c.IndentedJSON(http.StatusForbidden, gin.H{"error": "Insufficient access. Access denied!"})
+	}
+}
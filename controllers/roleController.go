@@ -0,0 +1,215 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/greeneg/todoer/model"
+	"github.com/gin-gonic/gin"
+)
+
+// isLastAdmin reports whether taking the admin role from a user, or locking
+// or deleting them, would leave nobody to administer the server
+func isLastAdmin(user model.User) (bool, error) {
+	isAdmin, err := model.UserHasRole(user.Id, model.RoleAdmin)
+	if err != nil || !isAdmin {
+		return false, err
+	}
+	count, err := model.CountUsersWithRole(model.RoleAdmin)
+	if err != nil {
+		return false, err
+	}
+
+	return count <= 1, nil
+}
+
+// GetRoles Retrieve the roles users can be granted
+//
+//	@Summary		Retrieve the roles users can be granted
+//	@Description	Retrieve the roles users can be granted
+//	@Tags			role
+//	@Produce		json
+//	@Security		BasicAuth
+//	@Success		200	{object}	model.RoleList
+//	@Failure		403	{object}	model.FailureMsg
+//	@Router			/roles [get]
+func (g *TodoerService) GetRoles(c *gin.Context) {
+	_, authed := g.GetUserId(c)
+	if authed {
+		roles, err := model.GetRoles()
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, gin.H{"data": roles})
+	} else {
+		c.IndentedJSON(http.StatusForbidden, gin.H{"error": "Insufficient access. Access denied!"})
+	}
+}
+
+// GetUserRoles Retrieve the roles of a user
+//
+//	@Summary		Retrieve the roles of a user
+//	@Description	Retrieve the roles of a user. Users may see their own roles and admins anyone's
+//	@Tags			role
+//	@Produce		json
+//	@Param			name	path	string	true	"User name"
+//	@Security		BasicAuth
+//	@Success		200	{object}	model.RoleList
+//	@Failure		403	{object}	model.FailureMsg
+//	@Failure		404	{object}	model.FailureMsg
+//	@Router			/user/{name}/roles [get]
+func (g *TodoerService) GetUserRoles(c *gin.Context) {
+	_, authed := g.GetUserId(c)
+	if authed {
+		user, ok := pathUser(c)
+		if !ok {
+			return
+		}
+
+		roles, err := model.GetUserRoles(user.Id)
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, gin.H{"data": roles})
+	} else {
+		c.IndentedJSON(http.StatusForbidden, gin.H{"error": "Insufficient access. Access denied!"})
+	}
+}
+
+// pathRole Returns the role named by the :role path parameter, answering
+// the request itself when there is no such role
+func pathRole(c *gin.Context) (model.Role, bool) {
+	roleName := c.Param("role")
+	role, err := model.GetRoleByName(roleName)
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+		return model.Role{}, false
+	}
+	if role.Id == 0 {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"error": "no role found with name " + roleName})
+		return model.Role{}, false
+	}
+
+	return role, true
+}
+
+// mayChangeRole Reports whether the actor may grant or revoke the role,
+// answering the request itself when not. Only admins hand out the admin role
+func mayChangeRole(c *gin.Context, actor model.User, role model.Role) bool {
+	if role.RoleName != model.RoleAdmin {
+		return true
+	}
+	admin, err := isAdmin(actor)
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+		return false
+	}
+	if !admin {
+		c.IndentedJSON(http.StatusForbidden, gin.H{"error": "Insufficient access. Access denied!"})
+		return false
+	}
+
+	return true
+}
+
+// GrantUserRole Grant a role to a user
+//
+//	@Summary		Grant a role to a user
+//	@Description	Grant a role to a user. Admins may grant any role, and org admins any but admin to the users of their org unit
+//	@Tags			role
+//	@Produce		json
+//	@Param			name	path	string	true	"User name"
+//	@Param			role	path	string	true	"Role name"
+//	@Security		BasicAuth
+//	@Success		200	{object}	model.SuccessMsg
+//	@Failure		403	{object}	model.FailureMsg
+//	@Failure		404	{object}	model.FailureMsg
+//	@Router			/user/{name}/roles/{role} [post]
+func (g *TodoerService) GrantUserRole(c *gin.Context) {
+	actor, authed := g.GetUserId(c)
+	if authed {
+		user, ok := pathUser(c)
+		if !ok {
+			return
+		}
+		role, ok := pathRole(c)
+		if !ok {
+			return
+		}
+		if !mayChangeRole(c, actor, role) {
+			return
+		}
+
+		if err := model.GrantRole(user.Id, role.Id); err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, gin.H{"message": "User '" + user.UserName + "' has been granted the '" +
+			role.RoleName + "' role"})
+	} else {
+		c.IndentedJSON(http.StatusForbidden, gin.H{"error": "Insufficient access. Access denied!"})
+	}
+}
+
+// RevokeUserRole Take a role away from a user
+//
+//	@Summary		Take a role away from a user
+//	@Description	Take a role away from a user. Admins may revoke any role, and org admins any but admin from the users of their org unit. The last admin keeps the admin role
+//	@Tags			role
+//	@Produce		json
+//	@Param			name	path	string	true	"User name"
+//	@Param			role	path	string	true	"Role name"
+//	@Security		BasicAuth
+//	@Success		200	{object}	model.SuccessMsg
+//	@Failure		400	{object}	model.FailureMsg
+//	@Failure		403	{object}	model.FailureMsg
+//	@Failure		404	{object}	model.FailureMsg
+//	@Router			/user/{name}/roles/{role} [delete]
+func (g *TodoerService) RevokeUserRole(c *gin.Context) {
+	actor, authed := g.GetUserId(c)
+	if authed {
+		user, ok := pathUser(c)
+		if !ok {
+			return
+		}
+		role, ok := pathRole(c)
+		if !ok {
+			return
+		}
+		if !mayChangeRole(c, actor, role) {
+			return
+		}
+
+		if role.RoleName == model.RoleAdmin {
+			last, err := isLastAdmin(user)
+			if err != nil {
+				c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+				return
+			}
+			if last {
+				c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "cannot revoke the admin role of the last admin"})
+				return
+			}
+		}
+
+		status, err := model.RevokeRole(user.Id, role.Id)
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+			return
+		}
+
+		if status {
+			c.IndentedJSON(http.StatusOK, gin.H{"message": "User '" + user.UserName + "' no longer has the '" +
+				role.RoleName + "' role"})
+		} else {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "user '" + user.UserName + "' does not have the '" +
+				role.RoleName + "' role"})
+		}
+	} else {
+		c.IndentedJSON(http.StatusForbidden, gin.H{"error": "Insufficient access. Access denied!"})
+	}
+}
@@ -0,0 +1,72 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/greeneg/todoer/model"
+	"github.com/gin-gonic/gin"
+)
+
+// SetUserServiceAccount Mark a user as a service account
+//
+//	@Summary		Mark a user as a service account
+//	@Description	Let a user log in with a TLS client certificate naming them, or stop them from doing so. Admins only. Admins and users with two-factor authentication cannot be service accounts
+//	@Tags			user
+//	@Accept			json
+//	@Produce		json
+//	@Param			name			path	string						true	"User name"
+//	@Param			serviceAccount	body	model.UserServiceAccount	true	"Service account"
+//	@Security		BasicAuth
+//	@Success		200	{object}	model.SuccessMsg
+//	@Failure		400	{object}	model.FailureMsg
+//	@Failure		403	{object}	model.FailureMsg
+//	@Failure		404	{object}	model.FailureMsg
+//	@Router			/user/{name}/service [patch]
+func (g *TodoerService) SetUserServiceAccount(c *gin.Context) {
+	_, authed := g.GetUserId(c)
+	if authed {
+		user, ok := pathUser(c)
+		if !ok {
+			return
+		}
+		var json model.UserServiceAccount
+		if err := c.ShouldBindJSON(&json); err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if json.ServiceAccount {
+			admin, err := isAdmin(user)
+			if err != nil {
+				c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+				return
+			}
+			if admin {
+				c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "admins cannot be service accounts"})
+				return
+			}
+			enabled, err := model.TwoFactorEnabled(user.Id)
+			if err != nil {
+				c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+				return
+			}
+			if enabled {
+				c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "users with two-factor authentication cannot be service accounts"})
+				return
+			}
+		}
+
+		if err := model.SetUserServiceAccount(user.Id, json.ServiceAccount); err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+			return
+		}
+
+		if json.ServiceAccount {
+			c.IndentedJSON(http.StatusOK, gin.H{"message": "User '" + user.UserName + "' is a service account"})
+		} else {
+			c.IndentedJSON(http.StatusOK, gin.H{"message": "User '" + user.UserName + "' is not a service account"})
+		}
+	} else {
+		c.IndentedJSON(http.StatusForbidden, gin.H{"error": "Insufficient access. Access denied!"})
+	}
+}
@@ -0,0 +1,115 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/greeneg/todoer/model"
+	"github.com/greeneg/todoer/sessionstore"
+	"github.com/gin-gonic/gin"
+)
+
+// GetUserSessions Retrieve the active sessions of a user
+//
+//	@Summary		Retrieve the active sessions of a user
+//	@Description	Retrieve the unexpired login sessions of a user, most recently used first
+//	@Tags			user
+//	@Produce		json
+//	@Param			name	path	string	true	"User name"
+//	@Security		BasicAuth
+//	@Success		200	{object}	model.SessionList
+//	@Failure		404	{object}	model.FailureMsg
+//	@Router			/user/{name}/sessions [get]
+func (g *TodoerService) GetUserSessions(c *gin.Context) {
+	_, authed := g.GetUserId(c)
+	if authed {
+		user, ok := pathUser(c)
+		if !ok {
+			return
+		}
+
+		sessions, err := model.GetSessionsForUser(user.Id, sessionstore.IdleCutoff())
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, gin.H{"data": sessions})
+	} else {
+		c.IndentedJSON(http.StatusForbidden, gin.H{"error": "Insufficient access. Access denied!"})
+	}
+}
+
+// DeleteUserSessions Revoke every session of a user
+//
+//	@Summary		Revoke every session of a user
+//	@Description	Sign a user out everywhere. Clients using Basic authentication are unaffected
+//	@Tags			user
+//	@Produce		json
+//	@Param			name	path	string	true	"User name"
+//	@Security		BasicAuth
+//	@Success		200	{object}	model.SuccessMsg
+//	@Failure		404	{object}	model.FailureMsg
+//	@Router			/user/{name}/sessions [delete]
+func (g *TodoerService) DeleteUserSessions(c *gin.Context) {
+	_, authed := g.GetUserId(c)
+	if authed {
+		user, ok := pathUser(c)
+		if !ok {
+			return
+		}
+
+		count, err := model.DeleteSessionsForUser(user.Id)
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, gin.H{"message": "Revoked " + strconv.Itoa(count) +
+			" sessions of user '" + user.UserName + "'"})
+	} else {
+		c.IndentedJSON(http.StatusForbidden, gin.H{"error": "Insufficient access. Access denied!"})
+	}
+}
+
+// DeleteUserSession Revoke one session of a user
+//
+//	@Summary		Revoke one session of a user
+//	@Description	Revoke one session of a user
+//	@Tags			user
+//	@Produce		json
+//	@Param			name	path	string	true	"User name"
+//	@Param			id		path	int		true	"Session Id"
+//	@Security		BasicAuth
+//	@Success		200	{object}	model.SuccessMsg
+//	@Failure		400	{object}	model.FailureMsg
+//	@Failure		404	{object}	model.FailureMsg
+//	@Router			/user/{name}/sessions/{id} [delete]
+func (g *TodoerService) DeleteUserSession(c *gin.Context) {
+	_, authed := g.GetUserId(c)
+	if authed {
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "invalid session id"})
+			return
+		}
+		user, ok := pathUser(c)
+		if !ok {
+			return
+		}
+
+		status, err := model.DeleteSessionForUser(user.Id, id)
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+			return
+		}
+
+		if status {
+			c.IndentedJSON(http.StatusOK, gin.H{"message": "Session " + strconv.Itoa(id) + " has been revoked"})
+		} else {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "no records found with session id " + strconv.Itoa(id)})
+		}
+	} else {
+		c.IndentedJSON(http.StatusForbidden, gin.H{"error": "Insufficient access. Access denied!"})
+	}
+}
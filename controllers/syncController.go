@@ -0,0 +1,76 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/greeneg/todoer/delta"
+	"github.com/gin-gonic/gin"
+)
+
+// GetSync Retrieve what changed since a sync token
+//
+//	@Summary		Retrieve changes since a sync token
+//	@Description	Returns the todos, lists and tags created, updated or deleted since the given sync token, along with a new token. Todos and lists the user can no longer see are reported as deleted. Without a token everything the user can see is returned and the response is flagged as full
+//	@Tags			sync
+//	@Produce		json
+//	@Param			since	query	string	false	"Sync token from a previous sync"
+//	@Security		BasicAuth
+//	@Success		200	{object}	delta.Delta
+//	@Failure		400	{object}	model.FailureMsg
+//	@Router			/sync [get]
+func (g *TodoerService) GetSync(c *gin.Context) {
+	user, authed := g.GetUserId(c)
+	if authed {
+		d, err := delta.Changes(user, c.Query("since"))
+		if err == delta.ErrInvalidToken {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "invalid sync token, start a full sync"})
+			return
+		}
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, d)
+	} else {
+		c.IndentedJSON(http.StatusForbidden, gin.H{"error": "Insufficient access. Access denied!"})
+	}
+}
+
+// PostSync Apply a batch of changes made offline
+//
+//	@Summary		Apply a batch of changes
+//	@Description	Applies changes made by a client while offline, in order. Conflicts are resolved field by field, the most recent change winning by the server's record of when each field last changed. Fields and changes that were not applied are reported as rejected. Pass the returned token to GET /sync to fetch the merged state
+//	@Tags			sync
+//	@Accept			json
+//	@Produce		json
+//	@Param			batch	body	delta.Batch	true	"Changes"
+//	@Security		BasicAuth
+//	@Success		200	{object}	delta.Result
+//	@Failure		400	{object}	model.FailureMsg
+//	@Router			/sync [post]
+func (g *TodoerService) PostSync(c *gin.Context) {
+	user, authed := g.GetUserId(c)
+	if authed {
+		var json delta.Batch
+		if err := c.ShouldBindJSON(&json); err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if len(json.Changes) > delta.MaxBatchSize {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "a batch may hold at most " + strconv.Itoa(delta.MaxBatchSize) + " changes"})
+			return
+		}
+
+		result, err := delta.Apply(user, json)
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, result)
+	} else {
+		c.IndentedJSON(http.StatusForbidden, gin.H{"error": "Insufficient access. Access denied!"})
+	}
+}
@@ -0,0 +1,74 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/greeneg/todoer/model"
+	"github.com/gin-gonic/gin"
+)
+
+// GetTags Retrieve the tags of visible todos
+//
+//	@Summary		Retrieve tags
+//	@Description	Retrieve the tags on the todos the user can see. Tags are created by adding them to todos, and each org unit has its own
+//	@Tags			tag
+//	@Produce		json
+//	@Security		BasicAuth
+//	@Success		200	{object}	model.TagList
+//	@Failure		400	{object}	model.FailureMsg
+//	@Router			/tags [get]
+func (g *TodoerService) GetTags(c *gin.Context) {
+	user, authed := g.GetUserId(c)
+	if authed {
+		tags, err := model.GetTagsForUser(user.Id)
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, gin.H{"data": tags})
+	} else {
+		c.IndentedJSON(http.StatusForbidden, gin.H{"error": "Insufficient access. Access denied!"})
+	}
+}
+
+// DeleteTag Remove a tag
+//
+//	@Summary		Delete tag
+//	@Description	Delete a tag, removing it from all todos. Tags on todos the user cannot see cannot be deleted
+//	@Tags			tag
+//	@Produce		json
+//	@Param			id	path	int	true	"Tag Id"
+//	@Security		BasicAuth
+//	@Success		200	{object}	model.SuccessMsg
+//	@Failure		400	{object}	model.FailureMsg
+//	@Router			/tags/{id} [delete]
+func (g *TodoerService) DeleteTag(c *gin.Context) {
+	user, authed := g.GetUserId(c)
+	if authed {
+		id, _ := strconv.Atoi(c.Param("id"))
+		tag, err := model.GetTagById(id)
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+			return
+		}
+		if tag.Id == 0 || tag.OrgUnitId != user.OrgUnitId {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "no records found with tag id " + strconv.Itoa(id)})
+			return
+		}
+		if model.TagUsedOutside(tag.Id, user.Id) {
+			c.IndentedJSON(http.StatusForbidden, gin.H{"error": "Tag is in use on todos you cannot see"})
+			return
+		}
+
+		if _, err := model.DeleteTag(tag.Id); err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "Unable to remove tag! " + string(err.Error())})
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, gin.H{"message": "Tag " + strconv.Itoa(tag.Id) + " has been removed"})
+	} else {
+		c.IndentedJSON(http.StatusForbidden, gin.H{"error": "Insufficient access. Access denied!"})
+	}
+}
@@ -1,11 +1,14 @@
 package controllers
 
 import (
+	"errors"
 	"log"
 	"net/http"
 	"strconv"
 
+	"github.com/greeneg/todoer/events"
 	"github.com/greeneg/todoer/model"
+	"github.com/greeneg/todoer/notify"
 	"github.com/gin-gonic/gin"
 )
 
@@ -22,7 +25,7 @@ import (
 //	@Failure		400	{object}	model.FailureMsg
 //	@Router			/todo [post]
 func (t *TodoerService) CreateTodo(c *gin.Context) {
-	_, authed := t.GetUserId(c)
+	user, authed := t.GetUserId(c)
 	if authed {
 		var json model.ProposedTodo
 		if err := c.ShouldBindJSON(&json); err != nil {
@@ -30,12 +33,36 @@ func (t *TodoerService) CreateTodo(c *gin.Context) {
 			return
 		}
 
-		s, err := model.CreateTodo(json)
-		if s {
-			c.IndentedJSON(http.StatusOK, gin.H{"message": "Todo has been created"})
-		} else {
+		if json.ListId != 0 && !model.CanViewList(user.Id, json.ListId) {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "no records found with list id " + strconv.Itoa(json.ListId)})
+			return
+		}
+		if json.AssigneeId != 0 && !model.SameOrgUnit(user.Id, json.AssigneeId) {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "no records found with user id " + strconv.Itoa(json.AssigneeId)})
+			return
+		}
+		if json.ParentId != 0 {
+			parent, err := model.GetTodoById(json.ParentId)
+			if err != nil {
+				c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+				return
+			}
+			if parent.Description == "" || !model.CanViewTodo(user.Id, parent) {
+				c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "no records found with todo id " + strconv.Itoa(json.ParentId)})
+				return
+			}
+		}
+
+		ent, err := model.CreateTodo(json, user.Id)
+		if err != nil {
 			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
 		}
+
+		notify.TodoAssigned(ent, user)
+		notify.TodoMentions(ent, user)
+		events.Publish(events.TodoCreated, user.UserName, ent, nil)
+		c.IndentedJSON(http.StatusOK, gin.H{"message": "Todo has been created"})
 	} else {
 		c.IndentedJSON(http.StatusForbidden, gin.H{"error": "Insufficient access. Access denied!"})
 	}
@@ -52,12 +79,17 @@ func (t *TodoerService) CreateTodo(c *gin.Context) {
 //	@Security		BasicAuth
 //	@Success		200	{object}	model.SuccessMsg
 //	@Failure		400	{object}	model.FailureMsg
+//	@Failure			404	{object}	model.FailureMsg
 //	@Router			/todo/{id} [delete]
 func (t *TodoerService) DeleteTodo(c *gin.Context) {
-	_, authed := t.GetUserId(c)
+	user, authed := t.GetUserId(c)
 	if authed {
-		id, _ := strconv.Atoi(c.Param("id"))
-		status, err := model.DeleteTodo(id)
+		ent, found := visibleTodo(c, user)
+		if !found {
+			return
+		}
+
+		status, err := model.DeleteTodo(ent.Id)
 		if err != nil {
 			log.Println("ERROR: Cannot delete todo: " + string(err.Error()))
 			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "Unable to remove todo! " + string(err.Error())})
@@ -65,10 +97,10 @@ func (t *TodoerService) DeleteTodo(c *gin.Context) {
 		}
 
 		if status {
-			idString := strconv.Itoa(id)
-			c.IndentedJSON(http.StatusOK, gin.H{"message": "User " + idString + " has been removed"})
+			events.Publish(events.TodoDeleted, user.UserName, ent, nil)
+			c.IndentedJSON(http.StatusOK, gin.H{"message": "Todo " + strconv.Itoa(ent.Id) + " has been removed"})
 		} else {
-			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "Unable to remove user!"})
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "Unable to remove todo!"})
 		}
 	} else {
 		c.IndentedJSON(http.StatusForbidden, gin.H{"error": "Insufficient access. Access denied!"})
@@ -78,7 +110,7 @@ func (t *TodoerService) DeleteTodo(c *gin.Context) {
 // GetTodos Retrieve list of all todos
 //
 //	@Summary		Retrieve list of todos
-//	@Description	Retrieve list of all todos
+//	@Description	Retrieve list of all todos the user created or is assigned to
 //	@Tags			todo
 //	@Produce		json
 //	@Security		BasicAuth
@@ -86,9 +118,9 @@ func (t *TodoerService) DeleteTodo(c *gin.Context) {
 //	@Failure		400	{object}	model.FailureMsg
 //	@Router			/todo [get]
 func (t *TodoerService) GetTodos(c *gin.Context) {
-	_, authed := t.GetUserId(c)
+	user, authed := t.GetUserId(c)
 	if authed {
-		todos, err := model.GetTodos()
+		todos, err := model.GetTodosForUser(user.Id)
 		if err != nil {
 			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
 			return
@@ -114,21 +146,13 @@ func (t *TodoerService) GetTodos(c *gin.Context) {
 //	@Security		BasicAuth
 //	@Success		200	{object}	model.Todo
 //	@Failure		400	{object}	model.FailureMsg
+//	@Failure			404	{object}	model.FailureMsg
 //	@Router			/todo/{id} [get]
 func (t *TodoerService) GetTodoById(c *gin.Context) {
-	_, authed := t.GetUserId(c)
+	user, authed := t.GetUserId(c)
 	if authed {
-		id, _ := strconv.Atoi(c.Param("id"))
-		ent, err := model.GetTodoById(id)
-		if err != nil {
-			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
-			return
-		}
-
-		if ent.Description == "" {
-			strId := strconv.Itoa(id)
-			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "no records found with todo id " + strId})
-		} else {
+		ent, found := visibleTodo(c, user)
+		if found {
 			c.IndentedJSON(http.StatusOK, ent)
 		}
 	} else {
@@ -148,33 +172,96 @@ func (t *TodoerService) GetTodoById(c *gin.Context) {
 //	@Security		BasicAuth
 //	@Success	200	{object}	model.SuccessMsg
 //	@Failure	400	{object}	model.FailureMsg
+//	@Failure		404	{object}	model.FailureMsg
 //	@Router		/todo/{id}/{status} [put]
 func (t *TodoerService) UpdateTodo(c *gin.Context) {
-	_, authed := t.GetUserId(c)
+	user, authed := t.GetUserId(c)
 	if authed {
 		// first, _get_ the Todo, then update it with the data
-		id, _ := strconv.Atoi(c.Param("id"))
-		ent, err := model.GetTodoById(id)
-		if err != nil {
-			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+		ent, found := visibleTodo(c, user)
+		if !found {
 			return
 		}
 		// now validate that the status string is one we know
 		status := c.Param("status")
 		statusId, err := model.GetStatusByName(status)
 		if err != nil {
+			var invalid *model.InvalidTodoStatus
+			if errors.As(err, &invalid) {
+				c.IndentedJSON(http.StatusBadRequest, gin.H{"error": string(err.Error())})
+				return
+			}
 			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
 			return
 		}
 		// now update ent with the new status
+		previous := ent
 		ent, err = model.UpdateTodo(ent.Id, statusId)
 		if err != nil {
 			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
 			return
 		} else {
+			if ent.Status != previous.Status {
+				events.Publish(events.TodoStatusChanged, user.UserName, ent, previous)
+			}
 			c.IndentedJSON(http.StatusOK, ent)
 		}
 	} else {
 		c.IndentedJSON(http.StatusForbidden, gin.H{"error": "Insufficient access. Access denied!"})
 	}
 }
+
+// ModifyTodo	Change the details of a todo
+//
+//	@Summary	Change the details of a todo
+//	@Description	Updates the description, assignee, list, due date, reminder, priority or tags of a todo. Omitted fields are left unchanged
+//	@Tags		todo
+//	@Accept		json
+//	@Produce	json
+//	@Param		id	path int true "Todo ID"
+//	@Param		todo	body	model.TodoUpdate	true	"Todo Data"
+//	@Security		BasicAuth
+//	@Success	200	{object}	model.Todo
+//	@Failure	400	{object}	model.FailureMsg
+//	@Failure		404	{object}	model.FailureMsg
+//	@Router		/todo/{id} [patch]
+func (t *TodoerService) ModifyTodo(c *gin.Context) {
+	user, authed := t.GetUserId(c)
+	if authed {
+		before, found := visibleTodo(c, user)
+		if !found {
+			return
+		}
+		var json model.TodoUpdate
+		if err := c.ShouldBindJSON(&json); err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if json.ListId != nil && *json.ListId != 0 && !model.CanViewList(user.Id, *json.ListId) {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "no records found with list id " + strconv.Itoa(*json.ListId)})
+			return
+		}
+		if json.AssigneeId != nil && *json.AssigneeId != 0 && !model.SameOrgUnit(before.CreatorId, *json.AssigneeId) {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "no records found with user id " + strconv.Itoa(*json.AssigneeId)})
+			return
+		}
+
+		ent, err := model.ModifyTodo(before.Id, json)
+		if err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": string(err.Error())})
+			return
+		}
+
+		if ent.AssigneeId != before.AssigneeId {
+			notify.TodoAssigned(ent, user)
+		}
+		if ent.Description != before.Description {
+			notify.TodoMentions(ent, user)
+		}
+		events.Publish(events.TodoUpdated, user.UserName, ent, before)
+		c.IndentedJSON(http.StatusOK, ent)
+	} else {
+		c.IndentedJSON(http.StatusForbidden, gin.H{"error": "Insufficient access. Access denied!"})
+	}
+}
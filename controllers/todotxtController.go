@@ -0,0 +1,147 @@
+package controllers
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/greeneg/todoer/events"
+	"github.com/greeneg/todoer/model"
+	"github.com/greeneg/todoer/todotxt"
+	"github.com/gin-gonic/gin"
+)
+
+// the largest todo.txt file accepted for import
+const maxTodoTxtSize = 10 << 20
+
+// ExportTodoTxt Retrieve the todos of the current user as a todo.txt file
+//
+//	@Summary		Export todo.txt
+//	@Description	Renders the todos the current user can see in the todo.txt format. Lists become +project words, tags become @context words and due dates become due: pairs
+//	@Tags			todos
+//	@Produce		plain
+//	@Security		BasicAuth
+//	@Success		200	{string}	string
+//	@Failure		403	{object}	model.FailureMsg
+//	@Router			/export/todotxt [get]
+func (g *TodoerService) ExportTodoTxt(c *gin.Context) {
+	user, authed := g.GetUserId(c)
+	if authed {
+		todos, err := model.GetTodosForUser(user.Id)
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+			return
+		}
+		lists, err := model.GetListsForUser(user.Id)
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+			return
+		}
+		listNames := make(map[int]string)
+		for _, l := range lists {
+			listNames[l.Id] = l.Name
+		}
+
+		tasks := make([]todotxt.Task, 0, len(todos))
+		for _, todo := range todos {
+			tasks = append(tasks, todotxt.FromTodo(todo, listNames[todo.ListId]))
+		}
+
+		c.Header("Content-Type", "text/plain; charset=utf-8")
+		c.Header("Content-Disposition", "attachment; filename=\"todo.txt\"")
+		c.Status(http.StatusOK)
+		todotxt.Encode(c.Writer, tasks)
+	} else {
+		c.IndentedJSON(http.StatusForbidden, gin.H{"error": "Insufficient access. Access denied!"})
+	}
+}
+
+// ImportTodoTxt Import the tasks of a todo.txt file
+//
+//	@Summary		Import todo.txt
+//	@Description	Creates a todo for every line of an uploaded todo.txt file, sent as the "file" field of a form or as the request body. The first +project of a line names its list, which is created when the current user cannot see one by that name; @context words become tags. The import is all or nothing: if any line cannot be read, no todos are created and every bad line is reported
+//	@Tags			todos
+//	@Accept			plain
+//	@Accept			multipart/form-data
+//	@Produce		json
+//	@Param			file	formData	file	false	"todo.txt file"
+//	@Security		BasicAuth
+//	@Success		200	{object}	model.ImportResult
+//	@Failure		400	{object}	model.ImportResult
+//	@Router			/import/todotxt [post]
+func (g *TodoerService) ImportTodoTxt(c *gin.Context) {
+	user, authed := g.GetUserId(c)
+	if !authed {
+		c.IndentedJSON(http.StatusForbidden, gin.H{"error": "Insufficient access. Access denied!"})
+		return
+	}
+
+	data, err := uploadedFile(c, maxTodoTxtSize)
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "Unable to read todo.txt file: " + err.Error()})
+		return
+	}
+	tasks, parseErrors, err := todotxt.ParseFile(bytes.NewReader(data))
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "Invalid todo.txt file: " + err.Error()})
+		return
+	}
+
+	result := model.ImportResult{Errors: make([]model.ImportError, 0)}
+	for _, e := range parseErrors {
+		result.Errors = append(result.Errors, model.ImportError{Line: e.Line, Error: e.Err.Error()})
+	}
+	if len(result.Errors) > 0 {
+		result.Message = "No todos have been imported: " + strconv.Itoa(len(result.Errors)) + " lines could not be read"
+		c.IndentedJSON(http.StatusBadRequest, result)
+		return
+	}
+
+	// projects name lists without their spaces, so match them against the
+	// lists the user can already see
+	lists, err := model.GetListsForUser(user.Id)
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+		return
+	}
+	listNames := make(map[string]string)
+	for _, l := range lists {
+		if _, seen := listNames[todotxt.ProjectName(l.Name)]; !seen || l.OwnerId == user.Id {
+			listNames[todotxt.ProjectName(l.Name)] = l.Name
+		}
+	}
+
+	items := make([]model.ImportedTodo, 0, len(tasks))
+	for _, task := range tasks {
+		item := todotxt.ToImported(task)
+		if name, found := listNames[item.ListName]; found {
+			item.ListName = name
+		}
+		items = append(items, item)
+	}
+
+	newLists, todos, err := model.ImportTodos(user.Id, items)
+	if err != nil {
+		var failed *model.ImportItemFailed
+		if errors.As(err, &failed) {
+			result.Message = "No todos have been imported"
+			result.Errors = append(result.Errors, model.ImportError{Line: tasks[failed.Index].Line, Error: failed.Error()})
+			c.IndentedJSON(http.StatusBadRequest, result)
+			return
+		}
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+		return
+	}
+
+	for _, l := range newLists {
+		events.Publish(events.ListCreated, user.UserName, l, nil)
+	}
+	for _, todo := range todos {
+		events.Publish(events.TodoCreated, user.UserName, todo, nil)
+	}
+
+	result.Imported = len(todos)
+	result.Message = strconv.Itoa(result.Imported) + " todos have been imported"
+	c.IndentedJSON(http.StatusOK, result)
+}
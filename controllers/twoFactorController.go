@@ -0,0 +1,384 @@
+package controllers
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/greeneg/todoer/helpers"
+	"github.com/greeneg/todoer/model"
+	"github.com/greeneg/todoer/passhash"
+	"github.com/greeneg/todoer/totp"
+	"github.com/gin-gonic/gin"
+	"github.com/skip2/go-qrcode"
+)
+
+// how many recovery codes a user gets, and the size of their QR code
+const (
+	recoveryCodeCount = 10
+	qrCodeSize        = 256
+)
+
+// issuer Returns the name todoer goes by in authenticator apps
+func (g *TodoerService) issuer() string {
+	if g.ConfStruct.TwoFactor.Issuer != "" {
+		return g.ConfStruct.TwoFactor.Issuer
+	}
+
+	return "todoer"
+}
+
+// newRecoveryCodes Returns new recovery codes, such as
+// "3f9a1-c07be-58d2e-a9b40", and their salted hashes to store
+func newRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		raw := make([]byte, helpers.RecoveryCodeBytes)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, nil, err
+		}
+		code := hex.EncodeToString(raw)
+		hash, err := passhash.Hash(code)
+		if err != nil {
+			return nil, nil, err
+		}
+		groups := make([]string, 0, len(code)/5)
+		for j := 0; j < len(code); j += 5 {
+			groups = append(groups, code[j:j+5])
+		}
+		codes = append(codes, strings.Join(groups, "-"))
+		hashes = append(hashes, hash)
+	}
+
+	return codes, hashes, nil
+}
+
+// pendingTwoFactor Returns the unconfirmed two-factor settings of the user,
+// answering the request itself when there are none
+func pendingTwoFactor(c *gin.Context, user model.User) (model.TwoFactor, bool) {
+	twoFactor, err := model.GetTwoFactor(user.Id)
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+		return model.TwoFactor{}, false
+	}
+	if twoFactor.Enabled {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "two-factor authentication is already enabled"})
+		return model.TwoFactor{}, false
+	}
+	if twoFactor.Secret == "" {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"error": "two-factor enrollment has not been started"})
+		return model.TwoFactor{}, false
+	}
+
+	return twoFactor, true
+}
+
+// confirmedByCode Checks the one-time password or recovery code in the body
+// of the request, answering the request itself when it is missing or wrong
+func confirmedByCode(c *gin.Context, user model.User) bool {
+	var json model.TwoFactorCode
+	if err := c.ShouldBindJSON(&json); err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return false
+	}
+	if !helpers.CheckSecondFactor(user, json.Code) {
+		c.IndentedJSON(http.StatusUnauthorized, gin.H{"error": "invalid two-factor code"})
+		return false
+	}
+
+	return true
+}
+
+// GetTwoFactor Retrieve the two-factor status of the logged in user
+//
+//	@Summary		Retrieve the two-factor status of the logged in user
+//	@Description	Shows whether two-factor authentication is enabled or being set up, and how many recovery codes are left
+//	@Tags			auth
+//	@Produce		json
+//	@Security		BasicAuth
+//	@Success		200	{object}	model.TwoFactorStatus
+//	@Failure		403	{object}	model.FailureMsg
+//	@Router			/me/2fa [get]
+func (g *TodoerService) GetTwoFactor(c *gin.Context) {
+	user, authed := g.GetUserId(c)
+	if authed {
+		twoFactor, err := model.GetTwoFactor(user.Id)
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+			return
+		}
+		left, err := model.CountRecoveryCodes(user.Id)
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, model.TwoFactorStatus{
+			Enabled:           twoFactor.Enabled,
+			Pending:           twoFactor.Secret != "" && !twoFactor.Enabled,
+			EnabledDate:       twoFactor.EnabledDate,
+			RecoveryCodesLeft: left,
+		})
+	} else {
+		c.IndentedJSON(http.StatusForbidden, gin.H{"error": "Insufficient access. Access denied!"})
+	}
+}
+
+// EnrollTwoFactor Start setting up two-factor authentication
+//
+//	@Summary		Start setting up two-factor authentication
+//	@Description	Creates a TOTP secret, returned as an otpauth:// URI and a base64 encoded QR code PNG for authenticator apps. Two-factor authentication is enabled once a code is sent to /me/2fa/confirm
+//	@Tags			auth
+//	@Produce		json
+//	@Security		BasicAuth
+//	@Success		200	{object}	model.TwoFactorEnrollmentMsg
+//	@Failure		400	{object}	model.FailureMsg
+//	@Failure		403	{object}	model.FailureMsg
+//	@Router			/me/2fa [post]
+func (g *TodoerService) EnrollTwoFactor(c *gin.Context) {
+	user, authed := g.GetUserId(c)
+	if authed {
+		enabled, err := model.TwoFactorEnabled(user.Id)
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+			return
+		}
+		if enabled {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "two-factor authentication is already enabled"})
+			return
+		}
+
+		secret, err := totp.GenerateSecret()
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+			return
+		}
+		uri := totp.URI(g.issuer(), user.UserName, secret)
+		png, err := qrcode.Encode(uri, qrcode.Medium, qrCodeSize)
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+			return
+		}
+		if err := model.StartTwoFactor(user.Id, secret); err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, model.TwoFactorEnrollmentMsg{
+			Message: "Scan the QR code with an authenticator app and confirm with a code",
+			Secret:  secret,
+			Uri:     uri,
+			QrCode:  base64.StdEncoding.EncodeToString(png),
+		})
+	} else {
+		c.IndentedJSON(http.StatusForbidden, gin.H{"error": "Insufficient access. Access denied!"})
+	}
+}
+
+// GetTwoFactorQrCode Retrieve the QR code of an unconfirmed TOTP secret
+//
+//	@Summary		Retrieve the QR code of an unconfirmed TOTP secret
+//	@Description	Returns the QR code of the secret from POST /me/2fa as a PNG, until two-factor authentication is confirmed
+//	@Tags			auth
+//	@Produce		png
+//	@Security		BasicAuth
+//	@Success		200	{file}		binary
+//	@Failure		400	{object}	model.FailureMsg
+//	@Failure		403	{object}	model.FailureMsg
+//	@Failure		404	{object}	model.FailureMsg
+//	@Router			/me/2fa/qr [get]
+func (g *TodoerService) GetTwoFactorQrCode(c *gin.Context) {
+	user, authed := g.GetUserId(c)
+	if authed {
+		twoFactor, ok := pendingTwoFactor(c, user)
+		if !ok {
+			return
+		}
+
+		png, err := qrcode.Encode(totp.URI(g.issuer(), user.UserName, twoFactor.Secret), qrcode.Medium, qrCodeSize)
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+			return
+		}
+
+		c.Header("Cache-Control", "no-store")
+		c.Data(http.StatusOK, "image/png", png)
+	} else {
+		c.IndentedJSON(http.StatusForbidden, gin.H{"error": "Insufficient access. Access denied!"})
+	}
+}
+
+// ConfirmTwoFactor Enable two-factor authentication
+//
+//	@Summary		Enable two-factor authentication
+//	@Description	Checks a code from the authenticator app against the secret from POST /me/2fa and enables two-factor authentication. The recovery codes are returned once and only their hashes are kept
+//	@Tags			auth
+//	@Accept			json
+//	@Produce		json
+//	@Param			code	body	model.TwoFactorCode	true	"One-time password"
+//	@Security		BasicAuth
+//	@Success		200	{object}	model.RecoveryCodesMsg
+//	@Failure		400	{object}	model.FailureMsg
+//	@Failure		401	{object}	model.FailureMsg
+//	@Failure		403	{object}	model.FailureMsg
+//	@Failure		404	{object}	model.FailureMsg
+//	@Router			/me/2fa/confirm [post]
+func (g *TodoerService) ConfirmTwoFactor(c *gin.Context) {
+	user, authed := g.GetUserId(c)
+	if authed {
+		twoFactor, ok := pendingTwoFactor(c, user)
+		if !ok {
+			return
+		}
+		var json model.TwoFactorCode
+		if err := c.ShouldBindJSON(&json); err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		step, valid := totp.Validate(twoFactor.Secret, json.Code, time.Now(), 0)
+		if !valid {
+			c.IndentedJSON(http.StatusUnauthorized, gin.H{"error": "invalid two-factor code"})
+			return
+		}
+
+		codes, hashes, err := newRecoveryCodes()
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+			return
+		}
+		if err := model.ConfirmTwoFactor(user.Id, step, hashes); err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, model.RecoveryCodesMsg{
+			Message:       "Two-factor authentication has been enabled. Keep the recovery codes somewhere safe",
+			RecoveryCodes: codes,
+		})
+	} else {
+		c.IndentedJSON(http.StatusForbidden, gin.H{"error": "Insufficient access. Access denied!"})
+	}
+}
+
+// RegenerateRecoveryCodes Replace the recovery codes
+//
+//	@Summary		Replace the recovery codes
+//	@Description	Replaces all recovery codes of the logged in user with new ones, confirmed with a current code
+//	@Tags			auth
+//	@Accept			json
+//	@Produce		json
+//	@Param			code	body	model.TwoFactorCode	true	"One-time password or recovery code"
+//	@Security		BasicAuth
+//	@Success		200	{object}	model.RecoveryCodesMsg
+//	@Failure		400	{object}	model.FailureMsg
+//	@Failure		401	{object}	model.FailureMsg
+//	@Failure		403	{object}	model.FailureMsg
+//	@Router			/me/2fa/recovery-codes [post]
+func (g *TodoerService) RegenerateRecoveryCodes(c *gin.Context) {
+	user, authed := g.GetUserId(c)
+	if authed {
+		if !confirmedByCode(c, user) {
+			return
+		}
+
+		codes, hashes, err := newRecoveryCodes()
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+			return
+		}
+		if err := model.ReplaceRecoveryCodes(user.Id, hashes); err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, model.RecoveryCodesMsg{
+			Message:       "The old recovery codes no longer work",
+			RecoveryCodes: codes,
+		})
+	} else {
+		c.IndentedJSON(http.StatusForbidden, gin.H{"error": "Insufficient access. Access denied!"})
+	}
+}
+
+// DisableTwoFactor Turn off two-factor authentication
+//
+//	@Summary		Turn off two-factor authentication
+//	@Description	Turns off two-factor authentication for the logged in user, confirmed with a current code, and removes the recovery codes
+//	@Tags			auth
+//	@Accept			json
+//	@Produce		json
+//	@Param			code	body	model.TwoFactorCode	true	"One-time password or recovery code"
+//	@Security		BasicAuth
+//	@Success		200	{object}	model.SuccessMsg
+//	@Failure		400	{object}	model.FailureMsg
+//	@Failure		401	{object}	model.FailureMsg
+//	@Failure		403	{object}	model.FailureMsg
+//	@Router			/me/2fa [delete]
+func (g *TodoerService) DisableTwoFactor(c *gin.Context) {
+	user, authed := g.GetUserId(c)
+	if authed {
+		enabled, err := model.TwoFactorEnabled(user.Id)
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+			return
+		}
+		// an enrollment that was never confirmed can be dropped without a code
+		if enabled && !confirmedByCode(c, user) {
+			return
+		}
+
+		if _, err := model.DeleteTwoFactor(user.Id); err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, gin.H{"message": "Two-factor authentication has been turned off"})
+	} else {
+		c.IndentedJSON(http.StatusForbidden, gin.H{"error": "Insufficient access. Access denied!"})
+	}
+}
+
+// ResetTwoFactor Turn off two-factor authentication for a user
+//
+//	@Summary		Turn off two-factor authentication for a user
+//	@Description	Turns off two-factor authentication for a user who lost their authenticator and recovery codes. Admins, and org admins for their org unit, only
+//	@Tags			user
+//	@Produce		json
+//	@Param			name	path	string	true	"User name"
+//	@Security		BasicAuth
+//	@Success		200	{object}	model.SuccessMsg
+//	@Failure		400	{object}	model.FailureMsg
+//	@Failure		403	{object}	model.FailureMsg
+//	@Failure		404	{object}	model.FailureMsg
+//	@Router			/user/{name}/2fa [delete]
+func (g *TodoerService) ResetTwoFactor(c *gin.Context) {
+	actor, authed := g.GetUserId(c)
+	if authed {
+		user, ok := pathUser(c)
+		if !ok {
+			return
+		}
+
+		status, err := model.DeleteTwoFactor(user.Id)
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+			return
+		}
+
+		if status {
+			log.Println("INFO: User '" + actor.UserName + "' reset two-factor authentication of '" + user.UserName + "'")
+			c.IndentedJSON(http.StatusOK, gin.H{"message": "Two-factor authentication of user '" + user.UserName +
+				"' has been turned off"})
+		} else {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "user '" + user.UserName +
+				"' does not use two-factor authentication"})
+		}
+	} else {
+		c.IndentedJSON(http.StatusForbidden, gin.H{"error": "Insufficient access. Access denied!"})
+	}
+}
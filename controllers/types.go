@@ -11,5 +11,24 @@ type TodoerService struct {
 type SafeUser struct {
 	Id           int    `json:"Id"`
 	UserName     string `json:"userName"`
+	Status       string `json:"status,omitempty"`
+	OrgUnitId    int    `json:"orgUnitId,omitempty"`
 	CreationDate string `json:"creationDate"`
 }
+
+// CurrentUser is the logged in user as shown to themselves
+type CurrentUser struct {
+	Id              int    `json:"Id"`
+	UserName        string `json:"userName"`
+	FullName        string `json:"fullName"`
+	Email           string `json:"email"`
+	Status          string `json:"status"`
+	OrgUnitId       int    `json:"orgUnitId"`
+	CreationDate    string `json:"creationDate"`
+	LastChangedDate string `json:"lastChangedDate"`
+}
+
+type LoginMsg struct {
+	Message string      `json:"message"`
+	User    CurrentUser `json:"user"`
+}
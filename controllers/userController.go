@@ -4,7 +4,9 @@ import (
 	"log"
 	"net/http"
 	"strconv"
+	"strings"
 
+	"github.com/greeneg/todoer/events"
 	"github.com/greeneg/todoer/model"
 	"github.com/gin-gonic/gin"
 )
@@ -12,7 +14,7 @@ import (
 // CreateUser Register a user for authentication and authorization
 //
 //	@Summary		Register user
-//	@Description	Add a new user
+//	@Description	Add a new user. Admins may add users to any org unit, by default their own, and org admins only to their own. A password breaking the password policy is refused with the rules it breaks
 //	@Tags			user
 //	@Accept			json
 //	@Produce		json
@@ -22,16 +24,61 @@ import (
 //	@Failure		400	{object}	model.FailureMsg
 //	@Router			/user [post]
 func (g *TodoerService) CreateUser(c *gin.Context) {
-	_, authed := g.GetUserId(c)
+	actor, authed := g.GetUserId(c)
 	if authed {
 		var json model.ProposedUser
 		if err := c.ShouldBindJSON(&json); err != nil {
 			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
+		if !passwordAccepted(c, 0, json.Password) {
+			return
+		}
+		json.FullName = strings.TrimSpace(json.FullName)
+		json.Email = strings.TrimSpace(json.Email)
+		if err := model.ValidateEmail(json.Email); err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := model.ValidateTimezone(json.Timezone); err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		locale, err := model.NormalizeLocale(json.Locale)
+		if err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		json.Locale = locale
+
+		admin, err := isAdmin(actor)
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+			return
+		}
+		if json.OrgUnitId == 0 {
+			json.OrgUnitId = actor.OrgUnitId
+		}
+		if json.OrgUnitId != actor.OrgUnitId && !admin {
+			c.IndentedJSON(http.StatusForbidden, gin.H{"error": "Insufficient access. Access denied!"})
+			return
+		}
+		orgUnit, err := model.GetOrgUnitById(json.OrgUnitId)
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+			return
+		}
+		if orgUnit.Id == 0 {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "no records found with org unit id " +
+				strconv.Itoa(json.OrgUnitId)})
+			return
+		}
 
 		s, err := model.CreateUser(json)
 		if s {
+			if user, err := model.GetUserByUserName(json.UserName); err == nil {
+				events.Publish(events.UserCreated, actor.UserName, safeUser(user), nil)
+			}
 			c.IndentedJSON(http.StatusOK, gin.H{"message": "User has been added to system"})
 		} else {
 			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -44,7 +91,7 @@ func (g *TodoerService) CreateUser(c *gin.Context) {
 // ChangeAccountPassowrd Change an account's password
 //
 //	@Summary		Change password
-//	@Description	Change password
+//	@Description	Change password. A new password breaking the password policy, or reusing a recent one, is refused with the rules it breaks
 //	@Tags			user
 //	@Accept			json
 //	@Produce		json
@@ -63,6 +110,14 @@ func (g *TodoerService) ChangeAccountPassword(c *gin.Context) {
 			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
+		user, err := model.GetUserByUserName(username)
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+			return
+		}
+		if !passwordAccepted(c, user.Id, json.NewPassword) {
+			return
+		}
 
 		status, err := model.ChangeAccountPassword(username, json.OldPassword, json.NewPassword)
 		if err != nil {
@@ -93,9 +148,24 @@ func (g *TodoerService) ChangeAccountPassword(c *gin.Context) {
 //	@Failure		400	{object}	model.FailureMsg
 //	@Router			/user/{name} [delete]
 func (g *TodoerService) DeleteUser(c *gin.Context) {
-	_, authed := g.GetUserId(c)
+	actor, authed := g.GetUserId(c)
 	if authed {
 		username := c.Param("name")
+		user, err := model.GetUserByUserName(username)
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+			return
+		}
+		last, err := isLastAdmin(user)
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+			return
+		}
+		if last {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "cannot remove the last admin"})
+			return
+		}
+
 		status, err := model.DeleteUser(username)
 		if err != nil {
 			log.Println("ERROR: Cannot delete user: " + string(err.Error()))
@@ -104,6 +174,9 @@ func (g *TodoerService) DeleteUser(c *gin.Context) {
 		}
 
 		if status {
+			if user.UserName != "" {
+				events.Publish(events.UserDeleted, actor.UserName, safeUser(user), nil)
+			}
 			c.IndentedJSON(http.StatusOK, gin.H{"message": "User " + username + " has been removed from system"})
 		} else {
 			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "Unable to remove user!"})
@@ -116,7 +189,7 @@ func (g *TodoerService) DeleteUser(c *gin.Context) {
 // GetUserStatus Retrieve the active status of a user. Can be either 'enabled' or 'locked'
 //
 //	@Summary		Retrieve a user's active status. Can be either 'enabled' or 'locked'
-//	@Description	Retrieve a user's active status
+//	@Description	Retrieve a user's active status, and for locked users why and when they were locked. Only admins and org admins of the user's org unit are told why and when
 //	@Tags			user
 //	@Accept			json
 //	@Produce		json
@@ -126,17 +199,51 @@ func (g *TodoerService) DeleteUser(c *gin.Context) {
 //	@Failure		400	{object}	model.FailureMsg
 //	@Router			/user/{name}/status [get]
 func (g *TodoerService) GetUserStatus(c *gin.Context) {
-	_, authed := g.GetUserId(c)
+	actor, authed := g.GetUserId(c)
 	if authed {
 		username := c.Param("name")
+		user, err := model.GetUserByUserName(username)
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+			return
+		}
+		visible, err := canSeeUser(actor, user)
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+			return
+		}
+		if user.UserName == "" || !visible {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "Unable to retrieve user status"})
+			return
+		}
+
 		status, err := model.GetUserStatus(username)
 		if err != nil {
 			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "Unable to get the user " + username + " status: " + string(err.Error())})
 			return
 		}
 
+		// why and when a user was locked, which may name the client address,
+		// is only for those who manage the account
+		manager, err := isAdmin(actor)
+		if err == nil && !manager && actor.OrgUnitId == user.OrgUnitId {
+			manager, err = model.UserHasRole(actor.Id, model.RoleOrgAdmin)
+		}
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+			return
+		}
+
 		if status != "" {
-			c.IndentedJSON(http.StatusOK, gin.H{"message": "User status: " + status, "userStatus": status})
+			msg := model.UserStatusMsg{
+				Message:    "User status: " + status,
+				UserStatus: status,
+			}
+			if manager {
+				msg.LockReason = user.LockReason
+				msg.LockDate = user.LockDate
+			}
+			c.IndentedJSON(http.StatusOK, msg)
 		} else {
 			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "Unable to retrieve user status"})
 		}
@@ -148,18 +255,18 @@ func (g *TodoerService) GetUserStatus(c *gin.Context) {
 // SetUserStatus Set the active status of a user. Can be either 'enabled' or 'locked'
 //
 //	@Summary		Set a user's active status. Can be either 'enabled' or 'locked'
-//	@Description	Set a user's active status
+//	@Description	Set a user's active status, with an optional reason for a lock. Unlocking also forgets the user's failed logins
 //	@Tags			user
 //	@Accept			json
 //	@Produce		json
-//	@Param			user	body	model.User.UserName	true	"User Data"
+//	@Param			user	body	model.UserStatus	true	"Status Data"
 //	@Param			name	path	string	true "User name"
 //	@Security		BasicAuth
 //	@Success		200	{object}	model.UserStatusMsg
 //	@Failure		400	{object}	model.FailureMsg
 //	@Router			/user/{name}/status [patch]
 func (g *TodoerService) SetUserStatus(c *gin.Context) {
-	_, authed := g.GetUserId(c)
+	actor, authed := g.GetUserId(c)
 	if authed {
 		username := c.Param("name")
 		var json model.UserStatus
@@ -168,6 +275,26 @@ func (g *TodoerService) SetUserStatus(c *gin.Context) {
 			return
 		}
 
+		previous, err := model.GetUserByUserName(username)
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+			return
+		}
+		if json.Status == "locked" && json.Reason == "" {
+			json.Reason = "locked by " + actor.UserName
+		}
+		if json.Status == "locked" {
+			last, err := isLastAdmin(previous)
+			if err != nil {
+				c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+				return
+			}
+			if last {
+				c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "cannot lock the last admin"})
+				return
+			}
+		}
+
 		status, err := model.SetUserStatus(username, json)
 		if err != nil {
 			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
@@ -175,6 +302,15 @@ func (g *TodoerService) SetUserStatus(c *gin.Context) {
 		}
 
 		if status {
+			if previous.UserName != "" && previous.Status != json.Status {
+				user := previous
+				user.Status = json.Status
+				eventType := events.UserUnlocked
+				if json.Status == "locked" {
+					eventType = events.UserLocked
+				}
+				events.Publish(eventType, actor.UserName, safeUser(user), safeUser(previous))
+			}
 			c.IndentedJSON(http.StatusOK, gin.H{"message": "User '" + username + "' has been " + json.Status})
 		} else {
 			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err})
@@ -187,7 +323,7 @@ func (g *TodoerService) SetUserStatus(c *gin.Context) {
 // GetUsers Retrieve list of all users
 //
 //	@Summary		Retrieve list of all users
-//	@Description	Retrieve list of all users
+//	@Description	Retrieve list of all users of the caller's org unit, or of every org unit for admins
 //	@Tags			user
 //	@Produce		json
 //	@Security		BasicAuth
@@ -195,9 +331,20 @@ func (g *TodoerService) SetUserStatus(c *gin.Context) {
 //	@Failure		400	{object}	model.FailureMsg
 //	@Router			/users [get]
 func (g *TodoerService) GetUsers(c *gin.Context) {
-	_, authed := g.GetUserId(c)
+	actor, authed := g.GetUserId(c)
 	if authed {
-		users, err := model.GetUsers()
+		admin, err := isAdmin(actor)
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+			return
+		}
+
+		var users []model.User
+		if admin {
+			users, err = model.GetUsers()
+		} else {
+			users, err = model.GetUsersInOrgUnit(actor.OrgUnitId)
+		}
 		if err != nil {
 			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
 			return
@@ -208,6 +355,7 @@ func (g *TodoerService) GetUsers(c *gin.Context) {
 			safeUser := SafeUser{}
 			safeUser.Id = user.Id
 			safeUser.UserName = user.UserName
+			safeUser.OrgUnitId = user.OrgUnitId
 			safeUser.CreationDate = user.CreationDate
 
 			safeUsers = append(safeUsers, safeUser)
@@ -235,7 +383,7 @@ func (g *TodoerService) GetUsers(c *gin.Context) {
 //	@Failure		400	{object}	model.FailureMsg
 //	@Router			/user/id/{id} [get]
 func (g *TodoerService) GetUserById(c *gin.Context) {
-	_, authed := g.GetUserId(c)
+	actor, authed := g.GetUserId(c)
 	if authed {
 		id, _ := strconv.Atoi(c.Param("id"))
 		ent, err := model.GetUserById(id)
@@ -243,14 +391,21 @@ func (g *TodoerService) GetUserById(c *gin.Context) {
 			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
 			return
 		}
+		visible, err := canSeeUser(actor, ent)
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+			return
+		}
 
 		// don't return the password hash
 		safeUser := new(SafeUser)
 		safeUser.Id = ent.Id
 		safeUser.UserName = ent.UserName
+		safeUser.OrgUnitId = ent.OrgUnitId
 		safeUser.CreationDate = ent.CreationDate
 
-		if ent.UserName == "" {
+		// users of other org units look the same as missing ones
+		if ent.UserName == "" || !visible {
 			strId := strconv.Itoa(id)
 			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "no records found with user id " + strId})
 		} else {
@@ -273,7 +428,7 @@ func (g *TodoerService) GetUserById(c *gin.Context) {
 //	@Failure		400	{object}	model.FailureMsg
 //	@Router			/user/name/{name} [get]
 func (g *TodoerService) GetUserByUserName(c *gin.Context) {
-	_, authed := g.GetUserId(c)
+	actor, authed := g.GetUserId(c)
 	if authed {
 		username := c.Param("name")
 		ent, err := model.GetUserByUserName(username)
@@ -281,14 +436,20 @@ func (g *TodoerService) GetUserByUserName(c *gin.Context) {
 			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
 			return
 		}
+		visible, err := canSeeUser(actor, ent)
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+			return
+		}
 
 		// don't return the password hash
 		safeUser := new(SafeUser)
 		safeUser.Id = ent.Id
 		safeUser.UserName = ent.UserName
+		safeUser.OrgUnitId = ent.OrgUnitId
 		safeUser.CreationDate = ent.CreationDate
 
-		if ent.UserName == "" {
+		if ent.UserName == "" || !visible {
 			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "no records found with user name " + username})
 		} else {
 			c.IndentedJSON(http.StatusOK, safeUser)
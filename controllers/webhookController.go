@@ -0,0 +1,201 @@
+package controllers
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/greeneg/todoer/events"
+	"github.com/greeneg/todoer/model"
+	"github.com/gin-gonic/gin"
+)
+
+// validateWebhook Returns an error message if the proposed webhook is unusable
+func validateWebhook(p model.ProposedWebhook) string {
+	u, err := url.Parse(p.Url)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "webhook url must be an absolute http or https URL"
+	}
+	if len(p.Events) == 0 {
+		return "webhook must subscribe to at least one event"
+	}
+	for _, e := range p.Events {
+		if e != "*" && !events.IsKnownType(e) {
+			return "unknown event type '" + e + "'"
+		}
+	}
+
+	return ""
+}
+
+// CreateWebhook Register a new webhook endpoint
+//
+//	@Summary		Register webhook
+//	@Description	Register an endpoint to receive events. Deliveries are signed with HMAC-SHA256 in the X-Todoer-Signature header. If no secret is given, one is generated and returned once
+//	@Tags			webhook
+//	@Accept			json
+//	@Produce		json
+//	@Param			webhook	body	model.ProposedWebhook	true	"Webhook Data"
+//	@Security		BasicAuth
+//	@Success		200	{object}	model.WebhookCreatedMsg
+//	@Failure		400	{object}	model.FailureMsg
+//	@Router			/webhooks [post]
+func (g *TodoerService) CreateWebhook(c *gin.Context) {
+	user, authed := g.GetUserId(c)
+	if authed {
+		var json model.ProposedWebhook
+		if err := c.ShouldBindJSON(&json); err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if msg := validateWebhook(json); msg != "" {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+
+		if json.Secret == "" {
+			secret, err := randomToken()
+			if err != nil {
+				c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+				return
+			}
+			json.Secret = secret
+		}
+
+		hook, err := model.CreateWebhook(json, user.Id)
+		if err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, model.WebhookCreatedMsg{
+			Message: "Webhook has been registered",
+			Secret:  json.Secret,
+			Webhook: hook,
+		})
+	} else {
+		c.IndentedJSON(http.StatusForbidden, gin.H{"error": "Insufficient access. Access denied!"})
+	}
+}
+
+// DeleteWebhook Remove a webhook
+//
+//	@Summary		Delete webhook
+//	@Description	Delete a webhook and its delivery log
+//	@Tags			webhook
+//	@Produce		json
+//	@Param			id	path	int	true	"Webhook Id"
+//	@Security		BasicAuth
+//	@Success		200	{object}	model.SuccessMsg
+//	@Failure		400	{object}	model.FailureMsg
+//	@Router			/webhooks/{id} [delete]
+func (g *TodoerService) DeleteWebhook(c *gin.Context) {
+	_, authed := g.GetUserId(c)
+	if authed {
+		id, _ := strconv.Atoi(c.Param("id"))
+		status, err := model.DeleteWebhook(id)
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "Unable to remove webhook! " + string(err.Error())})
+			return
+		}
+
+		idString := strconv.Itoa(id)
+		if status {
+			c.IndentedJSON(http.StatusOK, gin.H{"message": "Webhook " + idString + " has been removed"})
+		} else {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "no records found with webhook id " + idString})
+		}
+	} else {
+		c.IndentedJSON(http.StatusForbidden, gin.H{"error": "Insufficient access. Access denied!"})
+	}
+}
+
+// GetWebhooks Retrieve list of all webhooks
+//
+//	@Summary		Retrieve list of webhooks
+//	@Description	Retrieve list of all registered webhooks
+//	@Tags			webhook
+//	@Produce		json
+//	@Security		BasicAuth
+//	@Success		200	{object}	model.WebhookList
+//	@Failure		400	{object}	model.FailureMsg
+//	@Router			/webhooks [get]
+func (g *TodoerService) GetWebhooks(c *gin.Context) {
+	_, authed := g.GetUserId(c)
+	if authed {
+		hooks, err := model.GetWebhooks()
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, gin.H{"data": hooks})
+	} else {
+		c.IndentedJSON(http.StatusForbidden, gin.H{"error": "Insufficient access. Access denied!"})
+	}
+}
+
+// GetWebhookById Retrieve a webhook by its Id
+//
+//	@Summary		Retrieve a webhook by its Id
+//	@Description	Retrieve a webhook by its Id
+//	@Tags			webhook
+//	@Produce		json
+//	@Param			id	path	int	true	"Webhook Id"
+//	@Security		BasicAuth
+//	@Success		200	{object}	model.Webhook
+//	@Failure		400	{object}	model.FailureMsg
+//	@Router			/webhooks/{id} [get]
+func (g *TodoerService) GetWebhookById(c *gin.Context) {
+	_, authed := g.GetUserId(c)
+	if authed {
+		id, _ := strconv.Atoi(c.Param("id"))
+		hook, err := model.GetWebhookById(id)
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+			return
+		}
+
+		if hook.Url == "" {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "no records found with webhook id " + strconv.Itoa(id)})
+		} else {
+			c.IndentedJSON(http.StatusOK, hook)
+		}
+	} else {
+		c.IndentedJSON(http.StatusForbidden, gin.H{"error": "Insufficient access. Access denied!"})
+	}
+}
+
+// GetWebhookDeliveries Retrieve the delivery log of a webhook
+//
+//	@Summary		Retrieve the delivery log of a webhook
+//	@Description	Retrieve the most recent deliveries of a webhook, including response codes
+//	@Tags			webhook
+//	@Produce		json
+//	@Param			id		path	int	true	"Webhook Id"
+//	@Param			limit	query	int	false	"Maximum number of deliveries to return (default 100)"
+//	@Security		BasicAuth
+//	@Success		200	{object}	model.WebhookDeliveryList
+//	@Failure		400	{object}	model.FailureMsg
+//	@Router			/webhooks/{id}/deliveries [get]
+func (g *TodoerService) GetWebhookDeliveries(c *gin.Context) {
+	_, authed := g.GetUserId(c)
+	if authed {
+		id, _ := strconv.Atoi(c.Param("id"))
+		limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+		if err != nil || limit <= 0 {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "limit must be a positive integer"})
+			return
+		}
+
+		deliveries, err := model.GetDeliveriesForWebhook(id, limit)
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, gin.H{"data": deliveries})
+	} else {
+		c.IndentedJSON(http.StatusForbidden, gin.H{"error": "Insufficient access. Access denied!"})
+	}
+}
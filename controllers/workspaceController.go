@@ -0,0 +1,157 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/greeneg/todoer/archive"
+	"github.com/greeneg/todoer/events"
+	"github.com/greeneg/todoer/model"
+	"github.com/gin-gonic/gin"
+)
+
+// the largest workspace archive accepted for import
+const maxArchiveSize = 50 << 20
+
+// ExportWorkspace Retrieve everything the current user can see as an archive
+//
+//	@Summary		Export workspace
+//	@Description	Exports the lists and todos the current user can see, for backups or moving to another server. The json format is a versioned archive that also holds the tags, comments and history of the todos; the csv format holds one todo per row
+//	@Tags			workspace
+//	@Produce		json
+//	@Produce		text/csv
+//	@Param			format	query	string	false	"Archive format"	Enums(json, csv)
+//	@Security		BasicAuth
+//	@Success		200	{object}	model.Workspace
+//	@Failure		400	{object}	model.FailureMsg
+//	@Router			/export [get]
+func (g *TodoerService) ExportWorkspace(c *gin.Context) {
+	user, authed := g.GetUserId(c)
+	if !authed {
+		c.IndentedJSON(http.StatusForbidden, gin.H{"error": "Insufficient access. Access denied!"})
+		return
+	}
+
+	format := c.DefaultQuery("format", "json")
+	if format != "json" && format != "csv" {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "unsupported export format '" + format + "'"})
+		return
+	}
+
+	ws, err := model.GetWorkspace(user)
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+		return
+	}
+
+	fileName := "todoer-" + user.UserName + "-" + ws.ExportedAt[:10] + "." + format
+	c.Header("Content-Disposition", "attachment; filename=\""+fileName+"\"")
+	if format == "csv" {
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+		c.Status(http.StatusOK)
+		archive.EncodeCSV(c.Writer, ws)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, ws)
+}
+
+// ImportWorkspace Import an archive into the workspace of the current user
+//
+//	@Summary		Import workspace
+//	@Description	Imports an archive made by the export endpoint, sent as the "file" field of a form or as the request body. Every Id is replaced and references between lists, todos, comments and history are kept. In merge mode lists are merged into owned lists of the same name; in replace mode the lists the current user owns and the todos they created or that are on those lists are deleted first. The import is all or nothing
+//	@Tags			workspace
+//	@Accept			json
+//	@Accept			text/csv
+//	@Accept			multipart/form-data
+//	@Produce		json
+//	@Param			mode	query	string	false	"Import mode"	Enums(merge, replace)
+//	@Param			format	query	string	false	"Archive format, guessed from the content when omitted"	Enums(json, csv)
+//	@Param			file	formData	file	false	"Archive file"
+//	@Security		BasicAuth
+//	@Success		200	{object}	model.ImportResult
+//	@Failure		400	{object}	model.ImportResult
+//	@Router			/import [post]
+func (g *TodoerService) ImportWorkspace(c *gin.Context) {
+	user, authed := g.GetUserId(c)
+	if !authed {
+		c.IndentedJSON(http.StatusForbidden, gin.H{"error": "Insufficient access. Access denied!"})
+		return
+	}
+
+	mode := c.DefaultQuery("mode", "merge")
+	if mode != "merge" && mode != "replace" {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "unsupported import mode '" + mode + "'"})
+		return
+	}
+
+	data, err := uploadedFile(c, maxArchiveSize)
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "Unable to read archive: " + err.Error()})
+		return
+	}
+	format := c.Query("format")
+	if format == "" {
+		if c.ContentType() == "text/csv" || !bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+			format = "csv"
+		} else {
+			format = "json"
+		}
+	}
+
+	var ws model.Workspace
+	switch format {
+	case "json":
+		err = json.Unmarshal(data, &ws)
+	case "csv":
+		ws, err = archive.DecodeCSV(bytes.NewReader(data))
+	default:
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "unsupported import format '" + format + "'"})
+		return
+	}
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "Invalid archive: " + err.Error()})
+		return
+	}
+
+	imported, err := model.ImportWorkspace(user.Id, ws, model.ImportOptions{Replace: mode == "replace"})
+	if err != nil {
+		var failed *model.ImportItemFailed
+		if errors.As(err, &failed) {
+			c.IndentedJSON(http.StatusBadRequest, model.ImportResult{
+				Message: "Nothing has been imported",
+				Errors:  []model.ImportError{{Item: failed.Item, Error: failed.Error()}},
+			})
+			return
+		}
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+		return
+	}
+
+	for _, todo := range imported.DeletedTodos {
+		events.Publish(events.TodoDeleted, user.UserName, todo, nil)
+	}
+	for _, l := range imported.DeletedLists {
+		events.Publish(events.ListDeleted, user.UserName, l, nil)
+	}
+	for _, l := range imported.Lists {
+		events.Publish(events.ListCreated, user.UserName, l, nil)
+	}
+	for _, todo := range imported.Todos {
+		events.Publish(events.TodoCreated, user.UserName, todo, nil)
+	}
+
+	counts := []string{
+		strconv.Itoa(len(imported.Lists)) + " lists",
+		strconv.Itoa(len(imported.Todos)) + " todos",
+		strconv.Itoa(imported.Comments) + " comments",
+	}
+	c.IndentedJSON(http.StatusOK, model.ImportResult{
+		Message:  strings.Join(counts, ", ") + " and " + strconv.Itoa(imported.History) + " history entries have been imported",
+		Imported: len(imported.Todos),
+		Errors:   make([]model.ImportError, 0),
+	})
+}
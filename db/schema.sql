@@ -6,6 +6,351 @@
 PRAGMA foreign_keys = off;
 BEGIN TRANSACTION;
 
+-- Table: AccessTokens
+DROP TABLE IF EXISTS AccessTokens;
+
+CREATE TABLE IF NOT EXISTS AccessTokens (
+    Id           INTEGER  PRIMARY KEY AUTOINCREMENT
+                          UNIQUE
+                          NOT NULL,
+    UserId       INTEGER  REFERENCES Users (Id) ON DELETE CASCADE
+                          NOT NULL,
+    Name         STRING   NOT NULL,
+    TokenHash    STRING   UNIQUE
+                          NOT NULL,
+    Scopes       STRING   NOT NULL,
+    ExpiryDate   DATETIME,
+    LastUsedDate DATETIME,
+    CreationDate DATETIME NOT NULL
+                          DEFAULT (CURRENT_TIMESTAMP) 
+);
+
+
+-- Table: Changes
+DROP TABLE IF EXISTS Changes;
+
+CREATE TABLE IF NOT EXISTS Changes (
+    Seq        INTEGER  PRIMARY KEY AUTOINCREMENT
+                        UNIQUE
+                        NOT NULL,
+    EntityType STRING   NOT NULL,
+    EntityId   INTEGER  NOT NULL,
+    Operation  STRING   NOT NULL,
+    Audience   STRING,
+    ChangeDate DATETIME NOT NULL
+                        DEFAULT (CURRENT_TIMESTAMP) 
+);
+
+
+-- Table: Comments
+DROP TABLE IF EXISTS Comments;
+
+CREATE TABLE IF NOT EXISTS Comments (
+    Id              INTEGER  PRIMARY KEY AUTOINCREMENT
+                             UNIQUE
+                             NOT NULL,
+    TodoId          INTEGER  REFERENCES Todos (Id) ON DELETE CASCADE
+                             NOT NULL,
+    AuthorId        INTEGER  REFERENCES Users (Id) ON DELETE SET NULL,
+    Body            STRING   NOT NULL,
+    CreationDate    DATETIME NOT NULL
+                             DEFAULT (CURRENT_TIMESTAMP),
+    LastChangedDate DATETIME NOT NULL
+                             DEFAULT (CURRENT_TIMESTAMP) 
+);
+
+
+-- Table: FeedTokens
+DROP TABLE IF EXISTS FeedTokens;
+
+CREATE TABLE IF NOT EXISTS FeedTokens (
+    UserId       INTEGER  PRIMARY KEY
+                          REFERENCES Users (Id) ON DELETE CASCADE
+                          NOT NULL,
+    TokenHash    STRING   UNIQUE
+                          NOT NULL,
+    CreationDate DATETIME NOT NULL
+                          DEFAULT (CURRENT_TIMESTAMP) 
+);
+
+
+-- Table: FieldVersions
+DROP TABLE IF EXISTS FieldVersions;
+
+CREATE TABLE IF NOT EXISTS FieldVersions (
+    EntityType   STRING   NOT NULL,
+    EntityId     INTEGER  NOT NULL,
+    Field        STRING   NOT NULL,
+    ModifiedDate DATETIME NOT NULL,
+    PRIMARY KEY (
+        EntityType,
+        EntityId,
+        Field
+    )
+);
+
+
+-- Table: Invitations
+DROP TABLE IF EXISTS Invitations;
+
+CREATE TABLE IF NOT EXISTS Invitations (
+    Id           INTEGER  PRIMARY KEY AUTOINCREMENT
+                          UNIQUE
+                          NOT NULL,
+    TokenHash    STRING   UNIQUE
+                          NOT NULL,
+    Email        STRING,
+    FullName     STRING,
+    Roles        STRING   NOT NULL,
+    OrgUnitId    INTEGER  REFERENCES OrgUnits (Id) ON DELETE CASCADE
+                          NOT NULL,
+    Status       STRING   NOT NULL
+                          DEFAULT pending,
+    InvitedBy    INTEGER  REFERENCES Users (Id) ON DELETE SET NULL,
+    UserId       INTEGER  REFERENCES Users (Id) ON DELETE SET NULL,
+    ExpiryDate   DATETIME NOT NULL,
+    AcceptedDate DATETIME,
+    RevokedDate  DATETIME,
+    CreationDate DATETIME NOT NULL
+                          DEFAULT (CURRENT_TIMESTAMP) 
+);
+
+
+-- Table: ListMembers
+DROP TABLE IF EXISTS ListMembers;
+
+CREATE TABLE IF NOT EXISTS ListMembers (
+    ListId       INTEGER  REFERENCES Lists (Id) ON DELETE CASCADE
+                          NOT NULL,
+    UserId       INTEGER  REFERENCES Users (Id) ON DELETE CASCADE
+                          NOT NULL,
+    CreationDate DATETIME NOT NULL
+                          DEFAULT (CURRENT_TIMESTAMP),
+    PRIMARY KEY (
+        ListId,
+        UserId
+    )
+);
+
+
+-- Table: Lists
+DROP TABLE IF EXISTS Lists;
+
+CREATE TABLE IF NOT EXISTS Lists (
+    Id              INTEGER  PRIMARY KEY AUTOINCREMENT
+                             UNIQUE
+                             NOT NULL,
+    Name            STRING   NOT NULL,
+    OwnerId         INTEGER  REFERENCES Users (Id) ON DELETE CASCADE
+                             NOT NULL,
+    CreationDate    DATETIME NOT NULL
+                             DEFAULT (CURRENT_TIMESTAMP),
+    LastChangedDate DATETIME NOT NULL
+                             DEFAULT (CURRENT_TIMESTAMP) 
+);
+
+
+-- Table: LoginFailures
+DROP TABLE IF EXISTS LoginFailures;
+
+CREATE TABLE IF NOT EXISTS LoginFailures (
+    Id          INTEGER  PRIMARY KEY AUTOINCREMENT
+                         UNIQUE
+                         NOT NULL,
+    UserName    STRING   NOT NULL,
+    IpAddress   STRING   NOT NULL,
+    FailureDate DATETIME NOT NULL
+                         DEFAULT (CURRENT_TIMESTAMP) 
+);
+
+
+-- Table: MailOutbox
+DROP TABLE IF EXISTS MailOutbox;
+
+CREATE TABLE IF NOT EXISTS MailOutbox (
+    Id              INTEGER  PRIMARY KEY AUTOINCREMENT
+                             UNIQUE
+                             NOT NULL,
+    Kind            STRING   NOT NULL,
+    Recipient       STRING   NOT NULL,
+    Subject         STRING   NOT NULL,
+    TextBody        STRING   NOT NULL,
+    HtmlBody        STRING   NOT NULL,
+    Status          STRING   NOT NULL
+                             DEFAULT pending,
+    Attempts        INTEGER  NOT NULL
+                             DEFAULT 0,
+    LastError       STRING,
+    NextAttemptDate DATETIME NOT NULL
+                             DEFAULT (CURRENT_TIMESTAMP),
+    CreationDate    DATETIME NOT NULL
+                             DEFAULT (CURRENT_TIMESTAMP),
+    SentDate        DATETIME
+);
+
+
+-- Table: OidcIdentities
+DROP TABLE IF EXISTS OidcIdentities;
+
+CREATE TABLE IF NOT EXISTS OidcIdentities (
+    Issuer        STRING   NOT NULL,
+    Subject       STRING   NOT NULL,
+    UserId        INTEGER  REFERENCES Users (Id) ON DELETE CASCADE
+                           NOT NULL,
+    CreationDate  DATETIME NOT NULL
+                           DEFAULT (CURRENT_TIMESTAMP),
+    LastLoginDate DATETIME,
+    PRIMARY KEY (
+        Issuer,
+        Subject
+    )
+);
+
+
+-- Table: OrgUnits
+DROP TABLE IF EXISTS OrgUnits;
+
+CREATE TABLE IF NOT EXISTS OrgUnits (
+    Id           INTEGER  PRIMARY KEY AUTOINCREMENT
+                          UNIQUE
+                          NOT NULL,
+    OrgUnitName  STRING   UNIQUE
+                          NOT NULL,
+    Description  STRING   NOT NULL,
+    CreationDate DATETIME NOT NULL
+                          DEFAULT (CURRENT_TIMESTAMP) 
+);
+
+INSERT INTO OrgUnits (
+                         Id,
+                         OrgUnitName,
+                         Description
+                     )
+                     VALUES (
+                         1,
+                         'default',
+                         'Accounts not assigned to another organizational unit'
+                     );
+
+
+-- Table: PasswordHistory
+DROP TABLE IF EXISTS PasswordHistory;
+
+CREATE TABLE IF NOT EXISTS PasswordHistory (
+    Id           INTEGER  PRIMARY KEY AUTOINCREMENT
+                          UNIQUE
+                          NOT NULL,
+    UserId       INTEGER  REFERENCES Users (Id) ON DELETE CASCADE
+                          NOT NULL,
+    PasswordHash STRING   NOT NULL,
+    CreationDate DATETIME NOT NULL
+                          DEFAULT (CURRENT_TIMESTAMP) 
+);
+
+
+-- Table: PasswordResets
+DROP TABLE IF EXISTS PasswordResets;
+
+CREATE TABLE IF NOT EXISTS PasswordResets (
+    Id           INTEGER  PRIMARY KEY AUTOINCREMENT
+                          UNIQUE
+                          NOT NULL,
+    UserId       INTEGER  REFERENCES Users (Id) ON DELETE CASCADE
+                          NOT NULL,
+    TokenHash    STRING   UNIQUE
+                          NOT NULL,
+    ExpiryDate   DATETIME NOT NULL,
+    UsedDate     DATETIME,
+    CreationDate DATETIME NOT NULL
+                          DEFAULT (CURRENT_TIMESTAMP) 
+);
+
+
+-- Table: RecoveryCodes
+DROP TABLE IF EXISTS RecoveryCodes;
+
+CREATE TABLE IF NOT EXISTS RecoveryCodes (
+    Id           INTEGER  PRIMARY KEY AUTOINCREMENT
+                          UNIQUE
+                          NOT NULL,
+    UserId       INTEGER  REFERENCES Users (Id) ON DELETE CASCADE
+                          NOT NULL,
+    CodeHash     STRING   NOT NULL,
+    UsedDate     DATETIME,
+    CreationDate DATETIME NOT NULL
+                          DEFAULT (CURRENT_TIMESTAMP) 
+);
+
+
+-- Table: Roles
+DROP TABLE IF EXISTS Roles;
+
+CREATE TABLE IF NOT EXISTS Roles (
+    Id           INTEGER  PRIMARY KEY AUTOINCREMENT
+                          UNIQUE
+                          NOT NULL,
+    RoleName     STRING   UNIQUE
+                          NOT NULL,
+    Description  STRING   NOT NULL,
+    CreationDate DATETIME NOT NULL
+                          DEFAULT (CURRENT_TIMESTAMP) 
+);
+
+INSERT INTO Roles (
+                      Id,
+                      RoleName,
+                      Description
+                  )
+                  VALUES (
+                      1,
+                      'admin',
+                      'Manages user accounts, roles and webhooks'
+                  );
+
+INSERT INTO Roles (
+                      Id,
+                      RoleName,
+                      Description
+                  )
+                  VALUES (
+                      2,
+                      'member',
+                      'Manages their own account and todos'
+                  );
+
+INSERT INTO Roles (
+                      Id,
+                      RoleName,
+                      Description
+                  )
+                  VALUES (
+                      3,
+                      'orgadmin',
+                      'Manages the accounts of their organizational unit'
+                  );
+
+
+-- Table: Sessions
+DROP TABLE IF EXISTS Sessions;
+
+CREATE TABLE IF NOT EXISTS Sessions (
+    Id           INTEGER  PRIMARY KEY AUTOINCREMENT
+                          UNIQUE
+                          NOT NULL,
+    TokenHash    STRING   UNIQUE
+                          NOT NULL,
+    UserId       INTEGER  REFERENCES Users (Id) ON DELETE CASCADE,
+    Data         BLOB     NOT NULL,
+    UserAgent    STRING,
+    IpAddress    STRING,
+    CreationDate DATETIME NOT NULL
+                          DEFAULT (CURRENT_TIMESTAMP),
+    LastSeenDate DATETIME NOT NULL
+                          DEFAULT (CURRENT_TIMESTAMP),
+    ExpiryDate   DATETIME NOT NULL
+);
+
+
 -- Table: Statuses
 DROP TABLE IF EXISTS Statuses;
 
@@ -45,38 +390,177 @@ INSERT INTO Statuses (
                      );
 
 
--- Table: Todos
-DROP TABLE IF EXISTS Todos;
+-- Table: Tags
+DROP TABLE IF EXISTS Tags;
 
-CREATE TABLE IF NOT EXISTS Todos (
-    Id          INTEGER PRIMARY KEY AUTOINCREMENT
+CREATE TABLE IF NOT EXISTS Tags (
+    Id           INTEGER  PRIMARY KEY AUTOINCREMENT
+                          UNIQUE
+                          NOT NULL,
+    Name         STRING   NOT NULL,
+    OrgUnitId    INTEGER  REFERENCES OrgUnits (Id) ON DELETE CASCADE
+                          NOT NULL
+                          DEFAULT 1,
+    CreationDate DATETIME NOT NULL
+                          DEFAULT (CURRENT_TIMESTAMP),
+    UNIQUE (
+        OrgUnitId,
+        Name
+    )
+);
+
+
+-- Table: TodoHistory
+DROP TABLE IF EXISTS TodoHistory;
+
+CREATE TABLE IF NOT EXISTS TodoHistory (
+    Id         INTEGER  PRIMARY KEY AUTOINCREMENT
                         UNIQUE
                         NOT NULL,
-    Description STRING  NOT NULL,
-    Status      INTEGER REFERENCES Statuses (Id) 
-                        NOT NULL
+    TodoId     INTEGER  REFERENCES Todos (Id) ON DELETE CASCADE
+                        NOT NULL,
+    Field      STRING   NOT NULL,
+    OldValue   STRING,
+    NewValue   STRING,
+    ChangeDate DATETIME NOT NULL
+                        DEFAULT (CURRENT_TIMESTAMP) 
 );
 
 
--- Table: Users
-DROP TABLE IF EXISTS Users;
+-- Table: TodoTags
+DROP TABLE IF EXISTS TodoTags;
 
-CREATE TABLE IF NOT EXISTS Users (
+CREATE TABLE IF NOT EXISTS TodoTags (
+    TodoId INTEGER REFERENCES Todos (Id) ON DELETE CASCADE
+                   NOT NULL,
+    TagId  INTEGER REFERENCES Tags (Id) ON DELETE CASCADE
+                   NOT NULL,
+    PRIMARY KEY (
+        TodoId,
+        TagId
+    )
+);
+
+
+-- Table: Todos
+DROP TABLE IF EXISTS Todos;
+
+CREATE TABLE IF NOT EXISTS Todos (
     Id              INTEGER  PRIMARY KEY AUTOINCREMENT
                              UNIQUE
                              NOT NULL,
-    UserName        STRING   UNIQUE
+    Description     STRING   NOT NULL,
+    Status          INTEGER  REFERENCES Statuses (Id) 
                              NOT NULL,
-    FullName        STRING   NOT NULL,
-    PasswordHash    STRING   NOT NULL,
-    Status          STRING   NOT NULL
-                             DEFAULT enabled,
+    CreatorId       INTEGER  REFERENCES Users (Id) ON DELETE CASCADE
+                             NOT NULL,
+    AssigneeId      INTEGER  REFERENCES Users (Id) ON DELETE SET NULL,
+    ListId          INTEGER  REFERENCES Lists (Id) ON DELETE SET NULL,
+    ParentId        INTEGER  REFERENCES Todos (Id) ON DELETE CASCADE,
+    DueDate         DATETIME,
+    RemindDate      DATETIME,
+    CompletionDate  DATETIME,
+    ReminderSent    BOOLEAN  NOT NULL
+                             DEFAULT 0,
+    Priority        INTEGER  NOT NULL
+                             DEFAULT 0,
+    Uid             STRING,
+    ResourceName    STRING,
     CreationDate    DATETIME NOT NULL
                              DEFAULT (CURRENT_TIMESTAMP),
     LastChangedDate DATETIME NOT NULL
                              DEFAULT (CURRENT_TIMESTAMP) 
 );
 
+
+-- Table: TwoFactor
+DROP TABLE IF EXISTS TwoFactor;
+
+CREATE TABLE IF NOT EXISTS TwoFactor (
+    UserId       INTEGER  PRIMARY KEY
+                          REFERENCES Users (Id) ON DELETE CASCADE
+                          NOT NULL,
+    Secret       STRING   NOT NULL,
+    Enabled      BOOLEAN  NOT NULL
+                          DEFAULT 0,
+    LastUsedStep INTEGER  NOT NULL
+                          DEFAULT 0,
+    EnabledDate  DATETIME,
+    CreationDate DATETIME NOT NULL
+                          DEFAULT (CURRENT_TIMESTAMP) 
+);
+
+
+-- Table: UserRoles
+DROP TABLE IF EXISTS UserRoles;
+
+CREATE TABLE IF NOT EXISTS UserRoles (
+    UserId       INTEGER  REFERENCES Users (Id) ON DELETE CASCADE
+                          NOT NULL,
+    RoleId       INTEGER  REFERENCES Roles (Id) ON DELETE CASCADE
+                          NOT NULL,
+    CreationDate DATETIME NOT NULL
+                          DEFAULT (CURRENT_TIMESTAMP),
+    PRIMARY KEY (
+        UserId,
+        RoleId
+    )
+);
+
+INSERT INTO UserRoles (
+                          UserId,
+                          RoleId
+                      )
+                      VALUES (
+                          1,
+                          1
+                      );
+
+INSERT INTO UserRoles (
+                          UserId,
+                          RoleId
+                      )
+                      VALUES (
+                          1,
+                          2
+                      );
+
+
+-- Table: Users
+DROP TABLE IF EXISTS Users;
+
+CREATE TABLE IF NOT EXISTS Users (
+    Id                  INTEGER  PRIMARY KEY AUTOINCREMENT
+                                 UNIQUE
+                                 NOT NULL,
+    UserName            STRING   UNIQUE
+                                 NOT NULL,
+    FullName            STRING   NOT NULL,
+    Email               STRING,
+    Timezone            STRING,
+    Locale              STRING,
+    Preferences         STRING,
+    PasswordHash        STRING   NOT NULL,
+    Status              STRING   NOT NULL
+                                 DEFAULT enabled,
+    OrgUnitId           INTEGER  REFERENCES OrgUnits (Id) 
+                                 NOT NULL
+                                 DEFAULT 1,
+    Source              STRING   NOT NULL
+                                 DEFAULT local,
+    LdapDn              STRING   UNIQUE,
+    ServiceAccount      BOOLEAN  NOT NULL
+                                 DEFAULT 0,
+    LockReason          STRING,
+    LockDate            DATETIME,
+    CreationDate        DATETIME NOT NULL
+                                 DEFAULT (CURRENT_TIMESTAMP),
+    LastChangedDate     DATETIME NOT NULL
+                                 DEFAULT (CURRENT_TIMESTAMP),
+    PasswordChangedDate DATETIME NOT NULL
+                                 DEFAULT (CURRENT_TIMESTAMP) 
+);
+
 INSERT INTO Users (
                       Id,
                       UserName,
@@ -97,5 +581,699 @@ INSERT INTO Users (
                   );
 
 
+-- Table: WebhookDeliveries
+DROP TABLE IF EXISTS WebhookDeliveries;
+
+CREATE TABLE IF NOT EXISTS WebhookDeliveries (
+    Id              INTEGER  PRIMARY KEY AUTOINCREMENT
+                             UNIQUE
+                             NOT NULL,
+    WebhookId       INTEGER  REFERENCES Webhooks (Id) ON DELETE CASCADE
+                             NOT NULL,
+    EventId         STRING   NOT NULL,
+    EventType       STRING   NOT NULL,
+    Payload         STRING   NOT NULL,
+    Status          STRING   NOT NULL
+                             DEFAULT pending,
+    Attempts        INTEGER  NOT NULL
+                             DEFAULT 0,
+    ResponseCode    INTEGER,
+    ResponseBody    STRING,
+    LastError       STRING,
+    NextAttemptDate DATETIME NOT NULL
+                             DEFAULT (CURRENT_TIMESTAMP),
+    CreationDate    DATETIME NOT NULL
+                             DEFAULT (CURRENT_TIMESTAMP),
+    DeliveredDate   DATETIME
+);
+
+
+-- Table: Webhooks
+DROP TABLE IF EXISTS Webhooks;
+
+CREATE TABLE IF NOT EXISTS Webhooks (
+    Id           INTEGER  PRIMARY KEY AUTOINCREMENT
+                          UNIQUE
+                          NOT NULL,
+    Url          STRING   NOT NULL,
+    Secret       STRING   NOT NULL,
+    Events       STRING   NOT NULL,
+    Active       BOOLEAN  NOT NULL
+                          DEFAULT 1,
+    CreatorId    INTEGER  REFERENCES Users (Id) ON DELETE CASCADE
+                          NOT NULL,
+    CreationDate DATETIME NOT NULL
+                          DEFAULT (CURRENT_TIMESTAMP) 
+);
+
+
+-- Trigger: ListMembersDeleteChange
+DROP TRIGGER IF EXISTS ListMembersDeleteChange;
+CREATE TRIGGER IF NOT EXISTS ListMembersDeleteChange
+         AFTER DELETE
+            ON ListMembers
+BEGIN
+    INSERT INTO Changes (
+                            EntityType,
+                            EntityId,
+                            Operation,
+                            Audience
+                        )
+                        SELECT 'list',
+                               OLD.ListId,
+                               'revoke',
+                               ',' || OLD.UserId || ','
+                         WHERE EXISTS (SELECT 1 FROM Lists WHERE Id = OLD.ListId);
+    INSERT INTO Changes (
+                            EntityType,
+                            EntityId,
+                            Operation,
+                            Audience
+                        )
+                        SELECT 'todo',
+                               Id,
+                               'revoke',
+                               ',' || OLD.UserId || ','
+                          FROM Todos
+                         WHERE ListId = OLD.ListId;
+END;
+
+
+-- Trigger: ListMembersInsertChange
+DROP TRIGGER IF EXISTS ListMembersInsertChange;
+CREATE TRIGGER IF NOT EXISTS ListMembersInsertChange
+         AFTER INSERT
+            ON ListMembers
+BEGIN
+    DELETE FROM Changes
+          WHERE EntityType = 'list' AND 
+                EntityId = NEW.ListId AND 
+                Operation = 'upsert';
+    INSERT INTO Changes (
+                            EntityType,
+                            EntityId,
+                            Operation
+                        )
+                        VALUES (
+                            'list',
+                            NEW.ListId,
+                            'upsert'
+                        );
+    DELETE FROM Changes
+          WHERE EntityType = 'todo' AND 
+                EntityId IN (SELECT Id FROM Todos WHERE ListId = NEW.ListId) AND 
+                Operation = 'upsert';
+    INSERT INTO Changes (
+                            EntityType,
+                            EntityId,
+                            Operation
+                        )
+                        SELECT 'todo',
+                               Id,
+                               'upsert'
+                          FROM Todos
+                         WHERE ListId = NEW.ListId;
+END;
+
+
+-- Trigger: ListMembersOrgUnit
+DROP TRIGGER IF EXISTS ListMembersOrgUnit;
+CREATE TRIGGER IF NOT EXISTS ListMembersOrgUnit
+        BEFORE INSERT
+            ON ListMembers
+          WHEN (SELECT OrgUnitId FROM Users WHERE Id = NEW.UserId) IS NOT 
+               (SELECT OrgUnitId FROM Users WHERE Id = (SELECT OwnerId FROM Lists WHERE Id = NEW.ListId) ) 
+BEGIN
+    SELECT RAISE(ABORT, 'list members must belong to the organizational unit of the list owner');
+END;
+
+
+-- Trigger: ListsDeleteChange
+DROP TRIGGER IF EXISTS ListsDeleteChange;
+CREATE TRIGGER IF NOT EXISTS ListsDeleteChange
+        BEFORE DELETE
+            ON Lists
+BEGIN
+    INSERT INTO Changes (
+                            EntityType,
+                            EntityId,
+                            Operation,
+                            Audience
+                        )
+                        SELECT 'todo',
+                               Id,
+                               'revoke',
+                               ',' || OLD.OwnerId || ',' ||
+                 IFNULL( (SELECT GROUP_CONCAT(UserId) FROM ListMembers WHERE ListId = OLD.Id), '') || ','
+                          FROM Todos
+                         WHERE ListId = OLD.Id;
+    DELETE FROM Changes
+          WHERE EntityType = 'list' AND 
+                EntityId = OLD.Id AND 
+                Operation != 'revoke';
+    INSERT INTO Changes (
+                            EntityType,
+                            EntityId,
+                            Operation,
+                            Audience
+                        )
+                        VALUES (
+                            'list',
+                            OLD.Id,
+                            'delete',
+                            ',' || OLD.OwnerId || ',' ||
+                 IFNULL( (SELECT GROUP_CONCAT(UserId) FROM ListMembers WHERE ListId = OLD.Id), '') || ','
+                        );
+    DELETE FROM FieldVersions
+          WHERE EntityType = 'list' AND 
+                EntityId = OLD.Id;
+END;
+
+
+-- Trigger: ListsInsertChange
+DROP TRIGGER IF EXISTS ListsInsertChange;
+CREATE TRIGGER IF NOT EXISTS ListsInsertChange
+         AFTER INSERT
+            ON Lists
+BEGIN
+    INSERT INTO Changes (
+                            EntityType,
+                            EntityId,
+                            Operation
+                        )
+                        VALUES (
+                            'list',
+                            NEW.Id,
+                            'create'
+                        );
+END;
+
+
+-- Trigger: ListsUpdateChange
+DROP TRIGGER IF EXISTS ListsUpdateChange;
+CREATE TRIGGER IF NOT EXISTS ListsUpdateChange
+         AFTER UPDATE
+            ON Lists
+BEGIN
+    DELETE FROM Changes
+          WHERE EntityType = 'list' AND 
+                EntityId = NEW.Id AND 
+                Operation = 'upsert';
+    INSERT INTO Changes (
+                            EntityType,
+                            EntityId,
+                            Operation
+                        )
+                        VALUES (
+                            'list',
+                            NEW.Id,
+                            'upsert'
+                        );
+    INSERT OR REPLACE INTO FieldVersions (
+                                             EntityType,
+                                             EntityId,
+                                             Field,
+                                             ModifiedDate
+                                         )
+                                         SELECT 'list',
+                                                NEW.Id,
+                                                'name',
+                                                NEW.LastChangedDate
+                                          WHERE OLD.Name IS NOT NEW.Name;
+END;
+
+
+-- Trigger: TagsDeleteChange
+DROP TRIGGER IF EXISTS TagsDeleteChange;
+CREATE TRIGGER IF NOT EXISTS TagsDeleteChange
+         AFTER DELETE
+            ON Tags
+BEGIN
+    DELETE FROM Changes
+          WHERE EntityType = 'tag' AND 
+                EntityId = OLD.Id;
+    INSERT INTO Changes (
+                            EntityType,
+                            EntityId,
+                            Operation
+                        )
+                        VALUES (
+                            'tag',
+                            OLD.Id,
+                            'delete'
+                        );
+END;
+
+
+-- Trigger: TagsInsertChange
+DROP TRIGGER IF EXISTS TagsInsertChange;
+CREATE TRIGGER IF NOT EXISTS TagsInsertChange
+         AFTER INSERT
+            ON Tags
+BEGIN
+    INSERT INTO Changes (
+                            EntityType,
+                            EntityId,
+                            Operation
+                        )
+                        VALUES (
+                            'tag',
+                            NEW.Id,
+                            'create'
+                        );
+END;
+
+
+-- Trigger: TodoTagsDeleteChange
+DROP TRIGGER IF EXISTS TodoTagsDeleteChange;
+CREATE TRIGGER IF NOT EXISTS TodoTagsDeleteChange
+         AFTER DELETE
+            ON TodoTags
+          WHEN EXISTS (SELECT 1 FROM Todos WHERE Id = OLD.TodoId) 
+BEGIN
+    DELETE FROM Changes
+          WHERE EntityType = 'todo' AND 
+                EntityId = OLD.TodoId AND 
+                Operation = 'upsert';
+    INSERT INTO Changes (
+                            EntityType,
+                            EntityId,
+                            Operation
+                        )
+                        VALUES (
+                            'todo',
+                            OLD.TodoId,
+                            'upsert'
+                        );
+    INSERT OR REPLACE INTO FieldVersions (
+                                             EntityType,
+                                             EntityId,
+                                             Field,
+                                             ModifiedDate
+                                         )
+                                         VALUES (
+                                             'todo',
+                                             OLD.TodoId,
+                                             'tags',
+                                             CURRENT_TIMESTAMP
+                                         );
+END;
+
+
+-- Trigger: TodoTagsDeleteHistory
+DROP TRIGGER IF EXISTS TodoTagsDeleteHistory;
+CREATE TRIGGER IF NOT EXISTS TodoTagsDeleteHistory
+         AFTER DELETE
+            ON TodoTags
+          WHEN EXISTS (SELECT 1 FROM Todos WHERE Id = OLD.TodoId) 
+BEGIN
+    INSERT INTO TodoHistory (
+                                TodoId,
+                                Field,
+                                OldValue,
+                                NewValue,
+                                ChangeDate
+                            )
+                            SELECT OLD.TodoId,
+                                   'tags',
+                                   (SELECT Name FROM Tags WHERE Id = OLD.TagId),
+                                   NULL,
+                                   CURRENT_TIMESTAMP;
+END;
+
+
+-- Trigger: TodoTagsInsertChange
+DROP TRIGGER IF EXISTS TodoTagsInsertChange;
+CREATE TRIGGER IF NOT EXISTS TodoTagsInsertChange
+         AFTER INSERT
+            ON TodoTags
+BEGIN
+    DELETE FROM Changes
+          WHERE EntityType = 'todo' AND 
+                EntityId = NEW.TodoId AND 
+                Operation = 'upsert';
+    INSERT INTO Changes (
+                            EntityType,
+                            EntityId,
+                            Operation
+                        )
+                        VALUES (
+                            'todo',
+                            NEW.TodoId,
+                            'upsert'
+                        );
+    INSERT OR REPLACE INTO FieldVersions (
+                                             EntityType,
+                                             EntityId,
+                                             Field,
+                                             ModifiedDate
+                                         )
+                                         VALUES (
+                                             'todo',
+                                             NEW.TodoId,
+                                             'tags',
+                                             CURRENT_TIMESTAMP
+                                         );
+END;
+
+
+-- Trigger: TodoTagsInsertHistory
+DROP TRIGGER IF EXISTS TodoTagsInsertHistory;
+CREATE TRIGGER IF NOT EXISTS TodoTagsInsertHistory
+         AFTER INSERT
+            ON TodoTags
+BEGIN
+    INSERT INTO TodoHistory (
+                                TodoId,
+                                Field,
+                                OldValue,
+                                NewValue,
+                                ChangeDate
+                            )
+                            SELECT NEW.TodoId,
+                                   'tags',
+                                   NULL,
+                                   (SELECT Name FROM Tags WHERE Id = NEW.TagId),
+                                   CURRENT_TIMESTAMP;
+END;
+
+
+-- Trigger: TodosDeleteChange
+DROP TRIGGER IF EXISTS TodosDeleteChange;
+CREATE TRIGGER IF NOT EXISTS TodosDeleteChange
+         AFTER DELETE
+            ON Todos
+BEGIN
+    DELETE FROM Changes
+          WHERE EntityType = 'todo' AND 
+                EntityId = OLD.Id AND 
+                Operation != 'revoke';
+    INSERT INTO Changes (
+                            EntityType,
+                            EntityId,
+                            Operation,
+                            Audience
+                        )
+                        VALUES (
+                            'todo',
+                            OLD.Id,
+                            'delete',
+                            ',' || OLD.CreatorId || ',' || IFNULL(OLD.AssigneeId, '') || ',' ||
+                 IFNULL( (SELECT OwnerId FROM Lists WHERE Id = OLD.ListId), '') || ',' ||
+                 IFNULL( (SELECT GROUP_CONCAT(UserId) FROM ListMembers WHERE ListId = OLD.ListId), '') || ','
+                        );
+    DELETE FROM FieldVersions
+          WHERE EntityType = 'todo' AND 
+                EntityId = OLD.Id;
+END;
+
+
+-- Trigger: TodosInsertChange
+DROP TRIGGER IF EXISTS TodosInsertChange;
+CREATE TRIGGER IF NOT EXISTS TodosInsertChange
+         AFTER INSERT
+            ON Todos
+BEGIN
+    INSERT INTO Changes (
+                            EntityType,
+                            EntityId,
+                            Operation
+                        )
+                        VALUES (
+                            'todo',
+                            NEW.Id,
+                            'create'
+                        );
+END;
+
+
+-- Trigger: TodosInsertHistory
+DROP TRIGGER IF EXISTS TodosInsertHistory;
+CREATE TRIGGER IF NOT EXISTS TodosInsertHistory
+         AFTER INSERT
+            ON Todos
+BEGIN
+    INSERT INTO TodoHistory (
+                                TodoId,
+                                Field,
+                                OldValue,
+                                NewValue,
+                                ChangeDate
+                            )
+                            SELECT NEW.Id,
+                                   'created',
+                                   NULL,
+                                   NEW.Description,
+                                   NEW.CreationDate;
+END;
+
+
+-- Trigger: TodosInsertOrgUnit
+DROP TRIGGER IF EXISTS TodosInsertOrgUnit;
+CREATE TRIGGER IF NOT EXISTS TodosInsertOrgUnit
+        BEFORE INSERT
+            ON Todos
+          WHEN (NEW.AssigneeId IS NOT NULL AND 
+                (SELECT OrgUnitId FROM Users WHERE Id = NEW.AssigneeId) IS NOT 
+                (SELECT OrgUnitId FROM Users WHERE Id = NEW.CreatorId) ) OR 
+               (NEW.ListId IS NOT NULL AND 
+                (SELECT OrgUnitId FROM Users WHERE Id = (SELECT OwnerId FROM Lists WHERE Id = NEW.ListId) ) IS NOT 
+                (SELECT OrgUnitId FROM Users WHERE Id = NEW.CreatorId) ) 
+BEGIN
+    SELECT RAISE(ABORT, 'assignees and lists must belong to the organizational unit of the todo creator');
+END;
+
+
+-- Trigger: TodosUpdateChange
+DROP TRIGGER IF EXISTS TodosUpdateChange;
+CREATE TRIGGER IF NOT EXISTS TodosUpdateChange
+         AFTER UPDATE
+            ON Todos
+BEGIN
+    INSERT INTO Changes (
+                            EntityType,
+                            EntityId,
+                            Operation,
+                            Audience
+                        )
+                        SELECT 'todo',
+                               OLD.Id,
+                               'revoke',
+                               ',' || OLD.CreatorId || ',' || IFNULL(OLD.AssigneeId, '') || ',' ||
+                 IFNULL( (SELECT OwnerId FROM Lists WHERE Id = OLD.ListId), '') || ',' ||
+                 IFNULL( (SELECT GROUP_CONCAT(UserId) FROM ListMembers WHERE ListId = OLD.ListId), '') || ','
+                         WHERE OLD.AssigneeId IS NOT NEW.AssigneeId OR 
+                               OLD.ListId IS NOT NEW.ListId;
+    DELETE FROM Changes
+          WHERE EntityType = 'todo' AND 
+                EntityId = NEW.Id AND 
+                Operation = 'upsert';
+    INSERT INTO Changes (
+                            EntityType,
+                            EntityId,
+                            Operation
+                        )
+                        VALUES (
+                            'todo',
+                            NEW.Id,
+                            'upsert'
+                        );
+    INSERT OR REPLACE INTO FieldVersions (
+                                             EntityType,
+                                             EntityId,
+                                             Field,
+                                             ModifiedDate
+                                         )
+                                         SELECT 'todo',
+                                                NEW.Id,
+                                                'description',
+                                                NEW.LastChangedDate
+                                          WHERE OLD.Description IS NOT NEW.Description;
+    INSERT OR REPLACE INTO FieldVersions (
+                                             EntityType,
+                                             EntityId,
+                                             Field,
+                                             ModifiedDate
+                                         )
+                                         SELECT 'todo',
+                                                NEW.Id,
+                                                'status',
+                                                NEW.LastChangedDate
+                                          WHERE OLD.Status IS NOT NEW.Status;
+    INSERT OR REPLACE INTO FieldVersions (
+                                             EntityType,
+                                             EntityId,
+                                             Field,
+                                             ModifiedDate
+                                         )
+                                         SELECT 'todo',
+                                                NEW.Id,
+                                                'assigneeId',
+                                                NEW.LastChangedDate
+                                          WHERE OLD.AssigneeId IS NOT NEW.AssigneeId;
+    INSERT OR REPLACE INTO FieldVersions (
+                                             EntityType,
+                                             EntityId,
+                                             Field,
+                                             ModifiedDate
+                                         )
+                                         SELECT 'todo',
+                                                NEW.Id,
+                                                'listId',
+                                                NEW.LastChangedDate
+                                          WHERE OLD.ListId IS NOT NEW.ListId;
+    INSERT OR REPLACE INTO FieldVersions (
+                                             EntityType,
+                                             EntityId,
+                                             Field,
+                                             ModifiedDate
+                                         )
+                                         SELECT 'todo',
+                                                NEW.Id,
+                                                'dueDate',
+                                                NEW.LastChangedDate
+                                          WHERE OLD.DueDate IS NOT NEW.DueDate;
+    INSERT OR REPLACE INTO FieldVersions (
+                                             EntityType,
+                                             EntityId,
+                                             Field,
+                                             ModifiedDate
+                                         )
+                                         SELECT 'todo',
+                                                NEW.Id,
+                                                'remindDate',
+                                                NEW.LastChangedDate
+                                          WHERE OLD.RemindDate IS NOT NEW.RemindDate;
+    INSERT OR REPLACE INTO FieldVersions (
+                                             EntityType,
+                                             EntityId,
+                                             Field,
+                                             ModifiedDate
+                                         )
+                                         SELECT 'todo',
+                                                NEW.Id,
+                                                'priority',
+                                                NEW.LastChangedDate
+                                          WHERE OLD.Priority IS NOT NEW.Priority;
+END;
+
+
+-- Trigger: TodosUpdateHistory
+DROP TRIGGER IF EXISTS TodosUpdateHistory;
+CREATE TRIGGER IF NOT EXISTS TodosUpdateHistory
+         AFTER UPDATE
+            ON Todos
+BEGIN
+    INSERT INTO TodoHistory (
+                                TodoId,
+                                Field,
+                                OldValue,
+                                NewValue,
+                                ChangeDate
+                            )
+                            SELECT NEW.Id,
+                                   'description',
+                                   OLD.Description,
+                                   NEW.Description,
+                                   NEW.LastChangedDate
+                             WHERE OLD.Description IS NOT NEW.Description;
+    INSERT INTO TodoHistory (
+                                TodoId,
+                                Field,
+                                OldValue,
+                                NewValue,
+                                ChangeDate
+                            )
+                            SELECT NEW.Id,
+                                   'status',
+                                   (SELECT StatusName FROM Statuses WHERE Id = OLD.Status),
+                                   (SELECT StatusName FROM Statuses WHERE Id = NEW.Status),
+                                   NEW.LastChangedDate
+                             WHERE OLD.Status IS NOT NEW.Status;
+    INSERT INTO TodoHistory (
+                                TodoId,
+                                Field,
+                                OldValue,
+                                NewValue,
+                                ChangeDate
+                            )
+                            SELECT NEW.Id,
+                                   'assignee',
+                                   (SELECT UserName FROM Users WHERE Id = OLD.AssigneeId),
+                                   (SELECT UserName FROM Users WHERE Id = NEW.AssigneeId),
+                                   NEW.LastChangedDate
+                             WHERE OLD.AssigneeId IS NOT NEW.AssigneeId;
+    INSERT INTO TodoHistory (
+                                TodoId,
+                                Field,
+                                OldValue,
+                                NewValue,
+                                ChangeDate
+                            )
+                            SELECT NEW.Id,
+                                   'list',
+                                   (SELECT Name FROM Lists WHERE Id = OLD.ListId),
+                                   (SELECT Name FROM Lists WHERE Id = NEW.ListId),
+                                   NEW.LastChangedDate
+                             WHERE OLD.ListId IS NOT NEW.ListId;
+    INSERT INTO TodoHistory (
+                                TodoId,
+                                Field,
+                                OldValue,
+                                NewValue,
+                                ChangeDate
+                            )
+                            SELECT NEW.Id,
+                                   'dueDate',
+                                   OLD.DueDate,
+                                   NEW.DueDate,
+                                   NEW.LastChangedDate
+                             WHERE OLD.DueDate IS NOT NEW.DueDate;
+    INSERT INTO TodoHistory (
+                                TodoId,
+                                Field,
+                                OldValue,
+                                NewValue,
+                                ChangeDate
+                            )
+                            SELECT NEW.Id,
+                                   'remindDate',
+                                   OLD.RemindDate,
+                                   NEW.RemindDate,
+                                   NEW.LastChangedDate
+                             WHERE OLD.RemindDate IS NOT NEW.RemindDate;
+    INSERT INTO TodoHistory (
+                                TodoId,
+                                Field,
+                                OldValue,
+                                NewValue,
+                                ChangeDate
+                            )
+                            SELECT NEW.Id,
+                                   'priority',
+                                   OLD.Priority,
+                                   NEW.Priority,
+                                   NEW.LastChangedDate
+                             WHERE OLD.Priority IS NOT NEW.Priority;
+END;
+
+
+-- Trigger: TodosUpdateOrgUnit
+DROP TRIGGER IF EXISTS TodosUpdateOrgUnit;
+CREATE TRIGGER IF NOT EXISTS TodosUpdateOrgUnit
+        BEFORE UPDATE OF AssigneeId,
+                         ListId
+            ON Todos
+          WHEN (NEW.AssigneeId IS NOT NULL AND 
+                (SELECT OrgUnitId FROM Users WHERE Id = NEW.AssigneeId) IS NOT 
+                (SELECT OrgUnitId FROM Users WHERE Id = NEW.CreatorId) ) OR 
+               (NEW.ListId IS NOT NULL AND 
+                (SELECT OrgUnitId FROM Users WHERE Id = (SELECT OwnerId FROM Lists WHERE Id = NEW.ListId) ) IS NOT 
+                (SELECT OrgUnitId FROM Users WHERE Id = NEW.CreatorId) ) 
+BEGIN
+    SELECT RAISE(ABORT, 'assignees and lists must belong to the organizational unit of the todo creator');
+END;
+
+
 COMMIT TRANSACTION;
 PRAGMA foreign_keys = on;
@@ -0,0 +1,671 @@
+package delta
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/greeneg/todoer/events"
+	"github.com/greeneg/todoer/model"
+	"github.com/greeneg/todoer/notify"
+)
+
+// MaxBatchSize is the largest number of changes accepted in one batch
+const MaxBatchSize = 500
+
+// reasons a change, or a field of one, is rejected
+const (
+	ReasonStale       = "stale"
+	ReasonConflict    = "conflict"
+	ReasonNotFound    = "not_found"
+	ReasonForbidden   = "forbidden"
+	ReasonInvalid     = "invalid"
+	ReasonUnsupported = "unsupported"
+)
+
+// ClientChange is one change made by a client. Updates carry only the fields
+// that changed. ModifiedAt is when the change was made on the client; it
+// defaults to, and may not be later than, the time the server receives it.
+// New entities are given a ClientId so that the client can learn their server
+// Id, and so that todos created in the same batch can be put on a new list by
+// giving its ClientId as their listId
+type ClientChange struct {
+	Entity     string                     `json:"entity" enum:"todo,list,tag"`
+	Op         string                     `json:"op" enum:"create,update,delete"`
+	Id         int                        `json:"id"`
+	ClientId   string                     `json:"clientId"`
+	ModifiedAt string                     `json:"modifiedAt"`
+	Fields     map[string]json.RawMessage `json:"fields" swaggertype:"object"`
+}
+
+// Batch is a set of changes made by a client, applied in order
+type Batch struct {
+	Changes []ClientChange `json:"changes"`
+}
+
+// Mapping tells a client the server Id of an entity it created
+type Mapping struct {
+	Entity   string `json:"entity"`
+	ClientId string `json:"clientId"`
+	Id       int    `json:"id"`
+}
+
+// Rejection reports a change, or a single field of one, that was not applied.
+// Stale fields were changed on the server after the client changed them, and
+// ServerValue holds the value that was kept
+type Rejection struct {
+	Index       int    `json:"index"`
+	Entity      string `json:"entity"`
+	Id          int    `json:"id,omitempty"`
+	ClientId    string `json:"clientId,omitempty"`
+	Field       string `json:"field,omitempty"`
+	Reason      string `json:"reason" enum:"stale,conflict,not_found,forbidden,invalid,unsupported"`
+	Message     string `json:"message"`
+	ServerValue any    `json:"serverValue,omitempty"`
+}
+
+// Result is the outcome of applying a batch. Token is a sync token taken
+// before the batch was applied, so the next sync returns the merged state of
+// everything the batch touched
+type Result struct {
+	Token    string      `json:"token"`
+	Applied  int         `json:"applied"`
+	Created  []Mapping   `json:"created"`
+	Rejected []Rejection `json:"rejected"`
+}
+
+// applier applies the changes of one batch
+type applier struct {
+	user    model.User
+	now     string
+	result  Result
+	created map[string]map[string]int
+}
+
+// Apply applies a batch of client changes for the user, resolving conflicts
+// field by field: the most recent change to a field wins, judged by the
+// server's record of when each field last changed
+func Apply(user model.User, batch Batch) (Result, error) {
+	if len(batch.Changes) > MaxBatchSize {
+		return Result{}, errors.New("a batch may hold at most " + strconv.Itoa(MaxBatchSize) + " changes")
+	}
+
+	latest, err := model.GetLatestChangeSeq()
+	if err != nil {
+		return Result{}, err
+	}
+
+	a := applier{
+		user:    user,
+		now:     model.Now(),
+		result:  Result{Token: strconv.Itoa(latest), Created: make([]Mapping, 0), Rejected: make([]Rejection, 0)},
+		created: map[string]map[string]int{"todo": {}, "list": {}, "tag": {}},
+	}
+	for i, change := range batch.Changes {
+		applied, err := a.apply(i, change)
+		if err != nil {
+			return Result{}, err
+		}
+		if applied {
+			a.result.Applied++
+		}
+	}
+
+	return a.result, nil
+}
+
+func (a *applier) reject(index int, change ClientChange, field string, reason string, message string, serverValue any) {
+	a.result.Rejected = append(a.result.Rejected, Rejection{
+		Index:       index,
+		Entity:      change.Entity,
+		Id:          change.Id,
+		ClientId:    change.ClientId,
+		Field:       field,
+		Reason:      reason,
+		Message:     message,
+		ServerValue: serverValue,
+	})
+}
+
+// changeTime returns when a change was made as an SQL timestamp, capped at
+// the current time
+func (a *applier) changeTime(change ClientChange) (string, error) {
+	if change.ModifiedAt == "" {
+		return a.now, nil
+	}
+
+	d, err := time.Parse(time.RFC3339, change.ModifiedAt)
+	if err != nil {
+		return "", errors.New("modifiedAt must be an RFC 3339 timestamp")
+	}
+	at := d.UTC().Format(model.SqlDateTimeFormat)
+	if at > a.now {
+		return a.now, nil
+	}
+
+	return at, nil
+}
+
+// lastChanged returns the most recent of an entity's field versions and its
+// creation date
+func lastChanged(versions map[string]string, creationDate string) string {
+	latest, _ := model.NormalizeDate(creationDate)
+	for _, version := range versions {
+		if version > latest {
+			latest = version
+		}
+	}
+
+	return latest
+}
+
+// sortedFields returns the field names of a change in a stable order
+func sortedFields(change ClientChange) []string {
+	fields := make([]string, 0, len(change.Fields))
+	for field := range change.Fields {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	return fields
+}
+
+// apply applies a single change, reporting whether any of it was applied.
+// Errors are only returned for failures of the server itself
+func (a *applier) apply(index int, change ClientChange) (bool, error) {
+	if change.Op == "create" && change.ClientId != "" {
+		if _, ok := a.created[change.Entity][change.ClientId]; ok {
+			a.reject(index, change, "", ReasonInvalid, "clientId '"+change.ClientId+"' is used twice in this batch", nil)
+			return false, nil
+		}
+	}
+	at, err := a.changeTime(change)
+	if err != nil {
+		a.reject(index, change, "", ReasonInvalid, err.Error(), nil)
+		return false, nil
+	}
+
+	switch change.Entity {
+	case "todo":
+		switch change.Op {
+		case "create":
+			return a.createTodo(index, change)
+		case "update":
+			return a.updateTodo(index, change, at)
+		case "delete":
+			return a.deleteTodo(index, change, at)
+		}
+	case "list":
+		switch change.Op {
+		case "create":
+			return a.createList(index, change)
+		case "update":
+			return a.updateList(index, change, at)
+		case "delete":
+			return a.deleteList(index, change, at)
+		}
+	case "tag":
+		switch change.Op {
+		case "create":
+			return a.createTag(index, change)
+		case "update":
+			a.reject(index, change, "", ReasonUnsupported, "tags cannot be changed, only created or deleted", nil)
+			return false, nil
+		case "delete":
+			return a.deleteTag(index, change)
+		}
+	default:
+		a.reject(index, change, "", ReasonUnsupported, "unknown entity '"+change.Entity+"'", nil)
+		return false, nil
+	}
+
+	a.reject(index, change, "", ReasonUnsupported, "unknown operation '"+change.Op+"'", nil)
+	return false, nil
+}
+
+// listId decodes a list Id field, which is either a server Id or the
+// ClientId of a list created earlier in the batch
+func (a *applier) listId(raw json.RawMessage) (int, error) {
+	var id int
+	if err := json.Unmarshal(raw, &id); err == nil {
+		if id != 0 && !model.CanViewList(a.user.Id, id) {
+			return 0, errors.New("no records found with list id " + strconv.Itoa(id))
+		}
+		return id, nil
+	}
+
+	var clientId string
+	if err := json.Unmarshal(raw, &clientId); err != nil {
+		return 0, errors.New("listId must be a list Id or the clientId of a new list")
+	}
+	id, ok := a.created["list"][clientId]
+	if !ok {
+		return 0, errors.New("no list created with clientId '" + clientId + "' in this batch")
+	}
+
+	return id, nil
+}
+
+// todoFields are the fields of a todo a client may change
+type todoFields struct {
+	update model.TodoUpdate
+	status *string
+}
+
+// decodeTodoField decodes one field of a todo change into f
+func (a *applier) decodeTodoField(f *todoFields, field string, raw json.RawMessage) error {
+	var err error
+	switch field {
+	case "description":
+		err = json.Unmarshal(raw, &f.update.Description)
+		if err == nil && (f.update.Description == nil || *f.update.Description == "") {
+			err = errors.New("description must not be empty")
+		}
+	case "status":
+		var status string
+		if err = json.Unmarshal(raw, &status); err == nil {
+			if _, err = model.GetStatusByName(status); err == nil {
+				f.status = &status
+			}
+		}
+	case "assigneeId":
+		var assigneeId int
+		if err = json.Unmarshal(raw, &assigneeId); err == nil {
+			// as with the REST handlers, todos are only assigned within the
+			// org unit
+			if assigneeId != 0 && !model.SameOrgUnit(a.user.Id, assigneeId) {
+				return errors.New("no records found with user id " + strconv.Itoa(assigneeId))
+			}
+			f.update.AssigneeId = &assigneeId
+		}
+	case "listId":
+		var listId int
+		if listId, err = a.listId(raw); err == nil {
+			f.update.ListId = &listId
+		}
+	case "dueDate", "remindDate":
+		var date string
+		if err = json.Unmarshal(raw, &date); err == nil {
+			if _, err = model.NormalizeDate(date); err == nil {
+				if field == "dueDate" {
+					f.update.DueDate = &date
+				} else {
+					f.update.RemindDate = &date
+				}
+			}
+		}
+	case "priority":
+		var priority int
+		if err = json.Unmarshal(raw, &priority); err == nil {
+			if err = model.ValidatePriority(priority); err == nil {
+				f.update.Priority = &priority
+			}
+		}
+	case "tags":
+		var tags []string
+		if err = json.Unmarshal(raw, &tags); err == nil {
+			if _, err = model.NormalizeTags(tags); err == nil {
+				f.update.Tags = &tags
+			}
+		}
+	default:
+		err = errors.New("unknown field '" + field + "'")
+	}
+
+	return err
+}
+
+// todoValue returns the current value of a todo field
+func todoValue(todo model.Todo, field string) any {
+	switch field {
+	case "description":
+		return todo.Description
+	case "status":
+		return todo.Status
+	case "assigneeId":
+		return todo.AssigneeId
+	case "listId":
+		return todo.ListId
+	case "dueDate":
+		return todo.DueDate
+	case "remindDate":
+		return todo.RemindDate
+	case "priority":
+		return todo.Priority
+	case "tags":
+		return todo.Tags
+	}
+
+	return nil
+}
+
+// setStatus changes the status of a todo, publishing the change
+func (a *applier) setStatus(before model.Todo, status string, at string) error {
+	if before.Status == status {
+		return nil
+	}
+
+	statusId, err := model.GetStatusByName(status)
+	if err != nil {
+		return err
+	}
+	todo, err := model.UpdateTodoAt(before.Id, statusId, at)
+	if err != nil {
+		return err
+	}
+
+	events.Publish(events.TodoStatusChanged, a.user.UserName, todo, before)
+	return nil
+}
+
+func (a *applier) createTodo(index int, change ClientChange) (bool, error) {
+	var f todoFields
+	for _, field := range sortedFields(change) {
+		if err := a.decodeTodoField(&f, field, change.Fields[field]); err != nil {
+			a.reject(index, change, field, ReasonInvalid, err.Error(), nil)
+			return false, nil
+		}
+	}
+	if f.update.Description == nil {
+		a.reject(index, change, "description", ReasonInvalid, "description must not be empty", nil)
+		return false, nil
+	}
+
+	p := model.ProposedTodo{Description: *f.update.Description}
+	if f.update.AssigneeId != nil {
+		p.AssigneeId = *f.update.AssigneeId
+	}
+	if f.update.ListId != nil {
+		p.ListId = *f.update.ListId
+	}
+	if f.update.DueDate != nil {
+		p.DueDate = *f.update.DueDate
+	}
+	if f.update.RemindDate != nil {
+		p.RemindDate = *f.update.RemindDate
+	}
+	if f.update.Priority != nil {
+		p.Priority = *f.update.Priority
+	}
+	if f.update.Tags != nil {
+		p.Tags = *f.update.Tags
+	}
+
+	todo, err := model.CreateTodo(p, a.user.Id)
+	if err != nil {
+		a.reject(index, change, "", ReasonInvalid, err.Error(), nil)
+		return false, nil
+	}
+	notify.TodoAssigned(todo, a.user)
+	notify.TodoMentions(todo, a.user)
+	events.Publish(events.TodoCreated, a.user.UserName, todo, nil)
+
+	if f.status != nil {
+		if err := a.setStatus(todo, *f.status, model.Now()); err != nil {
+			return false, err
+		}
+	}
+
+	if change.ClientId != "" {
+		a.created["todo"][change.ClientId] = todo.Id
+	}
+	a.result.Created = append(a.result.Created, Mapping{Entity: "todo", ClientId: change.ClientId, Id: todo.Id})
+	return true, nil
+}
+
+// visibleTodo returns the todo a change refers to if the user can see it,
+// rejecting the change otherwise
+func (a *applier) visibleTodo(index int, change ClientChange) (model.Todo, bool, error) {
+	todo, err := model.GetTodoById(change.Id)
+	if err != nil {
+		return model.Todo{}, false, err
+	}
+	if todo.Id == 0 || !model.CanViewTodo(a.user.Id, todo) {
+		a.reject(index, change, "", ReasonNotFound, "no records found with todo id "+strconv.Itoa(change.Id), nil)
+		return model.Todo{}, false, nil
+	}
+
+	return todo, true, nil
+}
+
+func (a *applier) updateTodo(index int, change ClientChange, at string) (bool, error) {
+	before, ok, err := a.visibleTodo(index, change)
+	if err != nil || !ok {
+		return false, err
+	}
+	versions, err := model.GetFieldVersions("todo", before.Id)
+	if err != nil {
+		return false, err
+	}
+	created, _ := model.NormalizeDate(before.CreationDate)
+
+	var f todoFields
+	accepted := 0
+	for _, field := range sortedFields(change) {
+		version, ok := versions[field]
+		if !ok {
+			version = created
+		}
+		if at < version {
+			a.reject(index, change, field, ReasonStale, "changed on the server at "+version, todoValue(before, field))
+			continue
+		}
+		if err := a.decodeTodoField(&f, field, change.Fields[field]); err != nil {
+			a.reject(index, change, field, ReasonInvalid, err.Error(), nil)
+			continue
+		}
+		accepted++
+	}
+	if accepted == 0 {
+		return false, nil
+	}
+
+	current := before
+	if f.update != (model.TodoUpdate{}) {
+		todo, err := model.ModifyTodoAt(before.Id, f.update, at)
+		if err != nil {
+			a.reject(index, change, "", ReasonInvalid, err.Error(), nil)
+			return false, nil
+		}
+		if todo.AssigneeId != before.AssigneeId {
+			notify.TodoAssigned(todo, a.user)
+		}
+		if todo.Description != before.Description {
+			notify.TodoMentions(todo, a.user)
+		}
+		events.Publish(events.TodoUpdated, a.user.UserName, todo, before)
+		current = todo
+	}
+	if f.status != nil {
+		if err := a.setStatus(current, *f.status, at); err != nil {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+func (a *applier) deleteTodo(index int, change ClientChange, at string) (bool, error) {
+	todo, ok, err := a.visibleTodo(index, change)
+	if err != nil || !ok {
+		return false, err
+	}
+	versions, err := model.GetFieldVersions("todo", todo.Id)
+	if err != nil {
+		return false, err
+	}
+	if latest := lastChanged(versions, todo.CreationDate); at < latest {
+		a.reject(index, change, "", ReasonConflict, "changed on the server at "+latest+", after it was deleted", todo)
+		return false, nil
+	}
+
+	if _, err := model.DeleteTodo(todo.Id); err != nil {
+		return false, err
+	}
+	events.Publish(events.TodoDeleted, a.user.UserName, todo, nil)
+	return true, nil
+}
+
+// listName decodes the name field of a list change
+func listName(change ClientChange) (string, error) {
+	var name string
+	if err := json.Unmarshal(change.Fields["name"], &name); err != nil || name == "" {
+		return "", errors.New("list name must not be empty")
+	}
+
+	return name, nil
+}
+
+func (a *applier) createList(index int, change ClientChange) (bool, error) {
+	for _, field := range sortedFields(change) {
+		if field != "name" {
+			a.reject(index, change, field, ReasonInvalid, "unknown field '"+field+"'", nil)
+			return false, nil
+		}
+	}
+	name, err := listName(change)
+	if err != nil {
+		a.reject(index, change, "name", ReasonInvalid, err.Error(), nil)
+		return false, nil
+	}
+
+	list, err := model.CreateList(model.ProposedList{Name: name}, a.user.Id)
+	if err != nil {
+		a.reject(index, change, "", ReasonInvalid, err.Error(), nil)
+		return false, nil
+	}
+	events.Publish(events.ListCreated, a.user.UserName, list, nil)
+
+	if change.ClientId != "" {
+		a.created["list"][change.ClientId] = list.Id
+	}
+	a.result.Created = append(a.result.Created, Mapping{Entity: "list", ClientId: change.ClientId, Id: list.Id})
+	return true, nil
+}
+
+// ownedList returns the list a change refers to if the user owns it,
+// rejecting the change otherwise
+func (a *applier) ownedList(index int, change ClientChange) (model.List, bool, error) {
+	list, err := model.GetListById(change.Id)
+	if err != nil {
+		return model.List{}, false, err
+	}
+	if list.Id == 0 || !model.CanViewList(a.user.Id, list.Id) {
+		a.reject(index, change, "", ReasonNotFound, "no records found with list id "+strconv.Itoa(change.Id), nil)
+		return model.List{}, false, nil
+	}
+	if list.OwnerId != a.user.Id {
+		a.reject(index, change, "", ReasonForbidden, "Only the owner of a list can change it", nil)
+		return model.List{}, false, nil
+	}
+
+	return list, true, nil
+}
+
+func (a *applier) updateList(index int, change ClientChange, at string) (bool, error) {
+	before, ok, err := a.ownedList(index, change)
+	if err != nil || !ok {
+		return false, err
+	}
+	versions, err := model.GetFieldVersions("list", before.Id)
+	if err != nil {
+		return false, err
+	}
+
+	applied := false
+	for _, field := range sortedFields(change) {
+		if field != "name" {
+			a.reject(index, change, field, ReasonInvalid, "unknown field '"+field+"'", nil)
+			continue
+		}
+		version, ok := versions[field]
+		if !ok {
+			version, _ = model.NormalizeDate(before.CreationDate)
+		}
+		if at < version {
+			a.reject(index, change, field, ReasonStale, "changed on the server at "+version, before.Name)
+			continue
+		}
+		name, err := listName(change)
+		if err != nil {
+			a.reject(index, change, field, ReasonInvalid, err.Error(), nil)
+			continue
+		}
+
+		list, err := model.RenameListAt(before.Id, name, at)
+		if err != nil {
+			return false, err
+		}
+		events.Publish(events.ListUpdated, a.user.UserName, list, before)
+		applied = true
+	}
+
+	return applied, nil
+}
+
+func (a *applier) deleteList(index int, change ClientChange, at string) (bool, error) {
+	list, ok, err := a.ownedList(index, change)
+	if err != nil || !ok {
+		return false, err
+	}
+	versions, err := model.GetFieldVersions("list", list.Id)
+	if err != nil {
+		return false, err
+	}
+	if latest := lastChanged(versions, list.CreationDate); at < latest {
+		a.reject(index, change, "", ReasonConflict, "changed on the server at "+latest+", after it was deleted", list)
+		return false, nil
+	}
+
+	if _, err := model.DeleteList(list.Id); err != nil {
+		return false, err
+	}
+	events.Publish(events.ListDeleted, a.user.UserName, list, nil)
+	return true, nil
+}
+
+func (a *applier) createTag(index int, change ClientChange) (bool, error) {
+	var name string
+	if err := json.Unmarshal(change.Fields["name"], &name); err != nil {
+		a.reject(index, change, "name", ReasonInvalid, "tag name must be a string", nil)
+		return false, nil
+	}
+	names, err := model.NormalizeTags([]string{name})
+	if err != nil {
+		a.reject(index, change, "name", ReasonInvalid, err.Error(), nil)
+		return false, nil
+	}
+
+	tag, err := model.CreateTag(a.user.OrgUnitId, names[0])
+	if err != nil {
+		return false, err
+	}
+
+	if change.ClientId != "" {
+		a.created["tag"][change.ClientId] = tag.Id
+	}
+	a.result.Created = append(a.result.Created, Mapping{Entity: "tag", ClientId: change.ClientId, Id: tag.Id})
+	return true, nil
+}
+
+func (a *applier) deleteTag(index int, change ClientChange) (bool, error) {
+	tag, err := model.GetTagById(change.Id)
+	if err != nil {
+		return false, err
+	}
+	if tag.Id == 0 || tag.OrgUnitId != a.user.OrgUnitId {
+		a.reject(index, change, "", ReasonNotFound, "no records found with tag id "+strconv.Itoa(change.Id), nil)
+		return false, nil
+	}
+	if model.TagUsedOutside(tag.Id, a.user.Id) {
+		a.reject(index, change, "", ReasonForbidden, "tag is in use on todos you cannot see", nil)
+		return false, nil
+	}
+
+	if _, err := model.DeleteTag(tag.Id); err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,223 @@
+// Package delta implements delta sync for offline-capable clients: it reports
+// what changed since a sync token and applies batches of changes made offline
+package delta
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/greeneg/todoer/model"
+)
+
+// ErrInvalidToken is returned for sync tokens this server did not hand out
+var ErrInvalidToken = errors.New("invalid sync token")
+
+// Records holds created or updated entities
+type Records struct {
+	Todos []model.Todo `json:"todos"`
+	Lists []model.List `json:"lists"`
+	Tags  []model.Tag  `json:"tags"`
+}
+
+// Tombstones holds the Ids of entities that were deleted or that the user can
+// no longer see
+type Tombstones struct {
+	Todos []int `json:"todos"`
+	Lists []int `json:"lists"`
+	Tags  []int `json:"tags"`
+}
+
+// Delta is the answer to a sync request. Full deltas contain everything the
+// user can see, all reported as created, and the client should discard what
+// it has. Updated records may be new to the client when a list was just
+// shared with the user
+type Delta struct {
+	Token   string     `json:"token"`
+	Full    bool       `json:"full"`
+	Created Records    `json:"created"`
+	Updated Records    `json:"updated"`
+	Deleted Tombstones `json:"deleted"`
+}
+
+type entityKey struct {
+	entityType string
+	id         int
+}
+
+// entityChanges collects the change log entries of one entity
+type entityChanges struct {
+	createSeq int
+	upserted  bool
+	deleted   *model.Change
+	revokes   []model.Change
+}
+
+func newRecords() Records {
+	return Records{Todos: make([]model.Todo, 0), Lists: make([]model.List, 0), Tags: make([]model.Tag, 0)}
+}
+
+func newTombstones() Tombstones {
+	return Tombstones{Todos: make([]int, 0), Lists: make([]int, 0), Tags: make([]int, 0)}
+}
+
+func (t *Tombstones) add(entityType string, id int) {
+	switch entityType {
+	case "todo":
+		t.Todos = append(t.Todos, id)
+	case "list":
+		t.Lists = append(t.Lists, id)
+	case "tag":
+		t.Tags = append(t.Tags, id)
+	}
+}
+
+// ParseToken converts a sync token into a change sequence number. The empty
+// token asks for a full sync
+func ParseToken(token string) (int, error) {
+	if token == "" {
+		return 0, nil
+	}
+
+	seq, err := strconv.Atoi(token)
+	if err != nil || seq < 0 {
+		return 0, ErrInvalidToken
+	}
+
+	return seq, nil
+}
+
+// full returns everything the user can see
+func full(user model.User) (Delta, error) {
+	latest, err := model.GetLatestChangeSeq()
+	if err != nil {
+		return Delta{}, err
+	}
+
+	d := Delta{Token: strconv.Itoa(latest), Full: true, Created: newRecords(), Updated: newRecords(),
+		Deleted: newTombstones()}
+	if d.Created.Todos, err = model.GetTodosForUser(user.Id); err != nil {
+		return Delta{}, err
+	}
+	if d.Created.Lists, err = model.GetListsForUser(user.Id); err != nil {
+		return Delta{}, err
+	}
+	if d.Created.Tags, err = model.GetTagsForUser(user.Id); err != nil {
+		return Delta{}, err
+	}
+
+	return d, nil
+}
+
+// Changes returns what changed for the user since the given sync token
+func Changes(user model.User, token string) (Delta, error) {
+	since, err := ParseToken(token)
+	if err != nil {
+		return Delta{}, err
+	}
+	if since == 0 {
+		return full(user)
+	}
+
+	changes, latest, err := model.GetChangesSince(since)
+	if err != nil {
+		return Delta{}, err
+	}
+	if since > latest {
+		return Delta{}, ErrInvalidToken
+	}
+
+	keys := make([]entityKey, 0)
+	byEntity := make(map[entityKey]*entityChanges)
+	for i, change := range changes {
+		key := entityKey{change.EntityType, change.EntityId}
+		ec, ok := byEntity[key]
+		if !ok {
+			ec = &entityChanges{}
+			byEntity[key] = ec
+			keys = append(keys, key)
+		}
+		switch change.Operation {
+		case "create":
+			ec.createSeq = change.Seq
+		case "upsert":
+			ec.upserted = true
+		case "delete":
+			ec.deleted = &changes[i]
+		case "revoke":
+			ec.revokes = append(ec.revokes, change)
+		}
+	}
+
+	d := Delta{Token: strconv.Itoa(latest), Created: newRecords(), Updated: newRecords(), Deleted: newTombstones()}
+	for _, key := range keys {
+		ec := byEntity[key]
+		if ec.deleted != nil {
+			if ec.deleted.Concerns(user.Id) {
+				d.Deleted.add(key.entityType, key.id)
+			}
+			continue
+		}
+
+		visible, err := d.addRecord(user, key, ec)
+		if err != nil {
+			return Delta{}, err
+		}
+		if !visible {
+			for _, revoke := range ec.revokes {
+				if revoke.Concerns(user.Id) {
+					d.Deleted.add(key.entityType, key.id)
+					break
+				}
+			}
+		}
+	}
+
+	return d, nil
+}
+
+// addRecord adds the current state of an entity to the delta if the user can
+// see it, reporting whether they can
+func (d *Delta) addRecord(user model.User, key entityKey, ec *entityChanges) (bool, error) {
+	records := &d.Updated
+	if ec.createSeq != 0 {
+		records = &d.Created
+	}
+
+	switch key.entityType {
+	case "todo":
+		todo, err := model.GetTodoById(key.id)
+		if err != nil {
+			return false, err
+		}
+		if todo.Id == 0 || !model.CanViewTodo(user.Id, todo) {
+			return false, nil
+		}
+		if ec.createSeq != 0 || ec.upserted {
+			records.Todos = append(records.Todos, todo)
+		}
+	case "list":
+		list, err := model.GetListById(key.id)
+		if err != nil {
+			return false, err
+		}
+		if list.Id == 0 || !model.CanViewList(user.Id, list.Id) {
+			return false, nil
+		}
+		if ec.createSeq != 0 || ec.upserted {
+			records.Lists = append(records.Lists, list)
+		}
+	case "tag":
+		tag, err := model.GetTagById(key.id)
+		if err != nil {
+			return false, err
+		}
+		if tag.Id == 0 || !model.CanViewTag(user.Id, tag.Id) {
+			return false, nil
+		}
+		if ec.createSeq != 0 || ec.upserted {
+			records.Tags = append(records.Tags, tag)
+		}
+	}
+
+	return true, nil
+}
@@ -0,0 +1,117 @@
+// Package eventlog keeps a bounded, sequenced history of published events
+// and fans new ones out to live listeners such as the SSE stream
+package eventlog
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/greeneg/todoer/events"
+)
+
+// Entry is an event together with its position in the log
+type Entry struct {
+	Seq   uint64
+	Event events.Event
+}
+
+// how many entries a listener may fall behind before it is dropped
+const listenerBuffer = 64
+
+var (
+	mtx       sync.Mutex
+	epoch     = strconv.FormatInt(time.Now().Unix(), 36)
+	size      int
+	entries   []Entry
+	lastSeq   uint64
+	listeners = make(map[chan Entry]struct{})
+)
+
+// Init sets how many events are retained and starts recording events
+func Init(logSize int) {
+	size = logSize
+	if size <= 0 {
+		size = 1000
+	}
+	events.Subscribe(record)
+}
+
+func record(e events.Event) {
+	mtx.Lock()
+	defer mtx.Unlock()
+
+	lastSeq++
+	entry := Entry{Seq: lastSeq, Event: e}
+	entries = append(entries, entry)
+	if len(entries) > size {
+		entries = entries[len(entries)-size:]
+	}
+
+	for ch := range listeners {
+		select {
+		case ch <- entry:
+		default:
+			// slow consumer: drop it rather than block the publisher. It can
+			// reconnect and resume from the log with its last seen sequence
+			delete(listeners, ch)
+			close(ch)
+		}
+	}
+}
+
+// Id Returns the client-facing identifier of an entry. It is prefixed with
+// the epoch of this run so ids from before a restart are recognised
+func (e Entry) Id() string {
+	return epoch + "-" + strconv.FormatUint(e.Seq, 10)
+}
+
+// parseId Returns the sequence number of an id issued by this run
+func parseId(id string) (uint64, bool) {
+	prefix, seq, found := strings.Cut(id, "-")
+	if !found || prefix != epoch {
+		return 0, false
+	}
+	n, err := strconv.ParseUint(seq, 10, 64)
+	return n, err == nil
+}
+
+// Subscribe Returns a channel that receives every entry recorded from now
+// on. When lastId is set, the retained entries after it are returned as the
+// backlog, and complete is false if some of them have already been
+// discarded from the log or lastId is from a previous run of the service
+func Subscribe(lastId string) (backlog []Entry, ch chan Entry, complete bool) {
+	mtx.Lock()
+	defer mtx.Unlock()
+
+	complete = true
+	if lastId != "" {
+		since, ok := parseId(lastId)
+		if !ok || since > lastSeq || (len(entries) > 0 && entries[0].Seq > since+1) {
+			complete = false
+		}
+		if ok {
+			for _, entry := range entries {
+				if entry.Seq > since {
+					backlog = append(backlog, entry)
+				}
+			}
+		}
+	}
+
+	ch = make(chan Entry, listenerBuffer)
+	listeners[ch] = struct{}{}
+	return backlog, ch, complete
+}
+
+// Unsubscribe stops delivery to a channel returned by Subscribe
+func Unsubscribe(ch chan Entry) {
+	mtx.Lock()
+	defer mtx.Unlock()
+
+	if _, ok := listeners[ch]; ok {
+		delete(listeners, ch)
+		close(ch)
+	}
+}
@@ -0,0 +1,92 @@
+// Package events is the in-process publish point for changes to todos and
+// users. Other parts of the service subscribe to it to fan changes out
+package events
+
+import (
+	"sync"
+	"time"
+
+	"github.com/rs/xid"
+)
+
+const (
+	TodoCreated       = "todo.created"
+	TodoUpdated       = "todo.updated"
+	TodoStatusChanged = "todo.status_changed"
+	TodoDeleted       = "todo.deleted"
+	ListCreated       = "list.created"
+	ListUpdated       = "list.updated"
+	ListDeleted       = "list.deleted"
+	UserCreated       = "user.created"
+	UserDeleted       = "user.deleted"
+	UserLocked        = "user.locked"
+	UserUnlocked      = "user.unlocked"
+)
+
+// Types lists every event type that can be published
+var Types = []string{
+	TodoCreated,
+	TodoUpdated,
+	TodoStatusChanged,
+	TodoDeleted,
+	ListCreated,
+	ListUpdated,
+	ListDeleted,
+	UserCreated,
+	UserDeleted,
+	UserLocked,
+	UserUnlocked,
+}
+
+// Event describes a single change. Data holds the resource after the change
+// (or before it, for deletions) and Previous, when set, its prior state
+type Event struct {
+	Id        string `json:"id"`
+	Type      string `json:"event"`
+	Timestamp string `json:"timestamp"`
+	Actor     string `json:"actor"`
+	Data      any    `json:"data"`
+	Previous  any    `json:"previous,omitempty"`
+}
+
+var (
+	mtx         sync.RWMutex
+	subscribers []func(Event)
+)
+
+// IsKnownType reports whether t is a valid event type
+func IsKnownType(t string) bool {
+	for _, known := range Types {
+		if t == known {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Subscribe registers f to be called for every published event. Subscribers
+// are called synchronously and must not block
+func Subscribe(f func(Event)) {
+	mtx.Lock()
+	subscribers = append(subscribers, f)
+	mtx.Unlock()
+}
+
+// Publish builds an event and hands it to every subscriber
+func Publish(eventType string, actor string, data any, previous any) {
+	e := Event{
+		Id:        xid.New().String(),
+		Type:      eventType,
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+		Actor:     actor,
+		Data:      data,
+		Previous:  previous,
+	}
+
+	mtx.RLock()
+	defer mtx.RUnlock()
+	for _, f := range subscribers {
+		f(e)
+	}
+}
@@ -1,5 +1,8 @@
 package globals
 
-var Secret = []byte("secret")
-
 const UserKey = "user"
+
+// ScopesKey holds the scopes of the access token or client certificate a
+// request was authenticated with. It is unset for password and session
+// logins, which may do anything
+const ScopesKey = "scopes"
@@ -1,10 +1,165 @@
 package globals
 
 type Config struct {
-	TcpPort    int    `json:"tcpPort"`
-	TLSTcpPort int    `json:"tlsTcpPort"`
-	TLSPemFile string `json:"tlsPemFile"`
-	TLSKeyFile string `json:"tlsKeyFile"`
-	DbPath     string `json:"dbPath"`
-	UseTLS     bool   `json:"useTls"`
+	TcpPort      int    `json:"tcpPort"`
+	TLSTcpPort   int    `json:"tlsTcpPort"`
+	TLSPemFile   string `json:"tlsPemFile"`
+	TLSKeyFile   string `json:"tlsKeyFile"`
+	DbPath       string `json:"dbPath"`
+	UseTLS       bool   `json:"useTls"`
+	EventLogSize int    `json:"eventLogSize"`
+	// client certificates are asked for when TLSClientAuth is "optional" or
+	// "require", and checked against the CAs in TLSClientCaFile.
+	// TLSClientUserFrom names the certificate field holding the user name:
+	// "cn" (the default), "email" or "dns". TLSClientScopes limits what
+	// certificate logins may do, like the scopes of an access token; it
+	// defaults to todo:write and may not include admin
+	TLSClientCaFile   string   `json:"tlsClientCaFile"`
+	TLSClientAuth     string   `json:"tlsClientAuth"`
+	TLSClientUserFrom string   `json:"tlsClientUserFrom"`
+	TLSClientScopes   []string `json:"tlsClientScopes"`
+	// origins allowed to open the collaboration WebSocket from a browser. When
+	// empty only same-origin requests are accepted
+	WebSocketOrigins []string            `json:"webSocketOrigins"`
+	Smtp             SmtpConfig          `json:"smtp"`
+	Notifications    NotificationConfig  `json:"notifications"`
+	Webhooks         WebhookConfig       `json:"webhooks"`
+	Passwords        PasswordConfig      `json:"passwords"`
+	Sessions         SessionConfig       `json:"sessions"`
+	TwoFactor        TwoFactorConfig     `json:"twoFactor"`
+	Lockout          LockoutConfig       `json:"lockout"`
+	Oidc             OidcConfig          `json:"oidc"`
+	Ldap             LdapConfig          `json:"ldap"`
+	PasswordReset    PasswordResetConfig `json:"passwordReset"`
+	Invitations      InvitationConfig    `json:"invitations"`
+}
+
+type SmtpConfig struct {
+	Enabled            bool   `json:"enabled"`
+	Host               string `json:"host"`
+	Port               int    `json:"port"`
+	Username           string `json:"username"`
+	Password           string `json:"password"`
+	From               string `json:"from"`
+	TLSMode            string `json:"tlsMode"` // one of "none", "starttls" or "tls"
+	InsecureSkipVerify bool   `json:"insecureSkipVerify"`
+}
+
+type NotificationConfig struct {
+	BaseUrl               string `json:"baseUrl"`
+	PollIntervalSeconds   int    `json:"pollIntervalSeconds"`
+	MaxAttempts           int    `json:"maxAttempts"`
+	RetryBackoffSeconds   int    `json:"retryBackoffSeconds"`
+	DueSoonWindowHours    int    `json:"dueSoonWindowHours"`
+	DigestIntervalMinutes int    `json:"digestIntervalMinutes"`
+}
+
+type WebhookConfig struct {
+	PollIntervalSeconds int `json:"pollIntervalSeconds"`
+	MaxAttempts         int `json:"maxAttempts"`
+	RetryBackoffSeconds int `json:"retryBackoffSeconds"`
+	TimeoutSeconds      int `json:"timeoutSeconds"`
+}
+
+// PasswordConfig chooses how passwords are hashed, and which new passwords
+// are accepted. Zero values take the defaults of the passhash and passpolicy
+// packages
+type PasswordConfig struct {
+	Algorithm   string `json:"algorithm"` // one of "argon2id" or "bcrypt"
+	Memory      uint32 `json:"memory"`    // Argon2id memory in KiB
+	Iterations  uint32 `json:"iterations"`
+	Parallelism uint8  `json:"parallelism"`
+	SaltLength  uint32 `json:"saltLength"`
+	KeyLength   uint32 `json:"keyLength"`
+	BcryptCost  int    `json:"bcryptCost"`
+	// password policy
+	MinLength    int    `json:"minLength"`    // in characters
+	MinClasses   int    `json:"minClasses"`   // of lower case, upper case, digits and symbols
+	DenyListFile string `json:"denyListFile"` // more passwords to refuse, one per line, besides the bundled list
+	History      int    `json:"history"`      // how many of the last passwords may not be used again
+	MaxAgeDays   int    `json:"maxAgeDays"`   // days after which a password must be changed; 0 never
+}
+
+// SessionConfig holds the secrets that sign session cookies and the session
+// lifetimes. Secret signs new cookies; cookies signed with one of
+// PreviousSecrets are still accepted, so secrets can be rotated without
+// signing everyone out
+type SessionConfig struct {
+	Secret               string   `json:"secret"`
+	PreviousSecrets      []string `json:"previousSecrets"`
+	AbsoluteTimeoutHours int      `json:"absoluteTimeoutHours"` // lifetime of a session, however active
+	IdleTimeoutMinutes   int      `json:"idleTimeoutMinutes"`   // unused sessions expire after this long
+	SameSite             string   `json:"sameSite"`             // one of "lax", "strict" or "none"
+}
+
+// TwoFactorConfig sets how todoer names itself in authenticator apps
+type TwoFactorConfig struct {
+	Issuer string `json:"issuer"` // defaults to "todoer"
+}
+
+// LockoutConfig slows down and stops password guessing. Failed logins are
+// counted per user name and per client address over the last WindowMinutes.
+// Zero values take the defaults of the lockout package
+type LockoutConfig struct {
+	MaxFailures     int `json:"maxFailures"`     // failures before the account is locked; below zero never locks
+	IpMaxFailures   int `json:"ipMaxFailures"`   // failures before a client address is refused until the window passes
+	DelayAfter      int `json:"delayAfter"`      // failures before each further attempt is delayed, doubling every time
+	MaxDelaySeconds int `json:"maxDelaySeconds"` // longest delay
+	WindowMinutes   int `json:"windowMinutes"`
+}
+
+// OidcConfig lets users log in through an OpenID Connect identity provider.
+// Provider accounts are linked to users by their subject; unknown users are
+// only created, named by the claim named by UsernameClaim, when
+// AutoCreateUsers is set
+type OidcConfig struct {
+	Enabled         bool     `json:"enabled"`
+	Issuer          string   `json:"issuer"` // must match the issuer of the discovery document exactly
+	ClientId        string   `json:"clientId"`
+	ClientSecret    string   `json:"clientSecret"`    // left empty for public clients, which rely on PKCE alone
+	RedirectUrl     string   `json:"redirectUrl"`     // the URL of /api/v1/oidc/callback as registered with the provider
+	Scopes          []string `json:"scopes"`          // defaults to openid, profile and email
+	UsernameClaim   string   `json:"usernameClaim"`   // defaults to preferred_username
+	AutoCreateUsers bool     `json:"autoCreateUsers"` // create users on their first login
+	OrgUnitId       int      `json:"orgUnitId"`       // org unit of created users; defaults to the default org unit
+	PostLoginUrl    string   `json:"postLoginUrl"`    // where browsers go after logging in; when empty the user is returned as JSON
+}
+
+// LdapConfig lets users log in with their directory password. A user is
+// looked up by searching BaseDn with Filter, in which %s stands for the user
+// name, and their password is checked by binding as them. GroupRoles grants
+// todoer roles to the members of directory groups, named by their DN
+type LdapConfig struct {
+	Enabled            bool              `json:"enabled"`
+	Url                string            `json:"url"`                // ldap://host:389 or ldaps://host:636
+	StartTLS           bool              `json:"startTls"`           // upgrade ldap:// connections to TLS
+	CaFile             string            `json:"caFile"`             // CA bundle the server is checked with; system roots when empty
+	InsecureSkipVerify bool              `json:"insecureSkipVerify"` // do not check the server certificate
+	BindDn             string            `json:"bindDn"`             // account users are looked up with; anonymous when empty
+	BindPassword       string            `json:"bindPassword"`
+	BaseDn             string            `json:"baseDn"`
+	Filter             string            `json:"filter"`            // defaults to (uid=%s)
+	FullNameAttribute  string            `json:"fullNameAttribute"` // defaults to cn
+	EmailAttribute     string            `json:"emailAttribute"`    // defaults to mail
+	GroupAttribute     string            `json:"groupAttribute"`    // attribute of the user listing their groups; defaults to memberOf
+	GroupBaseDn        string            `json:"groupBaseDn"`       // with GroupFilter, groups are searched for instead; defaults to BaseDn
+	GroupFilter        string            `json:"groupFilter"`       // for instance (member=%s), where %s stands for the user DN
+	GroupRoles         map[string]string `json:"groupRoles"`        // group DN to role name
+	AutoCreateUsers    bool              `json:"autoCreateUsers"`   // create users on their first login
+	OrgUnitId          int               `json:"orgUnitId"`         // org unit of created users; defaults to the default org unit
+	TimeoutSeconds     int               `json:"timeoutSeconds"`
+}
+
+// PasswordResetConfig sets how long mailed password reset tokens last and
+// where they lead
+type PasswordResetConfig struct {
+	TokenMinutes int    `json:"tokenMinutes"` // defaults to 60
+	Url          string `json:"url"`          // page taking the token as ?token=; the mail only carries the token when empty
+}
+
+// InvitationConfig sets how long invitations last and where the mailed ones
+// lead
+type InvitationConfig struct {
+	ExpiryDays int    `json:"expiryDays"` // defaults to 7
+	Url        string `json:"url"`        // page taking the token as ?token=; the mail only carries the token when empty
 }
@@ -0,0 +1,67 @@
+package helpers
+
+import (
+	"log"
+
+	"github.com/greeneg/todoer/model"
+	"github.com/greeneg/todoer/passhash"
+	"github.com/greeneg/todoer/passpolicy"
+)
+
+// Authenticator checks user names and passwords against one place accounts
+// are kept. Authenticate reports false for users it does not know, and for
+// locked users
+type Authenticator interface {
+	Authenticate(username string, password string) bool
+}
+
+// authenticators are tried in order by CheckUserPass
+var authenticators = []Authenticator{SqliteAuthenticator{}}
+
+// SetAuthenticators chooses the authenticators CheckUserPass tries, in order
+func SetAuthenticators(a ...Authenticator) {
+	authenticators = a
+}
+
+// SqliteAuthenticator checks the password hashes kept in the Users table
+type SqliteAuthenticator struct{}
+
+func (SqliteAuthenticator) Authenticate(username string, password string) bool {
+	user, err := model.GetUserByUserName(username)
+	if err != nil {
+		return false
+	}
+	if user.UserName == "" {
+		// take as long as for a real user, so user names cannot be probed
+		passhash.VerifyNone(password)
+		return false
+	}
+
+	status := CheckIsNotLocked(user)
+	if !status {
+		return false
+	}
+
+	match, outdated := passhash.Verify(password, user.PasswordHash)
+	if match && outdated {
+		// upgrade legacy or weaker hashes now that we know the password
+		log.Println("INFO: Rehashing outdated password hash of user '" + username + "'")
+		if hash, err := passhash.Hash(password); err == nil {
+			model.UpgradePasswordHash(username, user.PasswordHash, hash)
+		}
+	}
+
+	return match
+}
+
+// PasswordExpired reports whether a user logging in with their password must
+// change it first, as it is older than the maximum password age. Passwords
+// checked by another authenticator, such as a directory, never expire here
+func PasswordExpired(user model.User, password string) bool {
+	if !passpolicy.Expired(user) {
+		return false
+	}
+	match, _ := passhash.Verify(password, user.PasswordHash)
+
+	return match
+}
@@ -1,37 +1,77 @@
 package helpers
 
 import (
-	"crypto/sha512"
 	"encoding/hex"
 	"log"
 	"strings"
+	"time"
 
 	"github.com/greeneg/todoer/model"
+	"github.com/greeneg/todoer/passhash"
+	"github.com/greeneg/todoer/totp"
 )
 
+// RecoveryCodeBytes is how many random bytes make up a recovery code
+const RecoveryCodeBytes = 10
+
 func CheckIsNotLocked(u model.User) bool {
 	return u.Status != "locked"
 }
 
+// CheckUserPass checks a user name and password with each of the
+// authenticators in turn, until one of them accepts it
 func CheckUserPass(username, password string) bool {
-	user, err := model.GetUserByUserName(username)
-	if err != nil {
-		return false
+	for _, authenticator := range authenticators {
+		if authenticator.Authenticate(username, password) {
+			return true
+		}
 	}
 
-	status := CheckIsNotLocked(user)
-	if !status {
+	return false
+}
+
+// NormalizeRecoveryCode strips the spaces and dashes users may type into a
+// recovery code
+func NormalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
+
+// CheckSecondFactor checks the one-time password or one of the unused
+// recovery codes of a user with two-factor authentication. Each code is only
+// accepted once
+func CheckSecondFactor(user model.User, code string) bool {
+	twoFactor, err := model.GetTwoFactor(user.Id)
+	if err != nil || !twoFactor.Enabled || strings.TrimSpace(code) == "" {
 		return false
 	}
 
-	// get the password hash from the user so we can compare it
-	pwHash := user.PasswordHash
+	if step, ok := totp.Validate(twoFactor.Secret, code, time.Now(), twoFactor.LastUsedStep); ok {
+		used, err := model.UseTotpStep(user.Id, step)
+		return err == nil && used
+	}
 
-	// now calculate the sha512 of the password and see if it matches
-	sha := sha512.Sum512([]byte(password))
-	newPwHash := hex.EncodeToString(sha[:])
+	// recovery codes are hashed like passwords, so only codes of the right
+	// shape are worth checking against them
+	code = NormalizeRecoveryCode(code)
+	if raw, err := hex.DecodeString(code); err != nil || len(raw) != RecoveryCodeBytes {
+		return false
+	}
+	recoveryCodes, err := model.GetUnusedRecoveryCodes(user.Id)
+	if err != nil {
+		return false
+	}
+	for _, recoveryCode := range recoveryCodes {
+		if match, _ := passhash.Verify(code, recoveryCode.CodeHash); !match {
+			continue
+		}
+		used, err := model.UseRecoveryCode(recoveryCode.Id)
+		if err == nil && used {
+			log.Println("WARN: User '" + user.UserName + "' used a recovery code")
+		}
+		return err == nil && used
+	}
 
-	return pwHash == newPwHash // returns boolean based on equality
+	return false
 }
 
 func EmptyUserPass(username, password string) bool {
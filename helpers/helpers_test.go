@@ -0,0 +1,114 @@
+package helpers
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/greeneg/todoer/model"
+	"github.com/greeneg/todoer/passhash"
+	"github.com/greeneg/todoer/totp"
+)
+
+// openDb points the model at a new database with the schema, which comes
+// with the user greeneg
+func openDb(t *testing.T) {
+	t.Helper()
+	schema, err := os.ReadFile(filepath.Join("..", "db", "schema.sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := sql.Open("sqlite3", "file:"+filepath.Join(t.TempDir(), "todoer.db")+"?_foreign_keys=on")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(string(schema)); err != nil {
+		t.Fatal(err)
+	}
+	model.DB = db
+	t.Cleanup(func() { db.Close() })
+}
+
+// enableTwoFactor turns on two-factor authentication for greeneg with the
+// recovery codes, returning the user and their secret
+func enableTwoFactor(t *testing.T, recoveryCodes ...string) (model.User, string) {
+	t.Helper()
+	openDb(t)
+	user, err := model.GetUserByUserName("greeneg")
+	if err != nil || user.Id == 0 {
+		t.Fatal("no user greeneg in the schema", err)
+	}
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := model.StartTwoFactor(user.Id, secret); err != nil {
+		t.Fatal(err)
+	}
+	hashes := make([]string, 0, len(recoveryCodes))
+	for _, code := range recoveryCodes {
+		hash, err := passhash.Hash(code)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hashes = append(hashes, hash)
+	}
+	if err := model.ConfirmTwoFactor(user.Id, 0, hashes); err != nil {
+		t.Fatal(err)
+	}
+
+	return user, secret
+}
+
+func TestCheckSecondFactorOneTimePassword(t *testing.T) {
+	user, secret := enableTwoFactor(t)
+	code, err := totp.Code(secret, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !CheckSecondFactor(user, code) {
+		t.Fatal("the current one-time password was refused")
+	}
+	if CheckSecondFactor(user, code) {
+		t.Error("a one-time password was accepted twice")
+	}
+}
+
+func TestCheckSecondFactorRecoveryCodes(t *testing.T) {
+	user, _ := enableTwoFactor(t, "0123456789abcdef0123", "fedcba9876543210fedc")
+
+	// codes are shown grouped, and may be typed in upper case
+	if !CheckSecondFactor(user, "01234-56789-ABCDE-F0123") {
+		t.Fatal("an unused recovery code was refused")
+	}
+	if CheckSecondFactor(user, "0123456789abcdef0123") {
+		t.Error("a recovery code was accepted twice")
+	}
+	if count, err := model.CountRecoveryCodes(user.Id); err != nil || count != 1 {
+		t.Errorf("%d recovery codes are left, want 1", count)
+	}
+
+	if CheckSecondFactor(user, "00000000000000000000") {
+		t.Error("an unknown recovery code was accepted")
+	}
+	if !CheckSecondFactor(user, "fedcba9876543210fedc") {
+		t.Error("the other recovery code was refused")
+	}
+}
+
+func TestCheckSecondFactorWithoutTwoFactor(t *testing.T) {
+	openDb(t)
+	user, err := model.GetUserByUserName("greeneg")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if CheckSecondFactor(user, "123456") {
+		t.Error("a second factor was accepted for a user without two-factor authentication")
+	}
+}
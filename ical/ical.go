@@ -0,0 +1,273 @@
+// Package ical reads and writes iCalendar (RFC 5545) data and maps VTODO
+// components to and from todos
+package ical
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"sort"
+	"strings"
+)
+
+// the longest a content line may be, in octets, before it is folded
+const maxLineLength = 75
+
+// Property is a content line of a component
+type Property struct {
+	Name   string
+	Params map[string]string
+	Value  string
+}
+
+// Component is a calendar component such as VCALENDAR or VTODO
+type Component struct {
+	Name       string
+	Properties []Property
+	Components []Component
+}
+
+// Get returns the first property with the given name
+func (c Component) Get(name string) (Property, bool) {
+	for _, p := range c.Properties {
+		if p.Name == name {
+			return p, true
+		}
+	}
+
+	return Property{}, false
+}
+
+// Value returns the value of the first property with the given name, or ""
+func (c Component) Value(name string) string {
+	p, _ := c.Get(name)
+	return p.Value
+}
+
+// Add appends a property
+func (c *Component) Add(name string, value string, params map[string]string) {
+	c.Properties = append(c.Properties, Property{Name: name, Params: params, Value: value})
+}
+
+// AddText appends a property with a TEXT value, escaping it
+func (c *Component) AddText(name string, value string) {
+	c.Add(name, EscapeText(value), nil)
+}
+
+// Children returns the sub-components with the given name
+func (c Component) Children(name string) []Component {
+	children := make([]Component, 0)
+	for _, child := range c.Components {
+		if child.Name == name {
+			children = append(children, child)
+		}
+	}
+
+	return children
+}
+
+// EscapeText escapes a TEXT value
+func EscapeText(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`).Replace(s)
+}
+
+// UnescapeText reverses EscapeText
+func UnescapeText(s string) string {
+	var b strings.Builder
+	escaped := false
+	for _, r := range s {
+		if escaped {
+			if r == 'n' || r == 'N' {
+				b.WriteRune('\n')
+			} else {
+				b.WriteRune(r)
+			}
+			escaped = false
+			continue
+		}
+		if r == '\\' {
+			escaped = true
+			continue
+		}
+		b.WriteRune(r)
+	}
+
+	return b.String()
+}
+
+// SplitText splits a multi-valued TEXT property, such as CATEGORIES, on
+// unescaped commas and unescapes the values
+func SplitText(s string) []string {
+	values := make([]string, 0)
+	start := 0
+	escaped := false
+	for i, r := range s {
+		switch {
+		case escaped:
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == ',':
+			values = append(values, UnescapeText(s[start:i]))
+			start = i + 1
+		}
+	}
+
+	return append(values, UnescapeText(s[start:]))
+}
+
+// fold writes a content line, folding it so no line is longer than
+// maxLineLength octets and no UTF-8 sequence is split
+func fold(w *bufio.Writer, line string) {
+	limit := maxLineLength
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && line[cut]&0xC0 == 0x80 {
+			cut--
+		}
+		w.WriteString(line[:cut])
+		w.WriteString("\r\n ")
+		line = line[cut:]
+		// the leading space of continuation lines counts towards their length
+		limit = maxLineLength - 1
+	}
+	w.WriteString(line)
+	w.WriteString("\r\n")
+}
+
+// paramValue quotes a parameter value when it holds characters that would
+// otherwise end it
+func paramValue(v string) string {
+	if strings.ContainsAny(v, ";:,") {
+		return `"` + strings.ReplaceAll(v, `"`, "") + `"`
+	}
+
+	return v
+}
+
+func (c Component) encode(w *bufio.Writer) {
+	fold(w, "BEGIN:"+c.Name)
+	for _, p := range c.Properties {
+		line := p.Name
+		names := make([]string, 0, len(p.Params))
+		for name := range p.Params {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			line += ";" + name + "=" + paramValue(p.Params[name])
+		}
+		fold(w, line+":"+p.Value)
+	}
+	for _, child := range c.Components {
+		child.encode(w)
+	}
+	fold(w, "END:"+c.Name)
+}
+
+// Encode writes the component and its sub-components
+func (c Component) Encode(out io.Writer) error {
+	w := bufio.NewWriter(out)
+	c.encode(w)
+	return w.Flush()
+}
+
+// unfold reads content lines, joining folded lines back together
+func unfold(r io.Reader) ([]string, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	lines := make([]string, 0)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+
+	return lines, scanner.Err()
+}
+
+// parseLine splits a content line into its name, parameters and value
+func parseLine(line string) (Property, error) {
+	segments := make([]string, 0, 2)
+	quoted := false
+	start := 0
+	for i, r := range line {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case quoted:
+		case r == ';' || r == ':':
+			segments = append(segments, line[start:i])
+			start = i + 1
+			if r != ':' {
+				continue
+			}
+
+			p := Property{Name: strings.ToUpper(segments[0]), Params: make(map[string]string), Value: line[i+1:]}
+			for _, param := range segments[1:] {
+				name, value, _ := strings.Cut(param, "=")
+				p.Params[strings.ToUpper(name)] = strings.Trim(value, `"`)
+			}
+			if p.Name == "" {
+				return Property{}, errors.New("malformed content line: " + line)
+			}
+			return p, nil
+		}
+	}
+
+	return Property{}, errors.New("malformed content line: " + line)
+}
+
+// Parse reads an iCalendar object
+func Parse(r io.Reader) (Component, error) {
+	lines, err := unfold(r)
+	if err != nil {
+		return Component{}, err
+	}
+
+	stack := make([]Component, 0)
+	var root *Component
+	for _, line := range lines {
+		p, err := parseLine(line)
+		if err != nil {
+			return Component{}, err
+		}
+
+		switch p.Name {
+		case "BEGIN":
+			stack = append(stack, Component{Name: strings.ToUpper(p.Value)})
+		case "END":
+			if len(stack) == 0 || stack[len(stack)-1].Name != strings.ToUpper(p.Value) {
+				return Component{}, errors.New("unexpected END:" + p.Value)
+			}
+			done := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if len(stack) == 0 {
+				root = &done
+			} else {
+				parent := &stack[len(stack)-1]
+				parent.Components = append(parent.Components, done)
+			}
+		default:
+			if len(stack) == 0 {
+				return Component{}, errors.New("property outside of a component: " + p.Name)
+			}
+			current := &stack[len(stack)-1]
+			current.Properties = append(current.Properties, p)
+		}
+	}
+
+	if len(stack) != 0 {
+		return Component{}, errors.New("missing END:" + stack[len(stack)-1].Name)
+	}
+	if root == nil {
+		return Component{}, errors.New("no calendar data found")
+	}
+
+	return *root, nil
+}
@@ -0,0 +1,215 @@
+package ical
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/greeneg/todoer/model"
+)
+
+const (
+	prodId = "-//greeneg//todoer//EN"
+
+	dateFormat     = "20060102"
+	dateTimeFormat = "20060102T150405"
+)
+
+// statuses maps todo statuses to VTODO statuses
+var statuses = map[string]string{
+	"new":        "NEEDS-ACTION",
+	"inprogress": "IN-PROCESS",
+	"completed":  "COMPLETED",
+}
+
+// Item is a todo read from a VTODO component
+type Item struct {
+	Uid      string
+	Status   string
+	Proposed model.ProposedTodo
+}
+
+// ItemError reports a VTODO component that could not be read
+type ItemError struct {
+	Index int
+	Uid   string
+	Err   error
+}
+
+func (e ItemError) Error() string {
+	return "VTODO " + strconv.Itoa(e.Index+1) + " (" + e.Uid + "): " + e.Err.Error()
+}
+
+// NewCalendar returns an empty VCALENDAR with the given display name
+func NewCalendar(name string) Component {
+	cal := Component{Name: "VCALENDAR"}
+	cal.Add("VERSION", "2.0", nil)
+	cal.Add("PRODID", prodId, nil)
+	cal.Add("CALSCALE", "GREGORIAN", nil)
+	if name != "" {
+		cal.AddText("X-WR-CALNAME", name)
+	}
+
+	return cal
+}
+
+// Uid returns the UID of a todo: the one a client gave it, or one made up
+// from its Id
+func Uid(todo model.Todo) string {
+	if todo.Uid != "" {
+		return todo.Uid
+	}
+
+	return "todo-" + strconv.Itoa(todo.Id) + "@todoer"
+}
+
+// formatTime converts a date read from the DB into an iCalendar UTC DATE-TIME
+func formatTime(date string) string {
+	d, err := time.Parse(time.RFC3339, date)
+	if err != nil {
+		if d, err = time.Parse(model.SqlDateTimeFormat, date); err != nil {
+			return ""
+		}
+	}
+
+	return d.UTC().Format(dateTimeFormat) + "Z"
+}
+
+// FromTodo renders a todo as a VTODO component
+func FromTodo(todo model.Todo) Component {
+	vtodo := Component{Name: "VTODO"}
+	vtodo.Add("UID", Uid(todo), nil)
+	vtodo.Add("DTSTAMP", formatTime(todo.LastChangedDate), nil)
+	vtodo.Add("CREATED", formatTime(todo.CreationDate), nil)
+	vtodo.Add("LAST-MODIFIED", formatTime(todo.LastChangedDate), nil)
+	vtodo.AddText("SUMMARY", todo.Description)
+	vtodo.Add("STATUS", statuses[todo.Status], nil)
+	if todo.Status == "completed" {
+		vtodo.Add("PERCENT-COMPLETE", "100", nil)
+		if todo.CompletionDate != "" {
+			vtodo.Add("COMPLETED", formatTime(todo.CompletionDate), nil)
+		}
+	}
+	if todo.Priority != 0 {
+		vtodo.Add("PRIORITY", strconv.Itoa(todo.Priority), nil)
+	}
+	if due := formatTime(todo.DueDate); due != "" {
+		if strings.HasSuffix(due, "T000000Z") {
+			// todos due on a day rather than at a time
+			vtodo.Add("DUE", due[:8], map[string]string{"VALUE": "DATE"})
+		} else {
+			vtodo.Add("DUE", due, nil)
+		}
+	}
+	if len(todo.Tags) != 0 {
+		categories := make([]string, 0, len(todo.Tags))
+		for _, tag := range todo.Tags {
+			categories = append(categories, EscapeText(tag))
+		}
+		vtodo.Add("CATEGORIES", strings.Join(categories, ","), nil)
+	}
+
+	return vtodo
+}
+
+// parseTime converts a DATE or DATE-TIME property into an SQL timestamp.
+// Local times are read in their TZID, or as UTC when it is missing or unknown
+func parseTime(p Property) (string, error) {
+	if p.Params["VALUE"] == "DATE" || len(p.Value) == len(dateFormat) {
+		d, err := time.Parse(dateFormat, p.Value)
+		if err != nil {
+			return "", errors.New("invalid date '" + p.Value + "'")
+		}
+		return d.Format(model.SqlDateTimeFormat), nil
+	}
+
+	location := time.UTC
+	value := p.Value
+	if strings.HasSuffix(value, "Z") {
+		value = strings.TrimSuffix(value, "Z")
+	} else if tzid, ok := p.Params["TZID"]; ok {
+		if l, err := time.LoadLocation(strings.TrimPrefix(tzid, "/")); err == nil {
+			location = l
+		}
+	}
+	d, err := time.ParseInLocation(dateTimeFormat, value, location)
+	if err != nil {
+		return "", errors.New("invalid date-time '" + p.Value + "'")
+	}
+
+	return d.UTC().Format(model.SqlDateTimeFormat), nil
+}
+
+// tagName turns a category into a valid tag name
+func tagName(category string) string {
+	return strings.Join(strings.FieldsFunc(category, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t' || r == '\r' || r == '\n'
+	}), "-")
+}
+
+// ToItem reads a VTODO component
+func ToItem(vtodo Component) (Item, error) {
+	item := Item{Uid: vtodo.Value("UID"), Status: "new"}
+	item.Proposed.Description = strings.TrimSpace(UnescapeText(vtodo.Value("SUMMARY")))
+	if item.Proposed.Description == "" {
+		item.Proposed.Description = strings.TrimSpace(UnescapeText(vtodo.Value("DESCRIPTION")))
+	}
+	if item.Proposed.Description == "" {
+		return Item{}, errors.New("VTODO has no SUMMARY")
+	}
+
+	switch strings.ToUpper(vtodo.Value("STATUS")) {
+	case "IN-PROCESS":
+		item.Status = "inprogress"
+	case "COMPLETED", "CANCELLED":
+		item.Status = "completed"
+	}
+
+	if priority := vtodo.Value("PRIORITY"); priority != "" {
+		p, err := strconv.Atoi(priority)
+		if err != nil || model.ValidatePriority(p) != nil {
+			return Item{}, errors.New("invalid PRIORITY '" + priority + "'")
+		}
+		item.Proposed.Priority = p
+	}
+
+	if due, ok := vtodo.Get("DUE"); ok {
+		date, err := parseTime(due)
+		if err != nil {
+			return Item{}, err
+		}
+		item.Proposed.DueDate = date
+	}
+
+	item.Proposed.Tags = make([]string, 0)
+	for _, p := range vtodo.Properties {
+		if p.Name != "CATEGORIES" {
+			continue
+		}
+		for _, category := range SplitText(p.Value) {
+			if tag := tagName(category); tag != "" {
+				item.Proposed.Tags = append(item.Proposed.Tags, tag)
+			}
+		}
+	}
+
+	return item, nil
+}
+
+// Items reads the VTODO components of a calendar. Components that cannot be
+// read are reported and skipped
+func Items(cal Component) ([]Item, []ItemError) {
+	items := make([]Item, 0)
+	errs := make([]ItemError, 0)
+	for i, vtodo := range cal.Children("VTODO") {
+		item, err := ToItem(vtodo)
+		if err != nil {
+			errs = append(errs, ItemError{Index: i, Uid: vtodo.Value("UID"), Err: err})
+			continue
+		}
+		items = append(items, item)
+	}
+
+	return items, errs
+}
@@ -0,0 +1,157 @@
+package importers
+
+import (
+	"encoding/json"
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/greeneg/todoer/model"
+)
+
+// taskListItem matches the items of a GitHub task list, such as "- [x] done"
+var taskListItem = regexp.MustCompile(`^\s*[-*+] \[([ xX])\] (.+)$`)
+
+type githubUser struct {
+	Login string `json:"login"`
+}
+
+type githubComment struct {
+	Author    githubUser `json:"author"`
+	User      githubUser `json:"user"`
+	Body      string     `json:"body"`
+	CreatedAt string     `json:"createdAt"`
+	Created   string     `json:"created_at"`
+}
+
+// githubIssue covers both the REST API and the `gh issue list --json` layouts
+type githubIssue struct {
+	Number int    `json:"number"`
+	Title  string `json:"title"`
+	Body   string `json:"body"`
+	State  string `json:"state"`
+	Labels []struct {
+		Name string `json:"name"`
+	} `json:"labels"`
+	Milestone *struct {
+		Title string `json:"title"`
+	} `json:"milestone"`
+	Author      githubUser      `json:"author"`
+	User        githubUser      `json:"user"`
+	Comments    json.RawMessage `json:"comments"`
+	PullRequest json.RawMessage `json:"pull_request"`
+	CreatedAt   string          `json:"createdAt"`
+	Created     string          `json:"created_at"`
+	UpdatedAt   string          `json:"updatedAt"`
+	Updated     string          `json:"updated_at"`
+	ClosedAt    string          `json:"closedAt"`
+	Closed      string          `json:"closed_at"`
+}
+
+// either returns the first of two values that is set
+func either(a string, b string) string {
+	if a != "" {
+		return a
+	}
+
+	return b
+}
+
+// GitHub reads a JSON array of GitHub issues, as saved from the REST API or
+// from `gh issue list --json`. Milestones become lists, labels become tags,
+// issue bodies and comments become comments and task list items in the body
+// become subtasks. Pull requests are skipped
+type GitHub struct{}
+
+func (GitHub) Name() string {
+	return "github"
+}
+
+func (GitHub) Read(data []byte) (model.Workspace, error) {
+	issues := make([]githubIssue, 0)
+	if err := json.Unmarshal(data, &issues); err != nil {
+		return model.Workspace{}, err
+	}
+
+	b := newBuilder()
+	for _, issue := range issues {
+		if len(issue.PullRequest) > 0 && string(issue.PullRequest) != "null" {
+			continue
+		}
+		fail := func(err error) (model.Workspace, error) {
+			return model.Workspace{}, errors.New("issue #" + strconv.Itoa(issue.Number) + ": " + err.Error())
+		}
+
+		dates := []string{
+			either(issue.CreatedAt, issue.Created),
+			either(issue.UpdatedAt, issue.Updated),
+			either(issue.ClosedAt, issue.Closed),
+		}
+		for i := range dates {
+			var err error
+			if dates[i], err = date(dates[i], time.RFC3339); err != nil {
+				return fail(err)
+			}
+		}
+		status := "new"
+		if strings.EqualFold(issue.State, "closed") {
+			status = "completed"
+		}
+		tags := make([]string, 0, len(issue.Labels))
+		for _, label := range issue.Labels {
+			tags = append(tags, label.Name)
+		}
+		listId := 0
+		if issue.Milestone != nil {
+			listId = b.list(issue.Milestone.Title)
+		}
+
+		id := b.todo(model.WorkspaceTodo{
+			Description:     issue.Title + " (#" + strconv.Itoa(issue.Number) + ")",
+			Status:          status,
+			ListId:          listId,
+			Tags:            tags,
+			CreationDate:    dates[0],
+			LastChangedDate: dates[1],
+			CompletionDate:  dates[2],
+		})
+		b.comment(id, attributed(either(issue.Author.Login, issue.User.Login), issue.Body), dates[0])
+
+		for _, line := range strings.Split(issue.Body, "\n") {
+			match := taskListItem.FindStringSubmatch(strings.TrimRight(line, "\r"))
+			if match == nil {
+				continue
+			}
+			itemStatus := "new"
+			if match[1] != " " {
+				itemStatus = "completed"
+			}
+			b.todo(model.WorkspaceTodo{
+				Description:  strings.TrimSpace(match[2]),
+				Status:       itemStatus,
+				ListId:       listId,
+				ParentId:     id,
+				CreationDate: dates[0],
+			})
+		}
+
+		// the REST API only counts comments, gh lists them
+		comments := make([]githubComment, 0)
+		if len(issue.Comments) > 0 && issue.Comments[0] == '[' {
+			if err := json.Unmarshal(issue.Comments, &comments); err != nil {
+				return fail(err)
+			}
+		}
+		for _, comment := range comments {
+			when, err := date(either(comment.CreatedAt, comment.Created), time.RFC3339)
+			if err != nil {
+				return fail(err)
+			}
+			b.comment(id, attributed(either(comment.Author.Login, comment.User.Login), comment.Body), when)
+		}
+	}
+
+	return b.workspace(), nil
+}
@@ -0,0 +1,164 @@
+// Package importers reads the exports of other task managers into workspace
+// archives, which are then stored like any other workspace import. Each
+// source has an Importer; All lists them
+package importers
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/greeneg/todoer/model"
+)
+
+// Importer converts the export of another task manager into a workspace
+type Importer interface {
+	// Name is the name of the source, as used in the import path
+	Name() string
+	// Read converts an export file. Comments are left without an author, for
+	// the importing user to take; the original author is kept in the text
+	Read(data []byte) (model.Workspace, error)
+}
+
+// All lists the available importers
+var All = []Importer{
+	Taskwarrior{},
+	Trello{},
+	GitHub{},
+}
+
+// Find returns the importer for a source
+func Find(name string) (Importer, bool) {
+	for _, i := range All {
+		if i.Name() == name {
+			return i, true
+		}
+	}
+
+	return nil, false
+}
+
+// Names returns the names of the available importers
+func Names() []string {
+	names := make([]string, 0, len(All))
+	for _, i := range All {
+		names = append(names, i.Name())
+	}
+
+	return names
+}
+
+// tagName turns a label into a tag name, which cannot hold commas or spaces
+func tagName(label string) string {
+	return strings.Join(strings.Fields(strings.ReplaceAll(label, ",", " ")), "-")
+}
+
+// date converts a timestamp in one of the given layouts to RFC 3339. Empty
+// strings are passed through
+func date(s string, layouts ...string) (string, error) {
+	if s == "" {
+		return "", nil
+	}
+	for _, layout := range layouts {
+		if d, err := time.Parse(layout, s); err == nil {
+			return d.UTC().Format(time.RFC3339), nil
+		}
+	}
+
+	return "", errors.New("invalid date '" + s + "'")
+}
+
+// attributed prefixes a comment with the name of its original author
+func attributed(author string, body string) string {
+	if author == "" {
+		return body
+	}
+
+	return author + " wrote:\n\n" + body
+}
+
+// builder assembles a workspace, numbering its records as they are added
+type builder struct {
+	ws      model.Workspace
+	listIds map[string]int
+}
+
+func newBuilder() *builder {
+	return &builder{
+		ws: model.Workspace{
+			Version:  model.WorkspaceVersion,
+			Lists:    make([]model.WorkspaceList, 0),
+			Tags:     make([]string, 0),
+			Todos:    make([]model.WorkspaceTodo, 0),
+			Comments: make([]model.Comment, 0),
+			History:  make([]model.HistoryEntry, 0),
+		},
+		listIds: make(map[string]int),
+	}
+}
+
+// list returns the Id of the named list, adding it if needed. An empty name
+// is no list
+func (b *builder) list(name string) int {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return 0
+	}
+	if id, found := b.listIds[name]; found {
+		return id
+	}
+
+	id := len(b.ws.Lists) + 1
+	b.ws.Lists = append(b.ws.Lists, model.WorkspaceList{Id: id, Name: name, Members: make([]string, 0)})
+	b.listIds[name] = id
+	return id
+}
+
+// todo adds a todo and returns its Id
+func (b *builder) todo(todo model.WorkspaceTodo) int {
+	todo.Id = len(b.ws.Todos) + 1
+	if todo.Status == "" {
+		todo.Status = "new"
+	}
+	tags := make([]string, 0, len(todo.Tags))
+	for _, tag := range todo.Tags {
+		if tag = tagName(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	todo.Tags = tags
+	b.ws.Todos = append(b.ws.Todos, todo)
+
+	return todo.Id
+}
+
+// comment adds a comment to a todo
+func (b *builder) comment(todoId int, body string, creationDate string) {
+	if strings.TrimSpace(body) == "" {
+		return
+	}
+	b.ws.Comments = append(b.ws.Comments, model.Comment{
+		Id:              len(b.ws.Comments) + 1,
+		TodoId:          todoId,
+		Body:            body,
+		CreationDate:    creationDate,
+		LastChangedDate: creationDate,
+	})
+}
+
+// workspace returns the assembled workspace
+func (b *builder) workspace() model.Workspace {
+	tags := make(map[string]bool)
+	for _, todo := range b.ws.Todos {
+		for _, tag := range todo.Tags {
+			tags[tag] = true
+		}
+	}
+	for tag := range tags {
+		b.ws.Tags = append(b.ws.Tags, tag)
+	}
+	sort.Strings(b.ws.Tags)
+
+	return b.ws
+}
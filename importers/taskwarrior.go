@@ -0,0 +1,119 @@
+package importers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+
+	"github.com/greeneg/todoer/model"
+)
+
+// the timestamp layout of Taskwarrior exports
+const taskwarriorDate = "20060102T150405Z"
+
+// taskwarriorPriorities maps Taskwarrior priorities to todo priorities
+var taskwarriorPriorities = map[string]int{
+	"H": 1,
+	"M": 5,
+	"L": 9,
+}
+
+type taskwarriorTask struct {
+	Uuid        string   `json:"uuid"`
+	Description string   `json:"description"`
+	Status      string   `json:"status"`
+	Project     string   `json:"project"`
+	Tags        []string `json:"tags"`
+	Priority    string   `json:"priority"`
+	Entry       string   `json:"entry"`
+	Modified    string   `json:"modified"`
+	Start       string   `json:"start"`
+	End         string   `json:"end"`
+	Due         string   `json:"due"`
+	Annotations []struct {
+		Entry       string `json:"entry"`
+		Description string `json:"description"`
+	} `json:"annotations"`
+}
+
+// Taskwarrior reads the output of `task export`. Projects become lists, tags
+// stay tags and annotations become comments. Deleted tasks and the templates
+// of recurring tasks are skipped
+type Taskwarrior struct{}
+
+func (Taskwarrior) Name() string {
+	return "taskwarrior"
+}
+
+func (Taskwarrior) Read(data []byte) (model.Workspace, error) {
+	tasks := make([]taskwarriorTask, 0)
+	data = bytes.TrimSpace(data)
+	if bytes.HasPrefix(data, []byte("[")) {
+		if err := json.Unmarshal(data, &tasks); err != nil {
+			return model.Workspace{}, err
+		}
+	} else {
+		// older versions write one task per line
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		for {
+			var task taskwarriorTask
+			err := decoder.Decode(&task)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return model.Workspace{}, err
+			}
+			tasks = append(tasks, task)
+		}
+	}
+
+	b := newBuilder()
+	for _, task := range tasks {
+		fail := func(err error) (model.Workspace, error) {
+			return model.Workspace{}, errors.New("task " + task.Uuid + ": " + err.Error())
+		}
+
+		status := "new"
+		switch task.Status {
+		case "deleted", "recurring":
+			continue
+		case "completed":
+			status = "completed"
+		default:
+			if task.Start != "" {
+				status = "inprogress"
+			}
+		}
+
+		dates := []string{task.Entry, task.Modified, task.End, task.Due}
+		for i := range dates {
+			var err error
+			if dates[i], err = date(dates[i], taskwarriorDate); err != nil {
+				return fail(err)
+			}
+		}
+
+		id := b.todo(model.WorkspaceTodo{
+			Description:     task.Description,
+			Status:          status,
+			ListId:          b.list(task.Project),
+			Priority:        taskwarriorPriorities[task.Priority],
+			Tags:            task.Tags,
+			CreationDate:    dates[0],
+			LastChangedDate: dates[1],
+			CompletionDate:  dates[2],
+			DueDate:         dates[3],
+		})
+		for _, a := range task.Annotations {
+			entry, err := date(a.Entry, taskwarriorDate)
+			if err != nil {
+				return fail(err)
+			}
+			b.comment(id, a.Description, entry)
+		}
+	}
+
+	return b.workspace(), nil
+}
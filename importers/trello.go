@@ -0,0 +1,189 @@
+package importers
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/greeneg/todoer/model"
+)
+
+type trelloBoard struct {
+	Name  string `json:"name"`
+	Lists []struct {
+		Id     string `json:"id"`
+		Name   string `json:"name"`
+		Closed bool   `json:"closed"`
+	} `json:"lists"`
+	Labels []struct {
+		Id    string `json:"id"`
+		Name  string `json:"name"`
+		Color string `json:"color"`
+	} `json:"labels"`
+	Cards []struct {
+		Id               string   `json:"id"`
+		Name             string   `json:"name"`
+		Desc             string   `json:"desc"`
+		IdList           string   `json:"idList"`
+		Closed           bool     `json:"closed"`
+		Due              string   `json:"due"`
+		DueComplete      bool     `json:"dueComplete"`
+		IdLabels         []string `json:"idLabels"`
+		DateLastActivity string   `json:"dateLastActivity"`
+	} `json:"cards"`
+	Checklists []struct {
+		IdCard     string `json:"idCard"`
+		CheckItems []struct {
+			Name  string  `json:"name"`
+			State string  `json:"state"`
+			Pos   float64 `json:"pos"`
+		} `json:"checkItems"`
+	} `json:"checklists"`
+	Actions []struct {
+		Type string `json:"type"`
+		Date string `json:"date"`
+		Data struct {
+			Text string `json:"text"`
+			Card struct {
+				Id string `json:"id"`
+			} `json:"card"`
+		} `json:"data"`
+		MemberCreator struct {
+			FullName string `json:"fullName"`
+			Username string `json:"username"`
+		} `json:"memberCreator"`
+	} `json:"actions"`
+}
+
+// trelloCreated returns when a Trello object was created, which is encoded in
+// the first four bytes of its Id
+func trelloCreated(id string) string {
+	if len(id) < 8 {
+		return ""
+	}
+	seconds, err := strconv.ParseInt(id[:8], 16, 64)
+	if err != nil {
+		return ""
+	}
+
+	return time.Unix(seconds, 0).UTC().Format(time.RFC3339)
+}
+
+// Trello reads the JSON export of a Trello board. Every open list of the board
+// becomes a list named after the board and the list, cards become todos,
+// labels become tags, card descriptions and comments become comments and
+// checklist items become subtasks. Archived cards and lists are skipped
+type Trello struct{}
+
+func (Trello) Name() string {
+	return "trello"
+}
+
+func (Trello) Read(data []byte) (model.Workspace, error) {
+	var board trelloBoard
+	if err := json.Unmarshal(data, &board); err != nil {
+		return model.Workspace{}, err
+	}
+	if board.Name == "" && len(board.Cards) == 0 {
+		return model.Workspace{}, errors.New("not a Trello board export")
+	}
+
+	b := newBuilder()
+	listNames := make(map[string]string)
+	for _, l := range board.Lists {
+		if !l.Closed {
+			listNames[l.Id] = board.Name + " / " + l.Name
+		}
+	}
+	labels := make(map[string]string)
+	for _, l := range board.Labels {
+		labels[l.Id] = l.Name
+		if l.Name == "" {
+			labels[l.Id] = l.Color
+		}
+	}
+
+	cardIds := make(map[string]int)
+	for _, card := range board.Cards {
+		listName, open := listNames[card.IdList]
+		if card.Closed || !open {
+			continue
+		}
+		fail := func(err error) (model.Workspace, error) {
+			return model.Workspace{}, errors.New("card " + card.Id + ": " + err.Error())
+		}
+
+		due, err := date(card.Due, time.RFC3339)
+		if err != nil {
+			return fail(err)
+		}
+		lastActivity, err := date(card.DateLastActivity, time.RFC3339)
+		if err != nil {
+			return fail(err)
+		}
+		tags := make([]string, 0, len(card.IdLabels))
+		for _, label := range card.IdLabels {
+			tags = append(tags, labels[label])
+		}
+		status := "new"
+		if card.DueComplete {
+			status = "completed"
+		}
+
+		id := b.todo(model.WorkspaceTodo{
+			Description:     card.Name,
+			Status:          status,
+			ListId:          b.list(listName),
+			DueDate:         due,
+			Tags:            tags,
+			CreationDate:    trelloCreated(card.Id),
+			LastChangedDate: lastActivity,
+		})
+		cardIds[card.Id] = id
+		b.comment(id, card.Desc, trelloCreated(card.Id))
+	}
+
+	for _, checklist := range board.Checklists {
+		parentId, found := cardIds[checklist.IdCard]
+		if !found {
+			continue
+		}
+		items := checklist.CheckItems
+		sort.SliceStable(items, func(i, j int) bool { return items[i].Pos < items[j].Pos })
+		for _, item := range items {
+			status := "new"
+			if item.State == "complete" {
+				status = "completed"
+			}
+			b.todo(model.WorkspaceTodo{
+				Description: item.Name,
+				Status:      status,
+				ListId:      b.ws.Todos[parentId-1].ListId,
+				ParentId:    parentId,
+			})
+		}
+	}
+
+	// exports list actions newest first
+	actions := board.Actions
+	sort.SliceStable(actions, func(i, j int) bool { return actions[i].Date < actions[j].Date })
+	for _, action := range actions {
+		todoId, found := cardIds[action.Data.Card.Id]
+		if action.Type != "commentCard" || !found {
+			continue
+		}
+		when, err := date(action.Date, time.RFC3339)
+		if err != nil {
+			return model.Workspace{}, errors.New("comment on card " + action.Data.Card.Id + ": " + err.Error())
+		}
+		author := action.MemberCreator.FullName
+		if author == "" {
+			author = action.MemberCreator.Username
+		}
+		b.comment(todoId, attributed(author, action.Data.Text), when)
+	}
+
+	return b.workspace(), nil
+}
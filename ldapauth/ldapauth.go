@@ -0,0 +1,293 @@
+// Package ldapauth checks passwords against an LDAP directory. Users are
+// looked up with a search and their password checked by binding as them;
+// their directory groups may grant them todoer roles
+package ldapauth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+
+	"github.com/greeneg/todoer/globals"
+	"github.com/greeneg/todoer/model"
+)
+
+// defaults for the settings left unset in the config
+const (
+	DefaultFilter            = "(uid=%s)"
+	DefaultFullNameAttribute = "cn"
+	DefaultEmailAttribute    = "mail"
+	DefaultGroupAttribute    = "memberOf"
+	DefaultTimeoutSeconds    = 10
+)
+
+// Authenticator is a helpers.Authenticator backed by a directory
+type Authenticator struct {
+	config    globals.LdapConfig
+	tlsConfig *tls.Config
+	timeout   time.Duration
+}
+
+// New returns an authenticator for the directory in the config. A relative
+// CaFile is taken to be in configDir
+func New(c globals.LdapConfig, configDir string) (*Authenticator, error) {
+	if c.Url == "" || c.BaseDn == "" {
+		return nil, errors.New("ldap: url and baseDn are required")
+	}
+	if c.Filter == "" {
+		c.Filter = DefaultFilter
+	}
+	if !strings.Contains(c.Filter, "%s") {
+		return nil, errors.New("ldap: filter must contain %s for the user name")
+	}
+	if c.GroupFilter != "" && !strings.Contains(c.GroupFilter, "%s") {
+		return nil, errors.New("ldap: groupFilter must contain %s for the user DN")
+	}
+	if c.FullNameAttribute == "" {
+		c.FullNameAttribute = DefaultFullNameAttribute
+	}
+	if c.EmailAttribute == "" {
+		c.EmailAttribute = DefaultEmailAttribute
+	}
+	if c.GroupAttribute == "" {
+		c.GroupAttribute = DefaultGroupAttribute
+	}
+	if c.GroupBaseDn == "" {
+		c.GroupBaseDn = c.BaseDn
+	}
+	if c.TimeoutSeconds <= 0 {
+		c.TimeoutSeconds = DefaultTimeoutSeconds
+	}
+
+	u, err := url.Parse(c.Url)
+	if err != nil {
+		return nil, errors.New("ldap: invalid url: " + err.Error())
+	}
+	tlsConfig := &tls.Config{ServerName: u.Hostname(), InsecureSkipVerify: c.InsecureSkipVerify}
+	if c.CaFile != "" {
+		caFile := c.CaFile
+		if !filepath.IsAbs(caFile) {
+			caFile = filepath.Join(configDir, caFile)
+		}
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, errors.New("ldap: no certificates found in " + caFile)
+		}
+	}
+
+	return &Authenticator{
+		config:    c,
+		tlsConfig: tlsConfig,
+		timeout:   time.Duration(c.TimeoutSeconds) * time.Second,
+	}, nil
+}
+
+// connect Opens a connection to the directory, bound as the search account
+func (a *Authenticator) connect() (*ldap.Conn, error) {
+	conn, err := ldap.DialURL(a.config.Url,
+		ldap.DialWithDialer(&net.Dialer{Timeout: a.timeout}),
+		ldap.DialWithTLSConfig(a.tlsConfig))
+	if err != nil {
+		return nil, err
+	}
+	conn.SetTimeout(a.timeout)
+
+	if a.config.StartTLS {
+		if err := conn.StartTLS(a.tlsConfig); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if a.config.BindDn != "" {
+		err = conn.Bind(a.config.BindDn, a.config.BindPassword)
+	} else {
+		err = conn.UnauthenticatedBind("")
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+// search Returns the entries below baseDn matching the filter, in which %s is
+// replaced by the escaped value
+func (a *Authenticator) search(conn *ldap.Conn, baseDn string, filter string, value string, sizeLimit int,
+	attributes []string) ([]*ldap.Entry, error) {
+	request := ldap.NewSearchRequest(baseDn, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, sizeLimit,
+		int(a.timeout/time.Second), false, strings.ReplaceAll(filter, "%s", ldap.EscapeFilter(value)), attributes, nil)
+	result, err := conn.Search(request)
+	if err != nil {
+		return nil, err
+	}
+
+	return result.Entries, nil
+}
+
+// Authenticate checks a password by binding as the directory entry of the
+// user. Only todoer users created by the directory or linked to the entry
+// may log in this way; others are created on their first login when the
+// config allows it, and their roles follow their groups on every login
+func (a *Authenticator) Authenticate(username string, password string) bool {
+	if username == "" || password == "" {
+		return false
+	}
+
+	conn, err := a.connect()
+	if err != nil {
+		log.Println("ERROR: Cannot connect to LDAP directory: " + string(err.Error()))
+		return false
+	}
+	defer conn.Close()
+
+	attributes := []string{a.config.FullNameAttribute, a.config.EmailAttribute, a.config.GroupAttribute}
+	entries, err := a.search(conn, a.config.BaseDn, a.config.Filter, username, 2, attributes)
+	if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+		log.Println("ERROR: Cannot look up user '" + username + "' in LDAP directory: " + string(err.Error()))
+		return false
+	}
+	if len(entries) != 1 {
+		if len(entries) > 1 {
+			log.Println("ERROR: More than one LDAP entry matches user '" + username + "'")
+		}
+		return false
+	}
+	entry := entries[0]
+
+	if err := conn.Bind(entry.DN, password); err != nil {
+		if !ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			log.Println("ERROR: Cannot bind as '" + entry.DN + "': " + string(err.Error()))
+		}
+		return false
+	}
+
+	groups := entry.GetAttributeValues(a.config.GroupAttribute)
+	if a.config.GroupFilter != "" {
+		// search as the search account again, the user may not see groups
+		if err := a.rebind(conn); err != nil {
+			log.Println("ERROR: Cannot bind to LDAP directory: " + string(err.Error()))
+			return false
+		}
+		groupEntries, err := a.search(conn, a.config.GroupBaseDn, a.config.GroupFilter, entry.DN, 0, []string{"dn"})
+		if err != nil {
+			log.Println("ERROR: Cannot look up groups of '" + entry.DN + "': " + string(err.Error()))
+			return false
+		}
+		groups = nil
+		for _, group := range groupEntries {
+			groups = append(groups, group.DN)
+		}
+	}
+
+	user, err := a.localUser(username, entry)
+	if err != nil || user.Id == 0 {
+		return false
+	}
+	if user.Status == "locked" {
+		return false
+	}
+	a.syncRoles(user, groups)
+
+	return true
+}
+
+// rebind Binds as the search account again
+func (a *Authenticator) rebind(conn *ldap.Conn) error {
+	if a.config.BindDn != "" {
+		return conn.Bind(a.config.BindDn, a.config.BindPassword)
+	}
+
+	return conn.UnauthenticatedBind("")
+}
+
+// localUser Returns the todoer user of a directory entry, creating it if the
+// config allows it, or an empty user otherwise. A todoer user of the same
+// name that is not linked to the entry, such as a local account, is never
+// taken over by it
+func (a *Authenticator) localUser(username string, entry *ldap.Entry) (model.User, error) {
+	user, err := model.GetUserByUserName(username)
+	if err != nil {
+		return model.User{}, err
+	}
+	if user.Id != 0 {
+		if !strings.EqualFold(user.LdapDn, entry.DN) {
+			log.Println("ERROR: User '" + username + "' is not linked to LDAP entry '" + entry.DN + "'")
+			return model.User{}, nil
+		}
+		return user, nil
+	}
+	if !a.config.AutoCreateUsers {
+		log.Println("ERROR: LDAP user '" + username + "' has no todoer account")
+		return model.User{}, nil
+	}
+
+	fullName := entry.GetAttributeValue(a.config.FullNameAttribute)
+	if fullName == "" {
+		fullName = username
+	}
+
+	return model.CreateLdapUser(model.ProposedUser{
+		UserName:  username,
+		Email:     entry.GetAttributeValue(a.config.EmailAttribute),
+		OrgUnitId: a.config.OrgUnitId,
+	}, fullName, entry.DN)
+}
+
+// syncRoles Grants the user the roles of their groups and takes away the
+// mapped roles they no longer have a group for. Roles not named in
+// GroupRoles are left alone, and the last admin keeps their role. Users not
+// linked to the directory are never touched
+func (a *Authenticator) syncRoles(user model.User, groups []string) {
+	if user.LdapDn == "" {
+		return
+	}
+	wanted := map[string]bool{}
+	for group, role := range a.config.GroupRoles {
+		member := false
+		for _, g := range groups {
+			if strings.EqualFold(strings.TrimSpace(g), strings.TrimSpace(group)) {
+				member = true
+			}
+		}
+		wanted[role] = wanted[role] || member
+	}
+
+	for roleName, want := range wanted {
+		role, err := model.GetRoleByName(roleName)
+		if err != nil || role.Id == 0 {
+			log.Println("ERROR: LDAP group mapped to unknown role '" + roleName + "'")
+			continue
+		}
+		has, err := model.UserHasRole(user.Id, roleName)
+		if err != nil || has == want {
+			continue
+		}
+		if want {
+			log.Println("INFO: Granting role '" + roleName + "' to LDAP user '" + user.UserName + "'")
+			model.GrantRole(user.Id, role.Id)
+			continue
+		}
+		if roleName == model.RoleAdmin {
+			if count, err := model.CountUsersWithRole(model.RoleAdmin); err != nil || count <= 1 {
+				log.Println("WARN: Not revoking role 'admin' from '" + user.UserName + "', as they are the last admin")
+				continue
+			}
+		}
+		log.Println("INFO: Revoking role '" + roleName + "' from LDAP user '" + user.UserName + "'")
+		model.RevokeRole(user.Id, role.Id)
+	}
+}
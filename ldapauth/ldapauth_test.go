@@ -0,0 +1,433 @@
+package ldapauth
+
+import (
+	"database/sql"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/greeneg/todoer/globals"
+	"github.com/greeneg/todoer/model"
+)
+
+const (
+	baseDn       = "dc=example,dc=com"
+	searchDn     = "cn=search,dc=example,dc=com"
+	searchPass   = "search-secret"
+	aliceDn      = "uid=alice,ou=people,dc=example,dc=com"
+	alicePass    = "alice-secret"
+	greenegDn    = "uid=greeneg,ou=people,dc=example,dc=com"
+	greenegPass  = "greeneg-secret"
+	orgAdminsDn  = "cn=orgadmins,ou=groups,dc=example,dc=com"
+	developersDn = "cn=developers,ou=groups,dc=example,dc=com"
+)
+
+type entry struct {
+	dn         string
+	attributes map[string][]string
+}
+
+// directory is an in-process LDAP server with just enough of the protocol for
+// the authenticator: simple binds, and searches with a single equality filter
+// that only the search account may make
+type directory struct {
+	listener  net.Listener
+	mtx       sync.Mutex
+	entries   []*entry
+	passwords map[string]string // DN to password
+}
+
+func newDirectory(t *testing.T) *directory {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &directory{
+		listener: listener,
+		entries: []*entry{
+			{dn: aliceDn, attributes: map[string][]string{
+				"uid":      {"alice"},
+				"cn":       {"Alice Example"},
+				"mail":     {"alice@example.com"},
+				"memberOf": {orgAdminsDn},
+			}},
+			{dn: greenegDn, attributes: map[string][]string{
+				"uid": {"greeneg"},
+				"cn":  {"Gary Greene"},
+			}},
+			{dn: orgAdminsDn, attributes: map[string][]string{"member": {aliceDn}}},
+			{dn: developersDn, attributes: map[string][]string{"member": {}}},
+		},
+		passwords: map[string]string{
+			searchDn:  searchPass,
+			aliceDn:   alicePass,
+			greenegDn: greenegPass,
+		},
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go d.serve(conn)
+		}
+	}()
+
+	return d
+}
+
+func (d *directory) url() string {
+	return "ldap://" + d.listener.Addr().String()
+}
+
+// setAttribute replaces the values of an attribute of an entry
+func (d *directory) setAttribute(dn string, name string, values ...string) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	for _, e := range d.entries {
+		if e.dn == dn {
+			e.attributes[name] = values
+		}
+	}
+}
+
+func result(messageId int64, tag ber.Tag, code int) *ber.Packet {
+	packet := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+	packet.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, messageId, "Message ID"))
+	response := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "Response")
+	response.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, code, "Result Code"))
+	response.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Matched DN"))
+	response.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Diagnostic Message"))
+	packet.AppendChild(response)
+
+	return packet
+}
+
+func searchEntry(messageId int64, e *entry, attributes []string) *ber.Packet {
+	packet := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+	packet.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, messageId, "Message ID"))
+	response := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "Entry")
+	response.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, e.dn, "Object Name"))
+	list := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attributes")
+	for name, values := range e.attributes {
+		wanted := false
+		for _, a := range attributes {
+			wanted = wanted || strings.EqualFold(a, name)
+		}
+		if !wanted {
+			continue
+		}
+		attribute := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attribute")
+		attribute.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, "Type"))
+		set := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "Values")
+		for _, value := range values {
+			set.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, value, "Value"))
+		}
+		attribute.AppendChild(set)
+		list.AppendChild(attribute)
+	}
+	response.AppendChild(list)
+	packet.AppendChild(response)
+
+	return packet
+}
+
+func (d *directory) serve(conn net.Conn) {
+	defer conn.Close()
+	boundDn := ""
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil || len(packet.Children) < 2 {
+			return
+		}
+		messageId, _ := packet.Children[0].Value.(int64)
+		request := packet.Children[1]
+
+		switch request.Tag {
+		case ldap.ApplicationBindRequest:
+			dn := request.Children[1].Data.String()
+			password := request.Children[2].Data.String()
+			code := ldap.LDAPResultSuccess
+			d.mtx.Lock()
+			if dn != "" && (password == "" || d.passwords[dn] != password) {
+				code = ldap.LDAPResultInvalidCredentials
+			}
+			d.mtx.Unlock()
+			if code == ldap.LDAPResultSuccess {
+				boundDn = dn
+			} else {
+				boundDn = ""
+			}
+			conn.Write(result(messageId, ldap.ApplicationBindResponse, code).Bytes())
+		case ldap.ApplicationSearchRequest:
+			if boundDn != searchDn {
+				conn.Write(result(messageId, ldap.ApplicationSearchResultDone,
+					ldap.LDAPResultInsufficientAccessRights).Bytes())
+				continue
+			}
+			base := strings.ToLower(request.Children[0].Data.String())
+			sizeLimit, _ := request.Children[3].Value.(int64)
+			filter := request.Children[6]
+			if filter.Tag != ldap.FilterEqualityMatch {
+				conn.Write(result(messageId, ldap.ApplicationSearchResultDone,
+					ldap.LDAPResultUnwillingToPerform).Bytes())
+				continue
+			}
+			name, value := filter.Children[0].Data.String(), filter.Children[1].Data.String()
+			var attributes []string
+			for _, a := range request.Children[7].Children {
+				attributes = append(attributes, a.Data.String())
+			}
+
+			code := ldap.LDAPResultSuccess
+			sent := int64(0)
+			d.mtx.Lock()
+			for _, e := range d.entries {
+				if !strings.HasSuffix(strings.ToLower(e.dn), base) {
+					continue
+				}
+				match := false
+				for attribute, values := range e.attributes {
+					for _, v := range values {
+						match = match || (strings.EqualFold(attribute, name) && strings.EqualFold(v, value))
+					}
+				}
+				if !match {
+					continue
+				}
+				if sizeLimit > 0 && sent == sizeLimit {
+					code = ldap.LDAPResultSizeLimitExceeded
+					break
+				}
+				conn.Write(searchEntry(messageId, e, attributes).Bytes())
+				sent++
+			}
+			d.mtx.Unlock()
+			conn.Write(result(messageId, ldap.ApplicationSearchResultDone, code).Bytes())
+		case ldap.ApplicationUnbindRequest:
+			return
+		default:
+			return
+		}
+	}
+}
+
+// openDb Points the model at a new database made from the schema, which holds
+// the greeneg admin account
+func openDb(t *testing.T) {
+	t.Helper()
+	schema, err := os.ReadFile(filepath.Join("..", "db", "schema.sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := sql.Open("sqlite3", "file:"+filepath.Join(t.TempDir(), "todoer.db")+"?_foreign_keys=on")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(string(schema)); err != nil {
+		t.Fatal(err)
+	}
+	model.DB = db
+	t.Cleanup(func() { db.Close() })
+}
+
+func newAuthenticator(t *testing.T, d *directory, change func(c *globals.LdapConfig)) *Authenticator {
+	t.Helper()
+	c := globals.LdapConfig{
+		Enabled:         true,
+		Url:             d.url(),
+		BindDn:          searchDn,
+		BindPassword:    searchPass,
+		BaseDn:          baseDn,
+		GroupRoles:      map[string]string{orgAdminsDn: model.RoleOrgAdmin},
+		AutoCreateUsers: true,
+		TimeoutSeconds:  5,
+	}
+	if change != nil {
+		change(&c)
+	}
+	a, err := New(c, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return a
+}
+
+func getUser(t *testing.T, username string) model.User {
+	t.Helper()
+	user, err := model.GetUserByUserName(username)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return user
+}
+
+func hasRole(t *testing.T, user model.User, role string) bool {
+	t.Helper()
+	has, err := model.UserHasRole(user.Id, role)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return has
+}
+
+func TestAuthenticateCreatesUser(t *testing.T) {
+	openDb(t)
+	a := newAuthenticator(t, newDirectory(t), nil)
+
+	if !a.Authenticate("alice", alicePass) {
+		t.Fatal("Authenticate refused the directory password")
+	}
+	user := getUser(t, "alice")
+	if user.Source != model.SourceLdap || user.LdapDn != aliceDn {
+		t.Errorf("user has source %q and DN %q, want %q and %q", user.Source, user.LdapDn, model.SourceLdap, aliceDn)
+	}
+	if user.FullName != "Alice Example" || user.Email != "alice@example.com" {
+		t.Errorf("user has full name %q and email %q", user.FullName, user.Email)
+	}
+	if !hasRole(t, user, model.RoleOrgAdmin) {
+		t.Error("member of the mapped group was not granted its role")
+	}
+}
+
+func TestAuthenticateRefusesWrongPassword(t *testing.T) {
+	openDb(t)
+	a := newAuthenticator(t, newDirectory(t), nil)
+
+	for _, password := range []string{"wrong", searchPass, greenegPass} {
+		if a.Authenticate("alice", password) {
+			t.Errorf("Authenticate accepted password %q", password)
+		}
+	}
+	if a.Authenticate("mallory", "anything") {
+		t.Error("Authenticate accepted a user missing from the directory")
+	}
+	if user := getUser(t, "alice"); user.Id != 0 {
+		t.Error("a failed login created a user")
+	}
+}
+
+func TestAuthenticateWithoutAutoCreate(t *testing.T) {
+	openDb(t)
+	a := newAuthenticator(t, newDirectory(t), func(c *globals.LdapConfig) { c.AutoCreateUsers = false })
+
+	if a.Authenticate("alice", alicePass) {
+		t.Error("Authenticate accepted a user without a todoer account")
+	}
+	if user := getUser(t, "alice"); user.Id != 0 {
+		t.Error("a user was created although autoCreateUsers is off")
+	}
+}
+
+func TestAuthenticateRefusesUnlinkedAccounts(t *testing.T) {
+	openDb(t)
+	d := newDirectory(t)
+	d.setAttribute(greenegDn, "memberOf", orgAdminsDn)
+	a := newAuthenticator(t, d, nil)
+
+	// the local greeneg account is not taken over by the directory entry of
+	// the same name
+	if a.Authenticate("greeneg", greenegPass) {
+		t.Fatal("Authenticate accepted a local account")
+	}
+	greeneg := getUser(t, "greeneg")
+	if hasRole(t, greeneg, model.RoleOrgAdmin) {
+		t.Error("roles were synced onto a local account")
+	}
+
+	// until an admin links it
+	if err := model.SetUserLdapDn(greeneg.Id, strings.ToUpper(greenegDn)); err != nil {
+		t.Fatal(err)
+	}
+	if !a.Authenticate("greeneg", greenegPass) {
+		t.Error("Authenticate refused a linked account")
+	}
+	if !hasRole(t, greeneg, model.RoleOrgAdmin) {
+		t.Error("member of the mapped group was not granted its role")
+	}
+}
+
+func TestGroupRolesFollowGroups(t *testing.T) {
+	openDb(t)
+	d := newDirectory(t)
+	a := newAuthenticator(t, d, nil)
+
+	if !a.Authenticate("alice", alicePass) {
+		t.Fatal("Authenticate refused the directory password")
+	}
+	alice := getUser(t, "alice")
+	if !hasRole(t, alice, model.RoleOrgAdmin) {
+		t.Fatal("member of the mapped group was not granted its role")
+	}
+
+	d.setAttribute(aliceDn, "memberOf", developersDn)
+	if !a.Authenticate("alice", alicePass) {
+		t.Fatal("Authenticate refused the directory password")
+	}
+	if hasRole(t, alice, model.RoleOrgAdmin) {
+		t.Error("role of a group the user left was not revoked")
+	}
+	if !hasRole(t, alice, model.RoleMember) {
+		t.Error("a role not mapped to a group was revoked")
+	}
+}
+
+func TestGroupFilter(t *testing.T) {
+	openDb(t)
+	d := newDirectory(t)
+	d.setAttribute(aliceDn, "memberOf")
+	a := newAuthenticator(t, d, func(c *globals.LdapConfig) {
+		c.GroupFilter = "(member=%s)"
+		c.GroupBaseDn = "ou=groups," + baseDn
+	})
+
+	// groups are searched for as the search account, as the directory only
+	// lets it search
+	if !a.Authenticate("alice", alicePass) {
+		t.Fatal("Authenticate refused the directory password")
+	}
+	alice := getUser(t, "alice")
+	if !hasRole(t, alice, model.RoleOrgAdmin) {
+		t.Fatal("member of the mapped group was not granted its role")
+	}
+
+	d.setAttribute(orgAdminsDn, "member")
+	if !a.Authenticate("alice", alicePass) {
+		t.Fatal("Authenticate refused the directory password")
+	}
+	if hasRole(t, alice, model.RoleOrgAdmin) {
+		t.Error("role of a group the user left was not revoked")
+	}
+}
+
+func TestLastAdminKeepsRole(t *testing.T) {
+	openDb(t)
+	a := newAuthenticator(t, newDirectory(t), func(c *globals.LdapConfig) {
+		c.GroupRoles = map[string]string{orgAdminsDn: model.RoleAdmin}
+	})
+	greeneg := getUser(t, "greeneg")
+	if err := model.SetUserLdapDn(greeneg.Id, greenegDn); err != nil {
+		t.Fatal(err)
+	}
+
+	if !a.Authenticate("greeneg", greenegPass) {
+		t.Fatal("Authenticate refused a linked account")
+	}
+	if !hasRole(t, greeneg, model.RoleAdmin) {
+		t.Error("the last admin lost their role")
+	}
+}
@@ -0,0 +1,146 @@
+// Package lockout slows down and stops password guessing. Failed logins are
+// recorded per user name and per client address; after a few of them every
+// further attempt is delayed, twice as long each time, client addresses with
+// too many failures are refused for a while, and accounts with too many
+// failures are locked until an admin unlocks them
+package lockout
+
+import (
+	"errors"
+	"log"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/greeneg/todoer/globals"
+	"github.com/greeneg/todoer/model"
+)
+
+// Defaults apply to the settings left unset in the config
+var Defaults = globals.LockoutConfig{
+	MaxFailures:     10,
+	IpMaxFailures:   100,
+	DelayAfter:      3,
+	MaxDelaySeconds: 16,
+	WindowMinutes:   15,
+}
+
+// ErrTooManyFailures is returned for client addresses that failed to log in
+// too often
+var ErrTooManyFailures = errors.New("too many failed logins; try again later")
+
+var config = Defaults
+
+// Init sets the lockout settings, filling unset fields from Defaults
+func Init(c globals.LockoutConfig) {
+	if c.MaxFailures == 0 {
+		c.MaxFailures = Defaults.MaxFailures
+	}
+	if c.IpMaxFailures <= 0 {
+		c.IpMaxFailures = Defaults.IpMaxFailures
+	}
+	if c.DelayAfter <= 0 {
+		c.DelayAfter = Defaults.DelayAfter
+	}
+	if c.MaxDelaySeconds <= 0 {
+		c.MaxDelaySeconds = Defaults.MaxDelaySeconds
+	}
+	if c.WindowMinutes <= 0 {
+		c.WindowMinutes = Defaults.WindowMinutes
+	}
+	config = c
+}
+
+// windowStart Returns the SQL timestamp from which failures are counted
+func windowStart() string {
+	window := time.Duration(config.WindowMinutes) * time.Minute
+	return time.Now().UTC().Add(-window).Format(model.SqlDateTimeFormat)
+}
+
+// delay Returns how long to wait before checking the next attempt after the
+// given number of failures
+func delay(failures int) time.Duration {
+	if failures < config.DelayAfter {
+		return 0
+	}
+	seconds := math.Min(math.Pow(2, float64(failures-config.DelayAfter)), float64(config.MaxDelaySeconds))
+
+	return time.Duration(seconds) * time.Second
+}
+
+// Wait is called before checking the password of a login. It waits out the
+// delay earned by earlier failures, and refuses client addresses with too
+// many of them
+func Wait(username string, ipAddress string) error {
+	userFailures, ipFailures, err := model.CountLoginFailures(username, ipAddress, windowStart())
+	if err != nil {
+		return err
+	}
+	if ipFailures >= config.IpMaxFailures {
+		log.Println("WARN: Refusing login from " + ipAddress + " after " + strconv.Itoa(ipFailures) + " failures")
+		return ErrTooManyFailures
+	}
+
+	if d := delay(max(userFailures, ipFailures)); d > 0 {
+		time.Sleep(d)
+	}
+
+	return nil
+}
+
+// Failed records a failed login, and locks the account once it has failed
+// too often
+func Failed(username string, ipAddress string) {
+	if model.RecordLoginFailure(username, ipAddress) != nil || config.MaxFailures < 0 {
+		return
+	}
+
+	userFailures, _, err := model.CountLoginFailures(username, ipAddress, windowStart())
+	if err != nil || userFailures < config.MaxFailures {
+		return
+	}
+
+	user, err := model.GetUserByUserName(username)
+	if err != nil || user.UserName == "" || user.Status == "locked" {
+		return
+	}
+	if lastAdmin(user) {
+		log.Println("WARN: Not locking '" + username + "' after " + strconv.Itoa(userFailures) +
+			" failed logins, as they are the last admin")
+		return
+	}
+
+	reason := strconv.Itoa(userFailures) + " failed logins, the last from " + ipAddress
+	log.Println("WARN: Locking user '" + username + "' after " + reason)
+	model.SetUserStatus(username, model.UserStatus{Status: "locked", Reason: reason})
+}
+
+// Succeeded forgets the failed logins as a user name once they got in
+func Succeeded(username string) {
+	model.ClearLoginFailures(username)
+}
+
+// lastAdmin reports whether locking the user would leave nobody to unlock
+// accounts
+func lastAdmin(user model.User) bool {
+	isAdmin, err := model.UserHasRole(user.Id, model.RoleAdmin)
+	if err != nil || !isAdmin {
+		return err != nil
+	}
+	count, err := model.CountUsersWithRole(model.RoleAdmin)
+
+	return err != nil || count <= 1
+}
+
+// Start removes failed logins older than the window every hour
+func Start() {
+	go func() {
+		for {
+			count, err := model.DeleteOldLoginFailures(windowStart())
+			if err == nil && count > 0 {
+				log.Printf("INFO: Removed %d old failed logins\n", count)
+			}
+			time.Sleep(time.Hour)
+		}
+	}()
+}
@@ -2,24 +2,36 @@ package main
 
 import (
 	"encoding/json"
+	"net/http"
 	"os"
 	"path/filepath"
 	"strconv"
 
 	"github.com/gin-contrib/sessions"
-	"github.com/gin-contrib/sessions/cookie"
 	"github.com/gin-gonic/gin"
 
 	swaggerfiles "github.com/swaggo/files"
 	ginSwagger "github.com/swaggo/gin-swagger"
 
+	"github.com/greeneg/todoer/caldav"
+	"github.com/greeneg/todoer/clientcert"
+	"github.com/greeneg/todoer/collab"
 	"github.com/greeneg/todoer/controllers"
 	_ "github.com/greeneg/todoer/docs"
+	"github.com/greeneg/todoer/eventlog"
 	"github.com/greeneg/todoer/globals"
 	"github.com/greeneg/todoer/helpers"
+	"github.com/greeneg/todoer/ldapauth"
+	"github.com/greeneg/todoer/lockout"
 	"github.com/greeneg/todoer/middleware"
 	"github.com/greeneg/todoer/model"
+	"github.com/greeneg/todoer/notify"
+	"github.com/greeneg/todoer/oidc"
+	"github.com/greeneg/todoer/passhash"
+	"github.com/greeneg/todoer/passpolicy"
 	"github.com/greeneg/todoer/routes"
+	"github.com/greeneg/todoer/sessionstore"
+	"github.com/greeneg/todoer/webhooks"
 )
 
 //	@title		Todoer
@@ -66,8 +78,51 @@ func main() {
 	err = model.ConnectDatabase(TodoerService.ConfStruct.DbPath)
 	helpers.FatalCheckError(err)
 
-	// some defaults for using session support
-	r.Use(sessions.Sessions("todoer-session", cookie.NewStore(globals.Secret)))
+	// password hashing parameters
+	err = passhash.Init(TodoerService.ConfStruct.Passwords)
+	helpers.FatalCheckError(err)
+	err = passpolicy.Init(TodoerService.ConfStruct.Passwords, configDir)
+	helpers.FatalCheckError(err)
+
+	// failed logins are delayed and eventually lock the account
+	lockout.Init(TodoerService.ConfStruct.Lockout)
+	lockout.Start()
+
+	// passwords are checked against the Users table, then the directory
+	if TodoerService.ConfStruct.Ldap.Enabled {
+		directory, err := ldapauth.New(TodoerService.ConfStruct.Ldap, configDir)
+		helpers.FatalCheckError(err)
+		helpers.SetAuthenticators(helpers.SqliteAuthenticator{}, directory)
+	}
+
+	// service accounts may authenticate with client certificates
+	clientTLSConfig, err := clientcert.Init(TodoerService.ConfStruct, configDir)
+	helpers.FatalCheckError(err)
+
+	// users may also log in through an OpenID Connect identity provider
+	err = oidc.Init(TodoerService.ConfStruct.Oidc)
+	helpers.FatalCheckError(err)
+
+	// mail notifications are queued in the DB and delivered in the background
+	err = notify.Init(TodoerService.ConfStruct, configDir)
+	helpers.FatalCheckError(err)
+	notify.Start()
+
+	// recent events are kept so that streaming clients can resume
+	eventlog.Init(TodoerService.ConfStruct.EventLogSize)
+	collab.Init()
+
+	// registered webhooks receive events through a durable delivery queue
+	webhooks.Init(TodoerService.ConfStruct.Webhooks)
+	webhooks.Start()
+
+	// sessions are kept in the DB; the cookie only carries a signed session Id
+	secrets, err := sessionstore.LoadSecrets(TodoerService.ConfStruct.Sessions, configDir)
+	helpers.FatalCheckError(err)
+	sessionstore.Start()
+	store, err := sessionstore.NewStore(TodoerService.ConfStruct, secrets)
+	helpers.FatalCheckError(err)
+	r.Use(sessions.Sessions("todoer-session", store))
 
 	// API
 	public := r.Group("/api/v1")
@@ -77,6 +132,12 @@ func main() {
 	private.Use(middleware.AuthCheck)
 	routes.PrivateRoutes(private, TodoerService)
 
+	// CalDAV, authenticated like the API
+	dav := r.Group(caldav.Prefix)
+	dav.Use(middleware.AuthCheck)
+	routes.DavRoutes(dav, TodoerService)
+	routes.WellKnownRoutes(r)
+
 	// Front-end stuff
 
 	// swagger doc
@@ -86,7 +147,12 @@ func main() {
 	tlsTcpPort := strconv.Itoa(TodoerService.ConfStruct.TLSTcpPort)
 	tlsPemFile := TodoerService.ConfStruct.TLSPemFile
 	tlsKeyFile := TodoerService.ConfStruct.TLSKeyFile
-	if TodoerService.ConfStruct.UseTLS {
+	if TodoerService.ConfStruct.UseTLS && clientTLSConfig != nil {
+		// gin's RunTLS has no room for asking clients for certificates
+		server := &http.Server{Addr: ":" + tlsTcpPort, Handler: r.Handler(), TLSConfig: clientTLSConfig}
+		err = server.ListenAndServeTLS(tlsPemFile, tlsKeyFile)
+		helpers.FatalCheckError(err)
+	} else if TodoerService.ConfStruct.UseTLS {
 		r.RunTLS(":"+tlsTcpPort, tlsPemFile, tlsKeyFile)
 	} else {
 		r.Run(":" + tcpPort)
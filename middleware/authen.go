@@ -7,8 +7,10 @@ import (
 	"net/http"
 	"strings"
 
+	"github.com/greeneg/todoer/clientcert"
 	"github.com/greeneg/todoer/globals"
 	"github.com/greeneg/todoer/helpers"
+	"github.com/greeneg/todoer/lockout"
 	"github.com/greeneg/todoer/model"
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
@@ -16,44 +18,203 @@ import (
 
 func processAuthorizationHeader(authHeader string) (string, string) {
 	// split the header value at the space
-	encodedString := strings.Split(authHeader, " ")
+	scheme, encodedString, _ := strings.Cut(authHeader, " ")
+	if !strings.EqualFold(scheme, "Basic") {
+		return "", ""
+	}
 
 	// remove base64 encoding
-	decodedString, _ := base64.StdEncoding.DecodeString(encodedString[1])
+	decodedString, _ := base64.StdEncoding.DecodeString(strings.TrimSpace(encodedString))
+
+	// now lets return both the user name and the password, which may itself
+	// contain colons
+	username, password, _ := strings.Cut(string(decodedString), ":")
+
+	return username, password
+}
 
-	// now lets return both the
-	authValues := strings.Split(string(decodedString), ":")
+// unauthorized rejects a request, asking clients that support it, such as
+// CalDAV clients, to send Basic credentials
+func unauthorized(c *gin.Context, message string) {
+	c.Header("WWW-Authenticate", `Basic realm="todoer", charset="UTF-8"`)
+	c.IndentedJSON(http.StatusUnauthorized, gin.H{"error": message})
+	c.Abort()
+}
+
+// authenticateToken Checks a personal access token, and if it is valid sets
+// its user and scopes for the request. When username is set the token must
+// belong to that user
+func authenticateToken(c *gin.Context, session sessions.Session, token string, username string) bool {
+	accessToken, err := model.GetAccessToken(model.HashToken(strings.TrimSpace(token)))
+	if err != nil || accessToken.Id == 0 {
+		return false
+	}
+	user, err := model.GetUserById(accessToken.UserId)
+	if err != nil || user.UserName == "" || !helpers.CheckIsNotLocked(user) {
+		return false
+	}
+	if username != "" && user.UserName != username {
+		return false
+	}
+
+	model.TouchAccessToken(accessToken.Id)
+	session.Set(globals.UserKey, user.UserName)
+	c.Set(globals.ScopesKey, accessToken.Scopes)
+	return true
+}
+
+// scopedAuthenticated Lets a request authenticated with an access token or a
+// client certificate through if its scopes allow what the request needs.
+// credential names what the request was authenticated with
+func scopedAuthenticated(c *gin.Context, credential string) {
+	scopes := c.GetStringSlice(globals.ScopesKey)
+	if scope := requiredScope(c); !model.HasScope(scopes, scope) {
+		log.Println("ERROR: The " + credential + " lacks the '" + scope + "' scope. Aborting")
+		c.IndentedJSON(http.StatusForbidden, gin.H{"error": credential + " lacks the '" + scope + "' scope"})
+		c.Abort()
+		return
+	}
+	log.Println("INFO: Authenticated with a " + credential)
+	c.Next()
+}
+
+// authenticateCertificate Sets the user named by a verified client
+// certificate for the request, with the scopes certificate logins have. Only
+// unlocked service accounts may log in this way; admins and users with
+// two-factor authentication need more than a certificate
+func authenticateCertificate(c *gin.Context, session sessions.Session, username string) bool {
+	user, err := model.GetUserByUserName(username)
+	if err != nil || user.UserName == "" {
+		log.Println("WARN: Client certificate names unknown user '" + username + "'")
+		return false
+	}
+	if !user.ServiceAccount {
+		log.Println("WARN: Client certificate names user '" + username + "', who is not a service account")
+		return false
+	}
+	if !helpers.CheckIsNotLocked(user) {
+		log.Println("WARN: User '" + username + "' is locked!")
+		return false
+	}
+	isAdmin, err := model.UserHasRole(user.Id, model.RoleAdmin)
+	if err != nil || isAdmin {
+		log.Println("WARN: Client certificate names admin '" + username + "'")
+		return false
+	}
+	if requiresSecondFactor(username) {
+		log.Println("WARN: Client certificate names user '" + username + "', who has two-factor authentication")
+		return false
+	}
+
+	session.Set(globals.UserKey, user.UserName)
+	c.Set(globals.ScopesKey, clientcert.Scopes())
+	return true
+}
+
+// requiresSecondFactor reports whether the user has two-factor
+// authentication, failing closed when that cannot be checked
+func requiresSecondFactor(username string) bool {
+	user, err := model.GetUserByUserName(username)
+	if err != nil {
+		return true
+	}
+	enabled, err := model.TwoFactorEnabled(user.Id)
+
+	return err != nil || enabled
+}
+
+// mayUseExpiredPassword reports whether a request may go ahead with the Basic
+// credentials of a user. Once the password is older than the maximum password
+// age it is only good for changing itself
+func mayUseExpiredPassword(c *gin.Context, username string, password string) bool {
+	user, err := model.GetUserByUserName(username)
+	if err != nil {
+		return false
+	}
+	if !helpers.PasswordExpired(user, password) {
+		return true
+	}
 
-	return authValues[0], authValues[1]
+	return c.Request.Method == http.MethodPatch && c.FullPath() == "/api/v1/user/:name" && c.Param("name") == username
 }
 
 func AuthCheck(c *gin.Context) {
 	session := sessions.Default(c)
 	user := session.Get("user")
 	if user == nil {
+		// service accounts may send a client certificate naming their user
+		if certUser := clientcert.Username(c.Request); certUser != "" && authenticateCertificate(c, session, certUser) {
+			scopedAuthenticated(c, "client certificate")
+			return
+		}
+
 		log.Println("INFO: No session found. Attempting to check for authentication headers")
 		baHeader := c.GetHeader("Authorization")
 		if baHeader == "" {
 			log.Println("ERROR: No authentication header found. Aborting")
-			c.IndentedJSON(http.StatusUnauthorized, gin.H{"error": "not authorized!"})
-			c.Abort()
+			unauthorized(c, "not authorized!")
 			return
 		}
+
+		// personal access tokens are sent as bearer tokens
+		if scheme, token, _ := strings.Cut(baHeader, " "); strings.EqualFold(scheme, "Bearer") {
+			if !authenticateToken(c, session, token, "") {
+				log.Println("ERROR: Access token authentication failed. Aborting")
+				unauthorized(c, "not authorized!")
+				return
+			}
+			scopedAuthenticated(c, "access token")
+			return
+		}
+
 		// otherwise, lets process that header
 		username, password := processAuthorizationHeader(baHeader)
-		authStatus := helpers.CheckUserPass(username, password)
+
+		// clients that only speak Basic, such as CalDAV clients, may send a
+		// personal access token in place of the password
+		if !helpers.EmptyUserPass(username, password) && authenticateToken(c, session, password, username) {
+			scopedAuthenticated(c, "access token")
+			return
+		}
+
+		if err := lockout.Wait(username, c.ClientIP()); err != nil {
+			log.Println("ERROR: " + string(err.Error()) + ". Aborting")
+			c.IndentedJSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+			c.Abort()
+			return
+		}
+		authStatus := !helpers.EmptyUserPass(username, password) && helpers.CheckUserPass(username, password)
+		if !authStatus && username != "" {
+			lockout.Failed(username, c.ClientIP())
+		}
+		if authStatus && requiresSecondFactor(username) {
+			// a password alone is not enough for these users, and Basic
+			// credentials have no room for a one-time password. It counts as
+			// a failure, so that the password does not clear earlier ones
+			log.Println("ERROR: User '" + username + "' has two-factor authentication. Aborting")
+			lockout.Failed(username, c.ClientIP())
+			unauthorized(c, "two-factor authentication is enabled; use a personal access token instead of a password")
+			return
+		}
+		if authStatus {
+			lockout.Succeeded(username)
+		}
+		if authStatus && !mayUseExpiredPassword(c, username, password) {
+			log.Println("ERROR: Password of user '" + username + "' has expired. Aborting")
+			c.IndentedJSON(http.StatusForbidden, gin.H{"error": "password has expired; change it with PATCH /api/v1/user/" +
+				username, "passwordExpired": true})
+			c.Abort()
+			return
+		}
 		if authStatus {
+			// Basic credentials are sent with every request, so the user is
+			// only set for this request rather than starting a stored session;
+			// browsers log in with POST /api/v1/login instead
 			session.Set(globals.UserKey, username)
-			if err := session.Save(); err != nil {
-				c.IndentedJSON(http.StatusInternalServerError,
-					gin.H{"error": "failed to save user session"})
-				// session saving is not fatal, so allow them to proceed
-			}
 			log.Println("INFO: Authenticated")
 		} else {
 			log.Println("ERROR: Authentication failed. Aborting")
-			c.IndentedJSON(http.StatusUnauthorized, gin.H{"error": "not authorized!"})
-			c.Abort()
+			unauthorized(c, "not authorized!")
 			return
 		}
 	} else {
@@ -0,0 +1,152 @@
+package middleware
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/greeneg/todoer/globals"
+	"github.com/greeneg/todoer/model"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+)
+
+// forbidden rejects a request from an authenticated user who may not make it
+func forbidden(c *gin.Context) {
+	c.IndentedJSON(http.StatusForbidden, gin.H{"error": "Insufficient access. Access denied!"})
+	c.Abort()
+}
+
+// sessionUser Returns the user AuthCheck authenticated
+func sessionUser(c *gin.Context) (model.User, bool) {
+	user := sessions.Default(c).Get(globals.UserKey)
+	if user == nil {
+		return model.User{}, false
+	}
+	userObject, err := model.GetUserByUserName(fmt.Sprintf("%v", user))
+	if err != nil || userObject.UserName == "" {
+		return model.User{}, false
+	}
+
+	return userObject, true
+}
+
+// hasRole reports whether the user has the role, answering the request with
+// an error when the roles cannot be checked
+func hasRole(c *gin.Context, user model.User, role string) (bool, bool) {
+	has, err := model.UserHasRole(user.Id, role)
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+		c.Abort()
+		return false, false
+	}
+
+	return has, true
+}
+
+// RequireRole only lets users with the role through. It must follow
+// AuthCheck
+func RequireRole(role string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, ok := sessionUser(c)
+		if !ok {
+			forbidden(c)
+			return
+		}
+		has, ok := hasRole(c, user, role)
+		if !ok {
+			return
+		}
+		if !has {
+			log.Println("WARN: User '" + user.UserName + "' lacks the '" + role + "' role")
+			forbidden(c)
+			return
+		}
+		c.Next()
+	}
+}
+
+// mayManageAccount reports whether the user may manage the account named by
+// the :name path parameter. Admins manage any account. Org admins manage the
+// accounts of their own org unit, but not those of admins; on routes without
+// :name the handler confines them to their org unit. The second result is
+// false when the request has already been answered
+func mayManageAccount(c *gin.Context, user model.User) (bool, bool) {
+	isAdmin, ok := hasRole(c, user, model.RoleAdmin)
+	if !ok || isAdmin {
+		return isAdmin, ok
+	}
+	isOrgAdmin, ok := hasRole(c, user, model.RoleOrgAdmin)
+	if !ok || !isOrgAdmin {
+		return false, ok
+	}
+	if c.Param("name") == "" {
+		return true, true
+	}
+
+	// accounts of other org units look the same as missing ones
+	target, err := model.GetUserByUserName(c.Param("name"))
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+		c.Abort()
+		return false, false
+	}
+	if target.UserName == "" || target.OrgUnitId != user.OrgUnitId {
+		return false, true
+	}
+	targetIsAdmin, ok := hasRole(c, target, model.RoleAdmin)
+	if !ok {
+		return false, false
+	}
+
+	return !targetIsAdmin, true
+}
+
+// RequireAccountAdmin only lets admins, and org admins acting within their
+// org unit, through. It must follow AuthCheck
+func RequireAccountAdmin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, ok := sessionUser(c)
+		if !ok {
+			forbidden(c)
+			return
+		}
+		may, ok := mayManageAccount(c, user)
+		if !ok {
+			return
+		}
+		if !may {
+			log.Println("WARN: User '" + user.UserName + "' may not manage account '" + c.Param("name") + "'")
+			forbidden(c)
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireSelfOrAccountAdmin lets users act on their own account, named by the
+// :name path parameter, as well as those RequireAccountAdmin lets through. It
+// must follow AuthCheck
+func RequireSelfOrAccountAdmin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, ok := sessionUser(c)
+		if !ok {
+			forbidden(c)
+			return
+		}
+		if user.UserName == c.Param("name") {
+			c.Next()
+			return
+		}
+		may, ok := mayManageAccount(c, user)
+		if !ok {
+			return
+		}
+		if !may {
+			log.Println("WARN: User '" + user.UserName + "' may not act on account '" + c.Param("name") + "'")
+			forbidden(c)
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,36 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/greeneg/todoer/model"
+	"github.com/gin-gonic/gin"
+)
+
+// requiredScope Returns the scope an access token needs for the request.
+// Reading is todo:read and changing anything is todo:write, while webhooks,
+// org units, invitations, changes to user accounts and profiles, two-factor
+// settings and the tokens and sessions of a user need admin. The profile holds
+// the email address password reset mails go to
+func requiredScope(c *gin.Context) string {
+	path := c.FullPath()
+	if strings.HasPrefix(path, "/api/v1/webhooks") || strings.HasPrefix(path, "/api/v1/orgunits") ||
+		strings.HasPrefix(path, "/api/v1/invitations") {
+		return model.ScopeAdmin
+	}
+	if strings.HasPrefix(path, "/api/v1/user/:name/tokens") || strings.HasPrefix(path, "/api/v1/user/:name/sessions") ||
+		strings.HasPrefix(path, "/api/v1/me/2fa") {
+		return model.ScopeAdmin
+	}
+
+	switch c.Request.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, "PROPFIND", "REPORT":
+		return model.ScopeTodoRead
+	}
+	if strings.HasPrefix(path, "/api/v1/user") || path == "/api/v1/me/profile" {
+		return model.ScopeAdmin
+	}
+
+	return model.ScopeTodoWrite
+}
@@ -1,7 +1,27 @@
 package model
 
+import (
+	"database/sql"
+	"errors"
+	"log"
+)
+
 func GetStatusByName(s string) (int, error) {
+	q, err := DB.Prepare("SELECT Id FROM Statuses WHERE StatusName = ?")
+	if err != nil {
+		log.Println("ERROR: Could not prepare the DB query!" + string(err.Error()))
+		return 0, err
+	}
+
 	var id int
+	err = q.QueryRow(s).Scan(&id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, &InvalidTodoStatus{Err: errors.New("invalid todo status: " + s)}
+		}
+		log.Println("ERROR: Cannot retrieve status from DB: " + string(err.Error()))
+		return 0, err
+	}
 
 	return id, nil
 }
@@ -0,0 +1,165 @@
+package model
+
+import (
+	"database/sql"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// scopes a personal access token can be granted. todo:write includes
+// todo:read, and admin includes both
+const (
+	ScopeTodoRead  = "todo:read"
+	ScopeTodoWrite = "todo:write"
+	ScopeAdmin     = "admin"
+)
+
+var Scopes = []string{ScopeTodoRead, ScopeTodoWrite, ScopeAdmin}
+
+const accessTokenColumns = "Id, UserId, Name, Scopes, ExpiryDate, LastUsedDate, CreationDate"
+
+func scanAccessToken(r rowScanner) (AccessToken, error) {
+	token := AccessToken{}
+	var scopes string
+	var expiryDate, lastUsedDate sql.NullString
+	err := r.Scan(
+		&token.Id,
+		&token.UserId,
+		&token.Name,
+		&scopes,
+		&expiryDate,
+		&lastUsedDate,
+		&token.CreationDate,
+	)
+	token.Scopes = strings.Split(scopes, ",")
+	token.ExpiryDate = expiryDate.String
+	token.LastUsedDate = lastUsedDate.String
+
+	return token, err
+}
+
+// IsValidScope reports whether scope is one of Scopes
+func IsValidScope(scope string) bool {
+	for _, s := range Scopes {
+		if s == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+// HasScope reports whether the granted scopes allow what scope allows
+func HasScope(granted []string, scope string) bool {
+	for _, s := range granted {
+		switch {
+		case s == scope, s == ScopeAdmin:
+			return true
+		case s == ScopeTodoWrite && scope == ScopeTodoRead:
+			return true
+		}
+	}
+
+	return false
+}
+
+func GetAccessTokenById(id int) (AccessToken, error) {
+	token, err := scanAccessToken(DB.QueryRow("SELECT "+accessTokenColumns+" FROM AccessTokens WHERE Id = ?", id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return AccessToken{}, nil
+		}
+		log.Println("ERROR: Cannot retrieve access token '" + strconv.Itoa(id) + "': " + string(err.Error()))
+		return AccessToken{}, err
+	}
+
+	return token, nil
+}
+
+// GetAccessToken returns the unexpired token with the given hash, or an
+// empty token if there is none
+func GetAccessToken(tokenHash string) (AccessToken, error) {
+	token, err := scanAccessToken(DB.QueryRow("SELECT "+accessTokenColumns+" FROM AccessTokens "+
+		"WHERE TokenHash = ? AND (ExpiryDate IS NULL OR ExpiryDate > ?)", tokenHash, Now()))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return AccessToken{}, nil
+		}
+		log.Println("ERROR: Cannot retrieve access token: " + string(err.Error()))
+		return AccessToken{}, err
+	}
+
+	return token, nil
+}
+
+// GetAccessTokensForUser returns all tokens of a user, expired ones
+// included, newest first
+func GetAccessTokensForUser(userId int) ([]AccessToken, error) {
+	rows, err := DB.Query("SELECT "+accessTokenColumns+" FROM AccessTokens WHERE UserId = ? ORDER BY Id DESC", userId)
+	if err != nil {
+		log.Println("ERROR: Could not run the DB query!" + string(err.Error()))
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := make([]AccessToken, 0)
+	for rows.Next() {
+		token, err := scanAccessToken(rows)
+		if err != nil {
+			log.Println("ERROR: Cannot marshal the access token objects!" + string(err.Error()))
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+
+	return tokens, rows.Err()
+}
+
+// CreateAccessToken stores the hash of a new token for a user. The expiry
+// date must already be normalized
+func CreateAccessToken(userId int, p ProposedAccessToken, tokenHash string) (AccessToken, error) {
+	log.Println("INFO: Access token creation requested: " + p.Name)
+	result, err := DB.Exec("INSERT INTO AccessTokens (UserId, Name, TokenHash, Scopes, ExpiryDate, CreationDate) "+
+		"VALUES (?, ?, ?, ?, ?, ?)", userId, p.Name, tokenHash, strings.Join(p.Scopes, ","),
+		nullString(p.ExpiryDate), Now())
+	if err != nil {
+		log.Println("ERROR: Cannot create access token '" + p.Name + "': " + string(err.Error()))
+		return AccessToken{}, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return AccessToken{}, err
+	}
+
+	return GetAccessTokenById(int(id))
+}
+
+// DeleteAccessToken revokes one token of a user
+func DeleteAccessToken(userId int, id int) (bool, error) {
+	result, err := DB.Exec("DELETE FROM AccessTokens WHERE Id = ? AND UserId = ?", id, userId)
+	if err != nil {
+		log.Println("ERROR: Cannot delete access token '" + strconv.Itoa(id) + "': " + string(err.Error()))
+		return false, err
+	}
+
+	count, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+// TouchAccessToken records that a token was used, at most once a minute
+func TouchAccessToken(id int) error {
+	cutoff := time.Now().UTC().Add(-time.Minute).Format(SqlDateTimeFormat)
+	_, err := DB.Exec("UPDATE AccessTokens SET LastUsedDate = ? WHERE Id = ? AND "+
+		"(LastUsedDate IS NULL OR LastUsedDate < ?)", Now(), id, cutoff)
+	if err != nil {
+		log.Println("ERROR: Cannot update access token '" + strconv.Itoa(id) + "': " + string(err.Error()))
+	}
+
+	return err
+}
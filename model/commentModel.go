@@ -0,0 +1,106 @@
+package model
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+)
+
+const commentColumns = "Comments.Id, Comments.TodoId, Comments.AuthorId, Users.UserName, Comments.Body, " +
+	"Comments.CreationDate, Comments.LastChangedDate"
+
+const commentSelect = "SELECT " + commentColumns + " FROM Comments LEFT JOIN Users ON Comments.AuthorId = Users.Id"
+
+func scanComment(r rowScanner) (Comment, error) {
+	comment := Comment{}
+	var authorId sql.NullInt64
+	var author sql.NullString
+	err := r.Scan(
+		&comment.Id,
+		&comment.TodoId,
+		&authorId,
+		&author,
+		&comment.Body,
+		&comment.CreationDate,
+		&comment.LastChangedDate,
+	)
+	comment.AuthorId = int(authorId.Int64)
+	comment.Author = author.String
+
+	return comment, err
+}
+
+// GetCommentsForTodo returns the comments on a todo, oldest first
+func GetCommentsForTodo(todoId int) ([]Comment, error) {
+	log.Println("INFO: List of comment objects requested for todo: " + strconv.Itoa(todoId))
+	rows, err := DB.Query(commentSelect+" WHERE Comments.TodoId = ? ORDER BY Comments.Id", todoId)
+	if err != nil {
+		log.Println("ERROR: Could not run the DB query!" + string(err.Error()))
+		return nil, err
+	}
+	defer rows.Close()
+
+	comments := make([]Comment, 0)
+	for rows.Next() {
+		comment, err := scanComment(rows)
+		if err != nil {
+			log.Println("ERROR: Cannot marshal the comment objects!" + string(err.Error()))
+			return nil, err
+		}
+		comments = append(comments, comment)
+	}
+
+	return comments, rows.Err()
+}
+
+func GetCommentById(id int) (Comment, error) {
+	log.Println("INFO: Comment by Id requested: " + strconv.Itoa(id))
+	comment, err := scanComment(DB.QueryRow(commentSelect+" WHERE Comments.Id = ?", id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			log.Println("ERROR: No such comment found in DB: " + string(err.Error()))
+			return Comment{}, nil
+		}
+		log.Println("ERROR: Cannot retrieve comment from DB: " + string(err.Error()))
+		return Comment{}, err
+	}
+
+	return comment, nil
+}
+
+func CreateComment(todoId int, p ProposedComment, authorId int) (Comment, error) {
+	log.Println("INFO: Comment creation requested for todo: " + strconv.Itoa(todoId))
+	if strings.TrimSpace(p.Body) == "" {
+		return Comment{}, errors.New("comment body must not be empty")
+	}
+
+	result, err := DB.Exec("INSERT INTO Comments (TodoId, AuthorId, Body) VALUES (?, ?, ?)", todoId, authorId, p.Body)
+	if err != nil {
+		log.Println("ERROR: Cannot create comment on todo '" + strconv.Itoa(todoId) + "': " + string(err.Error()))
+		return Comment{}, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return Comment{}, err
+	}
+
+	return GetCommentById(int(id))
+}
+
+func DeleteComment(id int) (bool, error) {
+	idString := strconv.Itoa(id)
+	log.Println("INFO: Comment deletion requested: " + idString)
+	result, err := DB.Exec("DELETE FROM Comments WHERE Id = ?", id)
+	if err != nil {
+		log.Println("ERROR: Cannot delete comment '" + idString + "': " + string(err.Error()))
+		return false, err
+	}
+	count, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
@@ -23,3 +23,23 @@ type SchedulingConflict struct {
 func (s *SchedulingConflict) Error() string {
 	return "Scheduling conflict: Start or end of event conflicts with existing scheduled event"
 }
+
+type InvalidTodoStatus struct {
+	Err error
+}
+
+func (i *InvalidTodoStatus) Error() string {
+	return "Invalid value! Must be one of 'new', 'inprogress' or 'completed'"
+}
+
+// ImportItemFailed reports the item of an import that could not be stored.
+// Index is the position of the item in the import, and Item names it
+type ImportItemFailed struct {
+	Index int
+	Item  string
+	Err   error
+}
+
+func (i *ImportItemFailed) Error() string {
+	return i.Err.Error()
+}
@@ -0,0 +1,51 @@
+package model
+
+import (
+	"database/sql"
+	"log"
+	"strconv"
+)
+
+// SetFeedToken stores the hash of a user's calendar feed token, replacing
+// any previous one
+func SetFeedToken(userId int, tokenHash string) error {
+	_, err := DB.Exec("INSERT OR REPLACE INTO FeedTokens (UserId, TokenHash, CreationDate) VALUES (?, ?, ?)",
+		userId, tokenHash, Now())
+	if err != nil {
+		log.Println("ERROR: Cannot store feed token for user '" + strconv.Itoa(userId) + "': " + string(err.Error()))
+	}
+
+	return err
+}
+
+// DeleteFeedToken revokes a user's calendar feed token
+func DeleteFeedToken(userId int) (bool, error) {
+	result, err := DB.Exec("DELETE FROM FeedTokens WHERE UserId = ?", userId)
+	if err != nil {
+		log.Println("ERROR: Cannot delete feed token for user '" + strconv.Itoa(userId) + "': " + string(err.Error()))
+		return false, err
+	}
+
+	count, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+// GetUserByFeedToken returns the user a calendar feed token belongs to, or
+// an empty user if no user has it
+func GetUserByFeedToken(tokenHash string) (User, error) {
+	user, err := scanUser(DB.QueryRow("SELECT "+userColumns+" FROM Users WHERE Id = "+
+		"(SELECT UserId FROM FeedTokens WHERE TokenHash = ?)", tokenHash))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return User{}, nil
+		}
+		log.Println("ERROR: Cannot retrieve user by feed token: " + string(err.Error()))
+		return User{}, err
+	}
+
+	return user, nil
+}
@@ -0,0 +1,50 @@
+package model
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"time"
+)
+
+// SqlDateTimeFormat is the layout SQLite uses for DATETIME columns
+const SqlDateTimeFormat = "2006-01-02 15:04:05"
+
+// Now returns the current time formatted for storing in a DATETIME column
+func Now() string {
+	return time.Now().UTC().Format(SqlDateTimeFormat)
+}
+
+// NormalizeDate converts an RFC 3339 or SQL DATETIME string into the SQL
+// DATETIME layout. Empty strings are passed through unchanged
+func NormalizeDate(s string) (string, error) {
+	if s == "" {
+		return "", nil
+	}
+
+	for _, layout := range []string{time.RFC3339, SqlDateTimeFormat, "2006-01-02"} {
+		if d, err := time.Parse(layout, s); err == nil {
+			return d.UTC().Format(SqlDateTimeFormat), nil
+		}
+	}
+
+	_, err := time.Parse(time.RFC3339, s)
+	return "", err
+}
+
+// nullString maps an empty string to SQL NULL
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+// nullInt maps a zero id to SQL NULL
+func nullInt(i int) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(i), Valid: i != 0}
+}
+
+// HashToken returns the hex encoded SHA-256 hash of a secret token. Tokens
+// are random, so unlike passwords they need no salt or key stretching
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,50 @@
+package model
+
+import (
+	"database/sql"
+	"log"
+	"strconv"
+)
+
+const historyColumns = "Id, TodoId, Field, OldValue, NewValue, ChangeDate"
+
+func scanHistoryEntry(r rowScanner) (HistoryEntry, error) {
+	entry := HistoryEntry{}
+	var oldValue, newValue sql.NullString
+	err := r.Scan(
+		&entry.Id,
+		&entry.TodoId,
+		&entry.Field,
+		&oldValue,
+		&newValue,
+		&entry.ChangeDate,
+	)
+	entry.OldValue = oldValue.String
+	entry.NewValue = newValue.String
+
+	return entry, err
+}
+
+// GetTodoHistory returns the recorded changes to a todo, oldest first. The
+// history is kept by triggers on the Todos and TodoTags tables
+func GetTodoHistory(todoId int) ([]HistoryEntry, error) {
+	log.Println("INFO: History requested for todo: " + strconv.Itoa(todoId))
+	rows, err := DB.Query("SELECT "+historyColumns+" FROM TodoHistory WHERE TodoId = ? ORDER BY Id", todoId)
+	if err != nil {
+		log.Println("ERROR: Could not run the DB query!" + string(err.Error()))
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := make([]HistoryEntry, 0)
+	for rows.Next() {
+		entry, err := scanHistoryEntry(rows)
+		if err != nil {
+			log.Println("ERROR: Cannot marshal the history objects!" + string(err.Error()))
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, rows.Err()
+}
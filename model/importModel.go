@@ -0,0 +1,144 @@
+package model
+
+import (
+	"log"
+	"strconv"
+)
+
+// ImportTodos stores a batch of imported todos in a single transaction, so
+// either all of them are created or none are. Lists are looked up by name
+// among the lists the creator can see, preferring their own, and created
+// when missing. It returns the lists it created along with the new todos
+func ImportTodos(creatorId int, items []ImportedTodo) ([]List, []Todo, error) {
+	log.Println("INFO: Import of " + strconv.Itoa(len(items)) + " todos requested")
+	visible, err := GetListsForUser(creatorId)
+	if err != nil {
+		return nil, nil, err
+	}
+	listIds := make(map[string]int)
+	for _, l := range visible {
+		if _, seen := listIds[l.Name]; !seen || l.OwnerId == creatorId {
+			listIds[l.Name] = l.Id
+		}
+	}
+	statusIds := make(map[string]int)
+
+	t, err := DB.Begin()
+	if err != nil {
+		log.Println("ERROR: Could not start DB transaction!" + string(err.Error()))
+		return nil, nil, err
+	}
+	defer t.Rollback()
+
+	newLists := make([]int, 0)
+	todoIds := make([]int, 0, len(items))
+	for i, item := range items {
+		fail := func(err error) ([]List, []Todo, error) {
+			return nil, nil, &ImportItemFailed{Index: i, Err: err}
+		}
+		p := item.Todo
+		if err := ValidatePriority(p.Priority); err != nil {
+			return fail(err)
+		}
+		dueDate, err := NormalizeDate(p.DueDate)
+		if err != nil {
+			return fail(err)
+		}
+		remindDate, err := NormalizeDate(p.RemindDate)
+		if err != nil {
+			return fail(err)
+		}
+		creationDate, err := NormalizeDate(item.CreationDate)
+		if err != nil {
+			return fail(err)
+		}
+		if creationDate == "" {
+			creationDate = Now()
+		}
+		completionDate, err := NormalizeDate(item.CompletionDate)
+		if err != nil {
+			return fail(err)
+		}
+		tags, err := NormalizeTags(p.Tags)
+		if err != nil {
+			return fail(err)
+		}
+
+		status := item.Status
+		if status == "" {
+			status = "new"
+		}
+		statusId, found := statusIds[status]
+		if !found {
+			if statusId, err = GetStatusByName(status); err != nil {
+				return fail(err)
+			}
+			statusIds[status] = statusId
+		}
+		if status != "completed" {
+			completionDate = ""
+		} else if completionDate == "" {
+			completionDate = Now()
+		}
+
+		listId := p.ListId
+		if item.ListName != "" {
+			if listId, found = listIds[item.ListName]; !found {
+				result, err := t.Exec("INSERT INTO Lists (Name, OwnerId) VALUES (?, ?)", item.ListName, creatorId)
+				if err != nil {
+					log.Println("ERROR: Cannot create list '" + item.ListName + "': " + string(err.Error()))
+					return fail(err)
+				}
+				id, err := result.LastInsertId()
+				if err != nil {
+					return fail(err)
+				}
+				listId = int(id)
+				listIds[item.ListName] = listId
+				newLists = append(newLists, listId)
+			}
+		}
+
+		result, err := t.Exec("INSERT INTO Todos (Description, Status, CreatorId, AssigneeId, ListId, DueDate, "+
+			"RemindDate, CompletionDate, Priority, Uid, CreationDate, LastChangedDate) "+
+			"VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+			p.Description, statusId, creatorId, nullInt(p.AssigneeId), nullInt(listId), nullString(dueDate),
+			nullString(remindDate), nullString(completionDate), p.Priority, nullString(p.Uid), creationDate, Now())
+		if err != nil {
+			log.Println("ERROR: Cannot create todo with description '" + p.Description + "': " + string(err.Error()))
+			return fail(err)
+		}
+		id, err := result.LastInsertId()
+		if err != nil {
+			return fail(err)
+		}
+		if err = setTodoTags(t, int(id), tags); err != nil {
+			return fail(err)
+		}
+		todoIds = append(todoIds, int(id))
+	}
+
+	if err = t.Commit(); err != nil {
+		return nil, nil, err
+	}
+
+	lists := make([]List, 0, len(newLists))
+	for _, id := range newLists {
+		list, err := GetListById(id)
+		if err != nil {
+			return nil, nil, err
+		}
+		lists = append(lists, list)
+	}
+	todos := make([]Todo, 0, len(todoIds))
+	for _, id := range todoIds {
+		todo, err := GetTodoById(id)
+		if err != nil {
+			return nil, nil, err
+		}
+		todos = append(todos, todo)
+	}
+
+	log.Println("INFO: " + strconv.Itoa(len(todos)) + " todos imported")
+	return lists, todos, nil
+}
@@ -0,0 +1,232 @@
+package model
+
+import (
+	"database/sql"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// invitation states. Expired is never stored; pending invitations past
+// their expiry date are reported as expired
+const (
+	InvitationPending  = "pending"
+	InvitationAccepted = "accepted"
+	InvitationRevoked  = "revoked"
+	InvitationExpired  = "expired"
+)
+
+var InvitationStatuses = []string{InvitationPending, InvitationAccepted, InvitationRevoked, InvitationExpired}
+
+// invitationColumns reports pending invitations past their expiry date as
+// expired. It takes the current time as its one parameter
+const invitationColumns = "Id, Email, FullName, Roles, OrgUnitId, " +
+	"CASE WHEN Status = 'pending' AND ExpiryDate <= ? THEN 'expired' ELSE Status END AS Status, " +
+	"InvitedBy, UserId, ExpiryDate, AcceptedDate, RevokedDate, CreationDate"
+
+func scanInvitation(r rowScanner) (Invitation, error) {
+	invitation := Invitation{}
+	var email, fullName, acceptedDate, revokedDate sql.NullString
+	var invitedBy, userId sql.NullInt64
+	var roles string
+	err := r.Scan(
+		&invitation.Id,
+		&email,
+		&fullName,
+		&roles,
+		&invitation.OrgUnitId,
+		&invitation.Status,
+		&invitedBy,
+		&userId,
+		&invitation.ExpiryDate,
+		&acceptedDate,
+		&revokedDate,
+		&invitation.CreationDate,
+	)
+	invitation.Email = email.String
+	invitation.FullName = fullName.String
+	invitation.Roles = strings.Split(roles, ",")
+	invitation.InvitedBy = int(invitedBy.Int64)
+	invitation.UserId = int(userId.Int64)
+	invitation.AcceptedDate = acceptedDate.String
+	invitation.RevokedDate = revokedDate.String
+
+	return invitation, err
+}
+
+// IsValidInvitationStatus reports whether status is one of InvitationStatuses
+func IsValidInvitationStatus(status string) bool {
+	for _, s := range InvitationStatuses {
+		if s == status {
+			return true
+		}
+	}
+
+	return false
+}
+
+// GetInvitations returns the invitations of an org unit, or of all org units
+// when orgUnitId is 0, newest first. An empty status returns them all
+func GetInvitations(orgUnitId int, status string) ([]Invitation, error) {
+	query := "SELECT * FROM (SELECT " + invitationColumns + " FROM Invitations) WHERE 1 = 1"
+	args := []any{Now()}
+	if orgUnitId != 0 {
+		query += " AND OrgUnitId = ?"
+		args = append(args, orgUnitId)
+	}
+	if status != "" {
+		query += " AND Status = ?"
+		args = append(args, status)
+	}
+	rows, err := DB.Query(query+" ORDER BY Id DESC", args...)
+	if err != nil {
+		log.Println("ERROR: Could not run the DB query!" + string(err.Error()))
+		return nil, err
+	}
+	defer rows.Close()
+
+	invitations := make([]Invitation, 0)
+	for rows.Next() {
+		invitation, err := scanInvitation(rows)
+		if err != nil {
+			log.Println("ERROR: Cannot marshal the invitation objects!" + string(err.Error()))
+			return nil, err
+		}
+		invitations = append(invitations, invitation)
+	}
+
+	return invitations, rows.Err()
+}
+
+// GetInvitationById returns an invitation, or an empty one if there is none
+func GetInvitationById(id int) (Invitation, error) {
+	invitation, err := scanInvitation(DB.QueryRow("SELECT "+invitationColumns+" FROM Invitations WHERE Id = ?", Now(), id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return Invitation{}, nil
+		}
+		log.Println("ERROR: Cannot retrieve invitation '" + strconv.Itoa(id) + "': " + string(err.Error()))
+		return Invitation{}, err
+	}
+
+	return invitation, nil
+}
+
+// GetPendingInvitation returns the pending, unexpired invitation with the
+// given token hash, or an empty invitation if there is none
+func GetPendingInvitation(tokenHash string) (Invitation, error) {
+	now := Now()
+	invitation, err := scanInvitation(DB.QueryRow("SELECT "+invitationColumns+" FROM Invitations "+
+		"WHERE TokenHash = ? AND Status = 'pending' AND ExpiryDate > ?", now, tokenHash, now))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return Invitation{}, nil
+		}
+		log.Println("ERROR: Cannot retrieve invitation: " + string(err.Error()))
+		return Invitation{}, err
+	}
+
+	return invitation, nil
+}
+
+// CreateInvitation stores the hash of a new invitation token. The org unit,
+// roles and expiry date must already be checked
+func CreateInvitation(p ProposedInvitation, invitedBy int, tokenHash string) (Invitation, error) {
+	log.Println("INFO: Invitation requested by user: " + strconv.Itoa(invitedBy))
+	result, err := DB.Exec("INSERT INTO Invitations (TokenHash, Email, FullName, Roles, OrgUnitId, InvitedBy, "+
+		"ExpiryDate, CreationDate) VALUES (?, ?, ?, ?, ?, ?, ?, ?)", tokenHash, nullString(p.Email),
+		nullString(p.FullName), strings.Join(p.Roles, ","), p.OrgUnitId, invitedBy, p.ExpiryDate, Now())
+	if err != nil {
+		log.Println("ERROR: Cannot create invitation: " + string(err.Error()))
+		return Invitation{}, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return Invitation{}, err
+	}
+
+	return GetInvitationById(int(id))
+}
+
+// RevokeInvitation withdraws a pending invitation. It reports false when the
+// invitation is not pending, or has expired
+func RevokeInvitation(id int) (bool, error) {
+	now := Now()
+	result, err := DB.Exec("UPDATE Invitations SET Status = 'revoked', RevokedDate = ? "+
+		"WHERE Id = ? AND Status = 'pending' AND ExpiryDate > ?", now, id, now)
+	if err != nil {
+		log.Println("ERROR: Cannot revoke invitation '" + strconv.Itoa(id) + "': " + string(err.Error()))
+		return false, err
+	}
+
+	count, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+// AcceptInvitation creates the account of an invitee in the org unit and
+// with the roles of their invitation, and marks the invitation accepted. It
+// returns the new user, or an empty user when the token is not valid
+func AcceptInvitation(tokenHash string, p ProposedUser, fullName string, passwordHash string) (User, error) {
+	log.Println("INFO: User creation requested through invitation: " + p.UserName)
+	t, err := DB.Begin()
+	if err != nil {
+		log.Println("ERROR: Could not start DB transaction!" + string(err.Error()))
+		return User{}, err
+	}
+	defer t.Rollback()
+
+	now := Now()
+	var invitationId, orgUnitId int
+	var roles string
+	err = t.QueryRow("SELECT Id, OrgUnitId, Roles FROM Invitations WHERE TokenHash = ? AND Status = 'pending' "+
+		"AND ExpiryDate > ?", tokenHash, now).Scan(&invitationId, &orgUnitId, &roles)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return User{}, nil
+		}
+		log.Println("ERROR: Cannot retrieve invitation: " + string(err.Error()))
+		return User{}, err
+	}
+
+	result, err := t.Exec("INSERT INTO Users (UserName, FullName, Email, PasswordHash, OrgUnitId) VALUES (?, ?, ?, ?, ?)",
+		p.UserName, fullName, nullString(p.Email), passwordHash, orgUnitId)
+	if err != nil {
+		log.Println("ERROR: Cannot create user '" + p.UserName + "': " + string(err.Error()))
+		return User{}, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return User{}, err
+	}
+
+	// every user is a member, whatever else they were invited as
+	for _, role := range append([]string{RoleMember}, strings.Split(roles, ",")...) {
+		if role == "" {
+			continue
+		}
+		_, err = t.Exec("INSERT OR IGNORE INTO UserRoles (UserId, RoleId) SELECT ?, Id FROM Roles WHERE RoleName = ?",
+			id, role)
+		if err != nil {
+			log.Println("ERROR: Cannot grant role to user '" + p.UserName + "': " + string(err.Error()))
+			return User{}, err
+		}
+	}
+
+	_, err = t.Exec("UPDATE Invitations SET Status = 'accepted', UserId = ?, AcceptedDate = ? WHERE Id = ?",
+		id, now, invitationId)
+	if err != nil {
+		log.Println("ERROR: Cannot accept invitation '" + strconv.Itoa(invitationId) + "': " + string(err.Error()))
+		return User{}, err
+	}
+
+	if err := t.Commit(); err != nil {
+		return User{}, err
+	}
+
+	log.Println("INFO: User '" + p.UserName + "' created")
+	return GetUserById(int(id))
+}
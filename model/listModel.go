@@ -0,0 +1,205 @@
+package model
+
+import (
+	"database/sql"
+	"log"
+	"strconv"
+)
+
+const listColumns = "Id, Name, OwnerId, CreationDate, LastChangedDate"
+
+// visibleListIds is a sub-query selecting the lists a user owns or is a
+// member of. It takes the user Id twice
+const visibleListIds = "SELECT Id FROM Lists WHERE OwnerId = ? UNION SELECT ListId FROM ListMembers WHERE UserId = ?"
+
+func scanList(r rowScanner) (List, error) {
+	list := List{}
+	err := r.Scan(
+		&list.Id,
+		&list.Name,
+		&list.OwnerId,
+		&list.CreationDate,
+		&list.LastChangedDate,
+	)
+
+	return list, err
+}
+
+// CanViewList reports whether a user owns or is a member of a list
+func CanViewList(userId int, listId int) bool {
+	var count int
+	err := DB.QueryRow("SELECT COUNT(*) FROM ("+visibleListIds+") WHERE Id = ?", userId, userId, listId).Scan(&count)
+	if err != nil {
+		log.Println("ERROR: Cannot check access to list '" + strconv.Itoa(listId) + "': " + string(err.Error()))
+		return false
+	}
+
+	return count > 0
+}
+
+func CreateList(p ProposedList, ownerId int) (List, error) {
+	log.Println("INFO: List creation requested: " + p.Name)
+	t, err := DB.Begin()
+	if err != nil {
+		log.Println("ERROR: Could not start DB transaction!" + string(err.Error()))
+		return List{}, err
+	}
+	defer t.Rollback()
+
+	q, err := t.Prepare("INSERT INTO Lists (Name, OwnerId) VALUES (?, ?)")
+	if err != nil {
+		log.Println("ERROR: Could not prepare the DB query!" + string(err.Error()))
+		return List{}, err
+	}
+
+	result, err := q.Exec(p.Name, ownerId)
+	if err != nil {
+		log.Println("ERROR: Cannot create list '" + p.Name + "': " + string(err.Error()))
+		return List{}, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return List{}, err
+	}
+
+	if err = t.Commit(); err != nil {
+		return List{}, err
+	}
+
+	log.Println("INFO: List '" + p.Name + "' created")
+	return GetListById(int(id))
+}
+
+func RenameList(id int, name string) (List, error) {
+	return RenameListAt(id, name, Now())
+}
+
+// RenameListAt renames a list, recording the change as made at the given SQL
+// timestamp
+func RenameListAt(id int, name string, changedAt string) (List, error) {
+	idString := strconv.Itoa(id)
+	log.Println("INFO: List rename requested: " + idString)
+	_, err := DB.Exec("UPDATE Lists SET Name = ?, LastChangedDate = ? WHERE Id = ?", name, changedAt, id)
+	if err != nil {
+		log.Println("ERROR: Cannot rename list '" + idString + "': " + string(err.Error()))
+		return List{}, err
+	}
+
+	return GetListById(id)
+}
+
+func DeleteList(id int) (bool, error) {
+	idString := strconv.Itoa(id)
+	log.Println("INFO: List deletion requested: " + idString)
+	t, err := DB.Begin()
+	if err != nil {
+		log.Println("ERROR: Could not start DB transaction!" + string(err.Error()))
+		return false, err
+	}
+	defer t.Rollback()
+
+	q, err := t.Prepare("DELETE FROM Lists WHERE Id IS ?")
+	if err != nil {
+		log.Println("ERROR: Could not prepare the DB query!" + string(err.Error()))
+		return false, err
+	}
+
+	_, err = q.Exec(id)
+	if err != nil {
+		log.Println("ERROR: Cannot delete list '" + idString + "': " + string(err.Error()))
+		return false, err
+	}
+
+	if err = t.Commit(); err != nil {
+		return false, err
+	}
+
+	log.Println("INFO: List with Id '" + idString + "' has been deleted")
+	return true, nil
+}
+
+func GetListById(id int) (List, error) {
+	log.Println("INFO: List by Id requested: " + strconv.Itoa(id))
+	rec, err := DB.Prepare("SELECT " + listColumns + " FROM Lists WHERE Id = ?")
+	if err != nil {
+		log.Println("ERROR: Could not prepare the DB query!" + string(err.Error()))
+		return List{}, err
+	}
+
+	list, err := scanList(rec.QueryRow(id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			log.Println("ERROR: No such list found in DB: " + string(err.Error()))
+			return List{}, nil
+		}
+		log.Println("ERROR: Cannot retrieve list from DB: " + string(err.Error()))
+		return List{}, err
+	}
+
+	return list, nil
+}
+
+// GetListsForUser returns the lists a user owns or is a member of
+func GetListsForUser(userId int) ([]List, error) {
+	log.Println("INFO: List of list objects requested for user: " + strconv.Itoa(userId))
+	rows, err := DB.Query("SELECT "+listColumns+" FROM Lists WHERE Id IN ("+visibleListIds+") ORDER BY Id",
+		userId, userId)
+	if err != nil {
+		log.Println("ERROR: Could not run the DB query!" + string(err.Error()))
+		return nil, err
+	}
+	defer rows.Close()
+
+	lists := make([]List, 0)
+	for rows.Next() {
+		list, err := scanList(rows)
+		if err != nil {
+			log.Println("ERROR: Cannot marshal the list objects!" + string(err.Error()))
+			return nil, err
+		}
+		lists = append(lists, list)
+	}
+
+	return lists, rows.Err()
+}
+
+// GetListMembers returns the users a list is shared with, not including its owner
+func GetListMembers(listId int) ([]User, error) {
+	rows, err := DB.Query("SELECT "+userColumns+" FROM Users WHERE Id IN "+
+		"(SELECT UserId FROM ListMembers WHERE ListId = ?) ORDER BY UserName", listId)
+	if err != nil {
+		log.Println("ERROR: Could not run the DB query!" + string(err.Error()))
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := make([]User, 0)
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			log.Println("ERROR: Cannot marshal the user objects!" + string(err.Error()))
+			return nil, err
+		}
+		users = append(users, user)
+	}
+
+	return users, rows.Err()
+}
+
+func AddListMember(listId int, userId int) error {
+	_, err := DB.Exec("INSERT OR IGNORE INTO ListMembers (ListId, UserId) VALUES (?, ?)", listId, userId)
+	if err != nil {
+		log.Println("ERROR: Cannot add member to list '" + strconv.Itoa(listId) + "': " + string(err.Error()))
+	}
+
+	return err
+}
+
+func RemoveListMember(listId int, userId int) error {
+	_, err := DB.Exec("DELETE FROM ListMembers WHERE ListId = ? AND UserId = ?", listId, userId)
+	if err != nil {
+		log.Println("ERROR: Cannot remove member from list '" + strconv.Itoa(listId) + "': " + string(err.Error()))
+	}
+
+	return err
+}
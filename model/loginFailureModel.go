@@ -0,0 +1,73 @@
+package model
+
+import (
+	"database/sql"
+	"log"
+)
+
+// execer is satisfied by both *sql.DB and *sql.Tx
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+// RecordLoginFailure records a failed login for a user name from a client
+// address. The user name need not exist
+func RecordLoginFailure(username string, ipAddress string) error {
+	_, err := DB.Exec("INSERT INTO LoginFailures (UserName, IpAddress, FailureDate) VALUES (?, ?, ?)",
+		username, ipAddress, Now())
+	if err != nil {
+		log.Println("ERROR: Cannot record failed login for user '" + username + "': " + string(err.Error()))
+	}
+
+	return err
+}
+
+// CountLoginFailures returns how many logins as a user name and from a
+// client address failed since the given SQL timestamp
+func CountLoginFailures(username string, ipAddress string, since string) (int, int, error) {
+	var userCount, ipCount int
+	err := DB.QueryRow("SELECT "+
+		"(SELECT COUNT(*) FROM LoginFailures WHERE UserName = ? AND FailureDate > ?), "+
+		"(SELECT COUNT(*) FROM LoginFailures WHERE IpAddress = ? AND FailureDate > ?)",
+		username, since, ipAddress, since).Scan(&userCount, &ipCount)
+	if err != nil {
+		log.Println("ERROR: Cannot count failed logins: " + string(err.Error()))
+		return 0, 0, err
+	}
+
+	return userCount, ipCount, nil
+}
+
+// ClearLoginFailures forgets the failed logins as a user name, once they have
+// logged in or been unlocked. Those of client addresses are kept
+func ClearLoginFailures(username string) error {
+	return clearLoginFailures(DB, username)
+}
+
+// clearLoginFailures forgets the failed logins as a user name within e, so
+// that unlocking a user can do it in the same transaction
+func clearLoginFailures(e execer, username string) error {
+	_, err := e.Exec("DELETE FROM LoginFailures WHERE UserName = ?", username)
+	if err != nil {
+		log.Println("ERROR: Cannot clear failed logins of user '" + username + "': " + string(err.Error()))
+	}
+
+	return err
+}
+
+// DeleteOldLoginFailures removes the failed logins from before the given SQL
+// timestamp, returning how many were removed
+func DeleteOldLoginFailures(before string) (int, error) {
+	result, err := DB.Exec("DELETE FROM LoginFailures WHERE FailureDate <= ?", before)
+	if err != nil {
+		log.Println("ERROR: Cannot remove old failed logins: " + string(err.Error()))
+		return 0, err
+	}
+
+	count, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(count), nil
+}
@@ -0,0 +1,86 @@
+package model
+
+import (
+	"database/sql"
+	"log"
+	"strconv"
+)
+
+// GetUserByOidcSubject returns the user an identity provider account is
+// linked to, or an empty user if it is not linked yet
+func GetUserByOidcSubject(issuer string, subject string) (User, error) {
+	user, err := scanUser(DB.QueryRow("SELECT "+userColumns+" FROM Users WHERE Id = "+
+		"(SELECT UserId FROM OidcIdentities WHERE Issuer = ? AND Subject = ?)", issuer, subject))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return User{}, nil
+		}
+		log.Println("ERROR: Cannot retrieve user of OpenID Connect subject '" + subject + "': " + string(err.Error()))
+		return User{}, err
+	}
+
+	return user, nil
+}
+
+// GetOidcSubject returns the identity provider account of an issuer a user
+// is linked to, or an empty string if there is none
+func GetOidcSubject(userId int, issuer string) (string, error) {
+	var subject string
+	err := DB.QueryRow("SELECT Subject FROM OidcIdentities WHERE UserId = ? AND Issuer = ?", userId, issuer).Scan(&subject)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", nil
+		}
+		log.Println("ERROR: Cannot retrieve OpenID Connect subject of user '" + strconv.Itoa(userId) + "': " +
+			string(err.Error()))
+		return "", err
+	}
+
+	return subject, nil
+}
+
+// LinkOidcIdentity links an identity provider account to a user, or records
+// another login of one that is already linked
+func LinkOidcIdentity(userId int, issuer string, subject string) error {
+	now := Now()
+	_, err := DB.Exec("INSERT INTO OidcIdentities (Issuer, Subject, UserId, CreationDate, LastLoginDate) "+
+		"VALUES (?, ?, ?, ?, ?) ON CONFLICT (Issuer, Subject) DO UPDATE SET LastLoginDate = excluded.LastLoginDate",
+		issuer, subject, userId, now, now)
+	if err != nil {
+		log.Println("ERROR: Cannot link OpenID Connect subject '" + subject + "' to user '" + strconv.Itoa(userId) +
+			"': " + string(err.Error()))
+	}
+
+	return err
+}
+
+// CreateOidcUser creates a member for an identity provider account on its
+// first login and links the two
+func CreateOidcUser(p ProposedUser, fullName string, issuer string, subject string) (User, error) {
+	log.Println("INFO: User creation requested for OpenID Connect subject '" + subject + "': " + p.UserName)
+	t, err := DB.Begin()
+	if err != nil {
+		log.Println("ERROR: Could not start DB transaction!" + string(err.Error()))
+		return User{}, err
+	}
+	defer t.Rollback()
+
+	id, err := insertExternalUser(t, p, fullName, SourceOidc, "")
+	if err != nil {
+		return User{}, err
+	}
+	now := Now()
+	_, err = t.Exec("INSERT INTO OidcIdentities (Issuer, Subject, UserId, CreationDate, LastLoginDate) VALUES (?, ?, ?, ?, ?)",
+		issuer, subject, id, now, now)
+	if err != nil {
+		log.Println("ERROR: Cannot link OpenID Connect subject '" + subject + "': " + string(err.Error()))
+		return User{}, err
+	}
+
+	if err := t.Commit(); err != nil {
+		return User{}, err
+	}
+
+	log.Println("INFO: User '" + p.UserName + "' created")
+	return GetUserById(id)
+}
@@ -0,0 +1,200 @@
+package model
+
+import (
+	"database/sql"
+	"log"
+	"strconv"
+)
+
+// DefaultOrgUnitId is the org unit users belong to unless they are put in
+// another one
+const DefaultOrgUnitId = 1
+
+const orgUnitColumns = "Id, OrgUnitName, Description, CreationDate"
+
+func scanOrgUnit(r rowScanner) (OrgUnit, error) {
+	orgUnit := OrgUnit{}
+	err := r.Scan(
+		&orgUnit.Id,
+		&orgUnit.OrgUnitName,
+		&orgUnit.Description,
+		&orgUnit.CreationDate,
+	)
+
+	return orgUnit, err
+}
+
+func GetOrgUnits() ([]OrgUnit, error) {
+	log.Println("INFO: List of org unit objects requested")
+	rows, err := DB.Query("SELECT " + orgUnitColumns + " FROM OrgUnits ORDER BY Id")
+	if err != nil {
+		log.Println("ERROR: Could not run the DB query!" + string(err.Error()))
+		return nil, err
+	}
+	defer rows.Close()
+
+	orgUnits := make([]OrgUnit, 0)
+	for rows.Next() {
+		orgUnit, err := scanOrgUnit(rows)
+		if err != nil {
+			log.Println("ERROR: Cannot marshal the org unit objects!" + string(err.Error()))
+			return nil, err
+		}
+		orgUnits = append(orgUnits, orgUnit)
+	}
+
+	return orgUnits, rows.Err()
+}
+
+// GetOrgUnitById returns the org unit, or an empty org unit if there is none
+func GetOrgUnitById(id int) (OrgUnit, error) {
+	orgUnit, err := scanOrgUnit(DB.QueryRow("SELECT "+orgUnitColumns+" FROM OrgUnits WHERE Id = ?", id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return OrgUnit{}, nil
+		}
+		log.Println("ERROR: Cannot retrieve org unit '" + strconv.Itoa(id) + "': " + string(err.Error()))
+		return OrgUnit{}, err
+	}
+
+	return orgUnit, nil
+}
+
+func CreateOrgUnit(p ProposedOrgUnit) (OrgUnit, error) {
+	log.Println("INFO: Org unit creation requested: " + p.OrgUnitName)
+	result, err := DB.Exec("INSERT INTO OrgUnits (OrgUnitName, Description, CreationDate) VALUES (?, ?, ?)",
+		p.OrgUnitName, p.Description, Now())
+	if err != nil {
+		log.Println("ERROR: Cannot create org unit '" + p.OrgUnitName + "': " + string(err.Error()))
+		return OrgUnit{}, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return OrgUnit{}, err
+	}
+
+	return GetOrgUnitById(int(id))
+}
+
+// DeleteOrgUnit removes an org unit. It fails while users still belong to it
+func DeleteOrgUnit(id int) (bool, error) {
+	log.Println("INFO: Org unit deletion requested: " + strconv.Itoa(id))
+	result, err := DB.Exec("DELETE FROM OrgUnits WHERE Id = ?", id)
+	if err != nil {
+		log.Println("ERROR: Cannot delete org unit '" + strconv.Itoa(id) + "': " + string(err.Error()))
+		return false, err
+	}
+
+	count, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+// CountUsersInOrgUnit returns how many users belong to an org unit
+func CountUsersInOrgUnit(id int) (int, error) {
+	var count int
+	err := DB.QueryRow("SELECT COUNT(*) FROM Users WHERE OrgUnitId = ?", id).Scan(&count)
+	if err != nil {
+		log.Println("ERROR: Cannot count users in org unit '" + strconv.Itoa(id) + "': " + string(err.Error()))
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// SameOrgUnit reports whether both users exist and belong to the same org
+// unit
+func SameOrgUnit(userId int, otherId int) bool {
+	var count int
+	err := DB.QueryRow("SELECT COUNT(*) FROM Users AS a JOIN Users AS b ON a.OrgUnitId = b.OrgUnitId "+
+		"WHERE a.Id = ? AND b.Id = ?", userId, otherId).Scan(&count)
+	if err != nil {
+		log.Println("ERROR: Cannot compare org units of users: " + string(err.Error()))
+		return false
+	}
+
+	return count > 0
+}
+
+// GetUsersInOrgUnit returns the users of an org unit
+func GetUsersInOrgUnit(id int) ([]User, error) {
+	rows, err := DB.Query("SELECT "+userColumns+" FROM Users WHERE OrgUnitId = ? ORDER BY UserName", id)
+	if err != nil {
+		log.Println("ERROR: Could not run the DB query!" + string(err.Error()))
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := make([]User, 0)
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			log.Println("ERROR: Cannot marshal the user objects!" + string(err.Error()))
+			return nil, err
+		}
+		users = append(users, user)
+	}
+
+	return users, rows.Err()
+}
+
+// SetUserOrgUnit moves a user to another org unit. Nothing may be shared
+// across org units, so in the same transaction the user leaves the lists of
+// their old org unit, the members of their own lists from there are dropped,
+// todos lose the assignees and lists that now belong to another org unit
+// than their creator, and the todos of the user take their tags along
+func SetUserOrgUnit(userId int, orgUnitId int) error {
+	log.Println("INFO: Org unit change requested for user: " + strconv.Itoa(userId))
+	t, err := DB.Begin()
+	if err != nil {
+		log.Println("ERROR: Could not start DB transaction!" + string(err.Error()))
+		return err
+	}
+	defer t.Rollback()
+
+	_, err = t.Exec("UPDATE Users SET OrgUnitId = ?, LastChangedDate = ? WHERE Id = ?", orgUnitId, Now(), userId)
+	if err != nil {
+		log.Println("ERROR: Cannot change org unit of user: " + string(err.Error()))
+		return err
+	}
+
+	_, err = t.Exec("DELETE FROM ListMembers WHERE (UserId = ? OR ListId IN (SELECT Id FROM Lists WHERE OwnerId = ?)) AND "+
+		"(SELECT OrgUnitId FROM Users WHERE Id = UserId) IS NOT "+
+		"(SELECT OrgUnitId FROM Users WHERE Id = (SELECT OwnerId FROM Lists WHERE Id = ListId))", userId, userId)
+	if err != nil {
+		log.Println("ERROR: Cannot remove list members of user: " + string(err.Error()))
+		return err
+	}
+
+	_, err = t.Exec("UPDATE Todos SET "+
+		"AssigneeId = CASE WHEN (SELECT OrgUnitId FROM Users WHERE Id = AssigneeId) IS NOT "+
+		"(SELECT OrgUnitId FROM Users WHERE Id = CreatorId) THEN NULL ELSE AssigneeId END, "+
+		"ListId = CASE WHEN (SELECT OrgUnitId FROM Users WHERE Id = (SELECT OwnerId FROM Lists WHERE Id = ListId)) IS NOT "+
+		"(SELECT OrgUnitId FROM Users WHERE Id = CreatorId) THEN NULL ELSE ListId END "+
+		"WHERE CreatorId = ? OR AssigneeId = ? OR ListId IN (SELECT Id FROM Lists WHERE OwnerId = ?)",
+		userId, userId, userId)
+	if err != nil {
+		log.Println("ERROR: Cannot detach todos of user: " + string(err.Error()))
+		return err
+	}
+
+	_, err = t.Exec("INSERT OR IGNORE INTO Tags (Name, OrgUnitId) SELECT DISTINCT Tags.Name, ? FROM TodoTags "+
+		"INNER JOIN Tags ON TodoTags.TagId = Tags.Id INNER JOIN Todos ON TodoTags.TodoId = Todos.Id "+
+		"WHERE Todos.CreatorId = ?", orgUnitId, userId)
+	if err != nil {
+		log.Println("ERROR: Cannot move tags of user: " + string(err.Error()))
+		return err
+	}
+	_, err = t.Exec("UPDATE TodoTags SET TagId = (SELECT Moved.Id FROM Tags INNER JOIN Tags AS Moved "+
+		"ON Moved.Name = Tags.Name AND Moved.OrgUnitId = ? WHERE Tags.Id = TodoTags.TagId) "+
+		"WHERE TodoId IN (SELECT Id FROM Todos WHERE CreatorId = ?)", orgUnitId, userId)
+	if err != nil {
+		log.Println("ERROR: Cannot move tags of user: " + string(err.Error()))
+		return err
+	}
+
+	return t.Commit()
+}
@@ -0,0 +1,123 @@
+package model
+
+import (
+	"database/sql"
+	"log"
+	"strconv"
+)
+
+const mailColumns = "Id, Kind, Recipient, Subject, TextBody, HtmlBody, Status, Attempts, LastError, " +
+	"NextAttemptDate, CreationDate, SentDate"
+
+func scanMail(r rowScanner) (MailMessage, error) {
+	m := MailMessage{}
+	var lastError, sentDate sql.NullString
+	err := r.Scan(
+		&m.Id,
+		&m.Kind,
+		&m.Recipient,
+		&m.Subject,
+		&m.TextBody,
+		&m.HtmlBody,
+		&m.Status,
+		&m.Attempts,
+		&lastError,
+		&m.NextAttemptDate,
+		&m.CreationDate,
+		&sentDate,
+	)
+	m.LastError = lastError.String
+	m.SentDate = sentDate.String
+
+	return m, err
+}
+
+// EnqueueMail stores a rendered message in the outbox for the delivery worker
+func EnqueueMail(m MailMessage) error {
+	log.Println("INFO: Queueing '" + m.Kind + "' mail for " + m.Recipient)
+	t, err := DB.Begin()
+	if err != nil {
+		log.Println("ERROR: Could not start DB transaction!" + string(err.Error()))
+		return err
+	}
+	defer t.Rollback()
+
+	q, err := t.Prepare("INSERT INTO MailOutbox (Kind, Recipient, Subject, TextBody, HtmlBody, NextAttemptDate) " +
+		"VALUES (?, ?, ?, ?, ?, ?)")
+	if err != nil {
+		log.Println("ERROR: Could not prepare the DB query!" + string(err.Error()))
+		return err
+	}
+
+	_, err = q.Exec(m.Kind, m.Recipient, m.Subject, m.TextBody, m.HtmlBody, Now())
+	if err != nil {
+		log.Println("ERROR: Cannot queue mail for '" + m.Recipient + "': " + string(err.Error()))
+		return err
+	}
+
+	return t.Commit()
+}
+
+// GetDeliverableMail returns pending messages whose next attempt is due
+func GetDeliverableMail(now string, limit int) ([]MailMessage, error) {
+	rows, err := DB.Query("SELECT "+mailColumns+" FROM MailOutbox WHERE Status = 'pending' AND NextAttemptDate <= ?"+
+		" ORDER BY NextAttemptDate LIMIT ?", now, limit)
+	if err != nil {
+		log.Println("ERROR: Could not run the DB query!" + string(err.Error()))
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages := make([]MailMessage, 0)
+	for rows.Next() {
+		m, err := scanMail(rows)
+		if err != nil {
+			log.Println("ERROR: Cannot marshal the mail objects!" + string(err.Error()))
+			return nil, err
+		}
+		messages = append(messages, m)
+	}
+
+	return messages, rows.Err()
+}
+
+func MarkMailSent(id int) error {
+	_, err := DB.Exec("UPDATE MailOutbox SET Status = 'sent', Attempts = Attempts + 1, LastError = NULL, "+
+		"SentDate = ? WHERE Id = ?", Now(), id)
+	if err != nil {
+		log.Println("ERROR: Cannot mark mail '" + strconv.Itoa(id) + "' as sent: " + string(err.Error()))
+	}
+
+	return err
+}
+
+// RecordMailFailure stores a failed delivery attempt. When nextAttempt is
+// empty the message is given up on and marked as failed
+func RecordMailFailure(id int, lastError string, nextAttempt string) error {
+	status := "pending"
+	if nextAttempt == "" {
+		status = "failed"
+		nextAttempt = Now()
+	}
+
+	_, err := DB.Exec("UPDATE MailOutbox SET Status = ?, Attempts = Attempts + 1, LastError = ?, "+
+		"NextAttemptDate = ? WHERE Id = ?", status, lastError, nextAttempt, id)
+	if err != nil {
+		log.Println("ERROR: Cannot record failure for mail '" + strconv.Itoa(id) + "': " + string(err.Error()))
+	}
+
+	return err
+}
+
+// HasMailSince reports whether a message of the given kind was queued for
+// the recipient at or after the given SQL timestamp
+func HasMailSince(kind string, recipient string, since string) (bool, error) {
+	var count int
+	err := DB.QueryRow("SELECT COUNT(*) FROM MailOutbox WHERE Kind = ? AND Recipient = ? AND CreationDate >= ?",
+		kind, recipient, since).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
@@ -0,0 +1,67 @@
+package model
+
+import (
+	"database/sql"
+	"log"
+	"strconv"
+)
+
+// MaxPasswordHistory is the number of replaced password hashes kept per user
+const MaxPasswordHistory = 24
+
+// recordPasswordHistory keeps the current password hash of the user matching
+// where, about to be replaced, and forgets the oldest ones beyond
+// MaxPasswordHistory
+func recordPasswordHistory(t *sql.Tx, where string, arg any) error {
+	var userId int
+	var passwordHash string
+	err := t.QueryRow("SELECT Id, PasswordHash FROM Users WHERE "+where, arg).Scan(&userId, &passwordHash)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		log.Println("ERROR: Cannot retrieve password hash: " + string(err.Error()))
+		return err
+	}
+
+	_, err = t.Exec("INSERT INTO PasswordHistory (UserId, PasswordHash, CreationDate) VALUES (?, ?, ?)",
+		userId, passwordHash, Now())
+	if err != nil {
+		log.Println("ERROR: Cannot record password history of user '" + strconv.Itoa(userId) + "': " +
+			string(err.Error()))
+		return err
+	}
+	_, err = t.Exec("DELETE FROM PasswordHistory WHERE UserId = ? AND Id NOT IN "+
+		"(SELECT Id FROM PasswordHistory WHERE UserId = ? ORDER BY Id DESC LIMIT ?)", userId, userId, MaxPasswordHistory)
+	if err != nil {
+		log.Println("ERROR: Cannot prune password history of user '" + strconv.Itoa(userId) + "': " +
+			string(err.Error()))
+	}
+
+	return err
+}
+
+// GetPasswordHistory returns the hashes of the last count passwords of a
+// user, newest first, starting with the current one
+func GetPasswordHistory(userId int, count int) ([]string, error) {
+	rows, err := DB.Query("SELECT PasswordHash FROM (SELECT PasswordHash, 1 AS Current, 0 AS Id FROM Users WHERE Id = ? "+
+		"UNION ALL SELECT PasswordHash, 0, Id FROM PasswordHistory WHERE UserId = ?) "+
+		"ORDER BY Current DESC, Id DESC LIMIT ?", userId, userId, count)
+	if err != nil {
+		log.Println("ERROR: Cannot retrieve password history of user '" + strconv.Itoa(userId) + "': " +
+			string(err.Error()))
+		return nil, err
+	}
+	defer rows.Close()
+
+	hashes := make([]string, 0)
+	for rows.Next() {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			return nil, err
+		}
+		hashes = append(hashes, hash)
+	}
+
+	return hashes, rows.Err()
+}
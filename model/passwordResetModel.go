@@ -0,0 +1,93 @@
+package model
+
+import (
+	"database/sql"
+	"log"
+	"strconv"
+)
+
+// CreatePasswordReset stores the hash of a password reset token for a user,
+// and forgets the expired tokens of everyone
+func CreatePasswordReset(userId int, tokenHash string, expiryDate string) error {
+	log.Println("INFO: Password reset requested for user: " + strconv.Itoa(userId))
+	now := Now()
+	if _, err := DB.Exec("DELETE FROM PasswordResets WHERE ExpiryDate <= ?", now); err != nil {
+		log.Println("ERROR: Cannot remove expired password reset tokens: " + string(err.Error()))
+		return err
+	}
+
+	_, err := DB.Exec("INSERT INTO PasswordResets (UserId, TokenHash, ExpiryDate, CreationDate) VALUES (?, ?, ?, ?)",
+		userId, tokenHash, expiryDate, now)
+	if err != nil {
+		log.Println("ERROR: Cannot store password reset token: " + string(err.Error()))
+	}
+
+	return err
+}
+
+// GetPasswordResetUser returns the user of an unused, unexpired reset token,
+// or an empty user when the token is not valid
+func GetPasswordResetUser(tokenHash string) (User, error) {
+	user, err := scanUser(DB.QueryRow("SELECT "+userColumns+" FROM Users WHERE Id = "+
+		"(SELECT UserId FROM PasswordResets WHERE TokenHash = ? AND UsedDate IS NULL AND ExpiryDate > ?)",
+		tokenHash, Now()))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return User{}, nil
+		}
+		log.Println("ERROR: Cannot retrieve user of password reset token: " + string(err.Error()))
+		return User{}, err
+	}
+
+	return user, nil
+}
+
+// ResetPassword sets a new password hash for the user of an unused, unexpired
+// reset token. The token and any others of the user are used up and the
+// user's sessions are revoked. It returns the user, or an empty user when the
+// token is not valid
+func ResetPassword(tokenHash string, passwordHash string) (User, error) {
+	t, err := DB.Begin()
+	if err != nil {
+		log.Println("ERROR: Could not start DB transaction!" + string(err.Error()))
+		return User{}, err
+	}
+	defer t.Rollback()
+
+	now := Now()
+	var userId int
+	err = t.QueryRow("SELECT UserId FROM PasswordResets WHERE TokenHash = ? AND UsedDate IS NULL AND ExpiryDate > ?",
+		tokenHash, now).Scan(&userId)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return User{}, nil
+		}
+		log.Println("ERROR: Cannot retrieve password reset token: " + string(err.Error()))
+		return User{}, err
+	}
+
+	if _, err := t.Exec("UPDATE PasswordResets SET UsedDate = ? WHERE UserId = ? AND UsedDate IS NULL", now, userId); err != nil {
+		log.Println("ERROR: Cannot use password reset token: " + string(err.Error()))
+		return User{}, err
+	}
+	if err := recordPasswordHistory(t, "Id = ?", userId); err != nil {
+		return User{}, err
+	}
+	_, err = t.Exec("UPDATE Users SET PasswordHash = ?, LastChangedDate = ?, PasswordChangedDate = ? WHERE Id = ?",
+		passwordHash, now, now, userId)
+	if err != nil {
+		log.Println("ERROR: Cannot store updated password hash in DB: " + string(err.Error()))
+		return User{}, err
+	}
+	if _, err := t.Exec("DELETE FROM Sessions WHERE UserId = ?", userId); err != nil {
+		log.Println("ERROR: Cannot revoke sessions: " + string(err.Error()))
+		return User{}, err
+	}
+
+	if err := t.Commit(); err != nil {
+		return User{}, err
+	}
+
+	log.Println("INFO: Password reset for user: " + strconv.Itoa(userId))
+	return GetUserById(userId)
+}
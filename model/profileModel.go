@@ -0,0 +1,142 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/mail"
+	"strconv"
+	"strings"
+	"time"
+	_ "time/tzdata"
+
+	"golang.org/x/text/language"
+)
+
+// SortFields are the todo fields clients may sort by default, descending when
+// prefixed with "-"
+var SortFields = []string{"id", "description", "status", "priority", "dueDate", "creationDate", "lastChangedDate"}
+
+// DefaultPreferences are the preferences of users who have not set any
+func DefaultPreferences() UserPreferences {
+	return UserPreferences{
+		Notifications: NotificationPreferences{
+			Assignments: true,
+			Mentions:    true,
+			Reminders:   true,
+			Digest:      true,
+		},
+	}
+}
+
+// decodePreferences reads stored preferences, taking the defaults for the
+// settings missing from them
+func decodePreferences(stored string) UserPreferences {
+	preferences := DefaultPreferences()
+	if stored != "" {
+		if err := json.Unmarshal([]byte(stored), &preferences); err != nil {
+			log.Println("WARN: Ignoring unreadable user preferences: " + string(err.Error()))
+			return DefaultPreferences()
+		}
+	}
+
+	return preferences
+}
+
+// ValidateTimezone checks that a time zone is a known IANA time zone name. An
+// empty time zone is not set
+func ValidateTimezone(timezone string) error {
+	if timezone == "" {
+		return nil
+	}
+	if timezone == "Local" {
+		return errors.New("unknown time zone '" + timezone + "'")
+	}
+	if _, err := time.LoadLocation(timezone); err != nil {
+		return errors.New("unknown time zone '" + timezone + "'")
+	}
+
+	return nil
+}
+
+// NormalizeLocale checks that a locale is a BCP 47 language tag, also taking
+// POSIX style underscores, and returns it in its canonical form. An empty
+// locale is not set
+func NormalizeLocale(locale string) (string, error) {
+	if locale == "" {
+		return "", nil
+	}
+	tag, err := language.Parse(strings.ReplaceAll(locale, "_", "-"))
+	if err != nil {
+		return "", errors.New("invalid locale '" + locale + "'")
+	}
+
+	return tag.String(), nil
+}
+
+// ValidateEmail checks that an email address is a plain address, without a
+// display name. An empty address is not set
+func ValidateEmail(email string) error {
+	if email == "" {
+		return nil
+	}
+	address, err := mail.ParseAddress(email)
+	if err != nil || address.Address != email {
+		return errors.New("invalid email address '" + email + "'")
+	}
+
+	return nil
+}
+
+// ValidatePreferences checks that the default list of a user is one they can
+// see and the default sort names a todo field
+func ValidatePreferences(userId int, p UserPreferences) error {
+	if p.DefaultListId != 0 && !CanViewList(userId, p.DefaultListId) {
+		return errors.New("no records found with list id " + strconv.Itoa(p.DefaultListId))
+	}
+	if p.DefaultSort != "" {
+		field := strings.TrimPrefix(p.DefaultSort, "-")
+		known := false
+		for _, f := range SortFields {
+			if f == field {
+				known = true
+			}
+		}
+		if !known {
+			return errors.New("unknown sort field '" + field + "'")
+		}
+	}
+
+	return nil
+}
+
+// GetProfile returns the profile of a user
+func GetProfile(u User) UserProfile {
+	return UserProfile{
+		FullName:    u.FullName,
+		Email:       u.Email,
+		Timezone:    u.Timezone,
+		Locale:      u.Locale,
+		Preferences: u.Preferences,
+	}
+}
+
+// UpdateProfile stores the profile of a user, which must already be
+// validated, and returns the updated user
+func UpdateProfile(userId int, p UserProfile) (User, error) {
+	log.Println("INFO: Profile update requested for user: " + strconv.Itoa(userId))
+	preferences, err := json.Marshal(p.Preferences)
+	if err != nil {
+		return User{}, err
+	}
+
+	_, err = DB.Exec("UPDATE Users SET FullName = ?, Email = ?, Timezone = ?, Locale = ?, Preferences = ?, "+
+		"LastChangedDate = ? WHERE Id = ?", p.FullName, nullString(p.Email), nullString(p.Timezone),
+		nullString(p.Locale), string(preferences), Now(), userId)
+	if err != nil {
+		log.Println("ERROR: Cannot update profile of user '" + strconv.Itoa(userId) + "': " + string(err.Error()))
+		return User{}, err
+	}
+
+	return GetUserById(userId)
+}
@@ -0,0 +1,128 @@
+package model
+
+import (
+	"database/sql"
+	"log"
+)
+
+// built-in roles. Every user is a member; admins manage user accounts, roles,
+// org units and webhooks, and org admins the accounts of their org unit
+const (
+	RoleAdmin    = "admin"
+	RoleMember   = "member"
+	RoleOrgAdmin = "orgadmin"
+)
+
+const roleColumns = "Id, RoleName, Description, CreationDate"
+
+func scanRole(r rowScanner) (Role, error) {
+	role := Role{}
+	err := r.Scan(
+		&role.Id,
+		&role.RoleName,
+		&role.Description,
+		&role.CreationDate,
+	)
+
+	return role, err
+}
+
+func queryRoles(query string, args ...any) ([]Role, error) {
+	rows, err := DB.Query(query, args...)
+	if err != nil {
+		log.Println("ERROR: Could not run the DB query!" + string(err.Error()))
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := make([]Role, 0)
+	for rows.Next() {
+		role, err := scanRole(rows)
+		if err != nil {
+			log.Println("ERROR: Cannot marshal the role objects!" + string(err.Error()))
+			return nil, err
+		}
+		roles = append(roles, role)
+	}
+
+	return roles, rows.Err()
+}
+
+func GetRoles() ([]Role, error) {
+	log.Println("INFO: List of role objects requested")
+	return queryRoles("SELECT " + roleColumns + " FROM Roles ORDER BY Id")
+}
+
+// GetRoleByName returns the named role, or an empty role if there is none
+func GetRoleByName(roleName string) (Role, error) {
+	role, err := scanRole(DB.QueryRow("SELECT "+roleColumns+" FROM Roles WHERE RoleName = ?", roleName))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return Role{}, nil
+		}
+		log.Println("ERROR: Cannot retrieve role '" + roleName + "': " + string(err.Error()))
+		return Role{}, err
+	}
+
+	return role, nil
+}
+
+// GetUserRoles returns the roles granted to a user
+func GetUserRoles(userId int) ([]Role, error) {
+	return queryRoles("SELECT "+roleColumns+" FROM Roles WHERE Id IN "+
+		"(SELECT RoleId FROM UserRoles WHERE UserId = ?) ORDER BY Id", userId)
+}
+
+// UserHasRole reports whether a user has been granted the named role
+func UserHasRole(userId int, roleName string) (bool, error) {
+	var found int
+	err := DB.QueryRow("SELECT COUNT(*) FROM UserRoles WHERE UserId = ? AND RoleId = "+
+		"(SELECT Id FROM Roles WHERE RoleName = ?)", userId, roleName).Scan(&found)
+	if err != nil {
+		log.Println("ERROR: Cannot check roles of user: " + string(err.Error()))
+		return false, err
+	}
+
+	return found > 0, nil
+}
+
+// CountUsersWithRole returns how many enabled users have the named role
+func CountUsersWithRole(roleName string) (int, error) {
+	var count int
+	err := DB.QueryRow("SELECT COUNT(*) FROM UserRoles JOIN Users ON Users.Id = UserRoles.UserId "+
+		"WHERE Users.Status = 'enabled' AND RoleId = (SELECT Id FROM Roles WHERE RoleName = ?)", roleName).Scan(&count)
+	if err != nil {
+		log.Println("ERROR: Cannot count users with role '" + roleName + "': " + string(err.Error()))
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// GrantRole gives a user a role. Granting a role the user already has is not
+// an error
+func GrantRole(userId int, roleId int) error {
+	_, err := DB.Exec("INSERT OR IGNORE INTO UserRoles (UserId, RoleId, CreationDate) VALUES (?, ?, ?)",
+		userId, roleId, Now())
+	if err != nil {
+		log.Println("ERROR: Cannot grant role: " + string(err.Error()))
+	}
+
+	return err
+}
+
+// RevokeRole takes a role away from a user, reporting whether they had it
+func RevokeRole(userId int, roleId int) (bool, error) {
+	result, err := DB.Exec("DELETE FROM UserRoles WHERE UserId = ? AND RoleId = ?", userId, roleId)
+	if err != nil {
+		log.Println("ERROR: Cannot revoke role: " + string(err.Error()))
+		return false, err
+	}
+
+	count, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
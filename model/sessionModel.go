@@ -0,0 +1,188 @@
+package model
+
+import (
+	"database/sql"
+	"log"
+	"strconv"
+	"time"
+)
+
+const sessionColumns = "Id, UserId, UserAgent, IpAddress, CreationDate, LastSeenDate, ExpiryDate"
+
+// sessionTouchInterval limits how often a session's last seen date is
+// written, so that every request does not cost a DB write
+const sessionTouchInterval = time.Minute
+
+func scanSession(r rowScanner) (Session, error) {
+	s := Session{}
+	var userId sql.NullInt64
+	var userAgent, ipAddress sql.NullString
+	err := r.Scan(
+		&s.Id,
+		&userId,
+		&userAgent,
+		&ipAddress,
+		&s.CreationDate,
+		&s.LastSeenDate,
+		&s.ExpiryDate,
+	)
+	s.UserId = int(userId.Int64)
+	s.UserAgent = userAgent.String
+	s.IpAddress = ipAddress.String
+
+	return s, err
+}
+
+// GetSessionData returns the encoded values of a session that has neither
+// expired nor been idle since idleCutoff, and whether there is one
+func GetSessionData(tokenHash string, idleCutoff string) ([]byte, bool, error) {
+	var data []byte
+	err := DB.QueryRow("SELECT Data FROM Sessions WHERE TokenHash = ? AND ExpiryDate > ? AND LastSeenDate > ?",
+		tokenHash, Now(), idleCutoff).Scan(&data)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, false, nil
+		}
+		log.Println("ERROR: Cannot retrieve session: " + string(err.Error()))
+		return nil, false, err
+	}
+
+	return data, true, nil
+}
+
+// CreateSession stores a new session. userId is zero for sessions that do
+// not belong to a user yet
+func CreateSession(tokenHash string, userId int, data []byte, userAgent string, ipAddress string, expiryDate string) error {
+	_, err := DB.Exec("INSERT INTO Sessions (TokenHash, UserId, Data, UserAgent, IpAddress, CreationDate, "+
+		"LastSeenDate, ExpiryDate) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		tokenHash, nullInt(userId), data, nullString(userAgent), nullString(ipAddress), Now(), Now(), expiryDate)
+	if err != nil {
+		log.Println("ERROR: Cannot store session: " + string(err.Error()))
+	}
+
+	return err
+}
+
+// UpdateSession replaces the values of an existing session, leaving its
+// expiry date alone. It returns false when the session no longer exists, for
+// instance because it was revoked
+func UpdateSession(tokenHash string, userId int, data []byte) (bool, error) {
+	result, err := DB.Exec("UPDATE Sessions SET UserId = ?, Data = ?, LastSeenDate = ? WHERE TokenHash = ?",
+		nullInt(userId), data, Now(), tokenHash)
+	if err != nil {
+		log.Println("ERROR: Cannot update session: " + string(err.Error()))
+		return false, err
+	}
+
+	count, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+// TouchSession records that a session was used
+func TouchSession(tokenHash string) error {
+	cutoff := time.Now().UTC().Add(-sessionTouchInterval).Format(SqlDateTimeFormat)
+	_, err := DB.Exec("UPDATE Sessions SET LastSeenDate = ? WHERE TokenHash = ? AND LastSeenDate < ?",
+		Now(), tokenHash, cutoff)
+	if err != nil {
+		log.Println("ERROR: Cannot update session: " + string(err.Error()))
+	}
+
+	return err
+}
+
+// DeleteSession removes a session by its token hash, as on logout
+func DeleteSession(tokenHash string) error {
+	_, err := DB.Exec("DELETE FROM Sessions WHERE TokenHash = ?", tokenHash)
+	if err != nil {
+		log.Println("ERROR: Cannot delete session: " + string(err.Error()))
+	}
+
+	return err
+}
+
+// GetSessionsForUser returns the live sessions of a user, most recently used
+// first
+func GetSessionsForUser(userId int, idleCutoff string) ([]Session, error) {
+	rows, err := DB.Query("SELECT "+sessionColumns+" FROM Sessions WHERE UserId = ? AND ExpiryDate > ? "+
+		"AND LastSeenDate > ? ORDER BY LastSeenDate DESC, Id DESC", userId, Now(), idleCutoff)
+	if err != nil {
+		log.Println("ERROR: Could not run the DB query!" + string(err.Error()))
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := make([]Session, 0)
+	for rows.Next() {
+		s, err := scanSession(rows)
+		if err != nil {
+			log.Println("ERROR: Cannot marshal the session objects!" + string(err.Error()))
+			return nil, err
+		}
+		sessions = append(sessions, s)
+	}
+
+	return sessions, rows.Err()
+}
+
+// DeleteSessionsForUser revokes every session of a user and returns how many
+// were removed
+func DeleteSessionsForUser(userId int) (int, error) {
+	result, err := DB.Exec("DELETE FROM Sessions WHERE UserId = ?", userId)
+	if err != nil {
+		log.Println("ERROR: Cannot delete sessions for user '" + strconv.Itoa(userId) + "': " + string(err.Error()))
+		return 0, err
+	}
+
+	count, err := result.RowsAffected()
+	return int(count), err
+}
+
+// DeleteSessionForUser revokes one session of a user
+func DeleteSessionForUser(userId int, id int) (bool, error) {
+	result, err := DB.Exec("DELETE FROM Sessions WHERE Id = ? AND UserId = ?", id, userId)
+	if err != nil {
+		log.Println("ERROR: Cannot delete session '" + strconv.Itoa(id) + "': " + string(err.Error()))
+		return false, err
+	}
+
+	count, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+// DeleteExpiredSessions removes sessions past their expiry date or unused
+// since idleCutoff
+func DeleteExpiredSessions(idleCutoff string) (int, error) {
+	result, err := DB.Exec("DELETE FROM Sessions WHERE ExpiryDate <= ? OR LastSeenDate <= ?", Now(), idleCutoff)
+	if err != nil {
+		log.Println("ERROR: Cannot delete expired sessions: " + string(err.Error()))
+		return 0, err
+	}
+
+	count, err := result.RowsAffected()
+	return int(count), err
+}
+
+// GetSessionUserId returns the Id of the user an unexpired session belongs
+// to, zero when it has none, and whether the session exists
+func GetSessionUserId(tokenHash string) (int, bool, error) {
+	var userId sql.NullInt64
+	err := DB.QueryRow("SELECT UserId FROM Sessions WHERE TokenHash = ? AND ExpiryDate > ?",
+		tokenHash, Now()).Scan(&userId)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, false, nil
+		}
+		log.Println("ERROR: Cannot retrieve session: " + string(err.Error()))
+		return 0, false, err
+	}
+
+	return int(userId.Int64), true, nil
+}
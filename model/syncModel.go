@@ -0,0 +1,135 @@
+package model
+
+import (
+	"database/sql"
+	"log"
+	"strconv"
+	"strings"
+)
+
+const changeColumns = "Seq, EntityType, EntityId, Operation, Audience, ChangeDate"
+
+func scanChange(r rowScanner) (Change, error) {
+	change := Change{}
+	var audience sql.NullString
+	err := r.Scan(
+		&change.Seq,
+		&change.EntityType,
+		&change.EntityId,
+		&change.Operation,
+		&audience,
+		&change.ChangeDate,
+	)
+	change.Audience = audience.String
+
+	return change, err
+}
+
+// Concerns reports whether a user could see the entity before a delete or
+// revoke change. Changes without an audience concern everyone
+func (c Change) Concerns(userId int) bool {
+	if c.Audience == "" {
+		return true
+	}
+
+	return strings.Contains(c.Audience, ","+strconv.Itoa(userId)+",")
+}
+
+// latestChangeSeq returns the highest sequence number handed out so far
+func latestChangeSeq(t *sql.Tx) (int, error) {
+	var seq int
+	err := t.QueryRow("SELECT seq FROM sqlite_sequence WHERE name = 'Changes'").Scan(&seq)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+
+	return seq, err
+}
+
+// GetChangesSince returns the change log after the given sequence number,
+// oldest first, along with the latest sequence number. Both are read in one
+// transaction so that no change falls between them
+func GetChangesSince(since int) ([]Change, int, error) {
+	log.Println("INFO: Changes requested since: " + strconv.Itoa(since))
+	t, err := DB.Begin()
+	if err != nil {
+		log.Println("ERROR: Could not start DB transaction!" + string(err.Error()))
+		return nil, 0, err
+	}
+	defer t.Rollback()
+
+	latest, err := latestChangeSeq(t)
+	if err != nil {
+		log.Println("ERROR: Cannot retrieve the latest change: " + string(err.Error()))
+		return nil, 0, err
+	}
+
+	rows, err := t.Query("SELECT "+changeColumns+" FROM Changes WHERE Seq > ? AND Seq <= ? ORDER BY Seq", since, latest)
+	if err != nil {
+		log.Println("ERROR: Could not run the DB query!" + string(err.Error()))
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	changes := make([]Change, 0)
+	for rows.Next() {
+		change, err := scanChange(rows)
+		if err != nil {
+			log.Println("ERROR: Cannot marshal the change objects!" + string(err.Error()))
+			return nil, 0, err
+		}
+		changes = append(changes, change)
+	}
+
+	return changes, latest, rows.Err()
+}
+
+// GetLatestChangeSeq returns the highest sequence number handed out so far
+func GetLatestChangeSeq() (int, error) {
+	t, err := DB.Begin()
+	if err != nil {
+		log.Println("ERROR: Could not start DB transaction!" + string(err.Error()))
+		return 0, err
+	}
+	defer t.Rollback()
+
+	return latestChangeSeq(t)
+}
+
+// GetFieldVersions returns when each field of an entity was last changed, as
+// SQL timestamps keyed by field name. Fields never changed since creation are
+// missing
+func GetFieldVersions(entityType string, entityId int) (map[string]string, error) {
+	rows, err := DB.Query("SELECT Field, ModifiedDate FROM FieldVersions WHERE EntityType = ? AND EntityId = ?",
+		entityType, entityId)
+	if err != nil {
+		log.Println("ERROR: Could not run the DB query!" + string(err.Error()))
+		return nil, err
+	}
+	defer rows.Close()
+
+	versions := make(map[string]string)
+	for rows.Next() {
+		var field, modified string
+		if err := rows.Scan(&field, &modified); err != nil {
+			log.Println("ERROR: Cannot marshal the field versions!" + string(err.Error()))
+			return nil, err
+		}
+		if versions[field], err = NormalizeDate(modified); err != nil {
+			return nil, err
+		}
+	}
+
+	return versions, rows.Err()
+}
+
+func setFieldVersion(t *sql.Tx, entityType string, entityId int, field string, modified string) error {
+	_, err := t.Exec("INSERT OR REPLACE INTO FieldVersions (EntityType, EntityId, Field, ModifiedDate) VALUES (?, ?, ?, ?)",
+		entityType, entityId, field, modified)
+	if err != nil {
+		log.Println("ERROR: Cannot record change to field '" + field + "' of " + entityType + " '" +
+			strconv.Itoa(entityId) + "': " + string(err.Error()))
+	}
+
+	return err
+}
@@ -0,0 +1,215 @@
+package model
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const tagColumns = "Id, Name, OrgUnitId, CreationDate"
+
+// todoOrgUnit selects the org unit of the creator of a todo, which its tags
+// belong to
+const todoOrgUnit = "SELECT Users.OrgUnitId FROM Todos INNER JOIN Users ON Todos.CreatorId = Users.Id WHERE Todos.Id = ?"
+
+// visibleTodoIds selects the todos a user can see, as CanViewTodo decides
+const visibleTodoIds = "SELECT Id FROM Todos WHERE CreatorId = ? OR AssigneeId = ? OR ListId IN (" + visibleListIds + ")"
+
+func scanTag(r rowScanner) (Tag, error) {
+	tag := Tag{}
+	err := r.Scan(
+		&tag.Id,
+		&tag.Name,
+		&tag.OrgUnitId,
+		&tag.CreationDate,
+	)
+
+	return tag, err
+}
+
+// NormalizeTags trims, de-duplicates and sorts tag names, rejecting names
+// that are empty or contain a comma or whitespace
+func NormalizeTags(names []string) ([]string, error) {
+	seen := make(map[string]bool)
+	tags := make([]string, 0, len(names))
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" || strings.ContainsAny(name, ", \t\r\n") {
+			return nil, errors.New("invalid tag name '" + name + "': tags must not be empty or contain commas or spaces")
+		}
+		if !seen[name] {
+			seen[name] = true
+			tags = append(tags, name)
+		}
+	}
+	sort.Strings(tags)
+
+	return tags, nil
+}
+
+// setTodoTags replaces the tags of a todo, creating any tags that do not
+// exist yet in the org unit of its creator
+func setTodoTags(t *sql.Tx, todoId int, names []string) error {
+	keep := make(map[string]bool)
+	for _, name := range names {
+		keep[name] = true
+	}
+
+	rows, err := t.Query("SELECT Tags.Id, Tags.Name FROM TodoTags INNER JOIN Tags ON TodoTags.TagId = Tags.Id "+
+		"WHERE TodoTags.TodoId = ?", todoId)
+	if err != nil {
+		log.Println("ERROR: Could not run the DB query!" + string(err.Error()))
+		return err
+	}
+	stale := make([]int, 0)
+	for rows.Next() {
+		var id int
+		var name string
+		if err := rows.Scan(&id, &name); err != nil {
+			rows.Close()
+			return err
+		}
+		if !keep[name] {
+			stale = append(stale, id)
+		}
+	}
+	rows.Close()
+
+	for _, tagId := range stale {
+		if _, err := t.Exec("DELETE FROM TodoTags WHERE TodoId = ? AND TagId = ?", todoId, tagId); err != nil {
+			log.Println("ERROR: Cannot remove tag from todo '" + strconv.Itoa(todoId) + "': " + string(err.Error()))
+			return err
+		}
+	}
+
+	for _, name := range names {
+		if _, err := t.Exec("INSERT OR IGNORE INTO Tags (Name, OrgUnitId) SELECT ?, ("+todoOrgUnit+")",
+			name, todoId); err != nil {
+			log.Println("ERROR: Cannot create tag '" + name + "': " + string(err.Error()))
+			return err
+		}
+		if _, err := t.Exec("INSERT OR IGNORE INTO TodoTags (TodoId, TagId) SELECT ?, Id FROM Tags WHERE Name = ? AND "+
+			"OrgUnitId = ("+todoOrgUnit+")", todoId, name, todoId); err != nil {
+			log.Println("ERROR: Cannot tag todo '" + strconv.Itoa(todoId) + "': " + string(err.Error()))
+			return err
+		}
+	}
+
+	return nil
+}
+
+// queryTags returns the tags a query selects
+func queryTags(query string, args ...any) ([]Tag, error) {
+	rows, err := DB.Query(query, args...)
+	if err != nil {
+		log.Println("ERROR: Could not run the DB query!" + string(err.Error()))
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := make([]Tag, 0)
+	for rows.Next() {
+		tag, err := scanTag(rows)
+		if err != nil {
+			log.Println("ERROR: Cannot marshal the tag objects!" + string(err.Error()))
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+
+	return tags, rows.Err()
+}
+
+// GetTagsForUser returns the tags on the todos a user can see
+func GetTagsForUser(userId int) ([]Tag, error) {
+	log.Println("INFO: List of tag objects requested for user: " + strconv.Itoa(userId))
+	return queryTags("SELECT "+tagColumns+" FROM Tags WHERE Id IN (SELECT TagId FROM TodoTags WHERE TodoId IN ("+
+		visibleTodoIds+")) ORDER BY Name", userId, userId, userId, userId)
+}
+
+// GetTagsInOrgUnit returns all tags of an org unit
+func GetTagsInOrgUnit(orgUnitId int) ([]Tag, error) {
+	log.Println("INFO: List of tag objects requested for org unit: " + strconv.Itoa(orgUnitId))
+	return queryTags("SELECT "+tagColumns+" FROM Tags WHERE OrgUnitId = ? ORDER BY Name", orgUnitId)
+}
+
+// CanViewTag reports whether a tag is on any todo the user can see
+func CanViewTag(userId int, tagId int) bool {
+	var count int
+	err := DB.QueryRow("SELECT COUNT(*) FROM TodoTags WHERE TagId = ? AND TodoId IN ("+visibleTodoIds+")",
+		tagId, userId, userId, userId, userId).Scan(&count)
+	if err != nil {
+		log.Println("ERROR: Cannot check visibility of tag '" + strconv.Itoa(tagId) + "': " + string(err.Error()))
+		return false
+	}
+
+	return count > 0
+}
+
+func GetTagById(id int) (Tag, error) {
+	log.Println("INFO: Tag by Id requested: " + strconv.Itoa(id))
+	tag, err := scanTag(DB.QueryRow("SELECT "+tagColumns+" FROM Tags WHERE Id = ?", id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			log.Println("ERROR: No such tag found in DB: " + string(err.Error()))
+			return Tag{}, nil
+		}
+		log.Println("ERROR: Cannot retrieve tag from DB: " + string(err.Error()))
+		return Tag{}, err
+	}
+
+	return tag, nil
+}
+
+// CreateTag returns the tag of an org unit with the given name, creating it
+// if needed
+func CreateTag(orgUnitId int, name string) (Tag, error) {
+	log.Println("INFO: Tag creation requested: " + name)
+	if _, err := DB.Exec("INSERT OR IGNORE INTO Tags (Name, OrgUnitId) VALUES (?, ?)", name, orgUnitId); err != nil {
+		log.Println("ERROR: Cannot create tag '" + name + "': " + string(err.Error()))
+		return Tag{}, err
+	}
+
+	tag, err := scanTag(DB.QueryRow("SELECT "+tagColumns+" FROM Tags WHERE OrgUnitId = ? AND Name = ?", orgUnitId, name))
+	if err != nil {
+		log.Println("ERROR: Cannot retrieve tag from DB: " + string(err.Error()))
+		return Tag{}, err
+	}
+
+	return tag, nil
+}
+
+// TagUsedOutside reports whether a tag is on any todo the user cannot see
+func TagUsedOutside(tagId int, userId int) bool {
+	var count int
+	err := DB.QueryRow("SELECT COUNT(*) FROM TodoTags INNER JOIN Todos ON TodoTags.TodoId = Todos.Id "+
+		"WHERE TodoTags.TagId = ? AND NOT (Todos.CreatorId = ? OR IFNULL(Todos.AssigneeId = ?, 0) OR "+
+		"IFNULL(Todos.ListId IN ("+visibleListIds+"), 0))", tagId, userId, userId, userId, userId).Scan(&count)
+	if err != nil {
+		log.Println("ERROR: Cannot check use of tag '" + strconv.Itoa(tagId) + "': " + string(err.Error()))
+		return true
+	}
+
+	return count > 0
+}
+
+func DeleteTag(id int) (bool, error) {
+	idString := strconv.Itoa(id)
+	log.Println("INFO: Tag deletion requested: " + idString)
+	result, err := DB.Exec("DELETE FROM Tags WHERE Id = ?", id)
+	if err != nil {
+		log.Println("ERROR: Cannot delete tag '" + idString + "': " + string(err.Error()))
+		return false, err
+	}
+
+	count, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	log.Println("INFO: Tag with Id '" + idString + "' has been deleted")
+	return count > 0, nil
+}
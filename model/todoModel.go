@@ -1,41 +1,161 @@
 package model
 
 import (
+	"database/sql"
+	"errors"
 	"log"
+	"sort"
 	"strconv"
+	"strings"
 )
 
-func CreateTodo(p ProposedTodo) (bool, error) {
+const todoColumns = "Todos.Id, Todos.Description, Statuses.StatusName, Todos.CreatorId, Todos.AssigneeId, " +
+	"Todos.ListId, Todos.ParentId, Todos.DueDate, Todos.RemindDate, Todos.CompletionDate, Todos.Priority, (SELECT GROUP_CONCAT(Tags.Name, ',') FROM TodoTags " +
+	"INNER JOIN Tags ON TodoTags.TagId = Tags.Id WHERE TodoTags.TodoId = Todos.Id), Todos.Uid, " +
+	"Todos.ResourceName, Todos.CreationDate, Todos.LastChangedDate"
+
+const todoSelect = "SELECT " + todoColumns + " FROM Todos INNER JOIN Statuses ON Todos.Status = Statuses.Id"
+
+func scanTodo(r rowScanner) (Todo, error) {
+	todo := Todo{}
+	var assigneeId, listId, parentId sql.NullInt64
+	var dueDate, remindDate, completionDate, tags, uid, resourceName sql.NullString
+	err := r.Scan(
+		&todo.Id,
+		&todo.Description,
+		&todo.Status,
+		&todo.CreatorId,
+		&assigneeId,
+		&listId,
+		&parentId,
+		&dueDate,
+		&remindDate,
+		&completionDate,
+		&todo.Priority,
+		&tags,
+		&uid,
+		&resourceName,
+		&todo.CreationDate,
+		&todo.LastChangedDate,
+	)
+	todo.AssigneeId = int(assigneeId.Int64)
+	todo.ListId = int(listId.Int64)
+	todo.ParentId = int(parentId.Int64)
+	todo.DueDate = dueDate.String
+	todo.RemindDate = remindDate.String
+	todo.CompletionDate = completionDate.String
+	todo.Uid = uid.String
+	todo.ResourceName = resourceName.String
+	todo.Tags = make([]string, 0)
+	if tags.String != "" {
+		todo.Tags = strings.Split(tags.String, ",")
+		sort.Strings(todo.Tags)
+	}
+
+	return todo, err
+}
+
+func queryTodos(query string, args ...any) ([]Todo, error) {
+	rows, err := DB.Query(query, args...)
+	if err != nil {
+		log.Println("ERROR: Could not run the DB query!" + string(err.Error()))
+		return nil, err
+	}
+	defer rows.Close()
+
+	todos := make([]Todo, 0)
+	for rows.Next() {
+		todo, err := scanTodo(rows)
+		if err != nil {
+			log.Println("ERROR: Cannot marshal the todo objects!" + string(err.Error()))
+			return nil, err
+		}
+		todos = append(todos, todo)
+	}
+
+	return todos, rows.Err()
+}
+
+// CanViewTodo reports whether a user may see a todo: they must have created
+// it, be assigned to it or be able to see the list it is on
+func CanViewTodo(userId int, todo Todo) bool {
+	if todo.CreatorId == userId || todo.AssigneeId == userId {
+		return true
+	}
+
+	return todo.ListId != 0 && CanViewList(userId, todo.ListId)
+}
+
+// ValidatePriority checks a priority is in the range used by iCalendar: 1 is
+// the highest, 9 the lowest and 0 means none
+func ValidatePriority(priority int) error {
+	if priority < 0 || priority > 9 {
+		return errors.New("priority must be between 0 (none) and 9")
+	}
+
+	return nil
+}
+
+func CreateTodo(p ProposedTodo, creatorId int) (Todo, error) {
 	log.Println("INFO: Todo creation requested: " + p.Description)
-	t, err := DB.Begin()
+	if err := ValidatePriority(p.Priority); err != nil {
+		return Todo{}, err
+	}
+	dueDate, err := NormalizeDate(p.DueDate)
 	if err != nil {
-		log.Println("ERROR: Could not start DB transaction!" + string(err.Error()))
-		return false, err
+		return Todo{}, err
+	}
+	remindDate, err := NormalizeDate(p.RemindDate)
+	if err != nil {
+		return Todo{}, err
+	}
+	tags, err := NormalizeTags(p.Tags)
+	if err != nil {
+		return Todo{}, err
 	}
 
 	// get the id for the "new" status
 	statusId, err := GetStatusByName("new")
 	if err != nil {
 		log.Println("ERROR: Could not retrieve status Id for status 'new':" + string(err.Error()))
-		return false, err
+		return Todo{}, err
+	}
+
+	t, err := DB.Begin()
+	if err != nil {
+		log.Println("ERROR: Could not start DB transaction!" + string(err.Error()))
+		return Todo{}, err
 	}
+	defer t.Rollback()
 
-	q, err := t.Prepare("INSERT INTO Todo (Description, Status) VALUES (?, ?)")
+	q, err := t.Prepare("INSERT INTO Todos (Description, Status, CreatorId, AssigneeId, ListId, ParentId, DueDate, " +
+		"RemindDate, Priority, Uid, ResourceName) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)")
 	if err != nil {
 		log.Println("ERROR: Could not prepare the DB query!" + string(err.Error()))
-		return false, err
+		return Todo{}, err
 	}
 
-	_, err = q.Exec(p.Description, statusId)
+	result, err := q.Exec(p.Description, statusId, creatorId, nullInt(p.AssigneeId), nullInt(p.ListId),
+		nullInt(p.ParentId), nullString(dueDate), nullString(remindDate), p.Priority, nullString(p.Uid),
+		nullString(p.ResourceName))
 	if err != nil {
 		log.Println("ERROR: Cannot create todo with description '" + p.Description + "': " + string(err.Error()))
-		return false, err
+		return Todo{}, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return Todo{}, err
+	}
+	if err = setTodoTags(t, int(id), tags); err != nil {
+		return Todo{}, err
 	}
 
-	t.Commit()
+	if err = t.Commit(); err != nil {
+		return Todo{}, err
+	}
 
 	log.Println("INFO: Todo with description '" + p.Description + "' created")
-	return true, nil
+	return GetTodoById(int(id))
 }
 
 func DeleteTodo(id int) (bool, error) {
@@ -46,8 +166,9 @@ func DeleteTodo(id int) (bool, error) {
 		log.Println("ERROR: Could not start DB transaction!" + string(err.Error()))
 		return false, err
 	}
+	defer t.Rollback()
 
-	q, err := DB.Prepare("DELETE FROM Todos WHERE Id IS ?")
+	q, err := t.Prepare("DELETE FROM Todos WHERE Id IS ?")
 	if err != nil {
 		log.Println("ERROR: Could not prepare the DB query!" + string(err.Error()))
 		return false, err
@@ -55,24 +176,236 @@ func DeleteTodo(id int) (bool, error) {
 
 	_, err = q.Exec(id)
 	if err != nil {
-		log.Println("ERROR: Cannot delete user '" + idString + "': " + string(err.Error()))
+		log.Println("ERROR: Cannot delete todo '" + idString + "': " + string(err.Error()))
 		return false, err
 	}
 
-	t.Commit()
+	if err = t.Commit(); err != nil {
+		return false, err
+	}
 
 	log.Println("INFO: Todo with Id '" + idString + "' has been deleted")
 	return true, nil
 }
 
 func GetTodos() ([]Todo, error) {
-	return nil, nil
+	log.Println("INFO: List of todo objects requested")
+	todos, err := queryTodos(todoSelect + " ORDER BY Todos.Id")
+	if err != nil {
+		return nil, err
+	}
+
+	log.Println("INFO: List of all todos retrieved")
+	return todos, nil
+}
+
+// GetTodosForUser returns the todos the user can see, as per CanViewTodo
+func GetTodosForUser(userId int) ([]Todo, error) {
+	log.Println("INFO: List of todo objects requested for user: " + strconv.Itoa(userId))
+	return queryTodos(todoSelect+" WHERE Todos.CreatorId = ? OR Todos.AssigneeId = ? OR Todos.ListId IN ("+
+		visibleListIds+") ORDER BY Todos.Id", userId, userId, userId, userId)
+}
+
+// GetTodosForList returns the todos on a list
+func GetTodosForList(listId int) ([]Todo, error) {
+	log.Println("INFO: List of todo objects requested for list: " + strconv.Itoa(listId))
+	return queryTodos(todoSelect+" WHERE Todos.ListId = ? ORDER BY Todos.Id", listId)
+}
+
+// GetTodoByResourceName returns the todo a CalDAV client stored under the
+// given name on a list, or an empty todo if there is none
+func GetTodoByResourceName(listId int, name string) (Todo, error) {
+	todos, err := queryTodos(todoSelect+" WHERE Todos.ListId = ? AND Todos.ResourceName = ?", listId, name)
+	if err != nil || len(todos) == 0 {
+		return Todo{}, err
+	}
+
+	return todos[0], nil
+}
+
+// GetTodoByUid returns the todo with the given iCalendar UID on a list, or an
+// empty todo if there is none
+func GetTodoByUid(listId int, uid string) (Todo, error) {
+	todos, err := queryTodos(todoSelect+" WHERE Todos.ListId = ? AND Todos.Uid = ?", listId, uid)
+	if err != nil || len(todos) == 0 {
+		return Todo{}, err
+	}
+
+	return todos[0], nil
 }
 
 func GetTodoById(id int) (Todo, error) {
-	return Todo{}, nil
+	log.Println("INFO: Todo by Id requested: " + strconv.Itoa(id))
+	rec, err := DB.Prepare(todoSelect + " WHERE Todos.Id = ?")
+	if err != nil {
+		log.Println("ERROR: Could not prepare the DB query!" + string(err.Error()))
+		return Todo{}, err
+	}
+
+	todo, err := scanTodo(rec.QueryRow(id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			log.Println("ERROR: No such todo found in DB: " + string(err.Error()))
+			return Todo{}, nil
+		}
+		log.Println("ERROR: Cannot retrieve todo from DB: " + string(err.Error()))
+		return Todo{}, err
+	}
+
+	return todo, nil
 }
 
 func UpdateTodo(id int, statusId int) (Todo, error) {
-	return Todo{}, nil
+	return UpdateTodoAt(id, statusId, Now())
+}
+
+// UpdateTodoAt sets the status of a todo, recording the change as made at
+// the given SQL timestamp
+func UpdateTodoAt(id int, statusId int, changedAt string) (Todo, error) {
+	idString := strconv.Itoa(id)
+	log.Println("INFO: Todo status update requested: " + idString)
+	t, err := DB.Begin()
+	if err != nil {
+		log.Println("ERROR: Could not start DB transaction!" + string(err.Error()))
+		return Todo{}, err
+	}
+	defer t.Rollback()
+
+	// completed todos remember when they were completed
+	q, err := t.Prepare("UPDATE Todos SET CompletionDate = CASE WHEN (SELECT StatusName FROM Statuses WHERE Id = ?) = " +
+		"'completed' THEN IFNULL(CompletionDate, ?) ELSE NULL END, Status = ?, LastChangedDate = ? WHERE Id = ?")
+	if err != nil {
+		log.Println("ERROR: Could not prepare the DB query!" + string(err.Error()))
+		return Todo{}, err
+	}
+
+	_, err = q.Exec(statusId, changedAt, statusId, changedAt, id)
+	if err != nil {
+		log.Println("ERROR: Cannot update todo '" + idString + "': " + string(err.Error()))
+		return Todo{}, err
+	}
+
+	if err = t.Commit(); err != nil {
+		return Todo{}, err
+	}
+
+	log.Println("INFO: Todo with Id '" + idString + "' has been updated")
+	return GetTodoById(id)
+}
+
+// ModifyTodo applies the non-nil fields of u to the todo with the given Id
+func ModifyTodo(id int, u TodoUpdate) (Todo, error) {
+	return ModifyTodoAt(id, u, Now())
+}
+
+// ModifyTodoAt is ModifyTodo, recording the change as made at the given SQL
+// timestamp
+func ModifyTodoAt(id int, u TodoUpdate, changedAt string) (Todo, error) {
+	idString := strconv.Itoa(id)
+	log.Println("INFO: Todo modification requested: " + idString)
+	current, err := GetTodoById(id)
+	if err != nil {
+		return Todo{}, err
+	}
+
+	if u.Description != nil {
+		current.Description = *u.Description
+	}
+	if u.AssigneeId != nil {
+		current.AssigneeId = *u.AssigneeId
+	}
+	if u.ListId != nil {
+		current.ListId = *u.ListId
+	}
+	if u.DueDate != nil {
+		current.DueDate = *u.DueDate
+	}
+	if u.Priority != nil {
+		if err := ValidatePriority(*u.Priority); err != nil {
+			return Todo{}, err
+		}
+		current.Priority = *u.Priority
+	}
+	reminderChanged := false
+	if u.RemindDate != nil {
+		current.RemindDate = *u.RemindDate
+		reminderChanged = true
+	}
+
+	// dates read back from the DB are RFC 3339, so normalize them all
+	dueDate, err := NormalizeDate(current.DueDate)
+	if err != nil {
+		return Todo{}, err
+	}
+	remindDate, err := NormalizeDate(current.RemindDate)
+	if err != nil {
+		return Todo{}, err
+	}
+	var tags []string
+	if u.Tags != nil {
+		if tags, err = NormalizeTags(*u.Tags); err != nil {
+			return Todo{}, err
+		}
+	}
+
+	t, err := DB.Begin()
+	if err != nil {
+		log.Println("ERROR: Could not start DB transaction!" + string(err.Error()))
+		return Todo{}, err
+	}
+	defer t.Rollback()
+
+	q, err := t.Prepare("UPDATE Todos SET Description = ?, AssigneeId = ?, ListId = ?, DueDate = ?, RemindDate = ?, " +
+		"Priority = ?, ReminderSent = CASE WHEN ? THEN 0 ELSE ReminderSent END, LastChangedDate = ? WHERE Id = ?")
+	if err != nil {
+		log.Println("ERROR: Could not prepare the DB query!" + string(err.Error()))
+		return Todo{}, err
+	}
+
+	_, err = q.Exec(current.Description, nullInt(current.AssigneeId), nullInt(current.ListId), nullString(dueDate),
+		nullString(remindDate), current.Priority, reminderChanged, changedAt, id)
+	if err != nil {
+		log.Println("ERROR: Cannot modify todo '" + idString + "': " + string(err.Error()))
+		return Todo{}, err
+	}
+	if u.Tags != nil {
+		if err = setTodoTags(t, id, tags); err != nil {
+			return Todo{}, err
+		}
+		if err = setFieldVersion(t, "todo", id, "tags", changedAt); err != nil {
+			return Todo{}, err
+		}
+	}
+
+	if err = t.Commit(); err != nil {
+		return Todo{}, err
+	}
+
+	log.Println("INFO: Todo with Id '" + idString + "' has been modified")
+	return GetTodoById(id)
+}
+
+// GetOpenTodosDueBefore returns the incomplete todos a user is responsible
+// for, either by assignment or as the creator of an unassigned todo, that are
+// due before the given SQL timestamp
+func GetOpenTodosDueBefore(userId int, before string) ([]Todo, error) {
+	return queryTodos(todoSelect+" WHERE Statuses.StatusName != 'completed' AND Todos.DueDate IS NOT NULL"+
+		" AND Todos.DueDate <= ? AND (Todos.AssigneeId = ? OR (Todos.AssigneeId IS NULL AND Todos.CreatorId = ?))"+
+		" ORDER BY Todos.DueDate", before, userId, userId)
+}
+
+// GetDueReminders returns incomplete todos whose reminder time has passed but
+// for which no reminder has been sent yet
+func GetDueReminders(now string) ([]Todo, error) {
+	return queryTodos(todoSelect+" WHERE Statuses.StatusName != 'completed' AND Todos.ReminderSent = 0"+
+		" AND Todos.RemindDate IS NOT NULL AND Todos.RemindDate <= ? ORDER BY Todos.RemindDate", now)
+}
+
+func MarkReminderSent(id int) error {
+	_, err := DB.Exec("UPDATE Todos SET ReminderSent = 1 WHERE Id = ?", id)
+	if err != nil {
+		log.Println("ERROR: Cannot flag reminder as sent for todo '" + strconv.Itoa(id) + "': " + string(err.Error()))
+	}
+
+	return err
 }
@@ -0,0 +1,222 @@
+package model
+
+import (
+	"database/sql"
+	"log"
+	"strconv"
+)
+
+const twoFactorColumns = "UserId, Secret, Enabled, LastUsedStep, EnabledDate, CreationDate"
+
+func scanTwoFactor(r rowScanner) (TwoFactor, error) {
+	twoFactor := TwoFactor{}
+	var enabledDate sql.NullString
+	err := r.Scan(
+		&twoFactor.UserId,
+		&twoFactor.Secret,
+		&twoFactor.Enabled,
+		&twoFactor.LastUsedStep,
+		&enabledDate,
+		&twoFactor.CreationDate,
+	)
+	twoFactor.EnabledDate = enabledDate.String
+
+	return twoFactor, err
+}
+
+// GetTwoFactor returns the two-factor settings of a user, or empty settings
+// if they never started enrolling
+func GetTwoFactor(userId int) (TwoFactor, error) {
+	twoFactor, err := scanTwoFactor(DB.QueryRow("SELECT "+twoFactorColumns+" FROM TwoFactor WHERE UserId = ?", userId))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return TwoFactor{}, nil
+		}
+		log.Println("ERROR: Cannot retrieve two-factor settings of user '" + strconv.Itoa(userId) + "': " +
+			string(err.Error()))
+		return TwoFactor{}, err
+	}
+
+	return twoFactor, nil
+}
+
+// TwoFactorEnabled reports whether a user has to give a one-time password to
+// log in
+func TwoFactorEnabled(userId int) (bool, error) {
+	twoFactor, err := GetTwoFactor(userId)
+	return twoFactor.Enabled, err
+}
+
+// StartTwoFactor stores a new secret for a user, replacing one from an
+// earlier enrollment that was never confirmed. Two-factor authentication is
+// not enabled until ConfirmTwoFactor
+func StartTwoFactor(userId int, secret string) error {
+	_, err := DB.Exec("INSERT OR REPLACE INTO TwoFactor (UserId, Secret, Enabled, LastUsedStep, CreationDate) "+
+		"VALUES (?, ?, 0, 0, ?)", userId, secret, Now())
+	if err != nil {
+		log.Println("ERROR: Cannot store two-factor secret of user '" + strconv.Itoa(userId) + "': " +
+			string(err.Error()))
+	}
+
+	return err
+}
+
+// insertRecoveryCodes replaces the recovery codes of a user with the given
+// hashes
+func insertRecoveryCodes(t *sql.Tx, userId int, codeHashes []string) error {
+	if _, err := t.Exec("DELETE FROM RecoveryCodes WHERE UserId = ?", userId); err != nil {
+		return err
+	}
+	now := Now()
+	for _, codeHash := range codeHashes {
+		_, err := t.Exec("INSERT INTO RecoveryCodes (UserId, CodeHash, CreationDate) VALUES (?, ?, ?)",
+			userId, codeHash, now)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ConfirmTwoFactor enables two-factor authentication for a user once they
+// have shown a code made with their new secret, and stores the hashes of
+// their recovery codes
+func ConfirmTwoFactor(userId int, step int64, codeHashes []string) error {
+	log.Println("INFO: Two-factor authentication enabled for user: " + strconv.Itoa(userId))
+	t, err := DB.Begin()
+	if err != nil {
+		log.Println("ERROR: Could not start DB transaction!" + string(err.Error()))
+		return err
+	}
+	defer t.Rollback()
+
+	_, err = t.Exec("UPDATE TwoFactor SET Enabled = 1, LastUsedStep = ?, EnabledDate = ? WHERE UserId = ?",
+		step, Now(), userId)
+	if err != nil {
+		log.Println("ERROR: Cannot enable two-factor authentication: " + string(err.Error()))
+		return err
+	}
+	if err := insertRecoveryCodes(t, userId, codeHashes); err != nil {
+		log.Println("ERROR: Cannot store recovery codes: " + string(err.Error()))
+		return err
+	}
+
+	return t.Commit()
+}
+
+// ReplaceRecoveryCodes swaps the recovery codes of a user for new ones
+func ReplaceRecoveryCodes(userId int, codeHashes []string) error {
+	t, err := DB.Begin()
+	if err != nil {
+		log.Println("ERROR: Could not start DB transaction!" + string(err.Error()))
+		return err
+	}
+	defer t.Rollback()
+
+	if err := insertRecoveryCodes(t, userId, codeHashes); err != nil {
+		log.Println("ERROR: Cannot store recovery codes: " + string(err.Error()))
+		return err
+	}
+
+	return t.Commit()
+}
+
+// UseTotpStep records that the code of a step was used, reporting false if
+// that or a later step was already used
+func UseTotpStep(userId int, step int64) (bool, error) {
+	result, err := DB.Exec("UPDATE TwoFactor SET LastUsedStep = ? WHERE UserId = ? AND LastUsedStep < ?",
+		step, userId, step)
+	if err != nil {
+		log.Println("ERROR: Cannot record use of one-time password: " + string(err.Error()))
+		return false, err
+	}
+
+	count, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+// GetUnusedRecoveryCodes returns the hashes of the recovery codes a user has
+// not used yet
+func GetUnusedRecoveryCodes(userId int) ([]RecoveryCode, error) {
+	rows, err := DB.Query("SELECT Id, CodeHash FROM RecoveryCodes WHERE UserId = ? AND UsedDate IS NULL", userId)
+	if err != nil {
+		log.Println("ERROR: Could not run the DB query!" + string(err.Error()))
+		return nil, err
+	}
+	defer rows.Close()
+
+	codes := make([]RecoveryCode, 0)
+	for rows.Next() {
+		var code RecoveryCode
+		if err := rows.Scan(&code.Id, &code.CodeHash); err != nil {
+			log.Println("ERROR: Cannot marshal the recovery codes!" + string(err.Error()))
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+
+	return codes, rows.Err()
+}
+
+// UseRecoveryCode spends an unused recovery code, reporting whether it was
+// still unused
+func UseRecoveryCode(id int) (bool, error) {
+	result, err := DB.Exec("UPDATE RecoveryCodes SET UsedDate = ? WHERE Id = ? AND UsedDate IS NULL", Now(), id)
+	if err != nil {
+		log.Println("ERROR: Cannot use recovery code: " + string(err.Error()))
+		return false, err
+	}
+
+	count, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+// CountRecoveryCodes returns how many unused recovery codes a user has left
+func CountRecoveryCodes(userId int) (int, error) {
+	var count int
+	err := DB.QueryRow("SELECT COUNT(*) FROM RecoveryCodes WHERE UserId = ? AND UsedDate IS NULL", userId).Scan(&count)
+	if err != nil {
+		log.Println("ERROR: Cannot count recovery codes: " + string(err.Error()))
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// DeleteTwoFactor turns two-factor authentication off for a user, reporting
+// whether they had started enrolling
+func DeleteTwoFactor(userId int) (bool, error) {
+	log.Println("INFO: Two-factor authentication removal requested for user: " + strconv.Itoa(userId))
+	t, err := DB.Begin()
+	if err != nil {
+		log.Println("ERROR: Could not start DB transaction!" + string(err.Error()))
+		return false, err
+	}
+	defer t.Rollback()
+
+	result, err := t.Exec("DELETE FROM TwoFactor WHERE UserId = ?", userId)
+	if err != nil {
+		log.Println("ERROR: Cannot remove two-factor settings: " + string(err.Error()))
+		return false, err
+	}
+	if _, err := t.Exec("DELETE FROM RecoveryCodes WHERE UserId = ?", userId); err != nil {
+		log.Println("ERROR: Cannot remove recovery codes: " + string(err.Error()))
+		return false, err
+	}
+
+	count, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return count > 0, t.Commit()
+}
@@ -1,7 +1,39 @@
 package model
 
+import "encoding/json"
+
 // primary object structs
 
+// AccessToken is a personal access token. The token itself is only shown
+// when it is created
+type AccessToken struct {
+	Id           int      `json:"Id"`
+	UserId       int      `json:"userId"`
+	Name         string   `json:"name"`
+	Scopes       []string `json:"scopes"`
+	ExpiryDate   string   `json:"expiryDate"`
+	LastUsedDate string   `json:"lastUsedDate"`
+	CreationDate string   `json:"creationDate"`
+}
+
+// Invitation lets someone set up their own account, with the roles and org
+// unit chosen by the admin who invited them. Status is one of pending,
+// accepted, revoked or expired. The token is only shown when it is created
+type Invitation struct {
+	Id           int      `json:"Id"`
+	Email        string   `json:"email"`
+	FullName     string   `json:"fullName"`
+	Roles        []string `json:"roles"`
+	OrgUnitId    int      `json:"orgUnitId"`
+	Status       string   `json:"status" enum:"pending,accepted,revoked,expired"`
+	InvitedBy    int      `json:"invitedBy"`
+	UserId       int      `json:"userId"`
+	ExpiryDate   string   `json:"expiryDate"`
+	AcceptedDate string   `json:"acceptedDate"`
+	RevokedDate  string   `json:"revokedDate"`
+	CreationDate string   `json:"creationDate"`
+}
+
 type HealthCheck struct {
 	Db           string `json:"db"`
 	DiskSpace    string `json:"diskSpace"`
@@ -15,66 +47,583 @@ type PasswordChange struct {
 	NewPassword string `json:"newPassword"`
 }
 
+// PasswordResetRequest names the account to reset, by user name or email
+type PasswordResetRequest struct {
+	UserName string `json:"userName"`
+	Email    string `json:"email"`
+}
+
+// PasswordResetConfirm sets a new password with a mailed reset token
+type PasswordResetConfirm struct {
+	Token       string `json:"token"`
+	NewPassword string `json:"newPassword"`
+}
+
+// Credentials are the user name and password sent to log in. Code is the
+// one-time password or a recovery code of users with two-factor
+// authentication
+type Credentials struct {
+	UserName string `json:"userName"`
+	Password string `json:"password"`
+	Code     string `json:"code"`
+}
+
+// TwoFactorCode is a one-time password or recovery code confirming a change
+// to two-factor authentication
+type TwoFactorCode struct {
+	Code string `json:"code"`
+}
+
 type Status struct {
 	Id           int    `json:"Id"`
 	StatusString string `json:"statusString"`
 }
 
-type Todo struct {
+// Change is an entry in the change log used by delta sync. Operation is one
+// of create, upsert, delete or revoke; Audience lists the Ids of the users who
+// could see the entity before a delete or revoke
+type Change struct {
+	Seq        int    `json:"seq"`
+	EntityType string `json:"entityType" enum:"todo,list,tag"`
+	EntityId   int    `json:"entityId"`
+	Operation  string `json:"operation" enum:"create,upsert,delete,revoke"`
+	Audience   string `json:"-"`
+	ChangeDate string `json:"changeDate"`
+}
+
+type Comment struct {
+	Id              int    `json:"Id"`
+	TodoId          int    `json:"todoId"`
+	AuthorId        int    `json:"authorId"`
+	Author          string `json:"author"`
+	Body            string `json:"body"`
+	CreationDate    string `json:"creationDate"`
+	LastChangedDate string `json:"lastChangedDate"`
+}
+
+// HistoryEntry records a change to a field of a todo. Statuses, assignees and
+// lists are recorded by name, so entries stay readable once those are gone
+type HistoryEntry struct {
+	Id         int    `json:"Id"`
+	TodoId     int    `json:"todoId"`
+	Field      string `json:"field" enum:"created,description,status,assignee,list,dueDate,remindDate,priority,tags"`
+	OldValue   string `json:"oldValue"`
+	NewValue   string `json:"newValue"`
+	ChangeDate string `json:"changeDate"`
+}
+
+type List struct {
+	Id              int    `json:"Id"`
+	Name            string `json:"name"`
+	OwnerId         int    `json:"ownerId"`
+	CreationDate    string `json:"creationDate"`
+	LastChangedDate string `json:"lastChangedDate"`
+}
+
+type MailMessage struct {
+	Id              int    `json:"Id"`
+	Kind            string `json:"kind"`
+	Recipient       string `json:"recipient"`
+	Subject         string `json:"subject"`
+	TextBody        string `json:"textBody"`
+	HtmlBody        string `json:"htmlBody"`
+	Status          string `json:"status" enum:"pending,sent,failed"`
+	Attempts        int    `json:"attempts"`
+	LastError       string `json:"lastError"`
+	NextAttemptDate string `json:"nextAttemptDate"`
+	CreationDate    string `json:"creationDate"`
+	SentDate        string `json:"sentDate"`
+}
+
+// OrgUnit is an organizational unit. Users only see the accounts, lists and
+// todos of their own org unit
+type OrgUnit struct {
+	Id           int    `json:"Id"`
+	OrgUnitName  string `json:"orgUnitName"`
+	Description  string `json:"description"`
+	CreationDate string `json:"creationDate"`
+}
+
+type Role struct {
 	Id           int    `json:"Id"`
+	RoleName     string `json:"roleName"`
 	Description  string `json:"description"`
-	Status       string `json:"status"`
 	CreationDate string `json:"creationDate"`
 }
 
+// Session is a login session as shown to users and admins. The session
+// token itself is never returned
+type Session struct {
+	Id           int    `json:"Id"`
+	UserId       int    `json:"userId"`
+	UserAgent    string `json:"userAgent"`
+	IpAddress    string `json:"ipAddress"`
+	CreationDate string `json:"creationDate"`
+	LastSeenDate string `json:"lastSeenDate"`
+	ExpiryDate   string `json:"expiryDate"`
+}
+
+type Tag struct {
+	Id           int    `json:"Id"`
+	Name         string `json:"name"`
+	OrgUnitId    int    `json:"orgUnitId"`
+	CreationDate string `json:"creationDate"`
+}
+
+type Todo struct {
+	Id              int      `json:"Id"`
+	Description     string   `json:"description"`
+	Status          string   `json:"status"`
+	CreatorId       int      `json:"creatorId"`
+	AssigneeId      int      `json:"assigneeId"`
+	ListId          int      `json:"listId"`
+	ParentId        int      `json:"parentId"`
+	DueDate         string   `json:"dueDate"`
+	RemindDate      string   `json:"remindDate"`
+	CompletionDate  string   `json:"completionDate"`
+	Priority        int      `json:"priority"`
+	Tags            []string `json:"tags"`
+	Uid             string   `json:"-"`
+	ResourceName    string   `json:"-"`
+	CreationDate    string   `json:"creationDate"`
+	LastChangedDate string   `json:"lastChangedDate"`
+}
+
+type AccessTokenList struct {
+	Data []AccessToken `json:"data"`
+}
+
+type CommentList struct {
+	Data []Comment `json:"data"`
+}
+
+type HistoryList struct {
+	Data []HistoryEntry `json:"data"`
+}
+
+type InvitationList struct {
+	Data []Invitation `json:"data"`
+}
+
+type ListsList struct {
+	Data []List `json:"data"`
+}
+
+type TagList struct {
+	Data []Tag `json:"data"`
+}
+
 type TodoList struct {
 	Data []Todo `json:"data"`
 }
 
+// TwoFactor holds the TOTP secret of a user. It is pending until the user
+// confirms it with a code
+type TwoFactor struct {
+	UserId       int    `json:"userId"`
+	Secret       string `json:"-"`
+	Enabled      bool   `json:"enabled"`
+	LastUsedStep int64  `json:"-"`
+	EnabledDate  string `json:"enabledDate"`
+	CreationDate string `json:"creationDate"`
+}
+
+// RecoveryCode is the salted hash of a recovery code, as made by passhash
+type RecoveryCode struct {
+	Id       int
+	CodeHash string
+}
+
+// TwoFactorStatus shows whether a user has two-factor authentication and
+// how many recovery codes they have left
+type TwoFactorStatus struct {
+	Enabled           bool   `json:"enabled"`
+	Pending           bool   `json:"pending"`
+	EnabledDate       string `json:"enabledDate"`
+	RecoveryCodesLeft int    `json:"recoveryCodesLeft"`
+}
+
 type User struct {
+	Id              int             `json:"Id"`
+	UserName        string          `json:"userName"`
+	FullName        string          `json:"fullName"`
+	Email           string          `json:"email"`
+	Timezone        string          `json:"timezone"`
+	Locale          string          `json:"locale"`
+	Preferences     UserPreferences `json:"preferences"`
+	PasswordHash    string          `json:"passwordHash"`
+	Status          string          `json:"status"`
+	OrgUnitId       int             `json:"orgUnitId"`
+	Source          string          `json:"source"`         // where the account came from: local, ldap or oidc
+	LdapDn          string          `json:"ldapDn"`         // the directory entry the account is linked to, if any
+	ServiceAccount  bool            `json:"serviceAccount"` // may log in with a client certificate
+	LockReason      string          `json:"lockReason"`
+	LockDate        string          `json:"lockDate"`
+	CreationDate    string          `json:"creationDate"`
+	LastChangedDate string          `json:"lastChangedDate"`
+	// when the password was last set, for the maximum password age
+	PasswordChangedDate string `json:"passwordChangedDate"`
+}
+
+// UserPreferences are settings clients keep for a user. DefaultSort names a
+// todo field, descending when prefixed with "-"
+type UserPreferences struct {
+	DefaultListId int                     `json:"defaultListId"`
+	DefaultSort   string                  `json:"defaultSort" example:"-dueDate"`
+	Notifications NotificationPreferences `json:"notifications"`
+}
+
+// NotificationPreferences chooses which mails a user gets. All of them are
+// sent unless turned off
+type NotificationPreferences struct {
+	Assignments bool `json:"assignments"`
+	Mentions    bool `json:"mentions"`
+	Reminders   bool `json:"reminders"`
+	Digest      bool `json:"digest"`
+}
+
+// UserProfile is what users keep about themselves. Timezone is an IANA time
+// zone name and Locale a BCP 47 language tag
+type UserProfile struct {
+	FullName    string          `json:"fullName"`
+	Email       string          `json:"email"`
+	Timezone    string          `json:"timezone" example:"Europe/Berlin"`
+	Locale      string          `json:"locale" example:"de-DE"`
+	Preferences UserPreferences `json:"preferences"`
+}
+
+// UserOrgUnit moves a user to another org unit
+type UserOrgUnit struct {
+	OrgUnitId int `json:"orgUnitId"`
+}
+
+// UserLdapLink links a user to a directory entry. An empty Dn unlinks them
+type UserLdapLink struct {
+	Dn string `json:"dn" example:"uid=alice,ou=people,dc=example,dc=com"`
+}
+
+// UserServiceAccount marks a user as a service account, or unmarks them
+type UserServiceAccount struct {
+	ServiceAccount bool `json:"serviceAccount"`
+}
+
+type Webhook struct {
+	Id           int      `json:"Id"`
+	Url          string   `json:"url"`
+	Secret       string   `json:"-"`
+	Events       []string `json:"events"`
+	Active       bool     `json:"active"`
+	CreatorId    int      `json:"creatorId"`
+	CreationDate string   `json:"creationDate"`
+}
+
+type WebhookDelivery struct {
 	Id              int    `json:"Id"`
-	UserName        string `json:"userName"`
-	FullName	string `json:"fullName"`
-	PasswordHash    string `json:"passwordHash"`
-	Status          string `json:"status"`
+	WebhookId       int    `json:"webhookId"`
+	EventId         string `json:"eventId"`
+	EventType       string `json:"eventType"`
+	Payload         string `json:"payload"`
+	Status          string `json:"status" enum:"pending,delivered,failed"`
+	Attempts        int    `json:"attempts"`
+	ResponseCode    int    `json:"responseCode"`
+	ResponseBody    string `json:"responseBody"`
+	LastError       string `json:"lastError"`
+	NextAttemptDate string `json:"nextAttemptDate"`
 	CreationDate    string `json:"creationDate"`
-	LastChangedDate string `json:"lastChangedDate"`
+	DeliveredDate   string `json:"deliveredDate"`
 }
 
+// UserStatus locks or unlocks a user. Reason is kept with a lock
 type UserStatus struct {
 	Status string `json:"status" enum:"enabled,disabled"`
+	Reason string `json:"reason"`
 }
 
 type UserStatusMsg struct {
 	Message    string `json:"message"`
 	UserStatus string `json:"userStatus" enum:"enabled,disabled"`
+	LockReason string `json:"lockReason,omitempty"`
+	LockDate   string `json:"lockDate,omitempty"`
 }
 
 // proposed object structs. Normally used when creating new DB entries
 
-type ProposedTodo struct {
+// ProposedAccessToken requests a personal access token. ExpiryDate is an RFC
+// 3339 date or a plain date; the token does not expire when it is empty
+type ProposedAccessToken struct {
+	Name       string   `json:"name"`
+	Scopes     []string `json:"scopes" enum:"todo:read,todo:write,admin"`
+	ExpiryDate string   `json:"expiryDate"`
+}
+
+type ProposedComment struct {
+	Body string `json:"body"`
+}
+
+// ProposedInvitation invites someone into an org unit, by default that of
+// the admin inviting them. Every invitee becomes a member, and Roles may add
+// others. ExpiryDate is an RFC 3339 date or a plain date; it defaults to the
+// configured number of days from now
+type ProposedInvitation struct {
+	Email      string   `json:"email"`
+	FullName   string   `json:"fullName"`
+	Roles      []string `json:"roles"`
+	OrgUnitId  int      `json:"orgUnitId"`
+	ExpiryDate string   `json:"expiryDate"`
+}
+
+// InvitationAcceptance sets up the account of an invitee. FullName and Email
+// default to those given in the invitation
+type InvitationAcceptance struct {
+	Token    string `json:"token"`
+	UserName string `json:"userName"`
+	FullName string `json:"fullName"`
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+type ProposedList struct {
+	Name string `json:"name"`
+}
+
+type ProposedOrgUnit struct {
+	OrgUnitName string `json:"orgUnitName"`
 	Description string `json:"description"`
 }
 
+type ProposedTodo struct {
+	Description string   `json:"description"`
+	AssigneeId  int      `json:"assigneeId"`
+	ListId      int      `json:"listId"`
+	ParentId    int      `json:"parentId"`
+	DueDate     string   `json:"dueDate"`
+	RemindDate  string   `json:"remindDate"`
+	Priority    int      `json:"priority"`
+	Tags        []string `json:"tags"`
+	// set by CalDAV clients
+	Uid          string `json:"-"`
+	ResourceName string `json:"-"`
+}
+
+type ProposedWebhook struct {
+	Url    string   `json:"url"`
+	Events []string `json:"events"`
+	Secret string   `json:"secret"`
+}
+
+// update object structs. Nil fields are left untouched
+
+type TodoUpdate struct {
+	Description *string   `json:"description"`
+	AssigneeId  *int      `json:"assigneeId"`
+	ListId      *int      `json:"listId"`
+	DueDate     *string   `json:"dueDate"`
+	RemindDate  *string   `json:"remindDate"`
+	Priority    *int      `json:"priority"`
+	Tags        *[]string `json:"tags"`
+}
+
+// ProfileUpdate changes the profile of a user. Preferences are merged into
+// the stored ones, so only the settings sent are changed
+type ProfileUpdate struct {
+	FullName    *string         `json:"fullName"`
+	Email       *string         `json:"email"`
+	Timezone    *string         `json:"timezone"`
+	Locale      *string         `json:"locale"`
+	Preferences json.RawMessage `json:"preferences" swaggertype:"object"`
+}
+
+// ImportedTodo is a todo read from an import file. Lists are named rather
+// than referred to by Id and may not exist yet
+type ImportedTodo struct {
+	Todo           ProposedTodo
+	ListName       string
+	Status         string
+	CreationDate   string
+	CompletionDate string
+}
+
 type ProposedUser struct {
 	Id       int    `json:"Id"`
 	UserName string `json:"userName"`
+	// the user name when not set
+	FullName string `json:"fullName"`
+	Email    string `json:"email"`
+	Timezone string `json:"timezone"`
+	Locale   string `json:"locale"`
 	Status   string `json:"status" enum:"enabled,disabled"`
 	Password string `json:"password"`
+	// the org unit of the creating admin when not set
+	OrgUnitId int `json:"orgUnitId"`
+}
+
+// archive object structs. Ids are only meaningful within an archive and
+// users are referred to by name, so an archive can be imported elsewhere
+
+// Workspace is the versioned archive of everything a user can see
+type Workspace struct {
+	Version    int             `json:"version"`
+	ExportedAt string          `json:"exportedAt"`
+	UserName   string          `json:"userName"`
+	Lists      []WorkspaceList `json:"lists"`
+	Tags       []string        `json:"tags"`
+	Todos      []WorkspaceTodo `json:"todos"`
+	Comments   []Comment       `json:"comments"`
+	History    []HistoryEntry  `json:"history"`
+}
+
+type WorkspaceList struct {
+	Id              int      `json:"Id"`
+	Name            string   `json:"name"`
+	Owner           string   `json:"owner"`
+	Members         []string `json:"members"`
+	CreationDate    string   `json:"creationDate"`
+	LastChangedDate string   `json:"lastChangedDate"`
+}
+
+type WorkspaceTodo struct {
+	Id              int      `json:"Id"`
+	Description     string   `json:"description"`
+	Status          string   `json:"status"`
+	Creator         string   `json:"creator"`
+	Assignee        string   `json:"assignee"`
+	ListId          int      `json:"listId"`
+	ParentId        int      `json:"parentId"`
+	DueDate         string   `json:"dueDate"`
+	RemindDate      string   `json:"remindDate"`
+	CompletionDate  string   `json:"completionDate"`
+	Priority        int      `json:"priority"`
+	Tags            []string `json:"tags"`
+	CreationDate    string   `json:"creationDate"`
+	LastChangedDate string   `json:"lastChangedDate"`
+}
+
+// ImportOptions control how a workspace is imported
+type ImportOptions struct {
+	Replace bool
+	DryRun  bool
+}
+
+// WorkspaceImport describes what importing a workspace changed
+type WorkspaceImport struct {
+	Lists        []List
+	Todos        []Todo
+	DeletedLists []List
+	DeletedTodos []Todo
+	Subtasks     int
+	Comments     int
+	History      int
 }
 
 // list object structs
 
+type OrgUnitList struct {
+	Data []OrgUnit `json:"data"`
+}
+
+type RoleList struct {
+	Data []Role `json:"data"`
+}
+
+type SessionList struct {
+	Data []Session `json:"data"`
+}
+
 type UsersList struct {
 	Data []User `json:"data"`
 }
 
+type WebhookList struct {
+	Data []Webhook `json:"data"`
+}
+
+type WebhookDeliveryList struct {
+	Data []WebhookDelivery `json:"data"`
+}
+
 // generic message structs
 
 type FailureMsg struct {
 	Error string `json:"error"`
 }
 
+// PasswordViolation names a rule of the password policy a password breaks
+type PasswordViolation struct {
+	Rule    string `json:"rule" enum:"minLength,maxLength,characterClasses,denyList,history"`
+	Message string `json:"message"`
+}
+
+// PasswordPolicyFailureMsg lists every rule of the password policy a
+// password breaks
+type PasswordPolicyFailureMsg struct {
+	Error      string              `json:"error"`
+	Violations []PasswordViolation `json:"violations"`
+}
+
 type SuccessMsg struct {
 	Message string `json:"message"`
 }
+
+type FeedTokenMsg struct {
+	Message string `json:"message"`
+	Token   string `json:"token"`
+	Path    string `json:"path"`
+}
+
+type ImportError struct {
+	Line  int    `json:"line,omitempty"`
+	Item  string `json:"item,omitempty"`
+	Error string `json:"error"`
+}
+
+type ImportResult struct {
+	Message  string        `json:"message"`
+	Imported int           `json:"imported"`
+	Errors   []ImportError `json:"errors"`
+}
+
+// ImportReport describes what an import from another task manager created,
+// or would create when it is a dry run
+type ImportReport struct {
+	Message  string        `json:"message"`
+	DryRun   bool          `json:"dryRun"`
+	Lists    []string      `json:"lists"`
+	Tags     []string      `json:"tags"`
+	Todos    int           `json:"todos"`
+	Subtasks int           `json:"subtasks"`
+	Comments int           `json:"comments"`
+	Errors   []ImportError `json:"errors"`
+}
+
+type InvitationCreatedMsg struct {
+	Message    string     `json:"message"`
+	Token      string     `json:"token"`
+	Invitation Invitation `json:"invitation"`
+}
+
+type AccessTokenCreatedMsg struct {
+	Message     string      `json:"message"`
+	Token       string      `json:"token"`
+	AccessToken AccessToken `json:"accessToken"`
+}
+
+// TwoFactorEnrollmentMsg holds a new TOTP secret, both as an otpauth:// URI
+// and as a base64 encoded PNG of its QR code
+type TwoFactorEnrollmentMsg struct {
+	Message string `json:"message"`
+	Secret  string `json:"secret"`
+	Uri     string `json:"uri"`
+	QrCode  string `json:"qrCode"`
+}
+
+// RecoveryCodesMsg holds recovery codes, which are only shown once
+type RecoveryCodesMsg struct {
+	Message       string   `json:"message"`
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
+
+type WebhookCreatedMsg struct {
+	Message string  `json:"message"`
+	Secret  string  `json:"secret"`
+	Webhook Webhook `json:"webhook"`
+}
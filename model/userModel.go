@@ -1,15 +1,66 @@
 package model
 
 import (
-	"crypto/sha512"
+	"crypto/rand"
 	"database/sql"
-	"encoding/hex"
+	"encoding/base64"
 	"errors"
 	"log"
 	"strconv"
-	"time"
+
+	"github.com/greeneg/todoer/passhash"
 )
 
+const userColumns = "Id, UserName, FullName, Email, Timezone, Locale, Preferences, PasswordHash, Status, OrgUnitId, " +
+	"Source, LdapDn, ServiceAccount, LockReason, LockDate, CreationDate, LastChangedDate, PasswordChangedDate"
+
+// where accounts come from: created in todoer, or on the first login through
+// a directory or an identity provider
+const (
+	SourceLocal = "local"
+	SourceLdap  = "ldap"
+	SourceOidc  = "oidc"
+)
+
+// rowScanner is satisfied by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanUser(r rowScanner) (User, error) {
+	user := User{}
+	var email, timezone, locale, preferences, ldapDn, lockReason, lockDate sql.NullString
+	err := r.Scan(
+		&user.Id,
+		&user.UserName,
+		&user.FullName,
+		&email,
+		&timezone,
+		&locale,
+		&preferences,
+		&user.PasswordHash,
+		&user.Status,
+		&user.OrgUnitId,
+		&user.Source,
+		&ldapDn,
+		&user.ServiceAccount,
+		&lockReason,
+		&lockDate,
+		&user.CreationDate,
+		&user.LastChangedDate,
+		&user.PasswordChangedDate,
+	)
+	user.Email = email.String
+	user.Timezone = timezone.String
+	user.Locale = locale.String
+	user.Preferences = decodePreferences(preferences.String)
+	user.LdapDn = ldapDn.String
+	user.LockReason = lockReason.String
+	user.LockDate = lockDate.String
+
+	return user, err
+}
+
 func getStoredPasswordHash(username string) (string, error) {
 	q, err := DB.Prepare("SELECT PasswordHash FROM Users WHERE UserName = ?")
 	if err != nil {
@@ -32,31 +83,43 @@ func storeNewPassword(hashedPassword string, username string) (bool, error) {
 	if err != nil {
 		return false, err
 	}
+	defer t.Rollback()
 
-	// now we need to create a new transaction to SET the password hash into the DB
-	q, err := DB.Prepare("UPDATE Users SET PasswordHash = ?, LastChangedDate = ? WHERE UserName = ?")
-	if err != nil {
+	// keep the password being replaced, so that it is not used again
+	if err := recordPasswordHistory(t, "UserName = ?", username); err != nil {
 		return false, err
 	}
 
 	// get time stamp
-	tStamp := time.Now().Format("2006-01-02 15:04:05") // force into SQL DateTime format
+	tStamp := Now()
 
-	_, err = q.Exec(hashedPassword, tStamp, username)
+	_, err = t.Exec("UPDATE Users SET PasswordHash = ?, LastChangedDate = ?, PasswordChangedDate = ? WHERE UserName = ?",
+		hashedPassword, tStamp, tStamp, username)
 	if err != nil {
 		return false, err
 	}
 
-	t.Commit()
+	if err := t.Commit(); err != nil {
+		return false, err
+	}
 
 	return true, nil
 }
 
+// UpgradePasswordHash replaces a password hash with a stronger one of the same
+// password. It does nothing if the password was changed in the meantime
+func UpgradePasswordHash(username string, oldHash string, newHash string) error {
+	_, err := DB.Exec("UPDATE Users SET PasswordHash = ? WHERE UserName = ? AND PasswordHash = ?",
+		newHash, username, oldHash)
+	if err != nil {
+		log.Println("ERROR: Cannot store upgraded password hash for user '" + username + "': " + string(err.Error()))
+	}
+
+	return err
+}
+
 func ChangeAccountPassword(username string, oldPassword string, newPassword string) (bool, error) {
 	log.Println("INFO: Password change requested")
-	hashedOldPassword := sha512.Sum512([]byte(oldPassword))
-	encodedHashedOldPassword := hex.EncodeToString(hashedOldPassword[:])
-
 	storedHash, err := getStoredPasswordHash(username)
 	if err != nil {
 		log.Println("ERROR: Cannot retrieve stored password hash from DB: " + string(err.Error()))
@@ -64,16 +127,19 @@ func ChangeAccountPassword(username string, oldPassword string, newPassword stri
 	}
 	log.Println("INFO: Retrieved stored hash for comparison")
 
-	// now get password hash from the db
-	if storedHash != encodedHashedOldPassword {
+	// now check the old password against the hash from the db
+	if match, _ := passhash.Verify(oldPassword, storedHash); !match {
 		log.Println("ERROR: Hashed value of old password does not match stored hashed value")
 		p := new(PasswordHashMismatch)
 		return false, p
 	}
 
 	// matches, so hash new password
-	hashedNewPassword := sha512.Sum512([]byte(newPassword))
-	encodedHashedNewPassword := hex.EncodeToString(hashedNewPassword[:])
+	encodedHashedNewPassword, err := passhash.Hash(newPassword)
+	if err != nil {
+		log.Println("ERROR: Cannot hash new password: " + string(err.Error()))
+		return false, err
+	}
 	_, err = storeNewPassword(encodedHashedNewPassword, username)
 	if err != nil {
 		log.Println("ERROR: Cannot store updated password hash in DB: " + string(err.Error()))
@@ -86,22 +152,13 @@ func ChangeAccountPassword(username string, oldPassword string, newPassword stri
 
 func GetUserById(id int) (User, error) {
 	log.Println("INFO: User by Id requested: " + strconv.Itoa(id))
-	rec, err := DB.Prepare("SELECT * FROM Users WHERE Id = ?")
+	rec, err := DB.Prepare("SELECT " + userColumns + " FROM Users WHERE Id = ?")
 	if err != nil {
 		log.Println("ERROR: Could not prepare the DB query!" + string(err.Error()))
 		return User{}, err
 	}
 
-	user := User{}
-	err = rec.QueryRow(id).Scan(
-		&user.Id,
-		&user.UserName,
-		&user.FullName,
-		&user.PasswordHash,
-		&user.Status,
-		&user.CreationDate,
-		&user.LastChangedDate,
-	)
+	user, err := scanUser(rec.QueryRow(id))
 	if err != nil {
 		if err == sql.ErrNoRows {
 			log.Println("ERROR: No such user found in DB: " + string(err.Error()))
@@ -116,22 +173,13 @@ func GetUserById(id int) (User, error) {
 
 func GetUserByUserName(username string) (User, error) {
 	log.Println("INFO: User by username requested: " + username)
-	rec, err := DB.Prepare("SELECT * FROM Users WHERE UserName = ?")
+	rec, err := DB.Prepare("SELECT " + userColumns + " FROM Users WHERE UserName = ?")
 	if err != nil {
 		log.Println("ERROR: Could not prepare the DB query!" + string(err.Error()))
 		return User{}, err
 	}
 
-	user := User{}
-	err = rec.QueryRow(username).Scan(
-		&user.Id,
-		&user.UserName,
-		&user.FullName,
-		&user.PasswordHash,
-		&user.Status,
-		&user.CreationDate,
-		&user.LastChangedDate,
-	)
+	user, err := scanUser(rec.QueryRow(username))
 	if err != nil {
 		if err == sql.ErrNoRows {
 			log.Println("ERROR: No such user found in DB: " + string(err.Error()))
@@ -146,34 +194,190 @@ func GetUserByUserName(username string) (User, error) {
 
 func CreateUser(p ProposedUser) (bool, error) {
 	log.Println("INFO: User creation requested: " + p.UserName)
+	// take password and hash it
+	passwdHash, err := passhash.Hash(p.Password)
+	if err != nil {
+		log.Println("ERROR: Cannot hash password: " + string(err.Error()))
+		return false, err
+	}
+
 	t, err := DB.Begin()
 	if err != nil {
 		log.Println("ERROR: Could not start DB transaction!" + string(err.Error()))
 		return false, err
 	}
+	defer t.Rollback()
+
+	orgUnitId := p.OrgUnitId
+	if orgUnitId == 0 {
+		orgUnitId = DefaultOrgUnitId
+	}
+
+	fullName := p.FullName
+	if fullName == "" {
+		fullName = p.UserName
+	}
 
-	q, err := t.Prepare("INSERT INTO Users (UserName, PasswordHash) VALUES (?, ?)")
+	q, err := t.Prepare("INSERT INTO Users (UserName, FullName, Email, Timezone, Locale, PasswordHash, OrgUnitId) " +
+		"VALUES (?, ?, ?, ?, ?, ?, ?)")
 	if err != nil {
 		log.Println("ERROR: Could not prepare the DB query!" + string(err.Error()))
 		return false, err
 	}
 
-	// take password and hash it
-	hash := sha512.Sum512([]byte(p.Password))
-	passwdHash := hex.EncodeToString(hash[:])
-
-	_, err = q.Exec(p.UserName, passwdHash)
+	result, err := q.Exec(p.UserName, fullName, nullString(p.Email), nullString(p.Timezone), nullString(p.Locale),
+		passwdHash, orgUnitId)
 	if err != nil {
 		log.Println("ERROR: Cannot create user '" + p.UserName + "': " + string(err.Error()))
 		return false, err
 	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return false, err
+	}
 
-	t.Commit()
+	// every user starts out as a plain member
+	_, err = t.Exec("INSERT INTO UserRoles (UserId, RoleId) SELECT ?, Id FROM Roles WHERE RoleName = ?", id, RoleMember)
+	if err != nil {
+		log.Println("ERROR: Cannot grant role to user '" + p.UserName + "': " + string(err.Error()))
+		return false, err
+	}
+
+	if err = t.Commit(); err != nil {
+		log.Println("ERROR: Cannot create user '" + p.UserName + "': " + string(err.Error()))
+		return false, err
+	}
 
 	log.Println("INFO: User '" + p.UserName + "' created")
 	return true, nil
 }
 
+// GetUsersByEmail returns the users with an email address, ignoring case
+func GetUsersByEmail(email string) ([]User, error) {
+	rows, err := DB.Query("SELECT "+userColumns+" FROM Users WHERE Email = ? COLLATE NOCASE", email)
+	if err != nil {
+		log.Println("ERROR: Cannot retrieve users by email: " + string(err.Error()))
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := make([]User, 0)
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+
+	return users, rows.Err()
+}
+
+// insertExternalUser adds a member whose password is checked elsewhere, such
+// as by an identity provider or a directory, recording where they came from
+// and for directory accounts their entry. The user gets a random password,
+// so that they can only log in that way until they set one
+func insertExternalUser(t *sql.Tx, p ProposedUser, fullName string, source string, ldapDn string) (int, error) {
+	password := make([]byte, 32)
+	if _, err := rand.Read(password); err != nil {
+		return 0, err
+	}
+	passwdHash, err := passhash.Hash(base64.RawStdEncoding.EncodeToString(password))
+	if err != nil {
+		log.Println("ERROR: Cannot hash password: " + string(err.Error()))
+		return 0, err
+	}
+
+	orgUnitId := p.OrgUnitId
+	if orgUnitId == 0 {
+		orgUnitId = DefaultOrgUnitId
+	}
+
+	result, err := t.Exec("INSERT INTO Users (UserName, FullName, Email, PasswordHash, OrgUnitId, Source, LdapDn) "+
+		"VALUES (?, ?, ?, ?, ?, ?, ?)", p.UserName, fullName, nullString(p.Email), passwdHash, orgUnitId, source,
+		nullString(ldapDn))
+	if err != nil {
+		log.Println("ERROR: Cannot create user '" + p.UserName + "': " + string(err.Error()))
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = t.Exec("INSERT INTO UserRoles (UserId, RoleId) SELECT ?, Id FROM Roles WHERE RoleName = ?", id, RoleMember)
+	if err != nil {
+		log.Println("ERROR: Cannot grant role to user '" + p.UserName + "': " + string(err.Error()))
+		return 0, err
+	}
+
+	return int(id), nil
+}
+
+// CreateLdapUser creates a member for a directory entry on their first
+// login, linked to the entry
+func CreateLdapUser(p ProposedUser, fullName string, dn string) (User, error) {
+	log.Println("INFO: User creation requested for LDAP entry '" + dn + "': " + p.UserName)
+	t, err := DB.Begin()
+	if err != nil {
+		log.Println("ERROR: Could not start DB transaction!" + string(err.Error()))
+		return User{}, err
+	}
+	defer t.Rollback()
+
+	id, err := insertExternalUser(t, p, fullName, SourceLdap, dn)
+	if err != nil {
+		return User{}, err
+	}
+	if err := t.Commit(); err != nil {
+		return User{}, err
+	}
+
+	log.Println("INFO: User '" + p.UserName + "' created")
+	return GetUserById(id)
+}
+
+// SetUserLdapDn links a user to a directory entry, so that they may log in
+// with its password, or unlinks them when dn is empty
+func SetUserLdapDn(userId int, dn string) error {
+	log.Println("INFO: LDAP link change requested for user: " + strconv.Itoa(userId))
+	_, err := DB.Exec("UPDATE Users SET LdapDn = ?, LastChangedDate = ? WHERE Id = ?", nullString(dn), Now(), userId)
+	if err != nil {
+		log.Println("ERROR: Cannot link user '" + strconv.Itoa(userId) + "' to LDAP entry: " + string(err.Error()))
+	}
+
+	return err
+}
+
+// SetUserServiceAccount marks a user as a service account, which may log in
+// with a client certificate, or unmarks them
+func SetUserServiceAccount(userId int, serviceAccount bool) error {
+	log.Println("INFO: Service account change requested for user: " + strconv.Itoa(userId))
+	_, err := DB.Exec("UPDATE Users SET ServiceAccount = ?, LastChangedDate = ? WHERE Id = ?", serviceAccount, Now(),
+		userId)
+	if err != nil {
+		log.Println("ERROR: Cannot change service account of user '" + strconv.Itoa(userId) + "': " +
+			string(err.Error()))
+	}
+
+	return err
+}
+
+// GetUserByLdapDn returns the user linked to a directory entry, or an empty
+// user if there is none. DNs are compared without regard to case
+func GetUserByLdapDn(dn string) (User, error) {
+	user, err := scanUser(DB.QueryRow("SELECT "+userColumns+" FROM Users WHERE LdapDn = ? COLLATE NOCASE", dn))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return User{}, nil
+		}
+		log.Println("ERROR: Cannot retrieve user of LDAP entry '" + dn + "': " + string(err.Error()))
+		return User{}, err
+	}
+
+	return user, nil
+}
+
 func DeleteUser(username string) (bool, error) {
 	log.Println("INFO: User deletion requested: " + username)
 	t, err := DB.Begin()
@@ -194,7 +398,10 @@ func DeleteUser(username string) (bool, error) {
 		return false, err
 	}
 
-	t.Commit()
+	if err = t.Commit(); err != nil {
+		log.Println("ERROR: Cannot delete user '" + username + "': " + string(err.Error()))
+		return false, err
+	}
 
 	log.Println("INFO: User '" + username + "' has been deleted")
 	return true, nil
@@ -202,7 +409,7 @@ func DeleteUser(username string) (bool, error) {
 
 func GetUsers() ([]User, error) {
 	log.Println("INFO: List of user object requested")
-	rows, err := DB.Query("SELECT * FROM Users")
+	rows, err := DB.Query("SELECT " + userColumns + " FROM Users")
 	if err != nil {
 		log.Println("ERROR: Could not run the DB query!" + string(err.Error()))
 		return nil, err
@@ -210,16 +417,7 @@ func GetUsers() ([]User, error) {
 
 	users := make([]User, 0)
 	for rows.Next() {
-		user := User{}
-		err = rows.Scan(
-			&user.Id,
-			&user.UserName,
-			&user.FullName,
-			&user.PasswordHash,
-			&user.Status,
-			&user.CreationDate,
-			&user.LastChangedDate,
-		)
+		user, err := scanUser(rows)
 		if err != nil {
 			log.Println("ERROR: Cannot marshal the user objects!" + string(err.Error()))
 			return nil, err
@@ -267,8 +465,9 @@ func SetUserStatus(username string, j UserStatus) (bool, error) {
 		log.Println("ERROR: Could not start DB transaction: " + string(err.Error()))
 		return false, err
 	}
+	defer t.Rollback()
 
-	q, err := DB.Prepare("UPDATE Users SET Status = ? WHERE UserName = ?")
+	q, err := t.Prepare("UPDATE Users SET Status = ?, LockReason = ?, LockDate = ? WHERE UserName = ?")
 	if err != nil {
 		log.Println("ERROR: Could not prepare DB query! " + string(err.Error()))
 		return false, err
@@ -280,7 +479,14 @@ func SetUserStatus(username string, j UserStatus) (bool, error) {
 		return false, &InvalidStatusValue{Err: errors.New("invalid value: " + j.Status)}
 	}
 
-	result, err := q.Exec(j.Status, username)
+	// locked users keep why and when they were locked until unlocked
+	lockReason, lockDate := sql.NullString{}, sql.NullString{}
+	if j.Status == "locked" {
+		lockReason = nullString(j.Reason)
+		lockDate = nullString(Now())
+	}
+
+	result, err := q.Exec(j.Status, lockReason, lockDate, username)
 	if err != nil {
 		log.Println("ERROR: Could not execute query for user '" + username + "': " + string(err.Error()))
 		return false, err
@@ -290,7 +496,25 @@ func SetUserStatus(username string, j UserStatus) (bool, error) {
 		return false, err
 	}
 
-	t.Commit()
+	// a locked user is signed out everywhere at once
+	if j.Status == "locked" {
+		_, err = t.Exec("DELETE FROM Sessions WHERE UserId = (SELECT Id FROM Users WHERE UserName = ?)", username)
+		if err != nil {
+			log.Println("ERROR: Could not revoke sessions for user '" + username + "': " + string(err.Error()))
+			return false, err
+		}
+	}
+	// an unlocked user starts over with a clean slate of failed logins
+	if j.Status == "enabled" {
+		if err := clearLoginFailures(t, username); err != nil {
+			return false, err
+		}
+	}
+
+	if err = t.Commit(); err != nil {
+		log.Println("ERROR: Could not change status of user '" + username + "': " + string(err.Error()))
+		return false, err
+	}
 
 	log.Println("INFO: SQL result: Rows: " + strconv.Itoa(int(numberOfRows)))
 	return true, nil
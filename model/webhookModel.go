@@ -0,0 +1,240 @@
+package model
+
+import (
+	"database/sql"
+	"log"
+	"strconv"
+	"strings"
+)
+
+const webhookColumns = "Id, Url, Secret, Events, Active, CreatorId, CreationDate"
+
+const deliveryColumns = "Id, WebhookId, EventId, EventType, Payload, Status, Attempts, ResponseCode, " +
+	"ResponseBody, LastError, NextAttemptDate, CreationDate, DeliveredDate"
+
+func scanWebhook(r rowScanner) (Webhook, error) {
+	hook := Webhook{}
+	var eventList string
+	err := r.Scan(
+		&hook.Id,
+		&hook.Url,
+		&hook.Secret,
+		&eventList,
+		&hook.Active,
+		&hook.CreatorId,
+		&hook.CreationDate,
+	)
+	hook.Events = strings.Split(eventList, ",")
+
+	return hook, err
+}
+
+func scanDelivery(r rowScanner) (WebhookDelivery, error) {
+	d := WebhookDelivery{}
+	var responseCode sql.NullInt64
+	var responseBody, lastError, deliveredDate sql.NullString
+	err := r.Scan(
+		&d.Id,
+		&d.WebhookId,
+		&d.EventId,
+		&d.EventType,
+		&d.Payload,
+		&d.Status,
+		&d.Attempts,
+		&responseCode,
+		&responseBody,
+		&lastError,
+		&d.NextAttemptDate,
+		&d.CreationDate,
+		&deliveredDate,
+	)
+	d.ResponseCode = int(responseCode.Int64)
+	d.ResponseBody = responseBody.String
+	d.LastError = lastError.String
+	d.DeliveredDate = deliveredDate.String
+
+	return d, err
+}
+
+func queryDeliveries(query string, args ...any) ([]WebhookDelivery, error) {
+	rows, err := DB.Query(query, args...)
+	if err != nil {
+		log.Println("ERROR: Could not run the DB query!" + string(err.Error()))
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := make([]WebhookDelivery, 0)
+	for rows.Next() {
+		d, err := scanDelivery(rows)
+		if err != nil {
+			log.Println("ERROR: Cannot marshal the delivery objects!" + string(err.Error()))
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+
+	return deliveries, rows.Err()
+}
+
+func CreateWebhook(p ProposedWebhook, creatorId int) (Webhook, error) {
+	log.Println("INFO: Webhook creation requested: " + p.Url)
+	t, err := DB.Begin()
+	if err != nil {
+		log.Println("ERROR: Could not start DB transaction!" + string(err.Error()))
+		return Webhook{}, err
+	}
+	defer t.Rollback()
+
+	q, err := t.Prepare("INSERT INTO Webhooks (Url, Secret, Events, CreatorId) VALUES (?, ?, ?, ?)")
+	if err != nil {
+		log.Println("ERROR: Could not prepare the DB query!" + string(err.Error()))
+		return Webhook{}, err
+	}
+
+	result, err := q.Exec(p.Url, p.Secret, strings.Join(p.Events, ","), creatorId)
+	if err != nil {
+		log.Println("ERROR: Cannot create webhook for '" + p.Url + "': " + string(err.Error()))
+		return Webhook{}, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return Webhook{}, err
+	}
+
+	if err = t.Commit(); err != nil {
+		return Webhook{}, err
+	}
+
+	log.Println("INFO: Webhook for '" + p.Url + "' created")
+	return GetWebhookById(int(id))
+}
+
+func DeleteWebhook(id int) (bool, error) {
+	idString := strconv.Itoa(id)
+	log.Println("INFO: Webhook deletion requested: " + idString)
+	t, err := DB.Begin()
+	if err != nil {
+		log.Println("ERROR: Could not start DB transaction!" + string(err.Error()))
+		return false, err
+	}
+	defer t.Rollback()
+
+	q, err := t.Prepare("DELETE FROM Webhooks WHERE Id IS ?")
+	if err != nil {
+		log.Println("ERROR: Could not prepare the DB query!" + string(err.Error()))
+		return false, err
+	}
+
+	result, err := q.Exec(id)
+	if err != nil {
+		log.Println("ERROR: Cannot delete webhook '" + idString + "': " + string(err.Error()))
+		return false, err
+	}
+	numberOfRows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	if err = t.Commit(); err != nil {
+		return false, err
+	}
+
+	log.Println("INFO: Webhook with Id '" + idString + "' has been deleted")
+	return numberOfRows > 0, nil
+}
+
+func GetWebhookById(id int) (Webhook, error) {
+	log.Println("INFO: Webhook by Id requested: " + strconv.Itoa(id))
+	rec, err := DB.Prepare("SELECT " + webhookColumns + " FROM Webhooks WHERE Id = ?")
+	if err != nil {
+		log.Println("ERROR: Could not prepare the DB query!" + string(err.Error()))
+		return Webhook{}, err
+	}
+
+	hook, err := scanWebhook(rec.QueryRow(id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			log.Println("ERROR: No such webhook found in DB: " + string(err.Error()))
+			return Webhook{}, nil
+		}
+		log.Println("ERROR: Cannot retrieve webhook from DB: " + string(err.Error()))
+		return Webhook{}, err
+	}
+
+	return hook, nil
+}
+
+func GetWebhooks() ([]Webhook, error) {
+	log.Println("INFO: List of webhook objects requested")
+	rows, err := DB.Query("SELECT " + webhookColumns + " FROM Webhooks ORDER BY Id")
+	if err != nil {
+		log.Println("ERROR: Could not run the DB query!" + string(err.Error()))
+		return nil, err
+	}
+	defer rows.Close()
+
+	hooks := make([]Webhook, 0)
+	for rows.Next() {
+		hook, err := scanWebhook(rows)
+		if err != nil {
+			log.Println("ERROR: Cannot marshal the webhook objects!" + string(err.Error()))
+			return nil, err
+		}
+		hooks = append(hooks, hook)
+	}
+
+	return hooks, rows.Err()
+}
+
+// EnqueueDelivery stores a payload to be posted to a webhook by the delivery worker
+func EnqueueDelivery(webhookId int, eventId string, eventType string, payload string) error {
+	_, err := DB.Exec("INSERT INTO WebhookDeliveries (WebhookId, EventId, EventType, Payload, NextAttemptDate) "+
+		"VALUES (?, ?, ?, ?, ?)", webhookId, eventId, eventType, payload, Now())
+	if err != nil {
+		log.Println("ERROR: Cannot queue delivery for webhook '" + strconv.Itoa(webhookId) + "': " + string(err.Error()))
+	}
+
+	return err
+}
+
+// GetDueDeliveries returns pending deliveries whose next attempt is due
+func GetDueDeliveries(now string, limit int) ([]WebhookDelivery, error) {
+	return queryDeliveries("SELECT "+deliveryColumns+" FROM WebhookDeliveries WHERE Status = 'pending'"+
+		" AND NextAttemptDate <= ? ORDER BY NextAttemptDate LIMIT ?", now, limit)
+}
+
+// GetDeliveriesForWebhook returns the most recent deliveries for a webhook
+func GetDeliveriesForWebhook(webhookId int, limit int) ([]WebhookDelivery, error) {
+	log.Println("INFO: Delivery log requested for webhook: " + strconv.Itoa(webhookId))
+	return queryDeliveries("SELECT "+deliveryColumns+" FROM WebhookDeliveries WHERE WebhookId = ?"+
+		" ORDER BY Id DESC LIMIT ?", webhookId, limit)
+}
+
+// RecordDeliveryAttempt stores the outcome of posting a delivery. A
+// delivered attempt is final; a failed one is retried at nextAttempt, or
+// given up on when nextAttempt is empty
+func RecordDeliveryAttempt(id int, delivered bool, responseCode int, responseBody string, lastError string,
+	nextAttempt string) error {
+	status := "pending"
+	deliveredDate := sql.NullString{}
+	if delivered {
+		status = "delivered"
+		deliveredDate = nullString(Now())
+	} else if nextAttempt == "" {
+		status = "failed"
+	}
+	if nextAttempt == "" {
+		nextAttempt = Now()
+	}
+
+	_, err := DB.Exec("UPDATE WebhookDeliveries SET Status = ?, Attempts = Attempts + 1, ResponseCode = ?, "+
+		"ResponseBody = ?, LastError = ?, NextAttemptDate = ?, DeliveredDate = ? WHERE Id = ?",
+		status, nullInt(responseCode), nullString(responseBody), nullString(lastError), nextAttempt,
+		deliveredDate, id)
+	if err != nil {
+		log.Println("ERROR: Cannot record attempt for delivery '" + strconv.Itoa(id) + "': " + string(err.Error()))
+	}
+
+	return err
+}
@@ -0,0 +1,456 @@
+package model
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// WorkspaceVersion is the version of the workspace archive format written by
+// GetWorkspace. ImportWorkspace refuses archives of any other version
+const WorkspaceVersion = 1
+
+// GetWorkspace collects everything a user can see into an archive: their
+// lists, todos and the tags, comments and history of those todos
+func GetWorkspace(user User) (Workspace, error) {
+	log.Println("INFO: Workspace export requested for user: " + user.UserName)
+	w := Workspace{
+		Version:    WorkspaceVersion,
+		ExportedAt: time.Now().UTC().Format(time.RFC3339),
+		UserName:   user.UserName,
+		Lists:      make([]WorkspaceList, 0),
+		Tags:       make([]string, 0),
+		Todos:      make([]WorkspaceTodo, 0),
+		Comments:   make([]Comment, 0),
+		History:    make([]HistoryEntry, 0),
+	}
+
+	userNames := map[int]string{0: "", user.Id: user.UserName}
+	userName := func(id int) (string, error) {
+		if name, found := userNames[id]; found {
+			return name, nil
+		}
+		u, err := GetUserById(id)
+		if err != nil {
+			return "", err
+		}
+		userNames[id] = u.UserName
+		return u.UserName, nil
+	}
+
+	lists, err := GetListsForUser(user.Id)
+	if err != nil {
+		return Workspace{}, err
+	}
+	for _, l := range lists {
+		owner, err := userName(l.OwnerId)
+		if err != nil {
+			return Workspace{}, err
+		}
+		members, err := GetListMembers(l.Id)
+		if err != nil {
+			return Workspace{}, err
+		}
+		memberNames := make([]string, 0, len(members))
+		for _, m := range members {
+			memberNames = append(memberNames, m.UserName)
+		}
+		w.Lists = append(w.Lists, WorkspaceList{
+			Id:              l.Id,
+			Name:            l.Name,
+			Owner:           owner,
+			Members:         memberNames,
+			CreationDate:    l.CreationDate,
+			LastChangedDate: l.LastChangedDate,
+		})
+	}
+
+	todos, err := GetTodosForUser(user.Id)
+	if err != nil {
+		return Workspace{}, err
+	}
+	tags := make(map[string]bool)
+	for _, todo := range todos {
+		creator, err := userName(todo.CreatorId)
+		if err != nil {
+			return Workspace{}, err
+		}
+		assignee, err := userName(todo.AssigneeId)
+		if err != nil {
+			return Workspace{}, err
+		}
+		w.Todos = append(w.Todos, WorkspaceTodo{
+			Id:              todo.Id,
+			Description:     todo.Description,
+			Status:          todo.Status,
+			Creator:         creator,
+			Assignee:        assignee,
+			ListId:          todo.ListId,
+			ParentId:        todo.ParentId,
+			DueDate:         todo.DueDate,
+			RemindDate:      todo.RemindDate,
+			CompletionDate:  todo.CompletionDate,
+			Priority:        todo.Priority,
+			Tags:            todo.Tags,
+			CreationDate:    todo.CreationDate,
+			LastChangedDate: todo.LastChangedDate,
+		})
+		for _, tag := range todo.Tags {
+			tags[tag] = true
+		}
+
+		comments, err := GetCommentsForTodo(todo.Id)
+		if err != nil {
+			return Workspace{}, err
+		}
+		w.Comments = append(w.Comments, comments...)
+		history, err := GetTodoHistory(todo.Id)
+		if err != nil {
+			return Workspace{}, err
+		}
+		w.History = append(w.History, history...)
+	}
+	// subtasks of todos the user cannot see are exported on their own
+	exported := make(map[int]bool)
+	for _, todo := range w.Todos {
+		exported[todo.Id] = true
+	}
+	for i := range w.Todos {
+		if !exported[w.Todos[i].ParentId] {
+			w.Todos[i].ParentId = 0
+		}
+	}
+	for tag := range tags {
+		w.Tags = append(w.Tags, tag)
+	}
+	sort.Strings(w.Tags)
+
+	return w, nil
+}
+
+// lookupUserId returns the Id of the user with the given name in the org unit
+// of the importing user, or 0 if there is none
+func lookupUserId(t *sql.Tx, importerId int, userName string) (int, error) {
+	if userName == "" {
+		return 0, nil
+	}
+
+	var id int
+	err := t.QueryRow("SELECT Id FROM Users WHERE UserName = ? AND "+
+		"OrgUnitId = (SELECT OrgUnitId FROM Users WHERE Id = ?)", userName, importerId).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+
+	return id, err
+}
+
+// importDate normalizes a date from an archive, defaulting to now
+func importDate(s string) (string, error) {
+	d, err := NormalizeDate(s)
+	if d == "" && err == nil {
+		d = Now()
+	}
+
+	return d, err
+}
+
+// ImportWorkspace stores an archive for a user in a single transaction. Every
+// Id in the archive is replaced by a new one and the references between lists,
+// todos, comments and history entries are remapped to match. The importing
+// user owns the lists and creates the todos; other users named in the archive
+// are kept as list members, assignees and comment authors when they exist in
+// the org unit of the importing user. When merging, lists with the name of a
+// list the user owns are merged into it. When replacing, the lists the user
+// owns and the todos they created or that are on those lists are deleted
+// first. A dry run does all of this but rolls it back, reporting what would
+// have been created
+func ImportWorkspace(userId int, w Workspace, opts ImportOptions) (WorkspaceImport, error) {
+	log.Println("INFO: Workspace import requested for user: " + strconv.Itoa(userId))
+	if w.Version != WorkspaceVersion {
+		return WorkspaceImport{}, &ImportItemFailed{Item: "archive",
+			Err: errors.New("unsupported archive version " + strconv.Itoa(w.Version))}
+	}
+
+	imported := WorkspaceImport{
+		Lists:        make([]List, 0),
+		Todos:        make([]Todo, 0),
+		DeletedLists: make([]List, 0),
+		DeletedTodos: make([]Todo, 0),
+	}
+	ownedLists := make(map[string]int)
+	lists, err := GetListsForUser(userId)
+	if err != nil {
+		return WorkspaceImport{}, err
+	}
+	for _, l := range lists {
+		if l.OwnerId != userId {
+			continue
+		}
+		if opts.Replace {
+			imported.DeletedLists = append(imported.DeletedLists, l)
+		} else {
+			ownedLists[l.Name] = l.Id
+		}
+	}
+	if opts.Replace {
+		imported.DeletedTodos, err = queryTodos(todoSelect+" WHERE Todos.CreatorId = ? OR Todos.ListId IN "+
+			"(SELECT Id FROM Lists WHERE OwnerId = ?) ORDER BY Todos.Id", userId, userId)
+		if err != nil {
+			return WorkspaceImport{}, err
+		}
+	}
+
+	t, err := DB.Begin()
+	if err != nil {
+		log.Println("ERROR: Could not start DB transaction!" + string(err.Error()))
+		return WorkspaceImport{}, err
+	}
+	defer t.Rollback()
+
+	if opts.Replace {
+		if _, err := t.Exec("DELETE FROM Todos WHERE CreatorId = ? OR ListId IN (SELECT Id FROM Lists WHERE OwnerId = ?)",
+			userId, userId); err != nil {
+			log.Println("ERROR: Cannot delete the todos of user '" + strconv.Itoa(userId) + "': " + string(err.Error()))
+			return WorkspaceImport{}, err
+		}
+		if _, err := t.Exec("DELETE FROM Lists WHERE OwnerId = ?", userId); err != nil {
+			log.Println("ERROR: Cannot delete the lists of user '" + strconv.Itoa(userId) + "': " + string(err.Error()))
+			return WorkspaceImport{}, err
+		}
+	}
+
+	listIds := make(map[int]int)
+	newLists := make([]int, 0)
+	for i, l := range w.Lists {
+		fail := func(err error) (WorkspaceImport, error) {
+			return WorkspaceImport{}, &ImportItemFailed{Index: i, Item: "list " + strconv.Itoa(l.Id), Err: err}
+		}
+		if l.Name == "" {
+			return fail(errors.New("list name must not be empty"))
+		}
+		if _, seen := listIds[l.Id]; seen {
+			return fail(errors.New("duplicate list Id"))
+		}
+
+		listId, found := ownedLists[l.Name]
+		if !found {
+			creationDate, err := importDate(l.CreationDate)
+			if err != nil {
+				return fail(err)
+			}
+			lastChangedDate, err := importDate(l.LastChangedDate)
+			if err != nil {
+				return fail(err)
+			}
+			result, err := t.Exec("INSERT INTO Lists (Name, OwnerId, CreationDate, LastChangedDate) VALUES (?, ?, ?, ?)",
+				l.Name, userId, creationDate, lastChangedDate)
+			if err != nil {
+				log.Println("ERROR: Cannot create list '" + l.Name + "': " + string(err.Error()))
+				return fail(err)
+			}
+			id, err := result.LastInsertId()
+			if err != nil {
+				return fail(err)
+			}
+			listId = int(id)
+			newLists = append(newLists, listId)
+		}
+		listIds[l.Id] = listId
+
+		// the original owner stays on the list as a member
+		for _, name := range append([]string{l.Owner}, l.Members...) {
+			memberId, err := lookupUserId(t, userId, name)
+			if err != nil {
+				return fail(err)
+			}
+			if memberId == 0 || memberId == userId {
+				continue
+			}
+			if _, err := t.Exec("INSERT OR IGNORE INTO ListMembers (ListId, UserId) VALUES (?, ?)",
+				listId, memberId); err != nil {
+				log.Println("ERROR: Cannot add member to list '" + l.Name + "': " + string(err.Error()))
+				return fail(err)
+			}
+		}
+	}
+
+	todoIds := make(map[int]int)
+	newTodos := make([]int, 0, len(w.Todos))
+	for i, todo := range w.Todos {
+		fail := func(err error) (WorkspaceImport, error) {
+			return WorkspaceImport{}, &ImportItemFailed{Index: i, Item: "todo " + strconv.Itoa(todo.Id), Err: err}
+		}
+		if _, seen := todoIds[todo.Id]; seen {
+			return fail(errors.New("duplicate todo Id"))
+		}
+		if err := ValidatePriority(todo.Priority); err != nil {
+			return fail(err)
+		}
+		tags, err := NormalizeTags(todo.Tags)
+		if err != nil {
+			return fail(err)
+		}
+		dates := []string{todo.DueDate, todo.RemindDate, todo.CompletionDate}
+		for j := range dates {
+			if dates[j], err = NormalizeDate(dates[j]); err != nil {
+				return fail(err)
+			}
+		}
+		creationDate, err := importDate(todo.CreationDate)
+		if err != nil {
+			return fail(err)
+		}
+		lastChangedDate, err := importDate(todo.LastChangedDate)
+		if err != nil {
+			return fail(err)
+		}
+
+		var statusId int
+		if err := t.QueryRow("SELECT Id FROM Statuses WHERE StatusName = ?", todo.Status).Scan(&statusId); err != nil {
+			if err == sql.ErrNoRows {
+				return fail(&InvalidTodoStatus{Err: errors.New("invalid todo status: " + todo.Status)})
+			}
+			return fail(err)
+		}
+		listId := 0
+		if todo.ListId != 0 {
+			if listId = listIds[todo.ListId]; listId == 0 {
+				return fail(errors.New("no list with Id " + strconv.Itoa(todo.ListId) + " in the archive"))
+			}
+		}
+		assigneeId, err := lookupUserId(t, userId, todo.Assignee)
+		if err != nil {
+			return fail(err)
+		}
+
+		result, err := t.Exec("INSERT INTO Todos (Description, Status, CreatorId, AssigneeId, ListId, DueDate, "+
+			"RemindDate, CompletionDate, Priority, CreationDate, LastChangedDate) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+			todo.Description, statusId, userId, nullInt(assigneeId), nullInt(listId), nullString(dates[0]),
+			nullString(dates[1]), nullString(dates[2]), todo.Priority, creationDate, lastChangedDate)
+		if err != nil {
+			log.Println("ERROR: Cannot create todo with description '" + todo.Description + "': " + string(err.Error()))
+			return fail(err)
+		}
+		id, err := result.LastInsertId()
+		if err != nil {
+			return fail(err)
+		}
+		if err = setTodoTags(t, int(id), tags); err != nil {
+			return fail(err)
+		}
+		// the archive brings its own history, replacing what the triggers
+		// recorded for the import itself
+		if _, err = t.Exec("DELETE FROM TodoHistory WHERE TodoId = ?", id); err != nil {
+			return fail(err)
+		}
+		todoIds[todo.Id] = int(id)
+		newTodos = append(newTodos, int(id))
+	}
+
+	// parents may come after their subtasks, so link them once all exist
+	for i, todo := range w.Todos {
+		if todo.ParentId == 0 {
+			continue
+		}
+		parentId := todoIds[todo.ParentId]
+		if parentId == 0 || todo.ParentId == todo.Id {
+			return WorkspaceImport{}, &ImportItemFailed{Index: i, Item: "todo " + strconv.Itoa(todo.Id),
+				Err: errors.New("no parent todo with Id " + strconv.Itoa(todo.ParentId) + " in the archive")}
+		}
+		if _, err := t.Exec("UPDATE Todos SET ParentId = ? WHERE Id = ?", parentId, todoIds[todo.Id]); err != nil {
+			log.Println("ERROR: Cannot set the parent of todo '" + strconv.Itoa(todoIds[todo.Id]) + "': " +
+				string(err.Error()))
+			return WorkspaceImport{}, err
+		}
+		imported.Subtasks++
+	}
+
+	for i, comment := range w.Comments {
+		fail := func(err error) (WorkspaceImport, error) {
+			return WorkspaceImport{}, &ImportItemFailed{Index: i, Item: "comment " + strconv.Itoa(comment.Id), Err: err}
+		}
+		todoId := todoIds[comment.TodoId]
+		if todoId == 0 {
+			return fail(errors.New("no todo with Id " + strconv.Itoa(comment.TodoId) + " in the archive"))
+		}
+		authorId, err := lookupUserId(t, userId, comment.Author)
+		if err != nil {
+			return fail(err)
+		}
+		creationDate, err := importDate(comment.CreationDate)
+		if err != nil {
+			return fail(err)
+		}
+		lastChangedDate, err := importDate(comment.LastChangedDate)
+		if err != nil {
+			return fail(err)
+		}
+		if _, err := t.Exec("INSERT INTO Comments (TodoId, AuthorId, Body, CreationDate, LastChangedDate) "+
+			"VALUES (?, ?, ?, ?, ?)", todoId, nullInt(authorId), comment.Body, creationDate, lastChangedDate); err != nil {
+			log.Println("ERROR: Cannot create comment on todo '" + strconv.Itoa(todoId) + "': " + string(err.Error()))
+			return fail(err)
+		}
+		imported.Comments++
+	}
+
+	for i, entry := range w.History {
+		fail := func(err error) (WorkspaceImport, error) {
+			return WorkspaceImport{}, &ImportItemFailed{Index: i, Item: "history entry " + strconv.Itoa(entry.Id), Err: err}
+		}
+		todoId := todoIds[entry.TodoId]
+		if todoId == 0 {
+			return fail(errors.New("no todo with Id " + strconv.Itoa(entry.TodoId) + " in the archive"))
+		}
+		changeDate, err := importDate(entry.ChangeDate)
+		if err != nil {
+			return fail(err)
+		}
+		if _, err := t.Exec("INSERT INTO TodoHistory (TodoId, Field, OldValue, NewValue, ChangeDate) VALUES (?, ?, ?, ?, ?)",
+			todoId, entry.Field, nullString(entry.OldValue), nullString(entry.NewValue), changeDate); err != nil {
+			log.Println("ERROR: Cannot record history of todo '" + strconv.Itoa(todoId) + "': " + string(err.Error()))
+			return fail(err)
+		}
+		imported.History++
+	}
+
+	if opts.DryRun {
+		for _, id := range newLists {
+			var name string
+			if err := t.QueryRow("SELECT Name FROM Lists WHERE Id = ?", id).Scan(&name); err != nil {
+				return WorkspaceImport{}, err
+			}
+			imported.Lists = append(imported.Lists, List{Name: name, OwnerId: userId})
+		}
+		for _, todo := range w.Todos {
+			imported.Todos = append(imported.Todos, Todo{Description: todo.Description, Status: todo.Status,
+				Tags: todo.Tags})
+		}
+		return imported, nil
+	}
+
+	if err = t.Commit(); err != nil {
+		return WorkspaceImport{}, err
+	}
+
+	for _, id := range newLists {
+		list, err := GetListById(id)
+		if err != nil {
+			return WorkspaceImport{}, err
+		}
+		imported.Lists = append(imported.Lists, list)
+	}
+	for _, id := range newTodos {
+		todo, err := GetTodoById(id)
+		if err != nil {
+			return WorkspaceImport{}, err
+		}
+		imported.Todos = append(imported.Todos, todo)
+	}
+
+	log.Println("INFO: " + strconv.Itoa(len(imported.Todos)) + " todos imported from workspace archive")
+	return imported, nil
+}
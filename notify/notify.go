@@ -0,0 +1,236 @@
+// Package notify renders notification emails and delivers them through a
+// persistent outbox so that an unavailable mail relay does not lose messages
+package notify
+
+import (
+	"log"
+	"math"
+	"net/url"
+	"regexp"
+	"time"
+
+	"github.com/greeneg/todoer/globals"
+	"github.com/greeneg/todoer/model"
+)
+
+var (
+	conf      globals.Config
+	templates map[string]messageTemplates
+)
+
+var mentionPattern = regexp.MustCompile(`(?:^|\s)@([A-Za-z0-9_.-]+)`)
+
+// Init loads the mail templates and applies defaults to the notification
+// settings. Templates in <configDir>/templates override the built-in ones
+func Init(c globals.Config, configDir string) error {
+	conf = c
+	if conf.Notifications.PollIntervalSeconds <= 0 {
+		conf.Notifications.PollIntervalSeconds = 30
+	}
+	if conf.Notifications.MaxAttempts <= 0 {
+		conf.Notifications.MaxAttempts = 8
+	}
+	if conf.Notifications.RetryBackoffSeconds <= 0 {
+		conf.Notifications.RetryBackoffSeconds = 60
+	}
+	if conf.Notifications.DueSoonWindowHours <= 0 {
+		conf.Notifications.DueSoonWindowHours = 24
+	}
+	if conf.Notifications.DigestIntervalMinutes <= 0 {
+		conf.Notifications.DigestIntervalMinutes = 24 * 60
+	}
+
+	var err error
+	templates, err = loadTemplates(configDir)
+	return err
+}
+
+// Start launches the outbox delivery worker and the reminder/digest
+// scheduler. It does nothing when SMTP is not enabled
+func Start() {
+	if !conf.Smtp.Enabled {
+		log.Println("INFO: SMTP notifications are disabled")
+		return
+	}
+
+	interval := time.Duration(conf.Notifications.PollIntervalSeconds) * time.Second
+	go func() {
+		for {
+			deliverOutbox()
+			scheduleReminders()
+			scheduleDigests()
+			time.Sleep(interval)
+		}
+	}()
+}
+
+// wanted Reports whether a user wants mails of a kind. Password reset and
+// invitation mails are always sent
+func wanted(kind string, user model.User) bool {
+	n := user.Preferences.Notifications
+	switch kind {
+	case "assignment":
+		return n.Assignments
+	case "mention":
+		return n.Mentions
+	case "reminder":
+		return n.Reminders
+	case "digest":
+		return n.Digest
+	}
+
+	return true
+}
+
+// queue Renders a message and stores it in the outbox, unless the recipient
+// turned that kind of mail off
+func queue(kind string, data messageData) {
+	if !conf.Smtp.Enabled || data.Recipient.Email == "" || !wanted(kind, data.Recipient) {
+		return
+	}
+	data.BaseUrl = conf.Notifications.BaseUrl
+
+	m, err := render(kind, data)
+	if err != nil {
+		log.Println("ERROR: Cannot render '" + kind + "' mail: " + string(err.Error()))
+		return
+	}
+	model.EnqueueMail(m)
+}
+
+// TodoAssigned Notifies the assignee of a todo, unless they assigned it themselves
+func TodoAssigned(todo model.Todo, actor model.User) {
+	if todo.AssigneeId == 0 || todo.AssigneeId == actor.Id {
+		return
+	}
+
+	assignee, err := model.GetUserById(todo.AssigneeId)
+	if err != nil || assignee.UserName == "" {
+		return
+	}
+	queue("assignment", messageData{Recipient: assignee, Actor: actor, Todo: todo})
+}
+
+// TodoMentions Notifies every existing user of the actor's org unit mentioned
+// as @username in the description of a todo, as long as they can see the todo
+func TodoMentions(todo model.Todo, actor model.User) {
+	seen := make(map[string]bool)
+	for _, match := range mentionPattern.FindAllStringSubmatch(todo.Description, -1) {
+		username := match[1]
+		if seen[username] || username == actor.UserName {
+			continue
+		}
+		seen[username] = true
+
+		user, err := model.GetUserByUserName(username)
+		if err != nil || user.UserName == "" || !model.SameOrgUnit(actor.Id, user.Id) ||
+			!model.CanViewTodo(user.Id, todo) {
+			continue
+		}
+		queue("mention", messageData{Recipient: user, Actor: actor, Todo: todo})
+	}
+}
+
+// PasswordReset Mails a password reset token to a user. resetUrl, when set,
+// is the page the token is handed to
+func PasswordReset(user model.User, token string, resetUrl string, expiryMinutes int) {
+	if resetUrl != "" {
+		resetUrl += "?token=" + url.QueryEscape(token)
+	}
+	queue("passwordreset", messageData{Recipient: user, Token: token, ResetUrl: resetUrl, ExpiryMinutes: expiryMinutes})
+}
+
+// Invitation Mails an invitation token to the invitee. inviteUrl, when set,
+// is the page the token is handed to
+func Invitation(invitation model.Invitation, inviter model.User, token string, inviteUrl string) {
+	if inviteUrl != "" {
+		inviteUrl += "?token=" + url.QueryEscape(token)
+	}
+	invitee := model.User{FullName: invitation.FullName, Email: invitation.Email}
+	queue("invitation", messageData{Recipient: invitee, Actor: inviter, Token: token, InviteUrl: inviteUrl,
+		ExpiryDate: invitation.ExpiryDate})
+}
+
+// backoff Returns the delay before the next delivery attempt
+func backoff(attempts int) time.Duration {
+	delay := float64(conf.Notifications.RetryBackoffSeconds) * math.Pow(2, float64(attempts))
+	return time.Duration(math.Min(delay, 6*60*60)) * time.Second
+}
+
+func deliverOutbox() {
+	messages, err := model.GetDeliverableMail(model.Now(), 50)
+	if err != nil {
+		return
+	}
+
+	for _, m := range messages {
+		err := send(m)
+		if err == nil {
+			log.Println("INFO: Delivered '" + m.Kind + "' mail to " + m.Recipient)
+			model.MarkMailSent(m.Id)
+			continue
+		}
+
+		log.Println("WARN: Delivery of mail to " + m.Recipient + " failed: " + string(err.Error()))
+		nextAttempt := ""
+		if m.Attempts+1 < conf.Notifications.MaxAttempts {
+			nextAttempt = time.Now().UTC().Add(backoff(m.Attempts)).Format(model.SqlDateTimeFormat)
+		} else {
+			log.Println("ERROR: Giving up on mail " + m.Subject + " to " + m.Recipient)
+		}
+		model.RecordMailFailure(m.Id, err.Error(), nextAttempt)
+	}
+}
+
+// recipientFor Returns who is responsible for a todo: its assignee if it has
+// one, otherwise its creator
+func recipientFor(todo model.Todo) (model.User, error) {
+	if todo.AssigneeId != 0 {
+		return model.GetUserById(todo.AssigneeId)
+	}
+
+	return model.GetUserById(todo.CreatorId)
+}
+
+func scheduleReminders() {
+	todos, err := model.GetDueReminders(model.Now())
+	if err != nil {
+		return
+	}
+
+	for _, todo := range todos {
+		recipient, err := recipientFor(todo)
+		if err != nil {
+			continue
+		}
+		queue("reminder", messageData{Recipient: recipient, Todo: todo})
+		model.MarkReminderSent(todo.Id)
+	}
+}
+
+func scheduleDigests() {
+	users, err := model.GetUsers()
+	if err != nil {
+		return
+	}
+
+	now := time.Now().UTC()
+	since := now.Add(-time.Duration(conf.Notifications.DigestIntervalMinutes) * time.Minute)
+	until := now.Add(time.Duration(conf.Notifications.DueSoonWindowHours) * time.Hour)
+	for _, user := range users {
+		if user.Email == "" || user.Status == "locked" || !wanted("digest", user) {
+			continue
+		}
+
+		sent, err := model.HasMailSince("digest", user.Email, since.Format(model.SqlDateTimeFormat))
+		if err != nil || sent {
+			continue
+		}
+
+		todos, err := model.GetOpenTodosDueBefore(user.Id, until.Format(model.SqlDateTimeFormat))
+		if err != nil || len(todos) == 0 {
+			continue
+		}
+		queue("digest", messageData{Recipient: user, Todos: todos})
+	}
+}
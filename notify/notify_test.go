@@ -0,0 +1,144 @@
+package notify
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/greeneg/todoer/globals"
+	"github.com/greeneg/todoer/model"
+)
+
+// openDb points the model at a new database with the schema, which comes
+// with the user greeneg
+func openDb(t *testing.T) {
+	t.Helper()
+	schema, err := os.ReadFile(filepath.Join("..", "db", "schema.sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := sql.Open("sqlite3", "file:"+filepath.Join(t.TempDir(), "todoer.db")+"?_foreign_keys=on")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(string(schema)); err != nil {
+		t.Fatal(err)
+	}
+	model.DB = db
+	t.Cleanup(func() { db.Close() })
+}
+
+// addUser Adds a user with the address <username>@example.com to an org
+// unit, returning their Id
+func addUser(t *testing.T, username string, orgUnitId int, preferences string) int {
+	t.Helper()
+	result, err := model.DB.Exec("INSERT INTO Users (UserName, FullName, Email, PasswordHash, OrgUnitId, Preferences) "+
+		"VALUES (?, ?, ?, 'x', ?, ?)", username, username, username+"@example.com", orgUnitId, preferences)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return int(id)
+}
+
+// queuedRecipients Returns the addresses mails of a kind were queued for
+func queuedRecipients(t *testing.T, kind string) []string {
+	t.Helper()
+	messages, err := model.GetDeliverableMail("9999-12-31 23:59:59", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recipients := make([]string, 0)
+	for _, m := range messages {
+		if m.Kind == kind {
+			recipients = append(recipients, m.Recipient)
+		}
+	}
+	sort.Strings(recipients)
+
+	return recipients
+}
+
+func TestTodoMentions(t *testing.T) {
+	openDb(t)
+	if err := Init(globals.Config{Smtp: globals.SmtpConfig{Enabled: true}}, t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conf = globals.Config{} })
+
+	actor, err := model.GetUserByUserName("greeneg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := model.DB.Exec("UPDATE Users SET Email = 'greeneg@example.com' WHERE Id = ?", actor.Id); err != nil {
+		t.Fatal(err)
+	}
+	sales, err := model.CreateOrgUnit(model.ProposedOrgUnit{OrgUnitName: "sales"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	member := addUser(t, "carol", actor.OrgUnitId, "")
+	addUser(t, "dave", actor.OrgUnitId, "")
+	assignee := addUser(t, "gina", actor.OrgUnitId, "")
+	quiet := addUser(t, "frank", actor.OrgUnitId, `{"notifications": {"mentions": false}}`)
+	outsider := addUser(t, "erin", actor.OrgUnitId, "")
+
+	list, err := model.CreateList(model.ProposedList{Name: "release"}, actor.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, userId := range []int{member, quiet, outsider} {
+		if err := model.AddListMember(list.Id, userId); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// erin keeps seeing the list after moving to another org unit
+	if _, err := model.DB.Exec("UPDATE Users SET OrgUnitId = ? WHERE Id = ?", sales.Id, outsider); err != nil {
+		t.Fatal(err)
+	}
+
+	todo := model.Todo{
+		Id: 1,
+		Description: "@carol and @dave, with @gina @frank and @erin: ask @nobody, not @greeneg. " +
+			"Thanks @carol! mail@example.com",
+		CreatorId:  actor.Id,
+		AssigneeId: assignee,
+		ListId:     list.Id,
+	}
+	TodoMentions(todo, actor)
+
+	// dave cannot see the todo, frank turned mentions off and erin is in
+	// another org unit
+	want := []string{"carol@example.com", "gina@example.com"}
+	if got := queuedRecipients(t, "mention"); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("mentions were mailed to %v, want %v", got, want)
+	}
+}
+
+func TestTodoMentionsWithoutSmtp(t *testing.T) {
+	openDb(t)
+	if err := Init(globals.Config{}, t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	actor, err := model.GetUserByUserName("greeneg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addUser(t, "carol", actor.OrgUnitId, "")
+
+	TodoMentions(model.Todo{Id: 1, Description: "ping @carol", CreatorId: actor.Id}, actor)
+
+	if got := queuedRecipients(t, "mention"); len(got) != 0 {
+		t.Errorf("mentions were mailed to %v with SMTP disabled", got)
+	}
+}
@@ -0,0 +1,110 @@
+package notify
+
+import (
+	"bytes"
+	"embed"
+	htmltemplate "html/template"
+	"log"
+	"os"
+	"path/filepath"
+	texttemplate "text/template"
+
+	"github.com/greeneg/todoer/model"
+)
+
+//go:embed templates/*.tmpl
+var defaultTemplates embed.FS
+
+// the kinds of message we know how to render. Each needs a <kind>.txt.tmpl
+// (which also defines the "subject" template) and a <kind>.html.tmpl
+var kinds = []string{"assignment", "mention", "reminder", "digest", "passwordreset", "invitation"}
+
+type messageTemplates struct {
+	text *texttemplate.Template
+	html *htmltemplate.Template
+}
+
+type messageData struct {
+	Recipient model.User
+	Actor     model.User
+	Todo      model.Todo
+	Todos     []model.Todo
+	BaseUrl   string
+	// password reset mails
+	Token         string
+	ResetUrl      string
+	ExpiryMinutes int
+	// invitation mails
+	InviteUrl  string
+	ExpiryDate string
+}
+
+// readTemplate Returns the template source, preferring an override from the
+// config/templates directory over the embedded default
+func readTemplate(configDir string, name string) (string, error) {
+	override := filepath.Join(configDir, "templates", name)
+	if content, err := os.ReadFile(override); err == nil {
+		log.Println("INFO: Using mail template override " + override)
+		return string(content), nil
+	} else if !os.IsNotExist(err) {
+		return "", err
+	}
+
+	content, err := defaultTemplates.ReadFile("templates/" + name)
+	if err != nil {
+		return "", err
+	}
+
+	return string(content), nil
+}
+
+func loadTemplates(configDir string) (map[string]messageTemplates, error) {
+	loaded := make(map[string]messageTemplates)
+	for _, kind := range kinds {
+		textSource, err := readTemplate(configDir, kind+".txt.tmpl")
+		if err != nil {
+			return nil, err
+		}
+		textTmpl, err := texttemplate.New(kind).Parse(textSource)
+		if err != nil {
+			return nil, err
+		}
+
+		htmlSource, err := readTemplate(configDir, kind+".html.tmpl")
+		if err != nil {
+			return nil, err
+		}
+		htmlTmpl, err := htmltemplate.New(kind).Parse(htmlSource)
+		if err != nil {
+			return nil, err
+		}
+
+		loaded[kind] = messageTemplates{text: textTmpl, html: htmlTmpl}
+	}
+
+	return loaded, nil
+}
+
+// render Builds an outbox message for the given kind of notification
+func render(kind string, data messageData) (model.MailMessage, error) {
+	tmpls := templates[kind]
+
+	var subject, text, html bytes.Buffer
+	if err := tmpls.text.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return model.MailMessage{}, err
+	}
+	if err := tmpls.text.Execute(&text, data); err != nil {
+		return model.MailMessage{}, err
+	}
+	if err := tmpls.html.Execute(&html, data); err != nil {
+		return model.MailMessage{}, err
+	}
+
+	return model.MailMessage{
+		Kind:      kind,
+		Recipient: data.Recipient.Email,
+		Subject:   subject.String(),
+		TextBody:  text.String(),
+		HtmlBody:  html.String(),
+	}, nil
+}
@@ -0,0 +1,264 @@
+// Package oidc logs users in through an OpenID Connect identity provider with
+// the authorization code flow and PKCE. The provider's endpoints are read
+// from its discovery document, and ID tokens are checked against the keys it
+// publishes
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/greeneg/todoer/globals"
+)
+
+// defaults for the settings left unset in the config
+var (
+	DefaultScopes        = []string{"openid", "profile", "email"}
+	DefaultUsernameClaim = "preferred_username"
+)
+
+var (
+	ErrDisabled     = errors.New("OpenID Connect login is not enabled")
+	ErrInvalidToken = errors.New("invalid ID token")
+)
+
+// Request holds what has to be remembered between sending a browser to the
+// provider and it coming back: the state that ties the two together, the
+// nonce expected in the ID token and the PKCE code verifier
+type Request struct {
+	State    string
+	Nonce    string
+	Verifier string
+}
+
+// Identity is who the provider says logged in
+type Identity struct {
+	Issuer   string
+	Subject  string
+	UserName string
+	FullName string
+	Email    string
+}
+
+// discovery is the part of the provider's discovery document todoer uses
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JwksUri               string `json:"jwks_uri"`
+}
+
+var (
+	config globals.OidcConfig
+	client = &http.Client{Timeout: 10 * time.Second}
+
+	// the discovery document is fetched on first use, so that todoer starts
+	// while the provider is down
+	providerMu sync.Mutex
+	provider   *discovery
+)
+
+// Init checks and keeps the OpenID Connect settings
+func Init(c globals.OidcConfig) error {
+	if !c.Enabled {
+		config = c
+		return nil
+	}
+	if c.Issuer == "" || c.ClientId == "" || c.RedirectUrl == "" {
+		return errors.New("oidc: issuer, clientId and redirectUrl are required")
+	}
+	if len(c.Scopes) == 0 {
+		c.Scopes = DefaultScopes
+	}
+	if !slices.Contains(c.Scopes, "openid") {
+		c.Scopes = append([]string{"openid"}, c.Scopes...)
+	}
+	if c.UsernameClaim == "" {
+		c.UsernameClaim = DefaultUsernameClaim
+	}
+	config = c
+
+	return nil
+}
+
+// Enabled reports whether users may log in through the provider
+func Enabled() bool {
+	return config.Enabled
+}
+
+// Config returns the settings in use
+func Config() globals.OidcConfig {
+	return config
+}
+
+// getProvider Returns the discovery document of the issuer, fetching it once
+func getProvider() (*discovery, error) {
+	providerMu.Lock()
+	defer providerMu.Unlock()
+	if provider != nil {
+		return provider, nil
+	}
+
+	var d discovery
+	if err := getJson(strings.TrimSuffix(config.Issuer, "/")+"/.well-known/openid-configuration", &d); err != nil {
+		return nil, err
+	}
+	if d.Issuer != config.Issuer {
+		return nil, errors.New("oidc: discovery document is for issuer '" + d.Issuer + "', not '" + config.Issuer + "'")
+	}
+	if d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" || d.JwksUri == "" {
+		return nil, errors.New("oidc: discovery document lacks an endpoint")
+	}
+	provider = &d
+
+	return provider, nil
+}
+
+// getJson fetches a JSON document from the provider
+func getJson(u string, v any) error {
+	resp, err := client.Get(u)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.New("oidc: " + u + " returned " + resp.Status)
+	}
+
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
+
+// randomString Returns 32 random bytes, base64url encoded
+func randomString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// challenge Returns the S256 PKCE code challenge of a code verifier
+func challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// NewRequest starts a login, returning what has to be kept until the browser
+// comes back and the provider URL to send it to
+func NewRequest() (Request, string, error) {
+	if !Enabled() {
+		return Request{}, "", ErrDisabled
+	}
+	p, err := getProvider()
+	if err != nil {
+		return Request{}, "", err
+	}
+
+	var r Request
+	for _, v := range []*string{&r.State, &r.Nonce, &r.Verifier} {
+		if *v, err = randomString(); err != nil {
+			return Request{}, "", err
+		}
+	}
+
+	authUrl, err := url.Parse(p.AuthorizationEndpoint)
+	if err != nil {
+		return Request{}, "", err
+	}
+	q := authUrl.Query()
+	q.Set("response_type", "code")
+	q.Set("client_id", config.ClientId)
+	q.Set("redirect_uri", config.RedirectUrl)
+	q.Set("scope", strings.Join(config.Scopes, " "))
+	q.Set("state", r.State)
+	q.Set("nonce", r.Nonce)
+	q.Set("code_challenge", challenge(r.Verifier))
+	q.Set("code_challenge_method", "S256")
+	authUrl.RawQuery = q.Encode()
+
+	return r, authUrl.String(), nil
+}
+
+// tokenResponse is the part of the token endpoint's answer todoer uses
+type tokenResponse struct {
+	IdToken          string `json:"id_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// Exchange trades the authorization code the browser came back with for an
+// ID token, and returns the identity in it once the token checks out
+func Exchange(r Request, code string) (Identity, error) {
+	if !Enabled() {
+		return Identity{}, ErrDisabled
+	}
+	p, err := getProvider()
+	if err != nil {
+		return Identity{}, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {config.RedirectUrl},
+		"code_verifier": {r.Verifier},
+		"client_id":     {config.ClientId},
+	}
+	req, err := http.NewRequest(http.MethodPost, p.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return Identity{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if config.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(config.ClientId), url.QueryEscape(config.ClientSecret))
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return Identity{}, err
+	}
+	defer resp.Body.Close()
+
+	var token tokenResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&token); err != nil {
+		return Identity{}, errors.New("oidc: cannot read token response: " + err.Error())
+	}
+	if token.Error != "" {
+		return Identity{}, errors.New("oidc: token request failed: " + token.Error + " " + token.ErrorDescription)
+	}
+	if resp.StatusCode != http.StatusOK || token.IdToken == "" {
+		return Identity{}, errors.New("oidc: token endpoint returned " + resp.Status + " without an ID token")
+	}
+
+	claims, err := verifyIdToken(token.IdToken, r.Nonce)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	identity := Identity{
+		Issuer:   claims.String("iss"),
+		Subject:  claims.String("sub"),
+		UserName: claims.String(config.UsernameClaim),
+		FullName: claims.String("name"),
+		Email:    claims.String("email"),
+	}
+	if identity.UserName == "" {
+		log.Println("ERROR: ID token of '" + identity.Subject + "' lacks the '" + config.UsernameClaim + "' claim")
+		return Identity{}, errors.New("oidc: ID token lacks the '" + config.UsernameClaim + "' claim")
+	}
+
+	return identity, nil
+}
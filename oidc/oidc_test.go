@@ -0,0 +1,292 @@
+package oidc
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/greeneg/todoer/globals"
+)
+
+// testProvider is an identity provider serving a discovery document, a key
+// set with one RSA key and a token endpoint that hands out idToken
+type testProvider struct {
+	server  *httptest.Server
+	key     *rsa.PrivateKey
+	idToken string
+	form    url.Values // the last token request
+}
+
+func newTestProvider(t *testing.T) *testProvider {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &testProvider{key: key}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(discovery{
+			Issuer:                p.server.URL,
+			AuthorizationEndpoint: p.server.URL + "/authorize",
+			TokenEndpoint:         p.server.URL + "/token",
+			JwksUri:               p.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"keys": []jwk{{
+			Kty: "RSA",
+			Kid: "test",
+			Use: "sig",
+			N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		p.form = r.PostForm
+		json.NewEncoder(w).Encode(map[string]string{"id_token": p.idToken, "token_type": "Bearer"})
+	})
+	p.server = httptest.NewServer(mux)
+
+	resetProvider := func() {
+		provider = nil
+		keys = nil
+		keysFetched = time.Time{}
+	}
+	resetProvider()
+	t.Cleanup(func() {
+		p.server.Close()
+		resetProvider()
+		config = globals.OidcConfig{}
+	})
+
+	err = Init(globals.OidcConfig{
+		Enabled:     true,
+		Issuer:      p.server.URL,
+		ClientId:    "todoer",
+		RedirectUrl: "http://localhost:8000/api/v1/oidc/callback",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return p
+}
+
+// claims Returns the claims of a valid ID token for the nonce
+func (p *testProvider) claims(nonce string) map[string]any {
+	now := time.Now().Unix()
+	return map[string]any{
+		"iss":                p.server.URL,
+		"aud":                "todoer",
+		"sub":                "248289761001",
+		"nonce":              nonce,
+		"iat":                now,
+		"exp":                now + 300,
+		"preferred_username": "alice",
+		"name":               "Alice Example",
+		"email":              "alice@example.com",
+	}
+}
+
+func encodeSegment(t *testing.T, v any) string {
+	t.Helper()
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// sign Returns an RS256 token with the claims, signed with key
+func sign(t *testing.T, key *rsa.PrivateKey, claims map[string]any) string {
+	t.Helper()
+	signed := encodeSegment(t, map[string]string{"alg": "RS256", "kid": "test", "typ": "JWT"}) + "." +
+		encodeSegment(t, claims)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestNewRequest(t *testing.T) {
+	p := newTestProvider(t)
+
+	r, authUrl, err := NewRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(authUrl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := u.Scheme + "://" + u.Host + u.Path; got != p.server.URL+"/authorize" {
+		t.Errorf("authorization URL is %s, want %s", got, p.server.URL+"/authorize")
+	}
+	q := u.Query()
+	want := map[string]string{
+		"response_type":         "code",
+		"client_id":             "todoer",
+		"state":                 r.State,
+		"nonce":                 r.Nonce,
+		"code_challenge":        challenge(r.Verifier),
+		"code_challenge_method": "S256",
+		"scope":                 "openid profile email",
+	}
+	for name, value := range want {
+		if q.Get(name) != value {
+			t.Errorf("%s is %q, want %q", name, q.Get(name), value)
+		}
+	}
+	if q.Get("code_verifier") != "" {
+		t.Error("the code verifier must not be sent to the browser")
+	}
+}
+
+func TestExchange(t *testing.T) {
+	p := newTestProvider(t)
+	r, _, err := NewRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.idToken = sign(t, p.key, p.claims(r.Nonce))
+
+	identity, err := Exchange(r, "the-code")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Identity{
+		Issuer:   p.server.URL,
+		Subject:  "248289761001",
+		UserName: "alice",
+		FullName: "Alice Example",
+		Email:    "alice@example.com",
+	}
+	if identity != want {
+		t.Errorf("identity is %+v, want %+v", identity, want)
+	}
+	if p.form.Get("code") != "the-code" || p.form.Get("code_verifier") != r.Verifier {
+		t.Errorf("token request sent code %q and verifier %q", p.form.Get("code"), p.form.Get("code_verifier"))
+	}
+}
+
+func TestExchangeRejectsInvalidTokens(t *testing.T) {
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		key    *rsa.PrivateKey // signs the token instead of the provider key
+		change func(claims map[string]any)
+	}{
+		{name: "bad signature", key: otherKey},
+		{name: "wrong issuer", change: func(c map[string]any) { c["iss"] = "https://evil.example.com" }},
+		{name: "wrong audience", change: func(c map[string]any) { c["aud"] = "another-client" }},
+		{name: "several audiences without azp", change: func(c map[string]any) { c["aud"] = []string{"todoer", "another-client"} }},
+		{name: "azp of another client", change: func(c map[string]any) { c["azp"] = "another-client" }},
+		{name: "wrong nonce", change: func(c map[string]any) { c["nonce"] = "replayed" }},
+		{name: "missing nonce", change: func(c map[string]any) { delete(c, "nonce") }},
+		{name: "missing subject", change: func(c map[string]any) { delete(c, "sub") }},
+		{name: "expired", change: func(c map[string]any) { c["exp"] = time.Now().Add(-time.Hour).Unix() }},
+		{name: "missing expiry", change: func(c map[string]any) { delete(c, "exp") }},
+		{name: "not valid yet", change: func(c map[string]any) { c["nbf"] = time.Now().Add(time.Hour).Unix() }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestProvider(t)
+			r, _, err := NewRequest()
+			if err != nil {
+				t.Fatal(err)
+			}
+			claims := p.claims(r.Nonce)
+			if tt.change != nil {
+				tt.change(claims)
+			}
+			key := p.key
+			if tt.key != nil {
+				key = tt.key
+			}
+			p.idToken = sign(t, key, claims)
+
+			if _, err := Exchange(r, "the-code"); !errors.Is(err, ErrInvalidToken) {
+				t.Errorf("Exchange returned %v, want %v", err, ErrInvalidToken)
+			}
+		})
+	}
+}
+
+func TestExchangeAcceptsSmallClockDrift(t *testing.T) {
+	p := newTestProvider(t)
+	r, _, err := NewRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims := p.claims(r.Nonce)
+	claims["exp"] = time.Now().Add(-leeway / 2).Unix()
+	p.idToken = sign(t, p.key, claims)
+
+	if _, err := Exchange(r, "the-code"); err != nil {
+		t.Errorf("Exchange returned %v for a token that expired within the leeway", err)
+	}
+}
+
+func TestExchangeRejectsUnsignedTokens(t *testing.T) {
+	p := newTestProvider(t)
+	r, _, err := NewRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.idToken = encodeSegment(t, map[string]string{"alg": "none", "typ": "JWT"}) + "." +
+		encodeSegment(t, p.claims(r.Nonce)) + "."
+
+	if _, err := Exchange(r, "the-code"); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Exchange returned %v, want %v", err, ErrInvalidToken)
+	}
+}
+
+func TestExchangeRequiresUsernameClaim(t *testing.T) {
+	p := newTestProvider(t)
+	r, _, err := NewRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims := p.claims(r.Nonce)
+	delete(claims, "preferred_username")
+	p.idToken = sign(t, p.key, claims)
+
+	_, err = Exchange(r, "the-code")
+	if err == nil || !strings.Contains(err.Error(), "preferred_username") {
+		t.Errorf("Exchange returned %v, want an error about the missing claim", err)
+	}
+}
+
+func TestDisabled(t *testing.T) {
+	if err := Init(globals.OidcConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := NewRequest(); !errors.Is(err, ErrDisabled) {
+		t.Errorf("NewRequest returned %v, want %v", err, ErrDisabled)
+	}
+	if _, err := Exchange(Request{}, "the-code"); !errors.Is(err, ErrDisabled) {
+		t.Errorf("Exchange returned %v, want %v", err, ErrDisabled)
+	}
+}
@@ -0,0 +1,332 @@
+package oidc
+
+import (
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"math/big"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// leeway allows for clock drift between todoer and the provider
+const leeway = time.Minute
+
+// keyRefreshInterval limits how often the keys are fetched again when a
+// signature does not verify with the key named by the token
+const keyRefreshInterval = time.Minute
+
+// Claims are the claims of a verified ID token
+type Claims map[string]any
+
+// String Returns a string claim, or an empty string when it is missing or
+// not a string
+func (c Claims) String(name string) string {
+	s, _ := c[name].(string)
+	return s
+}
+
+// time Returns a numeric date claim
+func (c Claims) time(name string) (time.Time, bool) {
+	n, ok := c[name].(float64)
+	if !ok {
+		return time.Time{}, false
+	}
+
+	return time.Unix(int64(n), 0), true
+}
+
+// audience Returns the aud claim, which is either a string or a list of them
+func (c Claims) audience() []string {
+	switch aud := c["aud"].(type) {
+	case string:
+		return []string{aud}
+	case []any:
+		audience := make([]string, 0, len(aud))
+		for _, a := range aud {
+			if s, ok := a.(string); ok {
+				audience = append(audience, s)
+			}
+		}
+		return audience
+	}
+
+	return nil
+}
+
+// jwk is a key of the provider's JSON Web Key Set
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+type publicKey struct {
+	kid string
+	key crypto.PublicKey
+}
+
+var (
+	keysMu      sync.Mutex
+	keys        []publicKey
+	keysFetched time.Time
+)
+
+// getKeys Returns the provider keys with the given Id, fetching the key set
+// again when the key is not known yet or refresh is set. Tokens without a key
+// Id may be signed with any of the keys
+func getKeys(kid string, refresh bool) ([]crypto.PublicKey, error) {
+	keysMu.Lock()
+	defer keysMu.Unlock()
+
+	found := findKeys(kid)
+	if len(found) > 0 && (!refresh || time.Since(keysFetched) < keyRefreshInterval) {
+		return found, nil
+	}
+
+	p, err := getProvider()
+	if err != nil {
+		return nil, err
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := getJson(p.JwksUri, &set); err != nil {
+		return nil, err
+	}
+	keysFetched = time.Now()
+	keys = keys[:0]
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			log.Println("WARN: Skipping provider key '" + k.Kid + "': " + string(err.Error()))
+			continue
+		}
+		keys = append(keys, publicKey{kid: k.Kid, key: key})
+	}
+
+	return findKeys(kid), nil
+}
+
+func findKeys(kid string) []crypto.PublicKey {
+	var found []crypto.PublicKey
+	for _, k := range keys {
+		if kid == "" || k.kid == kid {
+			found = append(found, k.key)
+		}
+	}
+
+	return found
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) == 0 {
+		return nil, errors.New("invalid key parameter")
+	}
+
+	return new(big.Int).SetBytes(b), nil
+}
+
+// publicKey Returns the RSA or EC public key of a JWK
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil || !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, errors.New("invalid RSA exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		var checker ecdh.Curve
+		switch k.Crv {
+		case "P-256":
+			curve, checker = elliptic.P256(), ecdh.P256()
+		case "P-384":
+			curve, checker = elliptic.P384(), ecdh.P384()
+		case "P-521":
+			curve, checker = elliptic.P521(), ecdh.P521()
+		default:
+			return nil, errors.New("unsupported curve " + k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		// ecdh rejects points that are not on the curve
+		size := (curve.Params().BitSize + 7) / 8
+		point := append([]byte{4}, append(x.FillBytes(make([]byte, size)), y.FillBytes(make([]byte, size))...)...)
+		if _, err := checker.NewPublicKey(point); err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+
+	return nil, errors.New("unsupported key type " + k.Kty)
+}
+
+// verifySignature checks the signature of a token with one of the keys
+func verifySignature(alg string, signed string, signature []byte, candidates []crypto.PublicKey) bool {
+	var hash crypto.Hash
+	switch alg[2:] {
+	case "256":
+		hash = crypto.SHA256
+	case "384":
+		hash = crypto.SHA384
+	case "512":
+		hash = crypto.SHA512
+	default:
+		return false
+	}
+	h := hash.New()
+	h.Write([]byte(signed))
+	digest := h.Sum(nil)
+
+	for _, candidate := range candidates {
+		switch key := candidate.(type) {
+		case *rsa.PublicKey:
+			switch alg[:2] {
+			case "RS":
+				if rsa.VerifyPKCS1v15(key, hash, digest, signature) == nil {
+					return true
+				}
+			case "PS":
+				if rsa.VerifyPSS(key, hash, digest, signature, nil) == nil {
+					return true
+				}
+			}
+		case *ecdsa.PublicKey:
+			size := (key.Curve.Params().BitSize + 7) / 8
+			if alg[:2] != "ES" || len(signature) != 2*size {
+				continue
+			}
+			r := new(big.Int).SetBytes(signature[:size])
+			s := new(big.Int).SetBytes(signature[size:])
+			if ecdsa.Verify(key, digest, r, s) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// verifyIdToken checks the signature, issuer, audience, lifetime and nonce of
+// an ID token and returns its claims
+func verifyIdToken(token string, nonce string) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidToken
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	headerJson, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil || json.Unmarshal(headerJson, &header) != nil {
+		return nil, ErrInvalidToken
+	}
+	// only asymmetric signatures; never "none"
+	switch header.Alg {
+	case "RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512":
+	default:
+		log.Println("ERROR: ID token signed with unsupported algorithm '" + header.Alg + "'")
+		return nil, ErrInvalidToken
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	// a known key Id may have been given to a new key, so the keys are
+	// fetched again once before giving up
+	verified := false
+	for _, refresh := range []bool{false, true} {
+		candidates, err := getKeys(header.Kid, refresh)
+		if err != nil {
+			return nil, err
+		}
+		if verified = verifySignature(header.Alg, parts[0]+"."+parts[1], signature, candidates); verified {
+			break
+		}
+	}
+	if !verified {
+		log.Println("ERROR: ID token signature does not verify")
+		return nil, ErrInvalidToken
+	}
+
+	var claims Claims
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || json.Unmarshal(payload, &claims) != nil {
+		return nil, ErrInvalidToken
+	}
+
+	now := time.Now()
+	switch {
+	case claims.String("iss") != config.Issuer:
+		log.Println("ERROR: ID token issued by '" + claims.String("iss") + "'")
+		return nil, ErrInvalidToken
+	case !validAudience(claims):
+		log.Println("ERROR: ID token is not meant for client '" + config.ClientId + "'")
+		return nil, ErrInvalidToken
+	case claims.String("sub") == "":
+		log.Println("ERROR: ID token lacks a subject")
+		return nil, ErrInvalidToken
+	case nonce == "" || subtle.ConstantTimeCompare([]byte(claims.String("nonce")), []byte(nonce)) != 1:
+		log.Println("ERROR: ID token nonce does not match")
+		return nil, ErrInvalidToken
+	}
+	if exp, ok := claims.time("exp"); !ok || now.After(exp.Add(leeway)) {
+		log.Println("ERROR: ID token has expired")
+		return nil, ErrInvalidToken
+	}
+	if nbf, ok := claims.time("nbf"); ok && now.Add(leeway).Before(nbf) {
+		log.Println("ERROR: ID token is not valid yet")
+		return nil, ErrInvalidToken
+	}
+
+	return claims, nil
+}
+
+// validAudience reports whether a token is meant for todoer. A token for
+// several audiences must name todoer as its authorized party
+func validAudience(claims Claims) bool {
+	audience := claims.audience()
+	if !slices.Contains(audience, config.ClientId) {
+		return false
+	}
+	if azp := claims.String("azp"); azp != "" || len(audience) > 1 {
+		return azp == config.ClientId
+	}
+
+	return true
+}
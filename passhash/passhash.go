@@ -0,0 +1,181 @@
+// Package passhash hashes and verifies passwords. New hashes use Argon2id or
+// bcrypt, stored as PHC strings such as
+//
+//	$argon2id$v=19$m=19456,t=2,p=1$<salt>$<key>
+//
+// Verification also accepts the unsalted SHA-512 hex digests of older
+// releases, and reports when a hash should be replaced with one made with the
+// current settings
+package passhash
+
+import (
+	"crypto/rand"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/greeneg/todoer/globals"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	Argon2id = "argon2id"
+	Bcrypt   = "bcrypt"
+)
+
+// Defaults follow the OWASP recommendations for Argon2id and bcrypt
+var Defaults = globals.PasswordConfig{
+	Algorithm:   Argon2id,
+	Memory:      19 * 1024,
+	Iterations:  2,
+	Parallelism: 1,
+	SaltLength:  16,
+	KeyLength:   32,
+	BcryptCost:  12,
+}
+
+var ErrInvalidHash = errors.New("invalid password hash")
+
+var (
+	config = Defaults
+
+	// decoy is hashed with the current settings on first use, so that
+	// VerifyNone takes as long as a real verification
+	decoy     string
+	decoyOnce sync.Once
+)
+
+// Init sets the hashing settings, filling unset fields from Defaults
+func Init(c globals.PasswordConfig) error {
+	if c.Algorithm == "" {
+		c.Algorithm = Defaults.Algorithm
+	}
+	if c.Algorithm != Argon2id && c.Algorithm != Bcrypt {
+		return errors.New("unsupported password hashing algorithm '" + c.Algorithm + "'")
+	}
+	if c.Memory == 0 {
+		c.Memory = Defaults.Memory
+	}
+	if c.Iterations == 0 {
+		c.Iterations = Defaults.Iterations
+	}
+	if c.Parallelism == 0 {
+		c.Parallelism = Defaults.Parallelism
+	}
+	if c.SaltLength == 0 {
+		c.SaltLength = Defaults.SaltLength
+	}
+	if c.KeyLength == 0 {
+		c.KeyLength = Defaults.KeyLength
+	}
+	if c.BcryptCost == 0 {
+		c.BcryptCost = Defaults.BcryptCost
+	}
+	if c.BcryptCost < bcrypt.MinCost || c.BcryptCost > bcrypt.MaxCost {
+		return fmt.Errorf("bcrypt cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+	}
+
+	config = c
+	return nil
+}
+
+// Hash hashes a password with the configured algorithm
+func Hash(password string) (string, error) {
+	if config.Algorithm == Bcrypt {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), config.BcryptCost)
+		return string(hash), err
+	}
+
+	salt := make([]byte, config.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, config.Iterations, config.Memory, config.Parallelism, config.KeyLength)
+
+	return fmt.Sprintf("$%s$v=%d$m=%d,t=%d,p=%d$%s$%s", Argon2id, argon2.Version, config.Memory, config.Iterations,
+		config.Parallelism, base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// Verify checks a password against a stored hash in constant time. The second
+// result reports whether the hash is outdated and should be replaced by a
+// fresh one from Hash once the password has been verified
+func Verify(password string, encoded string) (bool, bool) {
+	switch {
+	case strings.HasPrefix(encoded, "$"+Argon2id+"$"):
+		return verifyArgon2id(password, encoded)
+	case strings.HasPrefix(encoded, "$2a$") || strings.HasPrefix(encoded, "$2b$") || strings.HasPrefix(encoded, "$2y$"):
+		if bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password)) != nil {
+			return false, false
+		}
+		cost, err := bcrypt.Cost([]byte(encoded))
+		return true, err != nil || config.Algorithm != Bcrypt || cost != config.BcryptCost
+	case len(encoded) == hex.EncodedLen(sha512.Size):
+		// unsalted SHA-512, as stored by older releases
+		sum := sha512.Sum512([]byte(password))
+		match := subtle.ConstantTimeCompare([]byte(hex.EncodeToString(sum[:])), []byte(strings.ToLower(encoded))) == 1
+		return match, true
+	}
+
+	log.Println("ERROR: " + ErrInvalidHash.Error())
+	return false, false
+}
+
+// VerifyNone spends the time a verification would take, so that failed logins
+// for unknown users cannot be told apart from those with a wrong password
+func VerifyNone(password string) {
+	decoyOnce.Do(func() {
+		hash, err := Hash("todoer decoy password")
+		if err != nil {
+			log.Println("ERROR: Cannot hash decoy password: " + string(err.Error()))
+		}
+		decoy = hash
+	})
+	Verify(password, decoy)
+}
+
+func verifyArgon2id(password string, encoded string) (bool, bool) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 {
+		log.Println("ERROR: " + ErrInvalidHash.Error())
+		return false, false
+	}
+
+	var version int
+	var memory, iterations uint32
+	var parallelism uint8
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		log.Println("ERROR: Unsupported Argon2 version in password hash")
+		return false, false
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &iterations, &parallelism); err != nil {
+		log.Println("ERROR: " + ErrInvalidHash.Error())
+		return false, false
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		log.Println("ERROR: " + ErrInvalidHash.Error())
+		return false, false
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		log.Println("ERROR: " + ErrInvalidHash.Error())
+		return false, false
+	}
+
+	candidate := argon2.IDKey([]byte(password), salt, iterations, memory, parallelism, uint32(len(key)))
+	if subtle.ConstantTimeCompare(key, candidate) != 1 {
+		return false, false
+	}
+
+	outdated := config.Algorithm != Argon2id || memory != config.Memory || iterations != config.Iterations ||
+		parallelism != config.Parallelism || uint32(len(salt)) != config.SaltLength ||
+		uint32(len(key)) != config.KeyLength
+	return true, outdated
+}
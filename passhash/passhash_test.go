@@ -0,0 +1,152 @@
+package passhash
+
+import (
+	"crypto/sha512"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/greeneg/todoer/globals"
+)
+
+// useConfig sets the hashing settings for a test, restoring the defaults after
+func useConfig(t *testing.T, c globals.PasswordConfig) {
+	t.Helper()
+	if err := Init(c); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { config = Defaults })
+}
+
+// light settings keep the tests fast
+var (
+	lightArgon2id = globals.PasswordConfig{Algorithm: Argon2id, Memory: 64, Iterations: 1}
+	lightBcrypt   = globals.PasswordConfig{Algorithm: Bcrypt, BcryptCost: 4}
+)
+
+func TestRoundTrip(t *testing.T) {
+	tests := []struct {
+		name   string
+		config globals.PasswordConfig
+		prefix string
+	}{
+		{name: "argon2id", config: lightArgon2id, prefix: "$argon2id$v=19$m=64,t=1,p=1$"},
+		{name: "bcrypt", config: lightBcrypt, prefix: "$2a$04$"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useConfig(t, tt.config)
+
+			hash, err := Hash("correct horse")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasPrefix(hash, tt.prefix) {
+				t.Errorf("hash %s does not start with %s", hash, tt.prefix)
+			}
+			if ok, rehash := Verify("correct horse", hash); !ok || rehash {
+				t.Errorf("Verify returned %v, %v for the right password, want true, false", ok, rehash)
+			}
+			if ok, _ := Verify("wrong horse", hash); ok {
+				t.Error("Verify accepted a wrong password")
+			}
+
+			again, err := Hash("correct horse")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if again == hash {
+				t.Error("hashing a password twice gave the same hash, so it is not salted")
+			}
+		})
+	}
+}
+
+func TestLegacySha512(t *testing.T) {
+	useConfig(t, lightArgon2id)
+	sum := sha512.Sum512([]byte("secret"))
+	legacy := hex.EncodeToString(sum[:])
+
+	for _, hash := range []string{legacy, strings.ToUpper(legacy)} {
+		if ok, rehash := Verify("secret", hash); !ok || !rehash {
+			t.Errorf("Verify returned %v, %v for a legacy hash, want true, true", ok, rehash)
+		}
+	}
+	if ok, _ := Verify("Secret", legacy); ok {
+		t.Error("Verify accepted a wrong password for a legacy hash")
+	}
+}
+
+// TestRehash checks hashes made with other settings are reported as
+// outdated once the password is verified
+func TestRehash(t *testing.T) {
+	useConfig(t, lightArgon2id)
+	argon2idHash, err := Hash("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	useConfig(t, lightBcrypt)
+	bcryptHash, err := Hash("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		config globals.PasswordConfig
+		hash   string
+		rehash bool
+	}{
+		{name: "same argon2id settings", config: lightArgon2id, hash: argon2idHash, rehash: false},
+		{name: "more argon2id memory", config: globals.PasswordConfig{Algorithm: Argon2id, Memory: 128, Iterations: 1}, hash: argon2idHash, rehash: true},
+		{name: "more argon2id iterations", config: globals.PasswordConfig{Algorithm: Argon2id, Memory: 64, Iterations: 2}, hash: argon2idHash, rehash: true},
+		{name: "argon2id to bcrypt", config: lightBcrypt, hash: argon2idHash, rehash: true},
+		{name: "same bcrypt cost", config: lightBcrypt, hash: bcryptHash, rehash: false},
+		{name: "higher bcrypt cost", config: globals.PasswordConfig{Algorithm: Bcrypt, BcryptCost: 5}, hash: bcryptHash, rehash: true},
+		{name: "bcrypt to argon2id", config: lightArgon2id, hash: bcryptHash, rehash: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useConfig(t, tt.config)
+			ok, rehash := Verify("secret", tt.hash)
+			if !ok {
+				t.Fatal("Verify refused the right password")
+			}
+			if rehash != tt.rehash {
+				t.Errorf("Verify asked for a rehash: %v, want %v", rehash, tt.rehash)
+			}
+			if ok, rehash := Verify("wrong", tt.hash); ok || rehash {
+				t.Errorf("Verify returned %v, %v for a wrong password, want false, false", ok, rehash)
+			}
+		})
+	}
+}
+
+func TestVerifyRejectsInvalidHashes(t *testing.T) {
+	useConfig(t, lightArgon2id)
+	for _, hash := range []string{
+		"",
+		"plaintext",
+		"$argon2id$v=19$m=64,t=1,p=1$c2FsdA",
+		"$argon2id$v=18$m=64,t=1,p=1$c2FsdHNhbHRzYWx0$a2V5",
+		"$argon2id$v=19$m=64,t=1,p=1$c2FsdHNhbHRzYWx0$",
+		"$2a$04$short",
+	} {
+		if ok, rehash := Verify("secret", hash); ok || rehash {
+			t.Errorf("Verify returned %v, %v for %q, want false, false", ok, rehash, hash)
+		}
+	}
+}
+
+func TestInitRejectsInvalidSettings(t *testing.T) {
+	t.Cleanup(func() { config = Defaults })
+	for _, c := range []globals.PasswordConfig{
+		{Algorithm: "md5"},
+		{Algorithm: Bcrypt, BcryptCost: 3},
+		{Algorithm: Bcrypt, BcryptCost: 32},
+	} {
+		if err := Init(c); err == nil {
+			t.Errorf("Init accepted %+v", c)
+		}
+	}
+}
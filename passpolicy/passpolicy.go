@@ -0,0 +1,187 @@
+// Package passpolicy decides which new passwords are accepted: they must be
+// long enough but not too long to be hashed, mix enough kinds of characters,
+// not be a common password and not be one the user had recently. It also
+// tells when a password is too old and has to be changed
+package passpolicy
+
+import (
+	_ "embed"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/greeneg/todoer/globals"
+	"github.com/greeneg/todoer/model"
+	"github.com/greeneg/todoer/passhash"
+)
+
+// the rules a password can break
+const (
+	RuleMinLength        = "minLength"
+	RuleMaxLength        = "maxLength"
+	RuleCharacterClasses = "characterClasses"
+	RuleDenyList         = "denyList"
+	RuleHistory          = "history"
+)
+
+// bcryptMaxLength is the most bytes of a password bcrypt hashes. It refuses
+// longer ones rather than ignoring the rest
+const bcryptMaxLength = 72
+
+// Defaults apply to the policy settings left unset in the config
+var Defaults = globals.PasswordConfig{
+	MinLength:  8,
+	MinClasses: 1,
+}
+
+//go:embed common-passwords.txt
+var commonPasswords string
+
+var (
+	config   = Defaults
+	denyList = map[string]bool{}
+)
+
+func init() {
+	addDenyList(commonPasswords)
+}
+
+// addDenyList Adds the passwords listed one per line to the deny-list
+func addDenyList(list string) {
+	for _, line := range strings.Split(list, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		denyList[strings.ToLower(line)] = true
+	}
+}
+
+// Init sets the policy, filling unset fields from Defaults, and reads the
+// extra deny-list. A relative DenyListFile is taken to be in configDir
+func Init(c globals.PasswordConfig, configDir string) error {
+	if c.MinLength <= 0 {
+		c.MinLength = Defaults.MinLength
+	}
+	if c.MinClasses <= 0 {
+		c.MinClasses = Defaults.MinClasses
+	}
+	if c.MinClasses > 4 {
+		c.MinClasses = 4
+	}
+	if c.History > model.MaxPasswordHistory {
+		c.History = model.MaxPasswordHistory
+	}
+	config = c
+
+	if c.DenyListFile != "" {
+		denyListFile := c.DenyListFile
+		if !filepath.IsAbs(denyListFile) {
+			denyListFile = filepath.Join(configDir, denyListFile)
+		}
+		list, err := os.ReadFile(denyListFile)
+		if err != nil {
+			return err
+		}
+		addDenyList(string(list))
+	}
+
+	return nil
+}
+
+// classes Returns how many of lower case letters, upper case letters, digits
+// and symbols a password contains. Letters without case count as lower case
+func classes(password string) int {
+	var lower, upper, digit, symbol int
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			upper = 1
+		case unicode.IsLetter(r):
+			lower = 1
+		case unicode.IsDigit(r):
+			digit = 1
+		default:
+			symbol = 1
+		}
+	}
+
+	return lower + upper + digit + symbol
+}
+
+// Check Returns the rules a new password breaks, other than the history
+// rule, or nothing if it is acceptable
+func Check(password string) []model.PasswordViolation {
+	violations := make([]model.PasswordViolation, 0)
+	if utf8.RuneCountInString(password) < config.MinLength {
+		violations = append(violations, model.PasswordViolation{
+			Rule:    RuleMinLength,
+			Message: "password must be at least " + strconv.Itoa(config.MinLength) + " characters long",
+		})
+	}
+	if config.Algorithm == passhash.Bcrypt && len(password) > bcryptMaxLength {
+		violations = append(violations, model.PasswordViolation{
+			Rule:    RuleMaxLength,
+			Message: "password must be at most " + strconv.Itoa(bcryptMaxLength) + " bytes long",
+		})
+	}
+	if classes(password) < config.MinClasses {
+		violations = append(violations, model.PasswordViolation{
+			Rule: RuleCharacterClasses,
+			Message: "password must contain at least " + strconv.Itoa(config.MinClasses) +
+				" of lower case letters, upper case letters, digits and symbols",
+		})
+	}
+	if denyList[strings.ToLower(strings.TrimSpace(password))] {
+		violations = append(violations, model.PasswordViolation{
+			Rule:    RuleDenyList,
+			Message: "password is too common",
+		})
+	}
+
+	return violations
+}
+
+// CheckHistory Returns the history rule as broken if a new password of the
+// user is one of their last ones, the current one included
+func CheckHistory(userId int, password string) ([]model.PasswordViolation, error) {
+	violations := make([]model.PasswordViolation, 0)
+	if config.History <= 0 {
+		return violations, nil
+	}
+
+	hashes, err := model.GetPasswordHistory(userId, config.History)
+	if err != nil {
+		return nil, err
+	}
+	for _, hash := range hashes {
+		if match, _ := passhash.Verify(password, hash); match {
+			violations = append(violations, model.PasswordViolation{
+				Rule:    RuleHistory,
+				Message: "password must not be one of the last " + strconv.Itoa(config.History) + " passwords",
+			})
+			break
+		}
+	}
+
+	return violations, nil
+}
+
+// Expired reports whether the password of a user is older than the maximum
+// password age
+func Expired(user model.User) bool {
+	if config.MaxAgeDays <= 0 {
+		return false
+	}
+	changed, err := model.NormalizeDate(user.PasswordChangedDate)
+	if err != nil || changed == "" {
+		return false
+	}
+	cutoff := time.Now().UTC().AddDate(0, 0, -config.MaxAgeDays).Format(model.SqlDateTimeFormat)
+
+	return changed < cutoff
+}
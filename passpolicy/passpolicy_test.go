@@ -0,0 +1,192 @@
+package passpolicy
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/greeneg/todoer/globals"
+	"github.com/greeneg/todoer/model"
+	"github.com/greeneg/todoer/passhash"
+)
+
+// usePolicy sets the policy for a test, restoring the defaults after
+func usePolicy(t *testing.T, c globals.PasswordConfig) {
+	t.Helper()
+	if err := Init(c, t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { config = Defaults })
+}
+
+// rules Returns the rules of the violations
+func rules(violations []model.PasswordViolation) []string {
+	broken := make([]string, 0, len(violations))
+	for _, violation := range violations {
+		broken = append(broken, violation.Rule)
+	}
+
+	return broken
+}
+
+func equal(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   globals.PasswordConfig
+		password string
+		rules    []string
+	}{
+		{name: "acceptable", password: "tangerine-kettle", rules: []string{}},
+		{name: "too short", password: "tangeri", rules: []string{RuleMinLength}},
+		{name: "long enough", password: "tangerin", rules: []string{}},
+		{name: "length in characters, not bytes", config: globals.PasswordConfig{MinLength: 4}, password: "äöü", rules: []string{RuleMinLength}},
+		{name: "configured length", config: globals.PasswordConfig{MinLength: 12}, password: "tangerine-k", rules: []string{RuleMinLength}},
+		{name: "too few classes", config: globals.PasswordConfig{MinClasses: 3}, password: "tangerine-kettle", rules: []string{RuleCharacterClasses}},
+		{name: "enough classes", config: globals.PasswordConfig{MinClasses: 3}, password: "Tangerine-kettle", rules: []string{}},
+		{name: "all classes", config: globals.PasswordConfig{MinClasses: 4}, password: "Tangerine-kettle7", rules: []string{}},
+		{name: "classes capped at four", config: globals.PasswordConfig{MinClasses: 9}, password: "Tangerine-kettle7", rules: []string{}},
+		{name: "common password", password: "password", rules: []string{RuleDenyList}},
+		{name: "common password in other case", password: " PassWord ", rules: []string{RuleDenyList}},
+		{name: "several rules", config: globals.PasswordConfig{MinLength: 12, MinClasses: 2}, password: "password", rules: []string{RuleMinLength, RuleCharacterClasses, RuleDenyList}},
+		{name: "72 bytes with bcrypt", config: globals.PasswordConfig{Algorithm: passhash.Bcrypt}, password: string(make([]byte, 72)), rules: []string{}},
+		{name: "over 72 bytes with bcrypt", config: globals.PasswordConfig{Algorithm: passhash.Bcrypt}, password: "äöü" + string(make([]byte, 67)), rules: []string{RuleMaxLength}},
+		{name: "over 72 bytes with argon2id", config: globals.PasswordConfig{Algorithm: passhash.Argon2id}, password: string(make([]byte, 100)), rules: []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			usePolicy(t, tt.config)
+			if got := rules(Check(tt.password)); !equal(got, tt.rules) {
+				t.Errorf("Check(%q) broke %v, want %v", tt.password, got, tt.rules)
+			}
+		})
+	}
+}
+
+func TestDenyListFile(t *testing.T) {
+	dir := t.TempDir()
+	list := "# passwords of the office\n\nTodoerRocks2024\n  correcthorse  \n"
+	if err := os.WriteFile(filepath.Join(dir, "denied.txt"), []byte(list), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := Init(globals.PasswordConfig{DenyListFile: "denied.txt"}, dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { config = Defaults })
+
+	for _, password := range []string{"todoerrocks2024", "CorrectHorse"} {
+		if got := rules(Check(password)); !equal(got, []string{RuleDenyList}) {
+			t.Errorf("Check(%q) broke %v, want the deny-list", password, got)
+		}
+	}
+	if got := rules(Check("# passwords of the office")); len(got) != 0 {
+		t.Errorf("a comment of the deny-list file was taken as a password: %v", got)
+	}
+
+	if err := Init(globals.PasswordConfig{DenyListFile: "missing.txt"}, dir); err == nil {
+		t.Error("Init accepted a missing deny-list file")
+	}
+}
+
+// openDb points the model at a new database with the schema, which comes
+// with the user greeneg
+func openDb(t *testing.T) {
+	t.Helper()
+	schema, err := os.ReadFile(filepath.Join("..", "db", "schema.sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := sql.Open("sqlite3", "file:"+filepath.Join(t.TempDir(), "todoer.db")+"?_foreign_keys=on")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(string(schema)); err != nil {
+		t.Fatal(err)
+	}
+	model.DB = db
+	t.Cleanup(func() { db.Close() })
+}
+
+func TestCheckHistory(t *testing.T) {
+	openDb(t)
+	hash, err := passhash.Hash("first-password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := model.DB.Exec("UPDATE Users SET PasswordHash = ? WHERE Id = 1", hash); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := model.ChangeAccountPassword("greeneg", "first-password", "second-password"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := model.ChangeAccountPassword("greeneg", "second-password", "third-password"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		history  int
+		password string
+		broken   bool
+	}{
+		{history: 0, password: "third-password", broken: false},
+		{history: 1, password: "third-password", broken: true},
+		{history: 1, password: "second-password", broken: false},
+		{history: 2, password: "second-password", broken: true},
+		{history: 2, password: "first-password", broken: false},
+		{history: 3, password: "first-password", broken: true},
+		{history: 3, password: "fourth-password", broken: false},
+	}
+	for _, tt := range tests {
+		usePolicy(t, globals.PasswordConfig{History: tt.history})
+		violations, err := CheckHistory(1, tt.password)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []string{}
+		if tt.broken {
+			want = []string{RuleHistory}
+		}
+		if got := rules(violations); !equal(got, want) {
+			t.Errorf("with a history of %d, CheckHistory(%q) broke %v, want %v", tt.history, tt.password, got, want)
+		}
+	}
+}
+
+func TestExpired(t *testing.T) {
+	daysAgo := func(days int) model.User {
+		changed := time.Now().UTC().AddDate(0, 0, -days).Format(model.SqlDateTimeFormat)
+		return model.User{PasswordChangedDate: changed}
+	}
+
+	usePolicy(t, globals.PasswordConfig{})
+	if Expired(daysAgo(10000)) {
+		t.Error("a password expired without a maximum age")
+	}
+
+	usePolicy(t, globals.PasswordConfig{MaxAgeDays: 90})
+	if Expired(daysAgo(89)) {
+		t.Error("a password of 89 days expired with a maximum age of 90")
+	}
+	if !Expired(daysAgo(91)) {
+		t.Error("a password of 91 days did not expire with a maximum age of 90")
+	}
+	if Expired(model.User{}) {
+		t.Error("a password without a change date expired")
+	}
+}
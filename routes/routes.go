@@ -3,27 +3,132 @@ package routes
 import (
 	"github.com/gin-gonic/gin"
 
+	"github.com/greeneg/todoer/caldav"
 	"github.com/greeneg/todoer/controllers"
+	"github.com/greeneg/todoer/middleware"
+	"github.com/greeneg/todoer/model"
 )
 
 func PublicRoutes(g *gin.RouterGroup, i *controllers.TodoerService) {
-	g.GET("/health", i.GetHealth) // service health
+	g.GET("/health", i.GetHealth)                             // service health
+	g.GET("/calendar.ics", i.GetCalendarFeed)                 // calendar feed, authenticated by its token
+	g.POST("/login", i.Login)                                 // start a session
+	g.POST("/logout", i.Logout)                               // end the current session
+	g.GET("/oidc/login", i.OidcLogin)                         // log in through the identity provider
+	g.GET("/oidc/callback", i.OidcCallback)                   // finish logging in through the identity provider
+	g.POST("/oidc/2fa", i.OidcTwoFactor)                      // send the two-factor code after the identity provider
+	g.POST("/password-reset", i.RequestPasswordReset)         // mail a password reset token
+	g.POST("/password-reset/confirm", i.ConfirmPasswordReset) // set a new password with a reset token
+	g.POST("/invitations/accept", i.AcceptInvitation)         // set up an account with an invitation
 }
 
 func PrivateRoutes(g *gin.RouterGroup, i *controllers.TodoerService) {
+	// permission checks; users may only change their own account and org
+	// admins those of their org unit
+	admin := middleware.RequireRole(model.RoleAdmin)
+	accountAdmin := middleware.RequireAccountAdmin()
+	selfOrAdmin := middleware.RequireSelfOrAccountAdmin()
+
 	// todo related routes
-	g.GET("/todo", i.GetTodos)          // get todos
-	g.GET("/todo/:id", i.GetTodoById)	// get todo by its Id
-	g.POST("/todo", i.CreateTodo)       // create a new todo
-	g.DELETE("/todo/:id", i.DeleteTodo) // trash a todo entry
-	g.PUT("/todo/:id/:status", i.UpdateTodo)    // replace todo status
+	g.GET("/todo", i.GetTodos)                                 // get todos
+	g.GET("/todo/:id", i.GetTodoById)                          // get todo by its Id
+	g.POST("/todo", i.CreateTodo)                              // create a new todo
+	g.DELETE("/todo/:id", i.DeleteTodo)                        // trash a todo entry
+	g.PATCH("/todo/:id", i.ModifyTodo)                         // change a todo's details
+	g.PUT("/todo/:id/:status", i.UpdateTodo)                   // replace todo status
+	g.GET("/todo/:id/comments", i.GetComments)                 // get the comments on a todo
+	g.POST("/todo/:id/comments", i.CreateComment)              // comment on a todo
+	g.DELETE("/todo/:id/comments/:commentId", i.DeleteComment) // delete a comment
+	g.GET("/todo/:id/history", i.GetTodoHistory)               // get the change history of a todo
+	// live change stream
+	g.GET("/events", i.StreamEvents) // stream todo changes as server-sent events
+	g.GET("/ws", i.Collaborate)      // collaboration channel with presence
+	// list related routes
+	g.GET("/lists", i.GetLists)                            // get lists
+	g.GET("/list/:id", i.GetListById)                      // get list by its Id
+	g.GET("/list/:id/todos", i.GetListTodos)               // get the todos on a list
+	g.GET("/list/:id/members", i.GetListMembers)           // get who a list is shared with
+	g.POST("/list", i.CreateList)                          // create a new list
+	g.PATCH("/list/:id", i.RenameList)                     // rename a list
+	g.DELETE("/list/:id", i.DeleteList)                    // trash a list
+	g.POST("/list/:id/member/:name", i.AddListMember)      // share a list with a user
+	g.DELETE("/list/:id/member/:name", i.RemoveListMember) // stop sharing a list with a user
+	// tag related routes
+	g.GET("/tags", i.GetTags)          // get tags
+	g.DELETE("/tags/:id", i.DeleteTag) // trash a tag
+	// calendar related routes
+	g.POST("/calendar/token", i.CreateFeedToken)   // create a calendar feed token
+	g.DELETE("/calendar/token", i.DeleteFeedToken) // revoke the calendar feed token
+	g.POST("/calendar/import", i.ImportCalendar)   // import an iCalendar file into a list
+	// workspace archives
+	g.GET("/export", i.ExportWorkspace)     // export the workspace as JSON or CSV
+	g.POST("/import", i.ImportWorkspace)    // import a workspace archive
+	g.POST("/import/:source", i.ImportFrom) // import from another task manager
+	// todo.txt
+	g.GET("/export/todotxt", i.ExportTodoTxt)  // export todos as todo.txt
+	g.POST("/import/todotxt", i.ImportTodoTxt) // import a todo.txt file
+	// delta sync
+	g.GET("/sync", i.GetSync)   // get changes since a sync token
+	g.POST("/sync", i.PostSync) // apply a batch of offline changes
 	// user related routes
-	g.GET("/user/id/:id", i.GetUserById)            // get user by id
-	g.GET("/user/name/:name", i.GetUserByUserName)  // get user by username
-	g.GET("/user/:name/status", i.GetUserStatus)    // get whether a user is locked or not
-	g.GET("/users", i.GetUsers)                     // get users
-	g.POST("/user", i.CreateUser)                   // create new user
-	g.PATCH("/user/:name", i.ChangeAccountPassword) // update a user password
-	g.PATCH("/user/:name/status", i.SetUserStatus)  // lock a user
-	g.DELETE("/user/:name", i.DeleteUser)           // trash a user
+	g.GET("/me", i.GetMe)                                                  // get the logged in user
+	g.GET("/me/profile", i.GetProfile)                                     // get the profile of the logged in user
+	g.PATCH("/me/profile", i.UpdateProfile)                                // change the profile of the logged in user
+	g.GET("/me/2fa", i.GetTwoFactor)                                       // get the two-factor status
+	g.POST("/me/2fa", i.EnrollTwoFactor)                                   // start setting up two-factor authentication
+	g.GET("/me/2fa/qr", i.GetTwoFactorQrCode)                              // get the QR code of a new TOTP secret
+	g.POST("/me/2fa/confirm", i.ConfirmTwoFactor)                          // enable two-factor authentication
+	g.POST("/me/2fa/recovery-codes", i.RegenerateRecoveryCodes)            // replace the recovery codes
+	g.DELETE("/me/2fa", i.DisableTwoFactor)                                // turn off two-factor authentication
+	g.GET("/me/oidc/link", i.OidcLink)                                     // link an identity provider account
+	g.GET("/user/id/:id", i.GetUserById)                                   // get user by id
+	g.GET("/user/name/:name", i.GetUserByUserName)                         // get user by username
+	g.GET("/user/:name/status", i.GetUserStatus)                           // get whether a user is locked or not
+	g.GET("/users", i.GetUsers)                                            // get users
+	g.POST("/user", accountAdmin, i.CreateUser)                            // create new user
+	g.PATCH("/user/:name", selfOrAdmin, i.ChangeAccountPassword)           // update a user password
+	g.PATCH("/user/:name/status", accountAdmin, i.SetUserStatus)           // lock a user
+	g.DELETE("/user/:name", selfOrAdmin, i.DeleteUser)                     // trash a user
+	g.GET("/user/:name/sessions", selfOrAdmin, i.GetUserSessions)          // list a user's sessions
+	g.DELETE("/user/:name/sessions", selfOrAdmin, i.DeleteUserSessions)    // sign a user out everywhere
+	g.DELETE("/user/:name/sessions/:id", selfOrAdmin, i.DeleteUserSession) // revoke one session
+	g.GET("/user/:name/tokens", selfOrAdmin, i.GetAccessTokens)            // list a user's access tokens
+	g.POST("/user/:name/tokens", i.CreateAccessToken)                      // create an access token
+	g.DELETE("/user/:name/tokens/:id", selfOrAdmin, i.DeleteAccessToken)   // revoke an access token
+	g.PATCH("/user/:name/orgunit", admin, i.SetUserOrgUnit)                // move a user to another org unit
+	g.PATCH("/user/:name/ldap", admin, i.SetUserLdapDn)                    // link a user to a directory entry
+	g.PATCH("/user/:name/service", admin, i.SetUserServiceAccount)         // let a user log in with a client certificate
+	g.DELETE("/user/:name/2fa", accountAdmin, i.ResetTwoFactor)            // turn off a user's two-factor authentication
+	// invitation related routes
+	g.GET("/invitations", accountAdmin, i.GetInvitations)          // get invitations
+	g.GET("/invitations/:id", accountAdmin, i.GetInvitationById)   // get invitation by its Id
+	g.POST("/invitations", accountAdmin, i.CreateInvitation)       // invite someone to set up an account
+	g.DELETE("/invitations/:id", accountAdmin, i.RevokeInvitation) // revoke a pending invitation
+	// role related routes
+	g.GET("/roles", i.GetRoles)                                         // get the roles users can have
+	g.GET("/user/:name/roles", selfOrAdmin, i.GetUserRoles)             // get a user's roles
+	g.POST("/user/:name/roles/:role", accountAdmin, i.GrantUserRole)    // grant a role to a user
+	g.DELETE("/user/:name/roles/:role", accountAdmin, i.RevokeUserRole) // take a role from a user
+	// org unit related routes
+	g.GET("/orgunits", admin, i.GetOrgUnits)          // get org units
+	g.GET("/orgunits/:id/users", i.GetOrgUnitUsers)   // get the users of an org unit
+	g.POST("/orgunits", admin, i.CreateOrgUnit)       // create an org unit
+	g.DELETE("/orgunits/:id", admin, i.DeleteOrgUnit) // remove an empty org unit
+	// webhook related routes
+	g.GET("/webhooks", admin, i.GetWebhooks)                         // get webhooks
+	g.GET("/webhooks/:id", admin, i.GetWebhookById)                  // get webhook by its Id
+	g.GET("/webhooks/:id/deliveries", admin, i.GetWebhookDeliveries) // get the delivery log of a webhook
+	g.POST("/webhooks", admin, i.CreateWebhook)                      // register a webhook
+	g.DELETE("/webhooks/:id", admin, i.DeleteWebhook)                // remove a webhook
+}
+
+func DavRoutes(g *gin.RouterGroup, i *controllers.TodoerService) {
+	// the CalDAV tree, with lists as calendars of todos
+	for _, method := range caldav.Methods {
+		g.Handle(method, "/*path", i.Dav)
+	}
+}
+
+func WellKnownRoutes(r *gin.Engine) {
+	r.Any("/.well-known/caldav", caldav.WellKnown) // CalDAV service discovery
 }
@@ -0,0 +1,116 @@
+package sessionstore
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/greeneg/todoer/globals"
+)
+
+// SecretEnv names the environment variable that overrides the configured
+// session secret
+const SecretEnv = "TODOER_SESSION_SECRET"
+
+// SecretFile holds the generated session secrets, one per line with the
+// current one first, when none are configured
+const SecretFile = "session.secret"
+
+// minSecretLength is the shortest secret accepted without a warning
+const minSecretLength = 32
+
+// LoadSecrets returns the session secrets, current first. The current secret
+// comes from the environment, then the config, then <configDir>/session.secret,
+// which is created with a random secret on first start
+func LoadSecrets(c globals.SessionConfig, configDir string) ([][]byte, error) {
+	current := os.Getenv(SecretEnv)
+	if current == "" {
+		current = c.Secret
+	}
+
+	secrets := make([]string, 0)
+	if current != "" {
+		secrets = append(secrets, current)
+	} else {
+		stored, err := readSecretFile(filepath.Join(configDir, SecretFile))
+		if err != nil {
+			return nil, err
+		}
+		secrets = append(secrets, stored...)
+	}
+	for _, secret := range c.PreviousSecrets {
+		if secret != "" {
+			secrets = append(secrets, secret)
+		}
+	}
+
+	keys := make([][]byte, 0, len(secrets))
+	for _, secret := range secrets {
+		if len(secret) < minSecretLength {
+			log.Printf("WARN: Session secrets should be at least %d characters long\n", minSecretLength)
+		}
+		keys = append(keys, []byte(secret))
+	}
+
+	return keys, nil
+}
+
+// readSecretFile returns the secrets in path, generating the file when it
+// does not exist
+func readSecretFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		secret, err := generateSecret(path)
+		if err != nil {
+			return nil, err
+		}
+		return []string{secret}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	secrets := make([]string, 0)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		secrets = append(secrets, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(secrets) == 0 {
+		return nil, errors.New("no session secret in " + path)
+	}
+
+	return secrets, nil
+}
+
+func generateSecret(path string) (string, error) {
+	key := make([]byte, 64)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	secret := hex.EncodeToString(key)
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if _, err := f.WriteString(secret + "\n"); err != nil {
+		return "", err
+	}
+	log.Println("INFO: Generated a new session secret in " + path)
+
+	return secret, nil
+}
@@ -0,0 +1,275 @@
+// Package sessionstore keeps login sessions in the database. The session
+// cookie only carries a random session Id signed with the session secrets, so
+// sessions can be listed and revoked on the server
+package sessionstore
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/gob"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/greeneg/todoer/globals"
+	"github.com/greeneg/todoer/model"
+	"github.com/gin-contrib/sessions"
+	gsessions "github.com/gorilla/sessions"
+	"github.com/gorilla/securecookie"
+)
+
+// session lifetimes used when the config leaves them unset
+const (
+	DefaultAbsoluteTimeoutHours = 24 * 7
+	DefaultIdleTimeoutMinutes   = 8 * 60
+)
+
+// minIdleTimeout keeps the idle timeout well above the interval at which
+// session use is recorded
+const minIdleTimeout = 5 * time.Minute
+
+// idleTimeout is shared by all stores, as sessions live in the same table
+var idleTimeout = DefaultIdleTimeoutMinutes * time.Minute
+
+// ErrRevoked is returned when saving a session that was revoked while the
+// request was being handled
+var ErrRevoked = errors.New("session has been revoked")
+
+// Store is a sessions.Store backed by the Sessions table
+type Store struct {
+	Codecs  []securecookie.Codec
+	options *gsessions.Options
+}
+
+// NewStore returns a store signing cookies with the given secrets. The first
+// secret signs new cookies and all of them are accepted when verifying.
+// Cookies are HttpOnly, and Secure when the server uses TLS
+func NewStore(c globals.Config, secrets [][]byte) (*Store, error) {
+	if c.Sessions.AbsoluteTimeoutHours <= 0 {
+		c.Sessions.AbsoluteTimeoutHours = DefaultAbsoluteTimeoutHours
+	}
+	if c.Sessions.IdleTimeoutMinutes <= 0 {
+		c.Sessions.IdleTimeoutMinutes = DefaultIdleTimeoutMinutes
+	}
+	idleTimeout = max(time.Duration(c.Sessions.IdleTimeoutMinutes)*time.Minute, minIdleTimeout)
+
+	sameSite, err := parseSameSite(c.Sessions.SameSite)
+	if err != nil {
+		return nil, err
+	}
+	// browsers drop SameSite=None cookies that are not Secure
+	if sameSite == http.SameSiteNoneMode && !c.UseTLS {
+		return nil, errors.New("sameSite \"none\" requires useTls")
+	}
+
+	keyPairs := make([][]byte, 0, len(secrets)*2)
+	for _, secret := range secrets {
+		keyPairs = append(keyPairs, secret, nil)
+	}
+
+	s := &Store{
+		Codecs: securecookie.CodecsFromPairs(keyPairs...),
+		options: &gsessions.Options{
+			Path:     "/",
+			MaxAge:   c.Sessions.AbsoluteTimeoutHours * 3600,
+			Secure:   c.UseTLS,
+			HttpOnly: true,
+			SameSite: sameSite,
+		},
+	}
+	s.maxAge(s.options.MaxAge)
+
+	return s, nil
+}
+
+func parseSameSite(mode string) (http.SameSite, error) {
+	switch strings.ToLower(mode) {
+	case "", "lax":
+		return http.SameSiteLaxMode, nil
+	case "strict":
+		return http.SameSiteStrictMode, nil
+	case "none":
+		return http.SameSiteNoneMode, nil
+	}
+
+	return http.SameSiteDefaultMode, errors.New("invalid sameSite value: " + mode)
+}
+
+// IdleCutoff returns the time before which a session must have been used to
+// still be valid, formatted for the DB
+func IdleCutoff() string {
+	return time.Now().UTC().Add(-idleTimeout).Format(model.SqlDateTimeFormat)
+}
+
+func (s *Store) maxAge(age int) {
+	for _, codec := range s.Codecs {
+		if sc, ok := codec.(*securecookie.SecureCookie); ok {
+			sc.MaxAge(age)
+		}
+	}
+}
+
+// Options sets the default cookie options of new sessions
+func (s *Store) Options(options sessions.Options) {
+	s.options = options.ToGorillaOptions()
+	s.maxAge(s.options.MaxAge)
+}
+
+// Get returns the named session of a request, loading it once per request
+func (s *Store) Get(r *http.Request, name string) (*gsessions.Session, error) {
+	return gsessions.GetRegistry(r).Get(s, name)
+}
+
+// New loads the session named by the request's cookie. A missing, forged,
+// expired, idle or revoked session yields a new, empty session
+func (s *Store) New(r *http.Request, name string) (*gsessions.Session, error) {
+	session := gsessions.NewSession(s, name)
+	opts := *s.options
+	session.Options = &opts
+	session.IsNew = true
+
+	cookie, err := r.Cookie(name)
+	if err != nil {
+		return session, nil
+	}
+	var id string
+	if err := securecookie.DecodeMulti(name, cookie.Value, &id, s.Codecs...); err != nil {
+		return session, nil
+	}
+
+	tokenHash := model.HashToken(id)
+	data, found, err := model.GetSessionData(tokenHash, IdleCutoff())
+	if err != nil || !found {
+		return session, err
+	}
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&session.Values); err != nil {
+		log.Println("ERROR: Cannot decode session: " + string(err.Error()))
+		return session, nil
+	}
+	session.ID = id
+	session.IsNew = false
+	model.TouchSession(tokenHash)
+
+	return session, nil
+}
+
+// Save stores the session and sets its cookie. A session expires MaxAge
+// seconds after it was created, however often it is saved, and one whose
+// MaxAge is negative is deleted. When the session changes hands, for instance
+// from nobody to a user logging in, it gets a new Id so that an Id planted
+// before the login is worthless
+func (s *Store) Save(r *http.Request, w http.ResponseWriter, session *gsessions.Session) error {
+	if session.Options.MaxAge < 0 {
+		if session.ID != "" {
+			if err := model.DeleteSession(model.HashToken(session.ID)); err != nil {
+				return err
+			}
+		}
+		http.SetCookie(w, gsessions.NewCookie(session.Name(), "", session.Options))
+		return nil
+	}
+
+	var data bytes.Buffer
+	if err := gob.NewEncoder(&data).Encode(session.Values); err != nil {
+		return err
+	}
+	userId, err := sessionUserId(session)
+	if err != nil {
+		return err
+	}
+
+	if session.ID != "" {
+		tokenHash := model.HashToken(session.ID)
+		storedUserId, found, err := model.GetSessionUserId(tokenHash)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return ErrRevoked
+		}
+		if storedUserId == userId {
+			if _, err := model.UpdateSession(tokenHash, userId, data.Bytes()); err != nil {
+				return err
+			}
+		} else {
+			if err := model.DeleteSession(tokenHash); err != nil {
+				return err
+			}
+			session.ID = ""
+		}
+	}
+	if session.ID == "" {
+		maxAge := session.Options.MaxAge
+		if maxAge == 0 {
+			maxAge = s.options.MaxAge
+		}
+		expiryDate := time.Now().UTC().Add(time.Duration(maxAge) * time.Second).Format(model.SqlDateTimeFormat)
+		id, err := newSessionId()
+		if err != nil {
+			return err
+		}
+		err = model.CreateSession(model.HashToken(id), userId, data.Bytes(), r.UserAgent(), remoteAddr(r), expiryDate)
+		if err != nil {
+			return err
+		}
+		session.ID = id
+	}
+
+	encoded, err := securecookie.EncodeMulti(session.Name(), session.ID, s.Codecs...)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, gsessions.NewCookie(session.Name(), encoded, session.Options))
+
+	return nil
+}
+
+// Start removes expired and idle sessions in the background
+func Start() {
+	go func() {
+		for {
+			count, err := model.DeleteExpiredSessions(IdleCutoff())
+			if err == nil && count > 0 {
+				log.Printf("INFO: Removed %d expired sessions\n", count)
+			}
+			time.Sleep(time.Hour)
+		}
+	}()
+}
+
+// sessionUserId returns the Id of the user a session is logged in as, or
+// zero when nobody is
+func sessionUserId(session *gsessions.Session) (int, error) {
+	username, ok := session.Values[globals.UserKey].(string)
+	if !ok || username == "" {
+		return 0, nil
+	}
+	user, err := model.GetUserByUserName(username)
+	if err != nil {
+		return 0, err
+	}
+
+	return user.Id, nil
+}
+
+func newSessionId() (string, error) {
+	id := make([]byte, 32)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(id), nil
+}
+
+func remoteAddr(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
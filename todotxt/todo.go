@@ -0,0 +1,112 @@
+package todotxt
+
+import (
+	"strings"
+
+	"github.com/greeneg/todoer/model"
+)
+
+// projectTag marks tags that hold the projects of a task beyond the first,
+// which becomes its list
+const projectTag = "+"
+
+// Priority maps a todo.txt priority letter to a todo priority. Todos only
+// have nine levels, so everything below (I) shares the lowest one
+func Priority(letter byte) int {
+	if letter < 'A' || letter > 'Z' {
+		return 0
+	}
+	if letter > 'I' {
+		return 9
+	}
+
+	return int(letter-'A') + 1
+}
+
+// Letter maps a todo priority to a todo.txt priority letter
+func Letter(priority int) byte {
+	if priority < 1 || priority > 9 {
+		return 0
+	}
+
+	return 'A' + byte(priority-1)
+}
+
+// ProjectName turns a list name into a +project word, which cannot hold
+// spaces
+func ProjectName(listName string) string {
+	return strings.Join(strings.Fields(listName), "_")
+}
+
+// day returns the date part of an RFC 3339 or SQL timestamp
+func day(s string) string {
+	if len(s) < len(dateFormat) {
+		return ""
+	}
+
+	return s[:len(dateFormat)]
+}
+
+// FromTodo returns the task for a todo on the named list. Tags become
+// contexts, apart from those marked as extra projects
+func FromTodo(todo model.Todo, listName string) Task {
+	task := Task{
+		Done:         todo.Status == "completed",
+		Priority:     Letter(todo.Priority),
+		CreationDate: day(todo.CreationDate),
+		Description:  strings.Join(strings.Fields(todo.Description), " "),
+		Projects:     make([]string, 0),
+		Contexts:     make([]string, 0),
+		Due:          day(todo.DueDate),
+	}
+	if task.Done {
+		task.CompletionDate = day(todo.CompletionDate)
+		if task.CompletionDate == "" {
+			task.CompletionDate = day(todo.LastChangedDate)
+		}
+	}
+
+	if listName != "" {
+		task.Projects = append(task.Projects, ProjectName(listName))
+	}
+	for _, tag := range todo.Tags {
+		if strings.HasPrefix(tag, projectTag) && len(tag) > len(projectTag) {
+			task.Projects = append(task.Projects, tag[len(projectTag):])
+		} else {
+			task.Contexts = append(task.Contexts, tag)
+		}
+	}
+
+	return task
+}
+
+// ToImported returns the todo to import for a task. The first project names
+// its list and any others are kept as tags, so that they survive a round
+// trip
+func ToImported(task Task) model.ImportedTodo {
+	item := model.ImportedTodo{
+		Todo: model.ProposedTodo{
+			Description: task.Description,
+			DueDate:     task.Due,
+			Priority:    Priority(task.Priority),
+			Tags:        make([]string, 0, len(task.Contexts)+len(task.Projects)),
+		},
+		Status:         "new",
+		CreationDate:   task.CreationDate,
+		CompletionDate: task.CompletionDate,
+	}
+	if task.Done {
+		item.Status = "completed"
+	}
+
+	item.Todo.Tags = append(item.Todo.Tags, task.Contexts...)
+	for i, p := range task.Projects {
+		if i == 0 {
+			item.ListName = p
+		} else {
+			item.Todo.Tags = append(item.Todo.Tags, projectTag+p)
+		}
+	}
+
+	return item
+}
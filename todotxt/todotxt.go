@@ -0,0 +1,194 @@
+// Package todotxt reads and writes the todo.txt format: one task per line,
+// with optional completion mark, priority and dates, followed by a
+// description that may hold +project and @context words and key:value pairs.
+// See https://github.com/todotxt/todo.txt
+package todotxt
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const dateFormat = "2006-01-02"
+
+var (
+	priorityPattern = regexp.MustCompile(`^\([A-Z]\)$`)
+	datePattern     = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}$`)
+)
+
+// Task is one line of a todo.txt file. Projects and contexts are stored
+// without their + and @ marks and are removed from the description, as is
+// the due: pair. Any other key:value pairs are left in the description
+type Task struct {
+	Line           int
+	Done           bool
+	Priority       byte
+	CompletionDate string
+	CreationDate   string
+	Description    string
+	Projects       []string
+	Contexts       []string
+	Due            string
+}
+
+// ParseError reports a line that could not be read
+type ParseError struct {
+	Line int
+	Err  error
+}
+
+func (e ParseError) Error() string {
+	return "line " + strconv.Itoa(e.Line) + ": " + e.Err.Error()
+}
+
+// date checks a word is a valid todo.txt date. The second result tells
+// whether the word looked like a date at all
+func date(word string) (bool, error) {
+	if !datePattern.MatchString(word) {
+		return false, nil
+	}
+	_, err := time.Parse(dateFormat, word)
+	return true, err
+}
+
+// Parse reads a single todo.txt line
+func Parse(line string) (Task, error) {
+	task := Task{Projects: make([]string, 0), Contexts: make([]string, 0)}
+	words := strings.Fields(line)
+	i := 0
+
+	// leading dates: completion then creation for done tasks, only creation
+	// for open ones
+	dates := make([]*string, 0, 2)
+	if len(words) > 0 && words[0] == "x" {
+		task.Done = true
+		dates = append(dates, &task.CompletionDate, &task.CreationDate)
+		i++
+	} else {
+		if len(words) > 0 && priorityPattern.MatchString(words[0]) {
+			task.Priority = words[0][1]
+			i++
+		}
+		dates = append(dates, &task.CreationDate)
+	}
+	for _, d := range dates {
+		if i >= len(words) {
+			break
+		}
+		isDate, err := date(words[i])
+		if !isDate {
+			break
+		}
+		if err != nil {
+			return Task{}, errors.New("invalid date '" + words[i] + "'")
+		}
+		*d = words[i]
+		i++
+	}
+
+	text := make([]string, 0, len(words)-i)
+	for _, word := range words[i:] {
+		switch {
+		case len(word) > 1 && word[0] == '+':
+			task.Projects = append(task.Projects, word[1:])
+		case len(word) > 1 && word[0] == '@':
+			task.Contexts = append(task.Contexts, word[1:])
+		case strings.HasPrefix(word, "due:"):
+			if isDate, err := date(word[4:]); !isDate || err != nil {
+				return Task{}, errors.New("invalid due date '" + word[4:] + "'")
+			}
+			task.Due = word[4:]
+		case task.Done && task.Priority == 0 && len(word) == 5 && strings.HasPrefix(word, "pri:") &&
+			word[4] >= 'A' && word[4] <= 'Z':
+			// done tasks lose their priority prefix, so keep it as a pair
+			task.Priority = word[4]
+		default:
+			text = append(text, word)
+		}
+	}
+	task.Description = strings.Join(text, " ")
+	if task.Description == "" {
+		return Task{}, errors.New("missing description")
+	}
+
+	return task, nil
+}
+
+// ParseFile reads every line of a todo.txt file, skipping blank lines. Lines
+// that cannot be read are reported rather than stopping the parse
+func ParseFile(r io.Reader) ([]Task, []ParseError, error) {
+	tasks := make([]Task, 0)
+	parseErrors := make([]ParseError, 0)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		task, err := Parse(text)
+		if err != nil {
+			parseErrors = append(parseErrors, ParseError{Line: line, Err: err})
+			continue
+		}
+		task.Line = line
+		tasks = append(tasks, task)
+	}
+
+	return tasks, parseErrors, scanner.Err()
+}
+
+// String renders a task as a todo.txt line
+func (t Task) String() string {
+	words := make([]string, 0, 8)
+	if t.Done {
+		words = append(words, "x")
+		if t.CompletionDate != "" {
+			words = append(words, t.CompletionDate)
+			if t.CreationDate != "" {
+				words = append(words, t.CreationDate)
+			}
+		}
+	} else {
+		if t.Priority != 0 {
+			words = append(words, "("+string(t.Priority)+")")
+		}
+		if t.CreationDate != "" {
+			words = append(words, t.CreationDate)
+		}
+	}
+
+	words = append(words, t.Description)
+	for _, p := range t.Projects {
+		words = append(words, "+"+p)
+	}
+	for _, c := range t.Contexts {
+		words = append(words, "@"+c)
+	}
+	if t.Due != "" {
+		words = append(words, "due:"+t.Due)
+	}
+	if t.Done && t.Priority != 0 {
+		words = append(words, "pri:"+string(t.Priority))
+	}
+
+	return strings.Join(words, " ")
+}
+
+// Encode writes tasks as a todo.txt file
+func Encode(w io.Writer, tasks []Task) error {
+	for _, t := range tasks {
+		if _, err := io.WriteString(w, t.String()+"\n"); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
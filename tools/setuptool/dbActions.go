@@ -1,10 +1,10 @@
 package main
 
 import (
-	"crypto/sha512"
 	"database/sql"
-	"encoding/hex"
 	"time"
+
+	"github.com/greeneg/todoer/passhash"
 )
 
 func convertSqliteTimestamp(t string) string {
@@ -14,32 +14,48 @@ func convertSqliteTimestamp(t string) string {
 	return createTime.Format(timeFormat)
 }
 
-func createAccount(accountName string, accountFullName string, passwd string) (User, error) {
+// createAccount creates an account in an org unit with the member role, and
+// the role named by roleName unless it is empty, all at once
+func createAccount(accountName string, accountFullName string, passwd string, orgUnitId int,
+	roleName string) (User, error) {
+	// take password and hash it
+	passwdHash, err := passhash.Hash(passwd)
+	if err != nil {
+		errPrintln("Could not hash password!" + string(err.Error()))
+		return User{}, err
+	}
+
 	t, err := DB.Begin()
 	if err != nil {
 		errPrintln("Could not start DB transaction!" + string(err.Error()))
 		return User{}, err
 	}
+	defer t.Rollback()
 
-	q, err := t.Prepare("INSERT INTO Users (UserName, FullName, PasswordHash) VALUES (?, ?, ?)")
+	result, err := t.Exec("INSERT INTO Users (UserName, FullName, PasswordHash, OrgUnitId) VALUES (?, ?, ?, ?)",
+		accountName, accountFullName, passwdHash, orgUnitId)
 	if err != nil {
-		errPrintln("Could not prepare the DB query!" + string(err.Error()))
+		errPrintln("Cannot create user '" + accountName + "': " + string(err.Error()))
+		return User{}, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		errPrintln("Cannot retrieve Id of user '" + accountName + "': " + string(err.Error()))
 		return User{}, err
 	}
 
-	// take password and hash it
-	hash := sha512.Sum512([]byte(passwd))
-	passwdHash := hex.EncodeToString(hash[:])
-
-	// get the org Id
-
-	_, err = q.Exec(accountName, accountFullName, passwdHash)
+	// every account starts out as a plain member, besides any role asked for
+	_, err = t.Exec("INSERT OR IGNORE INTO UserRoles (UserId, RoleId) SELECT ?, Id FROM Roles "+
+		"WHERE RoleName IN ('member', ?)", id, roleName)
 	if err != nil {
-		errPrintln("Cannot create user '" + accountName + "': " + string(err.Error()))
+		errPrintln("Cannot grant roles to user '" + accountName + "': " + string(err.Error()))
 		return User{}, err
 	}
 
-	t.Commit()
+	if err = t.Commit(); err != nil {
+		errPrintln("Cannot create user '" + accountName + "': " + string(err.Error()))
+		return User{}, err
+	}
 
 	user, err := getAccountByName(accountName)
 	if err != nil {
@@ -51,7 +67,7 @@ func createAccount(accountName string, accountFullName string, passwd string) (U
 }
 
 func getAccountByName(accountName string) (User, error) {
-	rec, err := DB.Prepare("SELECT Id,UserName,FullName,Status,CreationDate FROM Users WHERE UserName = ?")
+	rec, err := DB.Prepare("SELECT Id,UserName,FullName,Status,OrgUnitId,CreationDate FROM Users WHERE UserName = ?")
 	if err != nil {
 		errPrintln("Could not prepare the DB query: " + string(err.Error()))
 		return User{}, err
@@ -63,6 +79,7 @@ func getAccountByName(accountName string) (User, error) {
 		&user.UserName,
 		&user.FullName,
 		&user.Status,
+		&user.OrgUnitId,
 		&user.CreationDate,
 	)
 	if err != nil {
@@ -86,13 +103,14 @@ func getAccountStatus(account string) (bool, error) {
 		return false, err
 	}
 
-	q, err := DB.Prepare("SELECT * FROM Users WHERE UserName IS ?")
+	q, err := DB.Prepare("SELECT Id FROM Users WHERE UserName IS ?")
 	if err != nil {
 		errPrintln("Could not prepare DB query! " + string(err.Error()))
 		return false, err
 	}
 
-	err = q.QueryRow(account).Scan()
+	var id int
+	err = q.QueryRow(account).Scan(&id)
 	if err != nil {
 		if err != sql.ErrNoRows {
 			errPrintln("Encountered error when querying database: " + string(err.Error()))
@@ -105,3 +123,134 @@ func getAccountStatus(account string) (bool, error) {
 
 	return true, nil
 }
+
+func getRoleByName(roleName string) (Role, error) {
+	rec, err := DB.Prepare("SELECT Id,RoleName,Description,CreationDate FROM Roles WHERE RoleName = ?")
+	if err != nil {
+		errPrintln("Could not prepare the DB query: " + string(err.Error()))
+		return Role{}, err
+	}
+
+	role := Role{}
+	err = rec.QueryRow(roleName).Scan(
+		&role.Id,
+		&role.RoleName,
+		&role.Description,
+		&role.CreationDate,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return Role{}, nil
+		}
+		errPrintln("Cannot retrieve role from DB: " + string(err.Error()))
+		return Role{}, err
+	}
+
+	role.CreationDate = convertSqliteTimestamp(role.CreationDate)
+
+	return role, nil
+}
+
+func createRole(roleName string, description string) (Role, error) {
+	_, err := DB.Exec("INSERT INTO Roles (RoleName, Description) VALUES (?, ?)", roleName, description)
+	if err != nil {
+		errPrintln("Cannot create role '" + roleName + "': " + string(err.Error()))
+		return Role{}, err
+	}
+
+	return getRoleByName(roleName)
+}
+
+func grantRole(accountName string, roleName string) error {
+	_, err := DB.Exec("INSERT OR IGNORE INTO UserRoles (UserId, RoleId) SELECT Users.Id, Roles.Id FROM Users, Roles "+
+		"WHERE Users.UserName = ? AND Roles.RoleName = ?", accountName, roleName)
+	if err != nil {
+		errPrintln("Cannot grant role '" + roleName + "' to account '" + accountName + "': " + string(err.Error()))
+	}
+
+	return err
+}
+
+func getOrgUnitByName(orgUnitName string) (OrgUnit, error) {
+	rec, err := DB.Prepare("SELECT Id,OrgUnitName,Description,CreationDate FROM OrgUnits WHERE OrgUnitName = ?")
+	if err != nil {
+		errPrintln("Could not prepare the DB query: " + string(err.Error()))
+		return OrgUnit{}, err
+	}
+
+	orgUnit := OrgUnit{}
+	err = rec.QueryRow(orgUnitName).Scan(
+		&orgUnit.Id,
+		&orgUnit.OrgUnitName,
+		&orgUnit.Description,
+		&orgUnit.CreationDate,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return OrgUnit{}, nil
+		}
+		errPrintln("Cannot retrieve org unit from DB: " + string(err.Error()))
+		return OrgUnit{}, err
+	}
+
+	orgUnit.CreationDate = convertSqliteTimestamp(orgUnit.CreationDate)
+
+	return orgUnit, nil
+}
+
+func createOrgUnit(orgUnitName string, description string) (OrgUnit, error) {
+	_, err := DB.Exec("INSERT INTO OrgUnits (OrgUnitName, Description) VALUES (?, ?)", orgUnitName, description)
+	if err != nil {
+		errPrintln("Cannot create org unit '" + orgUnitName + "': " + string(err.Error()))
+		return OrgUnit{}, err
+	}
+
+	return getOrgUnitByName(orgUnitName)
+}
+
+// assignOrgUnit moves an account to another org unit. As todoer does, it
+// drops list memberships and detaches assignees and lists that would
+// otherwise be shared across org units
+func assignOrgUnit(accountName string, orgUnitId int) error {
+	t, err := DB.Begin()
+	if err != nil {
+		errPrintln("Could not start DB transaction!" + string(err.Error()))
+		return err
+	}
+	defer t.Rollback()
+
+	var userId int
+	err = t.QueryRow("SELECT Id FROM Users WHERE UserName = ?", accountName).Scan(&userId)
+	if err != nil {
+		errPrintln("Cannot retrieve Id of user '" + accountName + "': " + string(err.Error()))
+		return err
+	}
+
+	_, err = t.Exec("UPDATE Users SET OrgUnitId = ? WHERE Id = ?", orgUnitId, userId)
+	if err != nil {
+		errPrintln("Cannot change org unit of user '" + accountName + "': " + string(err.Error()))
+		return err
+	}
+
+	_, err = t.Exec("DELETE FROM ListMembers WHERE (UserId = ? OR ListId IN (SELECT Id FROM Lists WHERE OwnerId = ?)) AND "+
+		"(SELECT OrgUnitId FROM Users WHERE Id = UserId) IS NOT "+
+		"(SELECT OrgUnitId FROM Users WHERE Id = (SELECT OwnerId FROM Lists WHERE Id = ListId))", userId, userId)
+	if err != nil {
+		errPrintln("Cannot remove list members of user '" + accountName + "': " + string(err.Error()))
+		return err
+	}
+
+	_, err = t.Exec("UPDATE Todos SET "+
+		"AssigneeId = CASE WHEN (SELECT OrgUnitId FROM Users WHERE Id = AssigneeId) IS NOT "+
+		"(SELECT OrgUnitId FROM Users WHERE Id = CreatorId) THEN NULL ELSE AssigneeId END, "+
+		"ListId = CASE WHEN (SELECT OrgUnitId FROM Users WHERE Id = (SELECT OwnerId FROM Lists WHERE Id = ListId)) IS NOT "+
+		"(SELECT OrgUnitId FROM Users WHERE Id = CreatorId) THEN NULL ELSE ListId END "+
+		"WHERE CreatorId = ? OR AssigneeId = ? OR ListId IN (SELECT Id FROM Lists WHERE OwnerId = ?)",
+		userId, userId, userId)
+	if err != nil {
+		errPrintln("Cannot detach todos of user '" + accountName + "': " + string(err.Error()))
+		return err
+	}
+
+	return t.Commit()
+}
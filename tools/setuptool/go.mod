@@ -2,10 +2,17 @@ module github.com/greeneg/todoer/tools/setuptool
 
 go 1.24rc1
 
-require github.com/pborman/getopt/v2 v2.1.0
+require (
+	github.com/greeneg/todoer v0.0.0-00010101000000-000000000000
+	github.com/mattn/go-sqlite3 v1.14.24
+	github.com/pborman/getopt/v2 v2.1.0
+	golang.org/x/term v0.27.0
+)
 
 require (
-	github.com/mattn/go-sqlite3 v1.14.24 // indirect
+	golang.org/x/crypto v0.31.0 // indirect
 	golang.org/x/sys v0.28.0 // indirect
-	golang.org/x/term v0.27.0 // indirect
+	golang.org/x/text v0.21.0 // indirect
 )
+
+replace github.com/greeneg/todoer => ../..
@@ -19,37 +19,50 @@ var app = path.Base(appFullPath)
 
 // setup the global flags
 var (
-	dbFile     string
-	account    string
-	fullName   string
-	optHelp    = getopt.BoolLong("help", 'h', "This help message")
-	optVersion = getopt.BoolLong("version", 'v', "Show the version")
+	dbFile             string
+	configFile         string
+	account            string
+	fullName           string
+	role               string
+	roleDescription    string
+	orgUnit            string
+	orgUnitDescription string
+	optHelp            = getopt.BoolLong("help", 'h', "This help message")
+	optVersion         = getopt.BoolLong("version", 'v', "Show the version")
 )
 
 func showHelp() {
 	println(app + " - Setup tool for Allocator Daemon")
 	dividerLine := strings.Repeat("=", 43)
 	println(dividerLine)
-	println("Add and configure roles or accounts for the Allocator Daemon\n")
+	println("Add and configure roles, org units or accounts for the Allocator Daemon\n")
 	println("OPTIONS:")
 	println("   -d|--database-file FILENAME_PATH       REQUIRED: The full or relative path")
 	println("                                          to the database file")
+	println("   -c|--config-file FILENAME_PATH         OPTIONAL: The todoer config file,")
+	println("                                          whose password hashing and policy")
+	println("                                          settings new accounts follow.")
 	println("   -a|--account ACCOUNT_NAME              OPTIONAL: The account to create")
-	println("   -r|--role ROLE_NAME                    OPTIONAL: The role to create")
+	println("   -r|--role ROLE_NAME                    OPTIONAL: The role to create. If the")
+	println("                                          account flag is set, the role is")
+	println("                                          granted to the account.")
 	println("   -o|--org-unit ORGANIZATIONAL_UNIT_NAME OPTIONAL: The organizational unit to")
-	println("                                          create")
+	println("                                          create. If the account flag is set,")
+	println("                                          the account is placed in it.")
 	println("   -f|--fullname QUOTED_FULLNAME          CONDITIONALLY OPTIONAL: If the")
 	println("                                          account flag is set, this is required.")
 	println("                                          This should be the full name or")
 	println("                                          description for the account to be")
 	println("                                          registered with the system.")
 	println("   -D|--role-description ROLE_DESCRIPTION CONDITIONALLY OPTIONAL: If the")
-	println("                                          role flag is set, this is required.")
+	println("                                          role flag names a new role, this is")
+	println("                                          required.")
 	println("                                          This should be the description for")
 	println("                                          the role to be registered with the")
 	println("                                          system.")
 	println("   -O|--org-unit-description DESCRIPTION  CONDITIONALLY OPTIONAL: If the")
-	println("                                          org unit flag is set, this is required.")
+	println("                                          org unit flag names a new org unit,")
+	println("                                          this is required.")
 	println("                                          This describes the organizational unit")
 	println("                                          for the org-unit to be registered with")
 	println("                                          the system.")
@@ -65,8 +78,13 @@ func showVersion() {
 
 func init() {
 	getopt.FlagLong(&dbFile, "database-file", 'd', "The full path to the database file")
+	getopt.FlagLong(&configFile, "config-file", 'c', "The todoer config file with the password settings")
 	getopt.FlagLong(&account, "account", 'a', "The account to add to the system")
 	getopt.FlagLong(&fullName, "fullname", 'f', "The full name to associate with the account")
+	getopt.FlagLong(&role, "role", 'r', "The role to create or grant to the account")
+	getopt.FlagLong(&roleDescription, "role-description", 'D', "The description of a new role")
+	getopt.FlagLong(&orgUnit, "org-unit", 'o', "The org unit to create or place the account in")
+	getopt.FlagLong(&orgUnitDescription, "org-unit-description", 'O', "The description of a new org unit")
 }
 
 func main() {
@@ -94,7 +112,17 @@ func main() {
 		os.Exit(1)
 	}
 
-	// do we need to process an account the user passed in?
+	if configFile != "" {
+		if err := loadPasswordConfig(configFile); err != nil {
+			errPrintln("Encountered error when reading config file '" + configFile + "': " + string(err.Error()))
+			os.Exit(1)
+		}
+	}
+
+	// do we need to process an account the user passed in? The password of a
+	// new account is asked for before anything is created
+	var accountStatus bool
+	var passwd string
 	if account != "" {
 		println("Account: " + account)
 		if fullName != "" {
@@ -104,25 +132,86 @@ func main() {
 			showHelp()
 			os.Exit(1)
 		}
+
+		// check if account already exists
+		var err error
+		accountStatus, err = getAccountStatus(account)
+		if err != nil && err != sql.ErrNoRows {
+			errPrintln("Encountered error when checking account status: " + string(err.Error()))
+			os.Exit(1)
+		}
+		if !accountStatus {
+			fmt.Print("Enter new password: ")
+			input, _ := term.ReadPassword(int(os.Stdin.Fd()))
+			fmt.Print("\nRe-enter passphrase: ")
+			input2, _ := term.ReadPassword(int(os.Stdin.Fd()))
+			println("")
+			if strings.Compare(string(input), string(input2)) != 0 {
+				errPrintln("Password does not match. Exiting")
+				os.Exit(1)
+			}
+			if err := checkPassword(string(input)); err != nil {
+				errPrintln("Password is not accepted: " + string(err.Error()) + ". Exiting")
+				os.Exit(1)
+			}
+			passwd = string(input)
+		}
 	}
 
-	// check if account already exists
-	accountStatus, err := getAccountStatus(account)
-	if err != nil && err != sql.ErrNoRows {
-		errPrintln("Encountered error when checking account status: " + string(err.Error()))
-		os.Exit(1)
+	// do we need to process a role the user passed in?
+	if role != "" {
+		println("Role: " + role)
+		roleRecord, err := getRoleByName(role)
+		if err != nil {
+			errPrintln("Encountered error when checking role: " + string(err.Error()))
+			os.Exit(1)
+		}
+		if roleRecord.Id == 0 {
+			if roleDescription == "" {
+				errPrintln("Role must have a description")
+				showHelp()
+				os.Exit(1)
+			}
+			if _, err := createRole(role, roleDescription); err != nil {
+				errPrintln("Encountered error when creating role '" + role + "': " + string(err.Error()))
+				os.Exit(1)
+			}
+			infoPrintln("role '" + role + "' created")
+		}
 	}
-	if !accountStatus {
-		fmt.Print("Enter new password: ")
-		input, _ := term.ReadPassword(int(os.Stdin.Fd()))
-		fmt.Print("\nRe-enter passphrase: ")
-		input2, _ := term.ReadPassword(int(os.Stdin.Fd()))
-		println("")
-		if strings.Compare(string(input), string(input2)) != 0 {
-			errPrintln("Password does not match. Exiting")
+
+	// do we need to process an org unit the user passed in? Accounts go in
+	// the default org unit otherwise
+	orgUnitId := 1
+	if orgUnit != "" {
+		println("Org unit: " + orgUnit)
+		orgUnitRecord, err := getOrgUnitByName(orgUnit)
+		if err != nil {
+			errPrintln("Encountered error when checking org unit: " + string(err.Error()))
 			os.Exit(1)
 		}
-		accountRecord, err := createAccount(account, fullName, string(input))
+		if orgUnitRecord.Id == 0 {
+			if orgUnitDescription == "" {
+				errPrintln("Org unit must have a description")
+				showHelp()
+				os.Exit(1)
+			}
+			orgUnitRecord, err = createOrgUnit(orgUnit, orgUnitDescription)
+			if err != nil {
+				errPrintln("Encountered error when creating org unit '" + orgUnit + "': " + string(err.Error()))
+				os.Exit(1)
+			}
+			infoPrintln("org unit '" + orgUnit + "' created")
+		}
+		orgUnitId = orgUnitRecord.Id
+	}
+
+	if account == "" {
+		return
+	}
+
+	if !accountStatus {
+		accountRecord, err := createAccount(account, fullName, passwd, orgUnitId, role)
 		if err != nil {
 			errPrintln("Encountered error when creating account '" + account + "': " + string(err.Error()))
 			os.Exit(1)
@@ -133,6 +222,21 @@ func main() {
 			os.Exit(1)
 		}
 		infoPrintln("account '" + account + "' created: " + string(accountRecordStr))
+		if role != "" {
+			infoPrintln("role '" + role + "' granted to account '" + account + "'")
+		}
+		return
+	} else if orgUnit != "" {
+		if err := assignOrgUnit(account, orgUnitId); err != nil {
+			os.Exit(1)
+		}
+		infoPrintln("account '" + account + "' placed in org unit '" + orgUnit + "'")
+	}
 
+	if role != "" {
+		if err := grantRole(account, role); err != nil {
+			os.Exit(1)
+		}
+		infoPrintln("role '" + role + "' granted to account '" + account + "'")
 	}
 }
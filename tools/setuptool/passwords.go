@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/greeneg/todoer/globals"
+	"github.com/greeneg/todoer/passhash"
+	"github.com/greeneg/todoer/passpolicy"
+)
+
+// loadPasswordConfig applies the password hashing and policy settings of a
+// todoer config file, so that accounts are created as the server would create
+// them. Without one the defaults of the server are used
+func loadPasswordConfig(configFile string) error {
+	content, err := os.ReadFile(configFile)
+	if err != nil {
+		return err
+	}
+	var config globals.Config
+	if err := json.Unmarshal(content, &config); err != nil {
+		return err
+	}
+	if err := passhash.Init(config.Passwords); err != nil {
+		return err
+	}
+
+	return passpolicy.Init(config.Passwords, filepath.Dir(configFile))
+}
+
+// checkPassword returns an error naming the rules of the password policy a
+// new password breaks, or nil if it is acceptable
+func checkPassword(passwd string) error {
+	violations := passpolicy.Check(passwd)
+	if len(violations) == 0 {
+		return nil
+	}
+	messages := make([]string, 0, len(violations))
+	for _, violation := range violations {
+		messages = append(messages, violation.Message)
+	}
+
+	return errors.New(strings.Join(messages, "; "))
+}
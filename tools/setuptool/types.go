@@ -5,5 +5,20 @@ type User struct {
 	UserName     string
 	FullName     string
 	Status       string
+	OrgUnitId    int
+	CreationDate string
+}
+
+type OrgUnit struct {
+	Id           int
+	OrgUnitName  string
+	Description  string
+	CreationDate string
+}
+
+type Role struct {
+	Id           int
+	RoleName     string
+	Description  string
 	CreationDate string
 }
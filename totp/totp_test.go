@@ -0,0 +1,163 @@
+package totp
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA1 key of the RFC 6238 test vectors, base32 encoded
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestCode(t *testing.T) {
+	// the last six digits of the eight digit codes of RFC 6238, appendix B
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{unix: 59, code: "287082"},
+		{unix: 1111111109, code: "081804"},
+		{unix: 1111111111, code: "050471"},
+		{unix: 1234567890, code: "005924"},
+		{unix: 2000000000, code: "279037"},
+		{unix: 20000000000, code: "353130"},
+	}
+	for _, tt := range tests {
+		code, err := Code(rfcSecret, time.Unix(tt.unix, 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != tt.code {
+			t.Errorf("code at %d is %s, want %s", tt.unix, code, tt.code)
+		}
+	}
+
+	// authenticator apps may show the secret in lower case or padded
+	if code, err := Code("gezdgnbvgy3tqojqgezdgnbvgy3tqojq====", time.Unix(59, 0)); err != nil || code != "287082" {
+		t.Errorf("code of a lower case, padded secret is %s, %v, want 287082", code, err)
+	}
+	if _, err := Code("not base32!", time.Unix(59, 0)); err == nil {
+		t.Error("Code accepted an invalid secret")
+	}
+}
+
+func TestValidateWindow(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	tests := []struct {
+		name  string
+		steps int64 // how many steps from now the code was made
+		valid bool
+	}{
+		{name: "current step", steps: 0, valid: true},
+		{name: "previous step", steps: -1, valid: true},
+		{name: "next step", steps: 1, valid: true},
+		{name: "two steps ago", steps: -2, valid: false},
+		{name: "two steps ahead", steps: 2, valid: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, err := Code(rfcSecret, now.Add(time.Duration(tt.steps)*Period))
+			if err != nil {
+				t.Fatal(err)
+			}
+			step, ok := Validate(rfcSecret, code, now, 0)
+			if ok != tt.valid {
+				t.Fatalf("Validate returned %v, want %v", ok, tt.valid)
+			}
+			if ok && step != Step(now)+tt.steps {
+				t.Errorf("Validate returned step %d, want %d", step, Step(now)+tt.steps)
+			}
+		})
+	}
+}
+
+func TestValidateRefusesUsedSteps(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	code, err := Code(rfcSecret, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	step, ok := Validate(rfcSecret, code, now, 0)
+	if !ok {
+		t.Fatal("Validate refused the current code")
+	}
+
+	if _, ok := Validate(rfcSecret, code, now, step); ok {
+		t.Error("Validate accepted a code a second time")
+	}
+	previous, err := Code(rfcSecret, now.Add(-Period))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := Validate(rfcSecret, previous, now, step); ok {
+		t.Error("Validate accepted a code older than the last one used")
+	}
+	next, err := Code(rfcSecret, now.Add(Period))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := Validate(rfcSecret, next, now, step); !ok {
+		t.Error("Validate refused the code of the next step")
+	}
+}
+
+func TestValidateInput(t *testing.T) {
+	now := time.Unix(59, 0)
+	for _, code := range []string{"287082", " 287 082 "} {
+		if _, ok := Validate(rfcSecret, code, now, 0); !ok {
+			t.Errorf("Validate refused %q", code)
+		}
+	}
+	for _, code := range []string{"", "28708", "2870820", "94287082", "287083"} {
+		if _, ok := Validate(rfcSecret, code, now, 0); ok {
+			t.Errorf("Validate accepted %q", code)
+		}
+	}
+	if _, ok := Validate("not base32!", "287082", now, 0); ok {
+		t.Error("Validate accepted a code for an invalid secret")
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := decodeSecret(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(key) != 20 {
+		t.Errorf("secret is %d bytes, want 20", len(key))
+	}
+	other, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other == secret {
+		t.Error("GenerateSecret returned the same secret twice")
+	}
+}
+
+func TestURI(t *testing.T) {
+	u, err := url.Parse(URI("Todoer Inc", "alice@example.com", rfcSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Scheme != "otpauth" || u.Host != "totp" || u.Path != "/Todoer Inc:alice@example.com" {
+		t.Errorf("URI is %s", u)
+	}
+	want := map[string]string{
+		"secret":    rfcSecret,
+		"issuer":    "Todoer Inc",
+		"algorithm": "SHA1",
+		"digits":    "6",
+		"period":    "30",
+	}
+	q := u.Query()
+	for name, value := range want {
+		if q.Get(name) != value {
+			t.Errorf("%s is %q, want %q", name, q.Get(name), value)
+		}
+	}
+}
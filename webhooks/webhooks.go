@@ -0,0 +1,164 @@
+// Package webhooks posts published events to registered endpoints. Every
+// delivery is queued in the DB first so it survives restarts and failures
+package webhooks
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/greeneg/todoer/events"
+	"github.com/greeneg/todoer/globals"
+	"github.com/greeneg/todoer/model"
+)
+
+const (
+	SignatureHeader = "X-Todoer-Signature"
+	EventHeader     = "X-Todoer-Event"
+	DeliveryHeader  = "X-Todoer-Delivery"
+
+	// longest response body kept in the delivery log
+	maxResponseBody = 1024
+)
+
+var (
+	conf   globals.WebhookConfig
+	client *http.Client
+)
+
+// Init applies defaults to the webhook settings and subscribes to events
+func Init(c globals.WebhookConfig) {
+	conf = c
+	if conf.PollIntervalSeconds <= 0 {
+		conf.PollIntervalSeconds = 5
+	}
+	if conf.MaxAttempts <= 0 {
+		conf.MaxAttempts = 10
+	}
+	if conf.RetryBackoffSeconds <= 0 {
+		conf.RetryBackoffSeconds = 30
+	}
+	if conf.TimeoutSeconds <= 0 {
+		conf.TimeoutSeconds = 10
+	}
+	client = &http.Client{Timeout: time.Duration(conf.TimeoutSeconds) * time.Second}
+
+	events.Subscribe(enqueue)
+}
+
+// Start launches the delivery worker
+func Start() {
+	interval := time.Duration(conf.PollIntervalSeconds) * time.Second
+	go func() {
+		for {
+			deliverPending()
+			time.Sleep(interval)
+		}
+	}()
+}
+
+// Sign Returns the value of the signature header for a payload
+func Sign(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func subscribed(hook model.Webhook, eventType string) bool {
+	for _, e := range hook.Events {
+		if e == eventType || e == "*" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// enqueue Queues a delivery of the event for every subscribed webhook
+func enqueue(e events.Event) {
+	hooks, err := model.GetWebhooks()
+	if err != nil {
+		return
+	}
+
+	payload, err := json.Marshal(e)
+	if err != nil {
+		log.Println("ERROR: Cannot encode event '" + e.Type + "': " + string(err.Error()))
+		return
+	}
+
+	for _, hook := range hooks {
+		if hook.Active && subscribed(hook, e.Type) {
+			model.EnqueueDelivery(hook.Id, e.Id, e.Type, string(payload))
+		}
+	}
+}
+
+// backoff Returns the delay before the next delivery attempt
+func backoff(attempts int) time.Duration {
+	delay := float64(conf.RetryBackoffSeconds) * math.Pow(2, float64(attempts))
+	return time.Duration(math.Min(delay, 6*60*60)) * time.Second
+}
+
+// post Sends a single delivery and returns the response code and body
+func post(hook model.Webhook, d model.WebhookDelivery) (int, string, error) {
+	req, err := http.NewRequest(http.MethodPost, hook.Url, bytes.NewBufferString(d.Payload))
+	if err != nil {
+		return 0, "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "todoer-webhooks")
+	req.Header.Set(SignatureHeader, Sign(hook.Secret, []byte(d.Payload)))
+	req.Header.Set(EventHeader, d.EventType)
+	req.Header.Set(DeliveryHeader, strconv.Itoa(d.Id))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxResponseBody))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, string(body), errors.New("endpoint responded with " + resp.Status)
+	}
+
+	return resp.StatusCode, string(body), nil
+}
+
+func deliverPending() {
+	deliveries, err := model.GetDueDeliveries(model.Now(), 50)
+	if err != nil {
+		return
+	}
+
+	for _, d := range deliveries {
+		hook, err := model.GetWebhookById(d.WebhookId)
+		if err != nil {
+			continue
+		}
+
+		code, body, err := post(hook, d)
+		if err == nil {
+			log.Println("INFO: Delivered '" + d.EventType + "' to webhook " + strconv.Itoa(hook.Id))
+			model.RecordDeliveryAttempt(d.Id, true, code, body, "", "")
+			continue
+		}
+
+		log.Println("WARN: Delivery to webhook " + strconv.Itoa(hook.Id) + " failed: " + string(err.Error()))
+		nextAttempt := ""
+		if d.Attempts+1 < conf.MaxAttempts && hook.Active {
+			nextAttempt = time.Now().UTC().Add(backoff(d.Attempts)).Format(model.SqlDateTimeFormat)
+		}
+		model.RecordDeliveryAttempt(d.Id, false, code, body, err.Error(), nextAttempt)
+	}
+}